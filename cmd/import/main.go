@@ -0,0 +1,106 @@
+// Command import loads chat history from a Slack workspace export or a
+// WhatsApp chat export. Running it again with the same export, or a newer
+// export of the same chat, only adds the messages that are missing.
+//
+//	go run ./cmd/import -source slack -file export.zip
+//	go run ./cmd/import -source whatsapp -file "WhatsApp Chat with Alice.zip" \
+//		-participants "Alice=alice@example.com,Bob=bob@example.com" -tz Europe/Berlin
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"mozho_chat/internal/config"
+	"mozho_chat/internal/db"
+	"mozho_chat/internal/importer"
+	"mozho_chat/internal/importer/dto"
+	"mozho_chat/internal/repository"
+	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/s3"
+)
+
+func main() {
+	source := flag.String("source", "", "export format: slack or whatsapp")
+	path := flag.String("file", "", "path to the export")
+	chatName := flag.String("chat-name", "", "whatsapp: room name (default: taken from the file name)")
+	participants := flag.String("participants", "", "whatsapp: comma separated Name=email pairs")
+	dateOrder := flag.String("date-order", "", "whatsapp: dmy or mdy (default: guessed)")
+	tz := flag.String("tz", "UTC", "whatsapp: time zone of the phone that made the export")
+	flag.Parse()
+
+	if *path == "" || (*source != importer.SourceSlack && *source != importer.SourceWhatsApp) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := os.Open(*path)
+	if err != nil {
+		log.Fatalf("open export: %v", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		log.Fatalf("stat export: %v", err)
+	}
+
+	cfg := config.LoadConfig()
+	dbConn := db.InitPostgres(cfg)
+	s3Service, err := s3.NewS3Service()
+	if err != nil {
+		log.Fatalf("failed to initialize S3 service: %v", err)
+	}
+
+	service := importer.NewService(
+		repository.NewMessageRepository(dbConn),
+		repository.NewChatRoomRepository(dbConn),
+		repository.NewUserRepository(dbConn),
+		s3Service,
+		encryption.NewEncryptionService(),
+	)
+
+	var report *dto.ImportReport
+	ctx := context.Background()
+	switch *source {
+	case importer.SourceSlack:
+		report, err = service.ImportSlack(ctx, file, info.Size())
+	case importer.SourceWhatsApp:
+		opts := importer.WhatsAppOptions{ChatName: *chatName, DateOrder: *dateOrder}
+		if opts.Location, err = time.LoadLocation(*tz); err != nil {
+			log.Fatalf("invalid -tz: %v", err)
+		}
+		if opts.Participants, err = parseParticipants(*participants); err != nil {
+			log.Fatalf("invalid -participants: %v", err)
+		}
+		report, err = service.ImportWhatsApp(ctx, info.Name(), file, info.Size(), opts)
+	}
+
+	if report != nil {
+		out, _ := json.MarshalIndent(report, "", "  ")
+		os.Stdout.Write(append(out, '\n'))
+	}
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+}
+
+func parseParticipants(value string) (map[string]string, error) {
+	res := make(map[string]string)
+	if value == "" {
+		return res, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		name, email, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(email) == "" {
+			return nil, fmt.Errorf("expected Name=email, got %q", pair)
+		}
+		res[strings.TrimSpace(name)] = strings.TrimSpace(email)
+	}
+	return res, nil
+}
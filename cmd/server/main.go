@@ -11,8 +11,9 @@ func main() {
 	cfg := config.LoadConfig()
 
 	dbConn := db.InitPostgres(cfg)
+	redisConn := db.InitRedis(cfg)
 
-	r := api.SetupRouter(dbConn)
+	r := api.SetupRouter(dbConn, redisConn)
 
 	if err := r.Run(":8080"); err != nil {
 		log.Fatalf("server failed to start: %v", err)
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// configureTrustedProxies decides where ClientIP reads the caller's address
+// from. Failed logins are counted per IP, so X-Forwarded-For is only
+// believed when it comes from one of TRUSTED_PROXIES (comma separated IPs or
+// CIDRs). TRUSTED_PLATFORM makes ClientIP read a header set by the hosting
+// platform instead: "cloudflare", "google" or the name of the header.
+func configureTrustedProxies(r *gin.Engine) error {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	// Without proxies no forwarding header is trusted
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		return err
+	}
+
+	switch platform := strings.TrimSpace(os.Getenv("TRUSTED_PLATFORM")); strings.ToLower(platform) {
+	case "":
+	case "cloudflare":
+		r.TrustedPlatform = gin.PlatformCloudflare
+	case "google":
+		r.TrustedPlatform = gin.PlatformGoogleAppEngine
+	default:
+		r.TrustedPlatform = platform
+	}
+	return nil
+}
@@ -1,47 +1,170 @@
 package api
 
 import (
+	"context"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	redisdb "mozho_chat/internal/db/redis"
 	"mozho_chat/internal/repository"
 	"mozho_chat/internal/user"
 	"mozho_chat/internal/chatroom"
+	"mozho_chat/internal/contact"
 	"mozho_chat/internal/message"
+	"mozho_chat/internal/poll"
+	"mozho_chat/internal/presence"
+	"mozho_chat/internal/export"
+	"mozho_chat/internal/importer"
+	"mozho_chat/internal/wellknown"
+	"mozho_chat/pkg/auth"
 	"mozho_chat/pkg/middleware"
 	"mozho_chat/pkg/s3"
 	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/mailer"
+	"mozho_chat/pkg/unfurl"
+)
+
+const (
+	// schedulerInterval is how often due scheduled messages are published.
+	schedulerInterval = 10 * time.Second
+	// reaperInterval is how often expired messages are hard-deleted.
+	reaperInterval = time.Minute
+	// unfurlInterval is how often queued link previews are fetched.
+	unfurlInterval = 5 * time.Second
+	// exportInterval is how often queued conversation exports are built.
+	exportInterval = 15 * time.Second
+	// sessionReaperInterval is how often expired refresh tokens are deleted.
+	sessionReaperInterval = time.Hour
+	// accountReaperInterval is how often accounts past their deletion grace
+	// period are purged.
+	accountReaperInterval = time.Hour
+	// keyRotationInterval is how often signing keys are reloaded and rotated
+	// when due.
+	keyRotationInterval = time.Minute
 )
 
-func SetupRouter(db *gorm.DB) *gin.Engine {
+// The Redis client stands in for these interfaces.
+var (
+	_ user.SessionRevoker = (*redisdb.RedisClient)(nil)
+	_ poll.Events         = (*redisdb.RedisClient)(nil)
+	_ user.RateLimiter    = (*redisdb.RedisClient)(nil)
+	_ presence.Store      = (*redisdb.RedisClient)(nil)
+)
+
+func SetupRouter(db *gorm.DB, rdb *redisdb.RedisClient) *gin.Engine {
 	r := gin.Default()
+	if err := configureTrustedProxies(r); err != nil {
+		panic("Invalid trusted proxies: " + err.Error())
+	}
 
 	r.Use(middleware.CORSMiddleware())
 
 	v1 := r.Group("/api/v1")
+	middleware.UseSessionRevocations(rdb)
+	middleware.UseIdempotencyStore(rdb)
+
+	// Signing keys
+	signingKeyRepo := repository.NewSigningKeyRepository(db)
+	keyManager, err := auth.NewKeyManager(context.Background(), signingKeyRepo, auth.KeyManagerOptionsFromEnv())
+	if err != nil {
+		panic("Failed to initialize signing keys: " + err.Error())
+	}
+	auth.UseKeyManager(keyManager)
+	wellKnownHandler := wellknown.NewHandler(keyManager)
+	wellKnownHandler.RegisterRoutes(&r.RouterGroup)
 
 	// User
 	userRepo := repository.NewUserRepository(db)
-	userService := user.NewUserService(userRepo)
+	sessionRepo := repository.NewSessionRepository(db)
+	userTokenRepo := repository.NewUserTokenRepository(db)
+	mfaRepo := repository.NewMFARepository(db)
+	passkeyRepo := repository.NewPasskeyRepository(db)
+	identityRepo := repository.NewIdentityRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	mail := mailer.NewFromEnv()
+	encryptionService := encryption.NewEncryptionService()
+	s3Service, err := s3.NewS3Service()
+	if err != nil {
+		panic("Failed to initialize S3 service: " + err.Error())
+	}
+	contactRepo := repository.NewContactRepository(db)
+	blockRepo := repository.NewBlockRepository(db)
+	accountDeletionRepo := repository.NewAccountDeletionRepository(db)
+	userService := user.NewUserService(user.Deps{
+		Repo:         userRepo,
+		SessionRepo:  sessionRepo,
+		TokenRepo:    userTokenRepo,
+		MFARepo:      mfaRepo,
+		PasskeyRepo:  passkeyRepo,
+		WebAuthn:     auth.WebAuthnConfigFromEnv(),
+		IdentityRepo: identityRepo,
+		OIDC:         auth.OIDCProvidersFromEnv(),
+		AuditRepo:    auditRepo,
+		Limiter:      rdb,
+		ContactRepo:  contactRepo,
+		DeletionRepo: accountDeletionRepo,
+		Mailer:       mail,
+		Encryption:   encryptionService,
+		S3Service:    s3Service,
+		Revoker:      rdb,
+	})
 	userHandler := user.NewHandler(userService)
 	userHandler.RegisterRoutes(v1)
 
+	// Presence
+	presenceService := presence.NewService(rdb, userRepo, contactRepo, blockRepo)
+	presenceHandler := presence.NewHandler(presenceService)
+	presenceHandler.RegisterRoutes(v1)
+	middleware.UsePresence(presenceService)
+
+	// Contacts
+	contactService := contact.NewService(contactRepo, blockRepo, userRepo, presenceService)
+	contactHandler := contact.NewHandler(contactService)
+	contactHandler.RegisterRoutes(v1)
+
 	// Chat Room
 	chatRoomRepo := repository.NewChatRoomRepository(db)
-	chatRoomService := chatroom.NewService(chatRoomRepo, userRepo)
+	chatRoomService := chatroom.NewService(chatRoomRepo, userRepo, contactRepo, blockRepo, rdb)
 	chatRoomHandler := chatroom.NewHandler(chatRoomService)
 	chatRoomHandler.RegisterRoutes(v1)
 
 	// Message ✅
 	messageRepo := repository.NewMessageRepository(db)
 	attachmentRepo := repository.NewAttachmentRepository(db)
-	s3Service, err := s3.NewS3Service()
-	if err != nil {
-		panic("Failed to initialize S3 service: " + err.Error())
-	}
-	encryptionService := encryption.NewEncryptionService()
-	messageService := message.NewMessageService(messageRepo, chatRoomRepo, userRepo, attachmentRepo, s3Service, encryptionService)
+	mentionRepo := repository.NewMentionRepository(db)
+	scheduledRepo := repository.NewScheduledMessageRepository(db)
+	linkPreviewRepo := repository.NewLinkPreviewRepository(db)
+	unfurler := unfurl.NewHTTPFetcher(unfurl.Options{})
+	messageService := message.NewMessageService(messageRepo, chatRoomRepo, userRepo, attachmentRepo, mentionRepo, scheduledRepo, linkPreviewRepo, blockRepo, contactRepo, s3Service, encryptionService, unfurler, rdb)
 	messageHandler := message.NewHandler(messageService)
 	messageHandler.RegisterRoutes(v1)
 
+	// Poll
+	pollRepo := repository.NewPollRepository(db)
+	pollService := poll.NewService(pollRepo, chatRoomRepo, blockRepo, rdb)
+	pollHandler := poll.NewHandler(pollService)
+	pollHandler.RegisterRoutes(v1)
+
+	// Export
+	exportRepo := repository.NewExportJobRepository(db)
+	exportService := export.NewService(exportRepo, messageRepo, chatRoomRepo, userRepo, auditRepo, s3Service, encryptionService, rdb)
+	exportHandler := export.NewHandler(exportService)
+	exportHandler.RegisterRoutes(v1)
+
+	// Import
+	importService := importer.NewService(messageRepo, chatRoomRepo, userRepo, s3Service, encryptionService)
+	importHandler := importer.NewHandler(importService)
+	importHandler.RegisterRoutes(v1)
+
+	// Background workers
+	message.StartScheduler(context.Background(), messageService, schedulerInterval)
+	message.StartReaper(context.Background(), messageService, reaperInterval)
+	message.StartUnfurler(context.Background(), messageService, unfurlInterval)
+	export.StartExporter(context.Background(), exportService, exportInterval)
+	user.StartSessionReaper(context.Background(), userService, sessionReaperInterval)
+	user.StartAccountReaper(context.Background(), userService, accountReaperInterval)
+	auth.StartKeyRotation(context.Background(), keyManager, keyRotationInterval)
+
 	return r
 }
@@ -7,11 +7,34 @@ type CreateChatRoomRequest struct {
 }
 
 type ChatRoomResponse struct {
-	ID    uuid.UUID   `json:"id"`
-	Users []UserBasic `json:"users"`
+	ID                 uuid.UUID   `json:"id"`
+	Users              []UserBasic `json:"users"`
+	MessageTTLSeconds  *int        `json:"message_ttl_seconds,omitempty"`
+	RestrictForwarding bool        `json:"restrict_forwarding"`
+	EncryptionMode     string      `json:"encryption_mode"`
+	// RequestedEncryptionMode is set while a direct message waits for the
+	// other participant to agree to leave end-to-end encryption
+	RequestedEncryptionMode string `json:"requested_encryption_mode,omitempty"`
+}
+
+// EncryptionModeEvent is sent to the members' user channels when a room's
+// encryption mode changes, or when a participant of a direct message asks
+// to leave end-to-end encryption.
+type EncryptionModeEvent struct {
+	ChatRoomID     string `json:"chat_room_id"`
+	UserID         string `json:"user_id"`
+	EncryptionMode string `json:"encryption_mode"`
 }
 
 type UserBasic struct {
-	ID       uuid.UUID `json:"id"`
-	Username string    `json:"username"`
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name,omitempty"`
+}
+
+// TypingEvent is sent to the other members' user channels while someone
+// types in a room.
+type TypingEvent struct {
+	ChatRoomID string `json:"chat_room_id"`
+	UserID     string `json:"user_id"`
 }
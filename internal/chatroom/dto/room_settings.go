@@ -0,0 +1,14 @@
+package dto
+
+// UpdateRoomSettingsRequest changes room-wide settings. Omitted fields are
+// left untouched.
+type UpdateRoomSettingsRequest struct {
+	// MessageTTL is a duration such as "24h" or "7d"; "off" disables
+	// disappearing messages.
+	MessageTTL *string `json:"message_ttl,omitempty"`
+	// RestrictForwarding stops members from forwarding messages out of the room
+	RestrictForwarding *bool `json:"restrict_forwarding,omitempty"`
+	// EncryptionMode is "e2e", "server" or "none". Messages in "server" and
+	// "none" rooms can be searched.
+	EncryptionMode *string `json:"encryption_mode,omitempty" binding:"omitempty,oneof=e2e server none"`
+}
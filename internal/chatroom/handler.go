@@ -17,7 +17,7 @@ func NewHandler(service Service) *Handler {
 }
 
 func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
-	r := rg.Group("/chatrooms", middleware.AuthMiddleware()) // protect with auth middleware
+	r := rg.Group("/chatrooms", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(middleware.MaxJSONBodySize)) // protect with auth middleware
 	{
 		r.POST("", h.CreateRoom)
 		r.GET("/:id", h.GetRoom)
@@ -25,6 +25,8 @@ func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
 		r.POST("/:id/leave", h.LeaveRoom)
 		r.GET("", h.ListRooms)
 		r.DELETE("/:id", h.DeleteRoom)
+		r.PATCH("/:id/settings", h.UpdateSettings)
+		r.POST("/:id/typing", h.SendTyping)
 	}
 }
 
@@ -127,3 +129,39 @@ func (h *Handler) DeleteRoom(c *gin.Context) {
 	}
 	c.Status(http.StatusNoContent)
 }
+
+func (h *Handler) UpdateSettings(c *gin.Context) {
+	roomID := c.Param("id")
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	var req dto.UpdateRoomSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	room, err := h.service.UpdateSettings(userID, roomID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, room)
+}
+
+func (h *Handler) SendTyping(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	if err := h.service.SendTyping(userID, c.Param("id")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
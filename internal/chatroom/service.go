@@ -2,6 +2,10 @@ package chatroom
 
 import (
 	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"mozho_chat/internal/models"
@@ -16,23 +20,55 @@ type Service interface {
 	LeaveRoom(userID, roomID string) error
 	ListRooms(userID string) ([]dto.ChatRoomResponse, error)
 	DeleteRoom(userID, roomID string) error
+	UpdateSettings(userID, roomID string, input dto.UpdateRoomSettingsRequest) (*dto.ChatRoomResponse, error)
+	SendTyping(userID, roomID string) error
 }
 
+// UserEvents delivers events to a single user's channel.
+// *redisdb.RedisClient implements it.
+type UserEvents interface {
+	PublishUserEvent(userID, eventType string, data any) error
+}
+
+const (
+	minMessageTTL = time.Minute
+	maxMessageTTL = 365 * 24 * time.Hour
+)
+
 type chatRoomService struct {
 	repo repository.ChatRoomRepository
 	userRepo repository.UserRepository
+	contactRepo repository.ContactRepository
+	blockRepo repository.BlockRepository
+	events UserEvents
 }
 
-func NewService(repo repository.ChatRoomRepository, userRepo repository.UserRepository) Service {
-	return &chatRoomService{repo: repo, userRepo: userRepo}
+func NewService(repo repository.ChatRoomRepository, userRepo repository.UserRepository, contactRepo repository.ContactRepository, blockRepo repository.BlockRepository, events UserEvents) Service {
+	return &chatRoomService{repo: repo, userRepo: userRepo, contactRepo: contactRepo, blockRepo: blockRepo, events: events}
 }
 
 func (s *chatRoomService) CreateRoom(userID string, input dto.CreateChatRoomRequest) (*dto.ChatRoomResponse, error) {
 	// Check that other user exists
-	_, err := s.userRepo.FindByID(input.OtherUserID.String())
+	other, err := s.userRepo.FindByID(input.OtherUserID.String())
 	if err != nil {
 		return nil, errors.New("other user not found")
 	}
+	blocked, err := s.blockRepo.IsBlockedEither(userID, other.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errors.New("you cannot start a conversation with this user")
+	}
+	if other.Privacy.DMsFromContactsOnly {
+		contacts, err := s.contactRepo.AreContacts(other.ID.String(), userID)
+		if err != nil {
+			return nil, err
+		}
+		if !contacts {
+			return nil, errors.New("this user only accepts messages from contacts")
+		}
+	}
 
 	// Check if a chat room already exists between these two users
 	existingRoom, err := s.repo.FindRoomBetweenUsers(userID, input.OtherUserID.String())
@@ -54,8 +90,8 @@ func (s *chatRoomService) CreateRoom(userID string, input dto.CreateChatRoomRequ
 		return nil, err
 	}
 
-	// Add both users
-	if err := s.repo.AddUser(room.ID.String(), userID); err != nil {
+	// Add both users; the creator owns the room
+	if err := s.repo.AddUserWithRole(room.ID.String(), userID, models.RoleOwner); err != nil {
 		return nil, err
 	}
 	if err := s.repo.AddUser(room.ID.String(), input.OtherUserID.String()); err != nil {
@@ -172,17 +208,192 @@ func (s *chatRoomService) DeleteRoom(userID, roomID string) error {
 	return s.repo.Delete(room)
 }
 
+func (s *chatRoomService) UpdateSettings(userID, roomID string, input dto.UpdateRoomSettingsRequest) (*dto.ChatRoomResponse, error) {
+	role, err := s.repo.GetMemberRole(roomID, userID)
+	if err != nil {
+		return nil, errors.New("user not in room")
+	}
+
+	room, err := s.repo.FindByID(roomID)
+	if err != nil {
+		return nil, err
+	}
+	// In direct messages both participants manage the settings
+	if room.IsGroup && role != models.RoleOwner && role != models.RoleAdmin {
+		return nil, errors.New("only room owners and admins can change room settings")
+	}
+
+	settings := make(map[string]any)
+	if input.MessageTTL != nil {
+		ttl, err := parseMessageTTL(*input.MessageTTL)
+		if err != nil {
+			return nil, err
+		}
+		if ttl == 0 {
+			settings["message_ttl_seconds"] = nil
+			room.MessageTTLSeconds = nil
+		} else {
+			seconds := int(ttl / time.Second)
+			settings["message_ttl_seconds"] = seconds
+			room.MessageTTLSeconds = &seconds
+		}
+	}
+
+	if input.RestrictForwarding != nil {
+		settings["restrict_forwarding"] = *input.RestrictForwarding
+		room.RestrictForwarding = *input.RestrictForwarding
+	}
+
+	event := ""
+	// Asking for the current mode withdraws a pending request
+	if input.EncryptionMode != nil && (*input.EncryptionMode != room.EncryptionMode || room.RequestedEncryptionMode != nil) {
+		event = changeEncryptionMode(room, userID, *input.EncryptionMode, settings)
+	}
+
+	if len(settings) > 0 {
+		if err := s.repo.UpdateSettings(roomID, settings); err != nil {
+			return nil, err
+		}
+	}
+	if event != "" {
+		s.publishToMembers(room, event, dto.EncryptionModeEvent{
+			ChatRoomID:     roomID,
+			UserID:         userID,
+			EncryptionMode: *input.EncryptionMode,
+		})
+	}
+
+	response := mapChatRoomToDTO(room)
+	return &response, nil
+}
+
+// parseMessageTTL accepts Go durations plus a "d" suffix for days. "off" or
+// an empty string disable the timer and yield zero.
+func parseMessageTTL(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "off" {
+		return 0, nil
+	}
+
+	var ttl time.Duration
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.New("invalid message_ttl")
+		}
+		ttl = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, errors.New("invalid message_ttl")
+		}
+		ttl = d
+	}
+
+	if ttl < minMessageTTL || ttl > maxMessageTTL {
+		return 0, errors.New("message_ttl must be between 1 minute and 365 days")
+	}
+	return ttl, nil
+}
+
+// SendTyping tells the other members of a room that the user is typing.
+// Members who blocked the user are not told.
+func (s *chatRoomService) SendTyping(userID, roomID string) error {
+	inRoom, err := s.repo.IsUserInRoom(roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !inRoom {
+		return errors.New("user not in room")
+	}
+
+	memberIDs, err := s.repo.ListMemberIDs(roomID)
+	if err != nil {
+		return err
+	}
+	blockers, err := s.blockRepo.ListBlockerIDs(userID, memberIDs)
+	if err != nil {
+		return err
+	}
+	skip := map[string]bool{userID: true}
+	for _, id := range blockers {
+		skip[id] = true
+	}
+
+	event := dto.TypingEvent{ChatRoomID: roomID, UserID: userID}
+	for _, memberID := range memberIDs {
+		if skip[memberID] {
+			continue
+		}
+		// Typing is transient; a missed event does no harm
+		if err := s.events.PublishUserEvent(memberID, "typing", event); err != nil {
+			log.Printf("failed to publish typing event: %v", err)
+		}
+	}
+	return nil
+}
+
+// changeEncryptionMode adds the encryption mode change to settings and
+// returns the event to tell the members about. Only new messages follow the
+// new mode; stored ones are left as they are. Leaving end-to-end encryption
+// lets the server read the conversation, so in direct messages it waits
+// until both participants asked for the same mode.
+func changeEncryptionMode(room *models.ChatRoom, userID, mode string, settings map[string]any) string {
+	if !room.IsGroup && room.EncryptionMode == models.EncryptionModeE2E {
+		requested := room.RequestedEncryptionMode != nil && *room.RequestedEncryptionMode == mode
+		if !requested || room.EncryptionRequestedBy == nil || room.EncryptionRequestedBy.String() == userID {
+			requestedBy := uuid.MustParse(userID)
+			settings["requested_encryption_mode"] = mode
+			settings["encryption_requested_by"] = requestedBy
+			room.RequestedEncryptionMode = &mode
+			room.EncryptionRequestedBy = &requestedBy
+			return "room.encryption_requested"
+		}
+	}
+
+	settings["encryption_mode"] = mode
+	settings["requested_encryption_mode"] = nil
+	settings["encryption_requested_by"] = nil
+	room.EncryptionMode = mode
+	room.RequestedEncryptionMode = nil
+	room.EncryptionRequestedBy = nil
+	return "room.encryption_changed"
+}
+
+// publishToMembers sends an event to the user channel of every member of
+// room. Delivery is best effort.
+func (s *chatRoomService) publishToMembers(room *models.ChatRoom, eventType string, data any) {
+	memberIDs, err := s.repo.ListMemberIDs(room.ID.String())
+	if err != nil {
+		log.Printf("failed to publish %s event: %v", eventType, err)
+		return
+	}
+	for _, memberID := range memberIDs {
+		if err := s.events.PublishUserEvent(memberID, eventType, data); err != nil {
+			log.Printf("failed to publish %s event: %v", eventType, err)
+		}
+	}
+}
+
 func mapChatRoomToDTO(room *models.ChatRoom) dto.ChatRoomResponse {
 	// Map users from the room's Users association
 	users := make([]dto.UserBasic, len(room.Users))
 	for i, user := range room.Users {
 		users[i] = dto.UserBasic{
-			ID:       user.ID,
-			Username: user.Username,
+			ID:          user.ID,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
 		}
 	}
-	return dto.ChatRoomResponse{
-		ID:    room.ID,
-		Users: users,
+	response := dto.ChatRoomResponse{
+		ID:                 room.ID,
+		Users:              users,
+		MessageTTLSeconds:  room.MessageTTLSeconds,
+		RestrictForwarding: room.RestrictForwarding,
+		EncryptionMode:     room.EncryptionMode,
+	}
+	if room.RequestedEncryptionMode != nil {
+		response.RequestedEncryptionMode = *room.RequestedEncryptionMode
 	}
+	return response
 }
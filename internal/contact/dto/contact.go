@@ -0,0 +1,47 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type UserBasic struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name,omitempty"`
+	// AvatarURL is the thumbnail, left out if the user hides their photo
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+type SendContactRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+}
+
+type ListContactRequestsQuery struct {
+	// Direction is "incoming" (the default) or "outgoing"
+	Direction string `form:"direction" binding:"omitempty,oneof=incoming outgoing"`
+}
+
+type ContactRequestResponse struct {
+	ID          uuid.UUID  `json:"id"`
+	From        UserBasic  `json:"from"`
+	To          UserBasic  `json:"to"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
+
+type ContactResponse struct {
+	User UserBasic `json:"user"`
+	// Online and LastSeen are false and empty when the contact hides them
+	Online   bool       `json:"online"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+	// Since is when the contact request was accepted
+	Since time.Time `json:"since"`
+}
+
+type BlockResponse struct {
+	User      UserBasic `json:"user"`
+	BlockedAt time.Time `json:"blocked_at"`
+}
@@ -0,0 +1,167 @@
+package contact
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mozho_chat/internal/contact/dto"
+	"mozho_chat/pkg/middleware"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
+	r := rg.Group("/contacts", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(middleware.MaxJSONBodySize))
+	{
+		r.GET("", h.ListContacts)
+		r.DELETE("/:id", h.RemoveContact)
+		r.GET("/requests", h.ListRequests)
+		r.POST("/requests", h.SendRequest)
+		r.POST("/requests/:id/accept", h.AcceptRequest)
+		r.POST("/requests/:id/decline", h.DeclineRequest)
+		r.DELETE("/requests/:id", h.CancelRequest)
+	}
+
+	blocks := rg.Group("/blocks", middleware.AuthMiddleware())
+	{
+		blocks.GET("", h.ListBlocks)
+		blocks.PUT("/:id", h.BlockUser)
+		blocks.DELETE("/:id", h.UnblockUser)
+	}
+}
+
+// writeError maps service errors to status codes.
+func writeError(c *gin.Context, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, errUserNotFound), errors.Is(err, errRequestNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, errAlreadyContacts), errors.Is(err, errRequestPending):
+		status = http.StatusConflict
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
+
+func (h *Handler) ListContacts(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	contacts, err := h.service.ListContacts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, contacts)
+}
+
+func (h *Handler) RemoveContact(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.RemoveContact(userID, c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListRequests(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var query dto.ListContactRequestsQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	requests, err := h.service.ListRequests(userID, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, requests)
+}
+
+func (h *Handler) SendRequest(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.SendContactRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, err := h.service.SendRequest(userID, req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, request)
+}
+
+func (h *Handler) AcceptRequest(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	contact, err := h.service.AcceptRequest(userID, c.Param("id"))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, contact)
+}
+
+func (h *Handler) DeclineRequest(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.DeclineRequest(userID, c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) CancelRequest(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.CancelRequest(userID, c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListBlocks(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	blocks, err := h.service.ListBlocks(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, blocks)
+}
+
+func (h *Handler) BlockUser(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.BlockUser(userID, c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) UnblockUser(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.UnblockUser(userID, c.Param("id")); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,314 @@
+package contact
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/contact/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+)
+
+// declinedRequestCooldown is how long after a decline the same request
+// cannot be sent again.
+const declinedRequestCooldown = 7 * 24 * time.Hour
+
+var (
+	errUserNotFound    = errors.New("user not found")
+	errRequestNotFound = errors.New("contact request not found")
+	errAlreadyContacts = errors.New("already a contact")
+	errRequestPending  = errors.New("a contact request is already pending")
+)
+
+// Presence tells what one user may see of another's online status and
+// last seen time. presence.Service implements it.
+type Presence interface {
+	Visible(viewer, subject *models.User, isContact bool) (bool, *time.Time, error)
+}
+
+type Service interface {
+	SendRequest(userID string, input dto.SendContactRequest) (*dto.ContactRequestResponse, error)
+	ListRequests(userID string, input dto.ListContactRequestsQuery) ([]dto.ContactRequestResponse, error)
+	AcceptRequest(userID, requestID string) (*dto.ContactResponse, error)
+	DeclineRequest(userID, requestID string) error
+	CancelRequest(userID, requestID string) error
+	ListContacts(userID string) ([]dto.ContactResponse, error)
+	RemoveContact(userID, contactID string) error
+
+	BlockUser(userID, blockedID string) error
+	UnblockUser(userID, blockedID string) error
+	ListBlocks(userID string) ([]dto.BlockResponse, error)
+}
+
+type contactService struct {
+	repo      repository.ContactRepository
+	blockRepo repository.BlockRepository
+	userRepo  repository.UserRepository
+	presence  Presence
+}
+
+func NewService(repo repository.ContactRepository, blockRepo repository.BlockRepository, userRepo repository.UserRepository, presence Presence) Service {
+	return &contactService{repo: repo, blockRepo: blockRepo, userRepo: userRepo, presence: presence}
+}
+
+// SendRequest asks another user to become a contact. If they already
+// asked the caller, their request is accepted instead.
+func (s *contactService) SendRequest(userID string, input dto.SendContactRequest) (*dto.ContactRequestResponse, error) {
+	otherID := input.UserID.String()
+	if otherID == userID {
+		return nil, errors.New("cannot add yourself as a contact")
+	}
+	other, err := s.userRepo.FindByID(otherID)
+	if err != nil || other.Placeholder {
+		return nil, errUserNotFound
+	}
+	blocked, err := s.blockRepo.IsBlockedEither(userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		// Blocked users see the same answer as for a missing account
+		return nil, errUserNotFound
+	}
+
+	contacts, err := s.repo.AreContacts(userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if contacts {
+		return nil, errAlreadyContacts
+	}
+
+	now := time.Now()
+	pending, err := s.repo.FindPendingRequest(userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		if pending.RequesterID.String() == userID {
+			return nil, errRequestPending
+		}
+		if _, err := s.repo.AcceptRequest(pending.ID.String(), now); err != nil {
+			return nil, err
+		}
+		return s.requestResponse(pending.ID.String())
+	}
+
+	latest, err := s.repo.FindLatestRequest(userID, otherID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil && latest.Status == models.ContactRequestDeclined && latest.RespondedAt != nil &&
+		now.Before(latest.RespondedAt.Add(declinedRequestCooldown)) {
+		// Looks the same as a request that is still waiting, so the
+		// requester is not told they were declined
+		return nil, errRequestPending
+	}
+
+	req := &models.ContactRequest{
+		RequesterID: uuid.MustParse(userID),
+		AddresseeID: other.ID,
+		Status:      models.ContactRequestPending,
+	}
+	if err := s.repo.CreateRequest(req); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, errRequestPending
+		}
+		return nil, err
+	}
+	return s.requestResponse(req.ID.String())
+}
+
+func (s *contactService) ListRequests(userID string, input dto.ListContactRequestsQuery) ([]dto.ContactRequestResponse, error) {
+	reqs, err := s.repo.ListPendingRequests(userID, input.Direction != "outgoing")
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.ContactRequestResponse, len(reqs))
+	for i := range reqs {
+		res[i] = mapRequestToDTO(&reqs[i])
+	}
+	return res, nil
+}
+
+// AcceptRequest accepts a request sent to the caller and returns the new
+// contact.
+func (s *contactService) AcceptRequest(userID, requestID string) (*dto.ContactResponse, error) {
+	req, err := s.findRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if req.AddresseeID.String() != userID || req.Status != models.ContactRequestPending {
+		return nil, errRequestNotFound
+	}
+	now := time.Now()
+	accepted, err := s.repo.AcceptRequest(requestID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !accepted {
+		return nil, errRequestNotFound
+	}
+
+	online, lastSeen, err := s.presence.Visible(&req.Addressee, &req.Requester, true)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.ContactResponse{User: mapUserToDTO(&req.Requester, true), Online: online, LastSeen: lastSeen, Since: now}, nil
+}
+
+func (s *contactService) DeclineRequest(userID, requestID string) error {
+	req, err := s.findRequest(requestID)
+	if err != nil {
+		return err
+	}
+	if req.AddresseeID.String() != userID {
+		return errRequestNotFound
+	}
+	declined, err := s.repo.DeclineRequest(requestID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !declined {
+		return errRequestNotFound
+	}
+	return nil
+}
+
+func (s *contactService) CancelRequest(userID, requestID string) error {
+	if _, err := uuid.Parse(requestID); err != nil {
+		return errRequestNotFound
+	}
+	cancelled, err := s.repo.CancelRequest(requestID, userID)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return errRequestNotFound
+	}
+	return nil
+}
+
+func (s *contactService) ListContacts(userID string) ([]dto.ContactResponse, error) {
+	viewer, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := s.repo.ListContacts(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.ContactResponse, len(contacts))
+	for i, c := range contacts {
+		online, lastSeen, err := s.presence.Visible(viewer, &c.ContactUser, true)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = dto.ContactResponse{User: mapUserToDTO(&c.ContactUser, true), Online: online, LastSeen: lastSeen, Since: c.CreatedAt}
+	}
+	return res, nil
+}
+
+// RemoveContact removes the contact for both users.
+func (s *contactService) RemoveContact(userID, contactID string) error {
+	if _, err := uuid.Parse(contactID); err != nil {
+		return errUserNotFound
+	}
+	removed, err := s.repo.RemoveContact(userID, contactID)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return errUserNotFound
+	}
+	return nil
+}
+
+// BlockUser blocks another user. Any contact or open contact request
+// between the two is removed.
+func (s *contactService) BlockUser(userID, blockedID string) error {
+	if _, err := uuid.Parse(blockedID); err != nil {
+		return errUserNotFound
+	}
+	if blockedID == userID {
+		return errors.New("cannot block yourself")
+	}
+	if _, err := s.userRepo.FindByID(blockedID); err != nil {
+		return errUserNotFound
+	}
+	return s.blockRepo.Create(userID, blockedID, time.Now())
+}
+
+func (s *contactService) UnblockUser(userID, blockedID string) error {
+	if _, err := uuid.Parse(blockedID); err != nil {
+		return errUserNotFound
+	}
+	unblocked, err := s.blockRepo.Delete(userID, blockedID)
+	if err != nil {
+		return err
+	}
+	if !unblocked {
+		return errUserNotFound
+	}
+	return nil
+}
+
+func (s *contactService) ListBlocks(userID string) ([]dto.BlockResponse, error) {
+	blocks, err := s.blockRepo.ListByBlocker(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.BlockResponse, len(blocks))
+	for i, b := range blocks {
+		res[i] = dto.BlockResponse{User: mapUserToDTO(&b.BlockedUser, false), BlockedAt: b.CreatedAt}
+	}
+	return res, nil
+}
+
+func (s *contactService) findRequest(requestID string) (*models.ContactRequest, error) {
+	if _, err := uuid.Parse(requestID); err != nil {
+		return nil, errRequestNotFound
+	}
+	req, err := s.repo.FindRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if req == nil {
+		return nil, errRequestNotFound
+	}
+	return req, nil
+}
+
+func (s *contactService) requestResponse(requestID string) (*dto.ContactRequestResponse, error) {
+	req, err := s.findRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	res := mapRequestToDTO(req)
+	return &res, nil
+}
+
+func mapRequestToDTO(req *models.ContactRequest) dto.ContactRequestResponse {
+	accepted := req.Status == models.ContactRequestAccepted
+	return dto.ContactRequestResponse{
+		ID:          req.ID,
+		From:        mapUserToDTO(&req.Requester, accepted),
+		To:          mapUserToDTO(&req.Addressee, accepted),
+		Status:      req.Status,
+		CreatedAt:   req.CreatedAt,
+		RespondedAt: req.RespondedAt,
+	}
+}
+
+// mapUserToDTO shows the user's avatar if their privacy settings allow it
+// for the relationship.
+func mapUserToDTO(user *models.User, isContact bool) dto.UserBasic {
+	res := dto.UserBasic{ID: user.ID, Username: user.Username, DisplayName: user.DisplayName}
+	if user.ProfilePhotoVisible(isContact) {
+		res.AvatarURL = user.AvatarThumbnail()
+	}
+	return res
+}
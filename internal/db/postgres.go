@@ -13,7 +13,10 @@ var DB *gorm.DB
 
 func InitPostgres(cfg *config.Config) *gorm.DB {
 	var err error
-	DB, err = gorm.Open(postgres.Open(cfg.PostgresURL), &gorm.Config{})
+	DB, err = gorm.Open(postgres.Open(cfg.PostgresURL), &gorm.Config{
+		// Surface unique violations as gorm.ErrDuplicatedKey
+		TranslateError: true,
+	})
 	if err != nil {
 		log.Fatalf("Failed to connect to Postgres: %v", err)
 	}
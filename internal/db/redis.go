@@ -0,0 +1,28 @@
+package db
+
+import (
+	"log"
+	"net"
+
+	"mozho_chat/internal/config"
+	redisdb "mozho_chat/internal/db/redis"
+)
+
+func InitRedis(cfg *config.Config) *redisdb.RedisClient {
+	host, port, err := net.SplitHostPort(cfg.RedisAddr)
+	if err != nil {
+		log.Fatalf("Invalid REDIS_ADDR %q: %v", cfg.RedisAddr, err)
+	}
+
+	client, err := redisdb.NewRedisClient(redisdb.Config{
+		Host:     host,
+		Port:     port,
+		Password: cfg.RedisPass,
+		DB:       cfg.RedisDB,
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+
+	return client
+}
@@ -1,14 +1,47 @@
 package redisdb
 
 import (
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 func (r *RedisClient) SetUserOnline(userID string, ttl time.Duration) error {
 	return r.Client.Set(r.Ctx, fmt.Sprintf("user:online:%s", userID), "1", ttl).Err()
 }
 
+// MarkUserOnline refreshes the user's online flag and last seen time. It
+// reports whether the user was offline before.
+func (r *RedisClient) MarkUserOnline(userID string, ttl time.Duration, now time.Time) (bool, error) {
+	var exists *redis.IntCmd
+	_, err := r.Client.TxPipelined(r.Ctx, func(pipe redis.Pipeliner) error {
+		key := fmt.Sprintf("user:online:%s", userID)
+		exists = pipe.Exists(r.Ctx, key)
+		pipe.Set(r.Ctx, key, "1", ttl)
+		pipe.Set(r.Ctx, fmt.Sprintf("user:last_seen:%s", userID), now.Unix(), 0)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists.Val() == 0, nil
+}
+
+// LastSeen returns when the user was last marked online, or nil if never.
+func (r *RedisClient) LastSeen(userID string) (*time.Time, error) {
+	unix, err := r.Client.Get(r.Ctx, fmt.Sprintf("user:last_seen:%s", userID)).Int64()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := time.Unix(unix, 0).UTC()
+	return &t, nil
+}
+
 func (r *RedisClient) IsUserOnline(userID string) (bool, error) {
 	val, err := r.Client.Exists(r.Ctx, fmt.Sprintf("user:online:%s", userID)).Result()
 	return val == 1, err
@@ -21,3 +54,18 @@ func (r *RedisClient) SetSessionToken(token string, userID string, ttl time.Dura
 func (r *RedisClient) GetUserIDFromToken(token string) (string, error) {
 	return r.Client.Get(r.Ctx, fmt.Sprintf("session:%s", token)).Result()
 }
+
+func revokedSessionToken(sessionID string) string {
+	return "revoked:" + sessionID
+}
+
+// RevokeSession rejects access tokens issued for the session. ttl only
+// needs to outlive the longest-lived access token.
+func (r *RedisClient) RevokeSession(sessionID, userID string, ttl time.Duration) error {
+	return r.SetSessionToken(revokedSessionToken(sessionID), userID, ttl)
+}
+
+func (r *RedisClient) IsSessionRevoked(sessionID string) (bool, error) {
+	val, err := r.Client.Exists(r.Ctx, fmt.Sprintf("session:%s", revokedSessionToken(sessionID))).Result()
+	return val == 1, err
+}
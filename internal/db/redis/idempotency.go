@@ -0,0 +1,33 @@
+package redisdb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ReserveIdempotencyKey stores value under the key unless it already exists.
+// It reports whether the reservation succeeded.
+func (r *RedisClient) ReserveIdempotencyKey(key, value string, ttl time.Duration) (bool, error) {
+	return r.Client.SetNX(r.Ctx, fmt.Sprintf("idempotency:%s", key), value, ttl).Result()
+}
+
+// GetIdempotencyRecord returns the stored record, or an empty string if the
+// key is unknown.
+func (r *RedisClient) GetIdempotencyRecord(key string) (string, error) {
+	val, err := r.Client.Get(r.Ctx, fmt.Sprintf("idempotency:%s", key)).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return val, err
+}
+
+func (r *RedisClient) SaveIdempotencyRecord(key, value string, ttl time.Duration) error {
+	return r.Client.Set(r.Ctx, fmt.Sprintf("idempotency:%s", key), value, ttl).Err()
+}
+
+func (r *RedisClient) DeleteIdempotencyKey(key string) error {
+	return r.Client.Del(r.Ctx, fmt.Sprintf("idempotency:%s", key)).Err()
+}
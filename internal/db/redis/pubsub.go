@@ -2,11 +2,18 @@ package redisdb
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// Event is the envelope published on room and user channels.
+type Event struct {
+	Type string `json:"type"`
+	Data any    `json:"data"`
+}
+
 func (r *RedisClient) PublishMessage(roomID string, message string) error {
 	channel := fmt.Sprintf("chat:room:%s", roomID)
 	return r.Client.Publish(r.Ctx, channel, message).Err()
@@ -16,3 +23,27 @@ func (r *RedisClient) SubscribeRoom(ctx context.Context, roomID string) *redis.P
 	channel := fmt.Sprintf("chat:room:%s", roomID)
 	return r.Client.Subscribe(ctx, channel)
 }
+
+// PublishRoomEvent broadcasts a typed event to everyone subscribed to the room.
+func (r *RedisClient) PublishRoomEvent(roomID, eventType string, data any) error {
+	payload, err := json.Marshal(Event{Type: eventType, Data: data})
+	if err != nil {
+		return err
+	}
+	return r.PublishMessage(roomID, string(payload))
+}
+
+// PublishUserEvent delivers a typed event to a single user's channel.
+func (r *RedisClient) PublishUserEvent(userID, eventType string, data any) error {
+	payload, err := json.Marshal(Event{Type: eventType, Data: data})
+	if err != nil {
+		return err
+	}
+	channel := fmt.Sprintf("chat:user:%s", userID)
+	return r.Client.Publish(r.Ctx, channel, string(payload)).Err()
+}
+
+func (r *RedisClient) SubscribeUser(ctx context.Context, userID string) *redis.PubSub {
+	channel := fmt.Sprintf("chat:user:%s", userID)
+	return r.Client.Subscribe(ctx, channel)
+}
@@ -0,0 +1,88 @@
+package redisdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func slidingWindowKey(key string) string {
+	return fmt.Sprintf("ratelimit:%s", key)
+}
+
+func lockKey(key string) string {
+	return fmt.Sprintf("lock:%s", key)
+}
+
+// HitSlidingWindow records an event for key and returns how many events
+// happened within the last window, this one included.
+func (r *RedisClient) HitSlidingWindow(key string, now time.Time, window time.Duration) (int64, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+	k := slidingWindowKey(key)
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + hex.EncodeToString(suffix)
+
+	var count *redis.IntCmd
+	_, err := r.Client.TxPipelined(r.Ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(r.Ctx, k, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+		pipe.ZAdd(r.Ctx, k, redis.Z{Score: float64(now.UnixNano()), Member: member})
+		count = pipe.ZCard(r.Ctx, k)
+		pipe.PExpire(r.Ctx, k, window)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count.Val(), nil
+}
+
+// SlidingWindow returns how many events for key happened within the last
+// window, and when the latest one did.
+func (r *RedisClient) SlidingWindow(key string, now time.Time, window time.Duration) (int64, time.Time, error) {
+	k := slidingWindowKey(key)
+	var count *redis.IntCmd
+	var latest *redis.ZSliceCmd
+	_, err := r.Client.TxPipelined(r.Ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(r.Ctx, k, "-inf", strconv.FormatInt(now.Add(-window).UnixNano(), 10))
+		count = pipe.ZCard(r.Ctx, k)
+		latest = pipe.ZRangeWithScores(r.Ctx, k, -1, -1)
+		return nil
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	var last time.Time
+	if z := latest.Val(); len(z) == 1 {
+		last = time.Unix(0, int64(z[0].Score))
+	}
+	return count.Val(), last, nil
+}
+
+func (r *RedisClient) ResetSlidingWindow(key string) error {
+	return r.Client.Del(r.Ctx, slidingWindowKey(key)).Err()
+}
+
+// Lock marks key as locked for ttl.
+func (r *RedisClient) Lock(key string, ttl time.Duration) error {
+	return r.Client.Set(r.Ctx, lockKey(key), "1", ttl).Err()
+}
+
+// LockedFor returns how long key stays locked, or zero if it is not.
+func (r *RedisClient) LockedFor(key string) (time.Duration, error) {
+	ttl, err := r.Client.PTTL(r.Ctx, lockKey(key)).Result()
+	if errors.Is(err, redis.Nil) || ttl < 0 {
+		return 0, nil
+	}
+	return ttl, err
+}
+
+func (r *RedisClient) Unlock(key string) error {
+	return r.Client.Del(r.Ctx, lockKey(key)).Err()
+}
@@ -0,0 +1,204 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// archiveRoom describes the exported room in messages.json.
+type archiveRoom struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name,omitempty"`
+	IsGroup    bool      `json:"is_group"`
+	ExportedAt time.Time `json:"exported_at"`
+	ExportedBy string    `json:"exported_by"`
+}
+
+type archiveAttachment struct {
+	FileName string `json:"file_name"`
+	MimeType string `json:"mime_type"`
+	Size     int64  `json:"size"`
+	// Path is the file's location inside the archive
+	Path string `json:"path,omitempty"`
+	// Missing is set when the file could no longer be read from storage
+	Missing bool `json:"missing,omitempty"`
+}
+
+type archiveMessage struct {
+	ID       string  `json:"id"`
+	SenderID string  `json:"sender_id"`
+	Sender   string  `json:"sender"`
+	Type     string  `json:"type"`
+	Text     *string `json:"text"`
+	// Encrypted marks end-to-end encrypted messages the server cannot read
+	Encrypted     bool                `json:"encrypted"`
+	Payload       json.RawMessage     `json:"payload,omitempty"`
+	Attachments   []archiveAttachment `json:"attachments,omitempty"`
+	ForwardedFrom string              `json:"forwarded_from,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+}
+
+type archive struct {
+	Room     archiveRoom      `json:"room"`
+	Messages []archiveMessage `json:"messages"`
+}
+
+// attachmentOpener streams an attachment from storage.
+type attachmentOpener func(key string) (io.ReadCloser, error)
+
+// pendingAttachment ties an archive entry to the storage key it is read from.
+type pendingAttachment struct {
+	key   string
+	entry *archiveAttachment
+}
+
+// writeArchive writes the zip: messages.json, transcript.html,
+// transcript.txt and the attachments under attachments/.
+func writeArchive(w io.Writer, a *archive, files []pendingAttachment, open attachmentOpener) error {
+	zw := zip.NewWriter(w)
+
+	// Attachments go first so missing files are flagged in the transcripts
+	for _, f := range files {
+		if err := copyAttachment(zw, f, open); err != nil {
+			return err
+		}
+	}
+
+	if err := writeJSON(zw, a); err != nil {
+		return err
+	}
+	if err := writeHTML(zw, a); err != nil {
+		return err
+	}
+	if err := writeText(zw, a); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func copyAttachment(zw *zip.Writer, f pendingAttachment, open attachmentOpener) error {
+	src, err := open(f.key)
+	if err != nil {
+		f.entry.Missing = true
+		f.entry.Path = ""
+		return nil
+	}
+	defer src.Close()
+
+	dst, err := zw.Create(f.entry.Path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	return err
+}
+
+func writeJSON(zw *zip.Writer, a *archive) error {
+	w, err := zw.Create("messages.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(a)
+}
+
+func writeText(zw *zip.Writer, a *archive) error {
+	w, err := zw.Create("transcript.txt")
+	if err != nil {
+		return err
+	}
+	for _, m := range a.Messages {
+		line := fmt.Sprintf("[%s] %s: %s\n", m.CreatedAt.UTC().Format("2006-01-02 15:04:05"), m.Sender, describe(m))
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+		for _, att := range m.Attachments {
+			if _, err := fmt.Fprintf(w, "    attachment: %s\n", attachmentLabel(att)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeHTML(zw *zip.Writer, a *archive) error {
+	w, err := zw.Create("transcript.html")
+	if err != nil {
+		return err
+	}
+	return transcriptTemplate.Execute(w, a)
+}
+
+// describe renders the body of a message as a single line of text.
+func describe(m archiveMessage) string {
+	switch {
+	case m.Encrypted:
+		return "[end-to-end encrypted message]"
+	case m.Text != nil && *m.Text != "":
+		return strings.ReplaceAll(*m.Text, "\n", "\n    ")
+	case len(m.Payload) > 0:
+		return fmt.Sprintf("[%s] %s", m.Type, string(m.Payload))
+	}
+	return ""
+}
+
+func attachmentLabel(a archiveAttachment) string {
+	if a.Missing {
+		return a.FileName + " (missing)"
+	}
+	return a.Path
+}
+
+// attachmentPath names the file inside the archive; the id prefix keeps
+// files with the same name apart.
+func attachmentPath(id, fileName string) string {
+	name := path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	if name == "." || name == "/" || name == "" {
+		name = "file"
+	}
+	return "attachments/" + id + "-" + name
+}
+
+var transcriptTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"describe":  describe,
+	"timestamp": func(t time.Time) string { return t.UTC().Format("2006-01-02 15:04:05 UTC") },
+	"isImage":   func(a archiveAttachment) bool { return strings.HasPrefix(a.MimeType, "image/") && !a.Missing },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Chat export {{.Room.ID}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, sans-serif; max-width: 820px; margin: 2rem auto; padding: 0 1rem; color: #1d1d1f; }
+header { border-bottom: 1px solid #ddd; margin-bottom: 1rem; }
+.message { padding: .5rem 0; border-bottom: 1px solid #f0f0f0; }
+.meta { color: #6e6e73; font-size: .85rem; }
+.sender { font-weight: 600; color: #1d1d1f; }
+.body { white-space: pre-wrap; margin-top: .25rem; }
+.encrypted, .missing { color: #8e8e93; font-style: italic; }
+.forwarded { color: #6e6e73; font-size: .8rem; }
+img { max-width: 320px; display: block; margin-top: .25rem; border-radius: 4px; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{if .Room.Name}}{{.Room.Name}}{{else}}Conversation{{end}}</h1>
+<p class="meta">Room {{.Room.ID}} &middot; exported {{timestamp .Room.ExportedAt}} &middot; {{len .Messages}} messages</p>
+</header>
+{{range .Messages}}<div class="message" id="m-{{.ID}}">
+<div class="meta"><span class="sender">{{.Sender}}</span> &middot; {{timestamp .CreatedAt}}</div>
+{{if .ForwardedFrom}}<div class="forwarded">Forwarded</div>{{end}}
+{{if .Encrypted}}<div class="body encrypted">End-to-end encrypted message</div>{{else}}<div class="body">{{describe .}}</div>{{end}}
+{{range .Attachments}}{{if .Missing}}<div class="missing">{{.FileName}} (missing)</div>{{else if isImage .}}<a href="{{.Path}}"><img src="{{.Path}}" alt="{{.FileName}}"></a>{{else}}<div><a href="{{.Path}}">{{.FileName}}</a></div>{{end}}
+{{end}}</div>
+{{end}}
+</body>
+</html>
+`))
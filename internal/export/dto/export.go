@@ -0,0 +1,47 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/models"
+)
+
+type CreateExportRequest struct {
+	ChatRoomID uuid.UUID `json:"chat_room_id" binding:"required"`
+}
+
+type ExportJobResponse struct {
+	ID           uuid.UUID  `json:"id"`
+	ChatRoomID   uuid.UUID  `json:"chat_room_id"`
+	Status       string     `json:"status"`
+	MessageCount int        `json:"message_count"`
+	Size         int64      `json:"size"`
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	// ExpiresAt is when the archive is deleted
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// DownloadURL is a presigned link, valid until DownloadURLExpiresAt
+	DownloadURL          string     `json:"download_url,omitempty"`
+	DownloadURLExpiresAt *time.Time `json:"download_url_expires_at,omitempty"`
+}
+
+func NewExportJobResponse(job *models.ExportJob) *ExportJobResponse {
+	res := &ExportJobResponse{
+		ID:           job.ID,
+		ChatRoomID:   job.ChatRoomID,
+		Status:       job.Status,
+		MessageCount: job.MessageCount,
+		Size:         job.Size,
+		CreatedAt:    job.CreatedAt,
+		CompletedAt:  job.CompletedAt,
+		ExpiresAt:    job.ExpiresAt,
+	}
+	// Errors of attempts that will be retried are not interesting to the user
+	if job.Status == models.ExportStatusFailed && job.Error != nil {
+		res.Error = *job.Error
+	}
+	return res
+}
@@ -0,0 +1,30 @@
+package export
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartExporter builds queued exports and deletes expired archives every
+// interval until ctx is done.
+func StartExporter(ctx context.Context, service Service, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := service.ProcessExports(ctx); err != nil {
+					log.Printf("exporter: failed to process exports: %v", err)
+				}
+				if _, err := service.PurgeExpiredExports(ctx); err != nil {
+					log.Printf("exporter: failed to purge expired exports: %v", err)
+				}
+			}
+		}
+	}()
+}
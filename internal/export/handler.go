@@ -0,0 +1,77 @@
+package export
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mozho_chat/internal/export/dto"
+	"mozho_chat/pkg/middleware"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
+	r := rg.Group("/exports", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(middleware.MaxJSONBodySize))
+	{
+		r.POST("", h.RequestExport)
+		r.GET("", h.ListExports)
+		r.GET("/:id", h.GetExport)
+	}
+}
+
+func (h *Handler) RequestExport(c *gin.Context) {
+	var req dto.CreateExportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	job, err := h.service.RequestExport(userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *Handler) ListExports(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	jobs, err := h.service.ListExports(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+func (h *Handler) GetExport(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	job, err := h.service.GetExport(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
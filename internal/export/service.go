@@ -0,0 +1,451 @@
+package export
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/export/dto"
+	"mozho_chat/internal/message"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/pkg/encryption"
+	s3upload "mozho_chat/pkg/s3"
+)
+
+const (
+	// exportLease is how long a worker owns a job before another may retry it.
+	exportLease = 15 * time.Minute
+	// maxExportAttempts is how many times a job is tried before it fails.
+	maxExportAttempts = 3
+	// exportRetention is how long a finished archive can be downloaded.
+	exportRetention = 7 * 24 * time.Hour
+	// downloadURLExpiry is how long a presigned download link is valid.
+	downloadURLExpiry = 15 * time.Minute
+	// exportPageSize is how many messages are read per query.
+	exportPageSize = 200
+	// maxListedExports caps ListExports.
+	maxListedExports = 50
+	// purgeBatchSize caps how many expired archives one purge run deletes.
+	purgeBatchSize = 100
+)
+
+type Service interface {
+	RequestExport(userID string, input dto.CreateExportRequest) (*dto.ExportJobResponse, error)
+	GetExport(userID, exportID string) (*dto.ExportJobResponse, error)
+	ListExports(userID string) ([]dto.ExportJobResponse, error)
+	ProcessExports(ctx context.Context) (int, error)
+	PurgeExpiredExports(ctx context.Context) (int, error)
+}
+
+// UserEvents delivers events to a single user's channel.
+// *redisdb.RedisClient implements it.
+type UserEvents interface {
+	PublishUserEvent(userID, eventType string, data any) error
+}
+
+type exportService struct {
+	repo        repository.ExportJobRepository
+	messageRepo repository.MessageRepository
+	roomRepo    repository.ChatRoomRepository
+	userRepo    repository.UserRepository
+	auditRepo   repository.AuditRepository
+	s3Service   s3upload.Service
+	encryption  encryption.EncryptionService
+	events      UserEvents
+}
+
+func NewService(
+	repo repository.ExportJobRepository,
+	messageRepo repository.MessageRepository,
+	roomRepo repository.ChatRoomRepository,
+	userRepo repository.UserRepository,
+	auditRepo repository.AuditRepository,
+	s3Service s3upload.Service,
+	encryption encryption.EncryptionService,
+	events UserEvents,
+) Service {
+	return &exportService{
+		repo:        repo,
+		messageRepo: messageRepo,
+		roomRepo:    roomRepo,
+		userRepo:    userRepo,
+		auditRepo:   auditRepo,
+		s3Service:   s3Service,
+		encryption:  encryption,
+		events:      events,
+	}
+}
+
+// RequestExport queues an export of a room. Members may export their own
+// rooms; compliance and admin users may export any room, which is recorded
+// in the audit log. Asking again while an export is still in progress
+// returns that export.
+func (s *exportService) RequestExport(userID string, input dto.CreateExportRequest) (*dto.ExportJobResponse, error) {
+	roomID := input.ChatRoomID.String()
+	outsider, err := s.checkAccess(roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := s.repo.FindActive(roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if active != nil {
+		return dto.NewExportJobResponse(active), nil
+	}
+
+	now := time.Now()
+	job := &models.ExportJob{
+		ID:          uuid.New(),
+		ChatRoomID:  input.ChatRoomID,
+		RequestedBy: uuid.MustParse(userID),
+		Status:      models.ExportStatusPending,
+		RunAfter:    now,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if outsider != nil {
+		if err := s.auditExport(job, outsider); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.Create(job); err != nil {
+		return nil, err
+	}
+	return dto.NewExportJobResponse(job), nil
+}
+
+// GetExport returns an export of the caller's, with a fresh download link
+// once the archive is ready.
+func (s *exportService) GetExport(userID, exportID string) (*dto.ExportJobResponse, error) {
+	if _, err := uuid.Parse(exportID); err != nil {
+		return nil, errors.New("export not found")
+	}
+	job, err := s.repo.FindByID(exportID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("export not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if job.RequestedBy.String() != userID {
+		return nil, errors.New("export not found")
+	}
+
+	res := dto.NewExportJobResponse(job)
+	if job.Status == models.ExportStatusCompleted && job.ObjectKey != nil {
+		url, err := s.s3Service.GetPrivateSignedUrl(*job.ObjectKey, downloadURLExpiry)
+		if err != nil {
+			return nil, err
+		}
+		expiresAt := time.Now().Add(downloadURLExpiry)
+		res.DownloadURL = url
+		res.DownloadURLExpiresAt = &expiresAt
+	}
+	return res, nil
+}
+
+func (s *exportService) ListExports(userID string) ([]dto.ExportJobResponse, error) {
+	jobs, err := s.repo.FindByRequester(userID, maxListedExports)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.ExportJobResponse, 0, len(jobs))
+	for i := range jobs {
+		res = append(res, *dto.NewExportJobResponse(&jobs[i]))
+	}
+	return res, nil
+}
+
+// checkAccess checks the user may export the room. For compliance and admin
+// users exporting a room they are not in, it returns the user so the export
+// can be audited.
+func (s *exportService) checkAccess(roomID, userID string) (*models.User, error) {
+	inRoom, err := s.roomRepo.IsUserInRoom(roomID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if inRoom {
+		return nil, nil
+	}
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role != models.UserRoleCompliance && user.Role != models.UserRoleAdmin {
+		return nil, errors.New("user not in room")
+	}
+	if _, err := s.roomRepo.FindByID(roomID); err != nil {
+		return nil, errors.New("chat room not found")
+	}
+	return user, nil
+}
+
+// auditExport records an export by someone outside the room. The export is
+// not queued when it cannot be recorded.
+func (s *exportService) auditExport(job *models.ExportJob, user *models.User) error {
+	details, err := json.Marshal(map[string]any{
+		"chat_room_id": job.ChatRoomID,
+		"export_id":    job.ID,
+		"role":         user.Role,
+	})
+	if err != nil {
+		return err
+	}
+	return s.auditRepo.Create(&models.AuditEvent{
+		Event:   models.AuditEventRoomExportedByNonMember,
+		ActorID: &user.ID,
+		Details: details,
+	})
+}
+
+// ProcessExports builds the archive for every runnable export and returns
+// how many were completed.
+func (s *exportService) ProcessExports(ctx context.Context) (int, error) {
+	completed := 0
+	for {
+		if ctx.Err() != nil {
+			return completed, nil
+		}
+
+		job, err := s.repo.ClaimNext(ctx, time.Now(), exportLease)
+		if err != nil {
+			return completed, err
+		}
+		if job == nil {
+			return completed, nil
+		}
+
+		if err := s.runExport(job); err != nil {
+			s.failAttempt(job, err)
+			continue
+		}
+		completed++
+		s.notify(job, "export.completed")
+	}
+}
+
+func (s *exportService) failAttempt(job *models.ExportJob, cause error) {
+	log.Printf("export %s: attempt %d failed: %v", job.ID, job.Attempts, cause)
+
+	if job.Attempts < maxExportAttempts {
+		backoff := time.Duration(job.Attempts) * time.Minute
+		if err := s.repo.Retry(job.ID.String(), cause.Error(), time.Now().Add(backoff)); err != nil {
+			log.Printf("export %s: failed to reschedule: %v", job.ID, err)
+		}
+		return
+	}
+
+	if err := s.repo.Fail(job.ID.String(), cause.Error()); err != nil {
+		log.Printf("export %s: failed to mark as failed: %v", job.ID, err)
+		return
+	}
+	reason := cause.Error()
+	job.Status = models.ExportStatusFailed
+	job.Error = &reason
+	s.notify(job, "export.failed")
+}
+
+func (s *exportService) notify(job *models.ExportJob, eventType string) {
+	if err := s.events.PublishUserEvent(job.RequestedBy.String(), eventType, dto.NewExportJobResponse(job)); err != nil {
+		log.Printf("export %s: failed to publish %s: %v", job.ID, eventType, err)
+	}
+}
+
+// runExport writes the archive to a temporary file, so large rooms are never
+// held in memory, and uploads it to the private bucket.
+func (s *exportService) runExport(job *models.ExportJob) error {
+	room, err := s.roomRepo.FindByID(job.ChatRoomID.String())
+	if err != nil {
+		return fmt.Errorf("load room: %w", err)
+	}
+
+	a, files, err := s.collect(room, job)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	open := func(key string) (io.ReadCloser, error) {
+		return s.s3Service.OpenFile(key, false)
+	}
+	if err := writeArchive(tmp, a, files, open); err != nil {
+		return fmt.Errorf("write archive: %w", err)
+	}
+
+	size, err := tmp.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("exports/%s/%s.zip", job.ChatRoomID, job.ID)
+	if err := s.s3Service.UploadObject(key, tmp, "application/zip", false); err != nil {
+		return fmt.Errorf("upload archive: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(exportRetention)
+	job.Status = models.ExportStatusCompleted
+	job.ObjectKey = &key
+	job.MessageCount = len(a.Messages)
+	job.Size = size
+	job.Error = nil
+	job.CompletedAt = &now
+	job.ExpiresAt = &expiresAt
+	return s.repo.Complete(job)
+}
+
+// collect reads the whole room, oldest message first.
+func (s *exportService) collect(room *models.ChatRoom, job *models.ExportJob) (*archive, []pendingAttachment, error) {
+	var msgs []models.Message
+	seen := make(map[uuid.UUID]bool)
+	for offset := 0; ; offset += exportPageSize {
+		page, err := s.messageRepo.FindByChatRoom(room.ID.String(), exportPageSize, offset)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load messages: %w", err)
+		}
+		for _, msg := range page {
+			// Messages sent while paging shift the offsets, so a page may
+			// repeat rows from the previous one
+			if !seen[msg.ID] {
+				seen[msg.ID] = true
+				msgs = append(msgs, msg)
+			}
+		}
+		if len(page) < exportPageSize {
+			break
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		if msgs[i].CreatedAt.Equal(msgs[j].CreatedAt) {
+			return msgs[i].ID.String() < msgs[j].ID.String()
+		}
+		return msgs[i].CreatedAt.Before(msgs[j].CreatedAt)
+	})
+
+	a := &archive{
+		Room: archiveRoom{
+			ID:         room.ID.String(),
+			Name:       room.Name,
+			IsGroup:    room.IsGroup,
+			ExportedAt: time.Now().UTC(),
+			ExportedBy: job.RequestedBy.String(),
+		},
+		Messages: make([]archiveMessage, 0, len(msgs)),
+	}
+
+	// The server may not read messages in end-to-end encrypted rooms, even
+	// those it could decrypt
+	sealed := room.EncryptionMode == models.EncryptionModeE2E
+	names := make(map[uuid.UUID]string)
+	for i := range msgs {
+		a.Messages = append(a.Messages, s.archiveMessage(&msgs[i], sealed, names))
+	}
+
+	// Entries are pointers into a.Messages, which no longer grows
+	var files []pendingAttachment
+	for i := range a.Messages {
+		for j := range a.Messages[i].Attachments {
+			files = append(files, pendingAttachment{key: msgs[i].Attachments[j].Key, entry: &a.Messages[i].Attachments[j]})
+		}
+	}
+	return a, files, nil
+}
+
+func (s *exportService) archiveMessage(msg *models.Message, sealed bool, names map[uuid.UUID]string) archiveMessage {
+	m := archiveMessage{
+		ID:        msg.ID.String(),
+		SenderID:  msg.SenderID.String(),
+		Sender:    s.senderName(msg.SenderID, names),
+		Type:      msg.Type,
+		CreatedAt: msg.CreatedAt.UTC(),
+	}
+	if m.Type == "" {
+		m.Type = models.MessageTypeText
+	}
+	if len(msg.Payload) > 0 {
+		m.Payload = json.RawMessage(msg.Payload)
+	}
+	if msg.ForwardedFromMessageID != nil {
+		m.ForwardedFrom = msg.ForwardedFromMessageID.String()
+	}
+
+	if sealed {
+		m.Encrypted = msg.Content != ""
+	} else if text, ok := message.ReadableText(s.encryption, msg); ok {
+		m.Text = &text
+	} else if msg.Content != "" {
+		m.Encrypted = true
+	}
+
+	for _, att := range msg.Attachments {
+		m.Attachments = append(m.Attachments, archiveAttachment{
+			FileName: att.FileName,
+			MimeType: att.MimeType,
+			Size:     att.Size,
+			Path:     attachmentPath(att.ID.String(), att.FileName),
+		})
+	}
+	return m
+}
+
+// senderName looks up a username once per export; deleted users fall back
+// to their id.
+func (s *exportService) senderName(id uuid.UUID, names map[uuid.UUID]string) string {
+	if name, ok := names[id]; ok {
+		return name
+	}
+	name := id.String()
+	if user, err := s.userRepo.FindByID(id.String()); err == nil && user != nil {
+		name = user.Username
+	}
+	names[id] = name
+	return name
+}
+
+// PurgeExpiredExports deletes archives past their retention period.
+func (s *exportService) PurgeExpiredExports(ctx context.Context) (int, error) {
+	jobs, err := s.repo.FindExpired(time.Now(), purgeBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, job := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		if job.ObjectKey != nil {
+			if err := s.s3Service.DeleteFile(*job.ObjectKey, false); err != nil {
+				log.Printf("export %s: failed to delete archive: %v", job.ID, err)
+				continue
+			}
+		}
+		if err := s.repo.MarkExpired(job.ID.String()); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,102 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+const (
+	SourceSlack    = "slack"
+	SourceWhatsApp = "whatsapp"
+)
+
+// Participant is a person as the source tool knows them.
+type Participant struct {
+	// Key identifies the participant within the export: a Slack user id or a
+	// WhatsApp display name
+	Key   string
+	Name  string
+	Email string
+}
+
+// File is an attachment referenced by an imported message. Open is nil when
+// the export does not contain the file itself.
+type File struct {
+	Name string
+	Size int64
+	Open func() (io.ReadCloser, error)
+}
+
+type Message struct {
+	// SourceID identifies the message within its conversation and is stable
+	// across exports of the same history
+	SourceID string
+	Author   string
+	Text     string
+	SentAt   time.Time
+	Files    []File
+}
+
+// Conversation is a channel, group or direct chat read from an export, with
+// its messages oldest first.
+type Conversation struct {
+	Source     string
+	ExternalID string
+	Name       string
+	IsGroup    bool
+	// Owner is the key of the participant who created the conversation, if known
+	Owner        string
+	Participants []Participant
+	Messages     []Message
+}
+
+// participant returns the participant with the given key, adding a bare one
+// for authors who are not listed as members.
+func (c *Conversation) participant(key string) *Participant {
+	for i := range c.Participants {
+		if c.Participants[i].Key == key {
+			return &c.Participants[i]
+		}
+	}
+	c.Participants = append(c.Participants, Participant{Key: key, Name: key})
+	return &c.Participants[len(c.Participants)-1]
+}
+
+// zipIndex maps entry names to files for quick lookups.
+type zipIndex map[string]*zip.File
+
+func indexZip(zr *zip.Reader) zipIndex {
+	idx := make(zipIndex, len(zr.File))
+	for _, f := range zr.File {
+		idx[f.Name] = f
+	}
+	return idx
+}
+
+// readJSON decodes an entry; found is false when the export does not have it.
+func (idx zipIndex) readJSON(name string, v any) (found bool, err error) {
+	f, ok := idx[name]
+	if !ok {
+		return false, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return true, err
+	}
+	defer rc.Close()
+	return true, decodeJSON(rc, v)
+}
+
+func decodeJSON(r io.Reader, v any) error {
+	return json.NewDecoder(r).Decode(v)
+}
+
+func zipFile(f *zip.File) File {
+	return File{
+		Name: f.FileInfo().Name(),
+		Size: int64(f.UncompressedSize64),
+		Open: func() (io.ReadCloser, error) { return f.Open() },
+	}
+}
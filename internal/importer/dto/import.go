@@ -0,0 +1,35 @@
+package dto
+
+import "github.com/google/uuid"
+
+type ImportRequest struct {
+	Source string `form:"source" binding:"required,oneof=slack whatsapp"`
+	// The fields below only apply to WhatsApp exports
+	ChatName string `form:"chat_name"`
+	// Participants is a JSON object mapping display names to emails
+	Participants string `form:"participants"`
+	DateOrder    string `form:"date_order" binding:"omitempty,oneof=dmy mdy"`
+	Timezone     string `form:"timezone"`
+}
+
+type ImportedRoom struct {
+	ChatRoomID       uuid.UUID `json:"chat_room_id"`
+	ExternalID       string    `json:"external_id"`
+	Name             string    `json:"name"`
+	Created          bool      `json:"created"`
+	MessagesImported int       `json:"messages_imported"`
+	MessagesSkipped  int       `json:"messages_skipped"`
+}
+
+// ImportReport summarizes an import. Messages that were imported by an
+// earlier run are counted as skipped.
+type ImportReport struct {
+	Rooms            []ImportedRoom `json:"rooms"`
+	UsersCreated     int            `json:"users_created"`
+	MessagesImported int            `json:"messages_imported"`
+	MessagesSkipped  int            `json:"messages_skipped"`
+	FilesUploaded    int            `json:"files_uploaded"`
+	// FilesMissing counts files the messages refer to that the export
+	// did not contain
+	FilesMissing int `json:"files_missing"`
+}
@@ -0,0 +1,100 @@
+package importer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"mozho_chat/internal/importer/dto"
+	"mozho_chat/pkg/middleware"
+)
+
+// maxImportUploadSize caps the export archive accepted by the API. Larger
+// exports can be imported with cmd/import.
+const maxImportUploadSize = 1 << 30
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
+	r := rg.Group("/admin/imports", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(maxImportUploadSize))
+	{
+		r.POST("", h.Import)
+	}
+}
+
+func (h *Handler) Import(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	if err := h.service.Authorize(userID); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportUploadSize)
+
+	var req dto.ImportRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	var report *dto.ImportReport
+	switch req.Source {
+	case SourceSlack:
+		report, err = h.service.ImportSlack(c.Request.Context(), file, header.Size)
+	case SourceWhatsApp:
+		opts, optsErr := whatsAppOptions(req)
+		if optsErr != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": optsErr.Error()})
+			return
+		}
+		report, err = h.service.ImportWhatsApp(c.Request.Context(), header.Filename, file, header.Size, opts)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func whatsAppOptions(req dto.ImportRequest) (WhatsAppOptions, error) {
+	opts := WhatsAppOptions{
+		ChatName:  req.ChatName,
+		DateOrder: req.DateOrder,
+	}
+	if req.Participants != "" {
+		if err := json.Unmarshal([]byte(req.Participants), &opts.Participants); err != nil {
+			return opts, err
+		}
+	}
+	if req.Timezone != "" {
+		loc, err := time.LoadLocation(req.Timezone)
+		if err != nil {
+			return opts, err
+		}
+		opts.Location = loc
+	}
+	return opts, nil
+}
@@ -0,0 +1,453 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/importer/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/pkg/encryption"
+	s3upload "mozho_chat/pkg/s3"
+)
+
+const (
+	// clientMsgIDPrefix marks messages created by an import.
+	clientMsgIDPrefix = "import:"
+	// importBatchSize is how many messages are stored per transaction.
+	importBatchSize = 200
+	// maxImportFileSize caps a single imported attachment.
+	maxImportFileSize = 100 << 20
+	// maxUsernameAttempts bounds the search for a free username.
+	maxUsernameAttempts = 20
+)
+
+// importNamespace seeds the ids of imported messages and attachments, so a
+// second run produces the same ids as the first.
+var importNamespace = uuid.MustParse("6f1f5a0e-8d0b-4b5e-9a55-0c1f3f6d2a71")
+
+type Service interface {
+	// Authorize checks that the user may run imports through the API.
+	Authorize(userID string) error
+	ImportSlack(ctx context.Context, r io.ReaderAt, size int64) (*dto.ImportReport, error)
+	ImportWhatsApp(ctx context.Context, fileName string, r io.ReaderAt, size int64, opts WhatsAppOptions) (*dto.ImportReport, error)
+}
+
+type importService struct {
+	messageRepo repository.MessageRepository
+	roomRepo    repository.ChatRoomRepository
+	userRepo    repository.UserRepository
+	s3Service   s3upload.Service
+	encryption  encryption.EncryptionService
+}
+
+func NewService(
+	messageRepo repository.MessageRepository,
+	roomRepo repository.ChatRoomRepository,
+	userRepo repository.UserRepository,
+	s3Service s3upload.Service,
+	encryption encryption.EncryptionService,
+) Service {
+	return &importService{
+		messageRepo: messageRepo,
+		roomRepo:    roomRepo,
+		userRepo:    userRepo,
+		s3Service:   s3Service,
+		encryption:  encryption,
+	}
+}
+
+func (s *importService) Authorize(userID string) error {
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return errors.New("admin access required")
+	}
+	if user.Role != models.UserRoleAdmin {
+		return errors.New("admin access required")
+	}
+	return nil
+}
+
+func (s *importService) ImportSlack(ctx context.Context, r io.ReaderAt, size int64) (*dto.ImportReport, error) {
+	convs, err := ParseSlackExport(r, size)
+	if err != nil {
+		return nil, err
+	}
+	return s.importConversations(ctx, convs)
+}
+
+func (s *importService) ImportWhatsApp(ctx context.Context, fileName string, r io.ReaderAt, size int64, opts WhatsAppOptions) (*dto.ImportReport, error) {
+	conv, err := ParseWhatsAppExport(fileName, r, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.importConversations(ctx, []Conversation{*conv})
+}
+
+// run holds what one import has resolved so far.
+type run struct {
+	report *dto.ImportReport
+	// users maps "<source>:<participant key>" to user ids
+	users map[string]uuid.UUID
+}
+
+func (s *importService) importConversations(ctx context.Context, convs []Conversation) (*dto.ImportReport, error) {
+	r := &run{
+		report: &dto.ImportReport{Rooms: []dto.ImportedRoom{}},
+		users:  make(map[string]uuid.UUID),
+	}
+	for i := range convs {
+		if err := ctx.Err(); err != nil {
+			return r.report, err
+		}
+		if err := s.importConversation(ctx, r, &convs[i]); err != nil {
+			return r.report, fmt.Errorf("%s: %w", convs[i].Name, err)
+		}
+	}
+	return r.report, nil
+}
+
+func (s *importService) importConversation(ctx context.Context, r *run, conv *Conversation) error {
+	members := make(map[string]uuid.UUID, len(conv.Participants))
+	for _, p := range conv.Participants {
+		id, err := s.resolveUser(r, conv.Source, p)
+		if err != nil {
+			return err
+		}
+		members[p.Key] = id
+	}
+
+	room, created, err := s.resolveRoom(conv)
+	if err != nil {
+		return err
+	}
+	for key, userID := range members {
+		inRoom, err := s.roomRepo.IsUserInRoom(room.ID.String(), userID.String())
+		if err != nil {
+			return err
+		}
+		if inRoom {
+			continue
+		}
+		role := models.RoleMember
+		if conv.IsGroup && key == conv.Owner {
+			role = models.RoleOwner
+		}
+		if err := s.roomRepo.AddUserWithRole(room.ID.String(), userID.String(), role); err != nil {
+			return err
+		}
+	}
+
+	existing, err := s.messageRepo.ListClientMsgIDs(room.ID.String(), clientMsgIDPrefix)
+	if err != nil {
+		return err
+	}
+	imported := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		imported[id] = true
+	}
+
+	summary := dto.ImportedRoom{
+		ChatRoomID: room.ID,
+		ExternalID: conv.ExternalID,
+		Name:       room.Name,
+		Created:    created,
+	}
+
+	var batch []*models.Message
+	var last time.Time
+	for i := range conv.Messages {
+		src := &conv.Messages[i]
+
+		// Sources only record seconds or minutes; nudging ties apart keeps
+		// messages in the order the export lists them
+		createdAt := src.SentAt
+		if !createdAt.After(last) && !last.IsZero() {
+			createdAt = last.Add(time.Microsecond)
+		}
+		last = createdAt
+
+		clientMsgID := importClientMsgID(conv.ExternalID, src.SourceID)
+		if imported[clientMsgID] {
+			summary.MessagesSkipped++
+			continue
+		}
+
+		msg, err := s.buildMessage(r, conv, room.ID, members[src.Author], src, clientMsgID, createdAt)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, msg)
+
+		if len(batch) == importBatchSize {
+			if err := s.storeBatch(ctx, batch); err != nil {
+				return err
+			}
+			summary.MessagesImported += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := s.storeBatch(ctx, batch); err != nil {
+			return err
+		}
+		summary.MessagesImported += len(batch)
+	}
+
+	r.report.Rooms = append(r.report.Rooms, summary)
+	r.report.MessagesImported += summary.MessagesImported
+	r.report.MessagesSkipped += summary.MessagesSkipped
+	return nil
+}
+
+func (s *importService) storeBatch(ctx context.Context, batch []*models.Message) error {
+	if err := s.messageRepo.CreateBatch(ctx, batch); err != nil {
+		return err
+	}
+	// Imported rooms use server-side encryption, so their history is searchable
+	for _, msg := range batch {
+		text, ok := s.plaintext(msg)
+		if !ok || text == "" {
+			continue
+		}
+		if err := s.messageRepo.IndexForSearch(msg.ID.String(), text); err != nil {
+			log.Printf("import: failed to index message %s: %v", msg.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *importService) buildMessage(r *run, conv *Conversation, roomID, senderID uuid.UUID, src *Message, clientMsgID string, createdAt time.Time) (*models.Message, error) {
+	msgID := uuid.NewSHA1(importNamespace, []byte(clientMsgID))
+	text := src.Text
+
+	var attachments []models.Attachment
+	for i, f := range src.Files {
+		if f.Open == nil {
+			r.report.FilesMissing++
+			text = strings.TrimSpace(text + "\n[" + f.Name + " was not included in the export]")
+			continue
+		}
+		attachment, err := s.uploadFile(msgID, i, f, createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("upload %s: %w", f.Name, err)
+		}
+		r.report.FilesUploaded++
+		attachments = append(attachments, *attachment)
+	}
+
+	content, key, err := s.encrypt(text)
+	if err != nil {
+		return nil, err
+	}
+	return &models.Message{
+		ID:          msgID,
+		ChatRoomID:  roomID,
+		SenderID:    senderID,
+		Type:        models.MessageTypeText,
+		Content:     content,
+		ClientMsgID: &clientMsgID,
+		EncryptionMetadata: models.EncryptionMetadata{
+			Algorithm: "AES",
+			Key:       key,
+		},
+		Attachments: attachments,
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt,
+	}, nil
+}
+
+// uploadFile stores an attachment under a key derived from the message, so
+// an interrupted import overwrites its own uploads when it runs again.
+func (s *importService) uploadFile(msgID uuid.UUID, index int, f File, createdAt time.Time) (*models.Attachment, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxImportFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportFileSize {
+		return nil, errors.New("file is too large")
+	}
+
+	mimeType := mime.TypeByExtension(strings.ToLower(path.Ext(f.Name)))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+
+	id := uuid.NewSHA1(importNamespace, []byte(fmt.Sprintf("%s/%d", msgID, index)))
+	key := fmt.Sprintf("messages/%s/attachment/%s%s", msgID, id, path.Ext(f.Name))
+	if err := s.s3Service.UploadObject(key, bytes.NewReader(data), mimeType, false); err != nil {
+		return nil, err
+	}
+	return &models.Attachment{
+		ID:        id,
+		MessageID: msgID,
+		Key:       key,
+		FileName:  f.Name,
+		MimeType:  mimeType,
+		Size:      int64(len(data)),
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// encrypt stores text the way rooms in "server" mode do: AES with a key per
+// message kept in the encryption metadata.
+func (s *importService) encrypt(text string) (string, string, error) {
+	key, err := s.encryption.GenerateAESKey()
+	if err != nil {
+		return "", "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", "", err
+	}
+	content, err := s.encryption.EncryptWithAES(text, string(raw))
+	if err != nil {
+		return "", "", err
+	}
+	return content, key, nil
+}
+
+func (s *importService) plaintext(msg *models.Message) (string, bool) {
+	raw, err := base64.StdEncoding.DecodeString(msg.EncryptionMetadata.Key)
+	if err != nil {
+		return "", false
+	}
+	text, err := s.encryption.DecryptWithAES(msg.Content, string(raw))
+	return text, err == nil
+}
+
+// resolveRoom finds the room an earlier run created for the conversation,
+// or creates it.
+func (s *importService) resolveRoom(conv *Conversation) (*models.ChatRoom, bool, error) {
+	room, err := s.roomRepo.FindByExternalID(conv.ExternalID)
+	if err != nil || room != nil {
+		return room, false, err
+	}
+
+	externalID := conv.ExternalID
+	room = &models.ChatRoom{
+		ID:             uuid.New(),
+		Name:           conv.Name,
+		IsGroup:        conv.IsGroup,
+		EncryptionMode: models.EncryptionModeServer,
+		ExternalID:     &externalID,
+	}
+	if len(conv.Messages) > 0 {
+		room.CreatedAt = conv.Messages[0].SentAt
+	}
+	if err := s.roomRepo.Create(room); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			// Another import created it in the meantime
+			room, err = s.roomRepo.FindByExternalID(conv.ExternalID)
+			return room, false, err
+		}
+		return nil, false, err
+	}
+	return room, true, nil
+}
+
+// resolveUser maps a participant to an account by email, creating a
+// placeholder account when there is none.
+func (s *importService) resolveUser(r *run, source string, p Participant) (uuid.UUID, error) {
+	cacheKey := source + ":" + p.Key
+	if id, ok := r.users[cacheKey]; ok {
+		return id, nil
+	}
+
+	email := p.Email
+	if email == "" {
+		// A stable address keeps later runs mapping to the same placeholder
+		sum := sha256.Sum256([]byte(p.Key))
+		email = fmt.Sprintf("%s-%s@%s", source, hex.EncodeToString(sum[:8]), models.PlaceholderEmailDomain)
+	}
+
+	user, err := s.findUserByEmail(email)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if user == nil {
+		user, err = s.createPlaceholder(p.Name, email)
+		if err != nil {
+			return uuid.Nil, err
+		}
+		r.report.UsersCreated++
+	}
+	r.users[cacheKey] = user.ID
+	return user.ID, nil
+}
+
+func (s *importService) findUserByEmail(email string) (*models.User, error) {
+	user, err := s.userRepo.FindByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return user, err
+}
+
+var usernameInvalid = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// createPlaceholder creates an account nobody can log in to until its owner
+// claims it through a link sent to the same email.
+func (s *importService) createPlaceholder(name, email string) (*models.User, error) {
+	base := strings.Trim(usernameInvalid.ReplaceAllString(strings.ToLower(name), "-"), "-._")
+	if base == "" {
+		base = "user"
+	}
+
+	for attempt := 1; attempt <= maxUsernameAttempts; attempt++ {
+		username := base
+		if attempt > 1 {
+			username = fmt.Sprintf("%s-%d", base, attempt)
+		}
+		if attempt == maxUsernameAttempts {
+			username = base + "-" + uuid.NewString()[:8]
+		}
+
+		user := &models.User{
+			Username:    username,
+			Email:       email,
+			Role:        models.UserRoleUser,
+			Placeholder: true,
+		}
+		err := s.userRepo.Create(user)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, err
+		}
+
+		// The email may have been taken in the meantime rather than the username
+		if existing, err := s.findUserByEmail(email); err != nil || existing != nil {
+			return existing, err
+		}
+	}
+	return nil, errors.New("could not find a free username for " + email)
+}
+
+func importClientMsgID(externalID, sourceID string) string {
+	sum := sha256.Sum256([]byte(externalID + "\x00" + sourceID))
+	return clientMsgIDPrefix + hex.EncodeToString(sum[:20])
+}
@@ -0,0 +1,280 @@
+package importer
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type slackUser struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	RealName string `json:"real_name"`
+	Profile  struct {
+		Email       string `json:"email"`
+		DisplayName string `json:"display_name"`
+		RealName    string `json:"real_name"`
+	} `json:"profile"`
+}
+
+type slackChannel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Creator string   `json:"creator"`
+	Members []string `json:"members"`
+}
+
+type slackFile struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+}
+
+type slackMessage struct {
+	Type    string      `json:"type"`
+	Subtype string      `json:"subtype"`
+	User    string      `json:"user"`
+	Text    string      `json:"text"`
+	TS      string      `json:"ts"`
+	Files   []slackFile `json:"files"`
+}
+
+// slackSkippedSubtypes are membership and settings notices rather than
+// something a person wrote.
+var slackSkippedSubtypes = map[string]bool{
+	"channel_join":      true,
+	"channel_leave":     true,
+	"channel_topic":     true,
+	"channel_purpose":   true,
+	"channel_name":      true,
+	"channel_archive":   true,
+	"channel_unarchive": true,
+	"group_join":        true,
+	"group_leave":       true,
+	"group_topic":       true,
+	"group_purpose":     true,
+	"group_name":        true,
+	"pinned_item":       true,
+	"unpinned_item":     true,
+	"bot_add":           true,
+	"bot_remove":        true,
+}
+
+// ParseSlackExport reads a workspace export: users.json, the channel lists
+// (channels.json, groups.json, mpims.json and dms.json) and one folder of
+// daily message files per conversation. Files are only imported when the
+// export embeds them under __uploads/<file id>/.
+func ParseSlackExport(r io.ReaderAt, size int64) ([]Conversation, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("not a zip archive: %w", err)
+	}
+	idx := indexZip(zr)
+
+	var users []slackUser
+	if _, err := idx.readJSON("users.json", &users); err != nil {
+		return nil, fmt.Errorf("users.json: %w", err)
+	}
+	people := make(map[string]Participant, len(users))
+	for _, u := range users {
+		people[u.ID] = slackParticipant(u)
+	}
+
+	// Day files are named YYYY-MM-DD.json, so sorting them sorts by date
+	days := make(map[string][]*zip.File)
+	for _, f := range zr.File {
+		dir, name := path.Split(f.Name)
+		if dir == "" || strings.Count(dir, "/") != 1 || path.Ext(name) != ".json" {
+			continue
+		}
+		folder := strings.TrimSuffix(dir, "/")
+		days[folder] = append(days[folder], f)
+	}
+	for _, files := range days {
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	}
+
+	lists := []struct {
+		file    string
+		isGroup bool
+		// dms have no name, so their folder is named after the id
+		byID bool
+	}{
+		{"channels.json", true, false},
+		{"groups.json", true, false},
+		{"mpims.json", true, false},
+		{"dms.json", false, true},
+	}
+
+	var convs []Conversation
+	found := false
+	for _, list := range lists {
+		var channels []slackChannel
+		ok, err := idx.readJSON(list.file, &channels)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", list.file, err)
+		}
+		found = found || ok
+
+		for _, ch := range channels {
+			folder := ch.Name
+			if list.byID {
+				folder = ch.ID
+			}
+			conv, err := parseSlackChannel(ch, list.isGroup, days[folder], people, idx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", folder, err)
+			}
+			convs = append(convs, *conv)
+		}
+	}
+	if !found {
+		return nil, errors.New("not a slack export: channels.json is missing")
+	}
+	return convs, nil
+}
+
+func slackParticipant(u slackUser) Participant {
+	// name is the Slack handle, which makes the best username
+	name := u.Name
+	for _, alt := range []string{u.Profile.DisplayName, u.Profile.RealName, u.RealName, u.ID} {
+		if name == "" {
+			name = alt
+		}
+	}
+	return Participant{Key: u.ID, Name: name, Email: strings.ToLower(u.Profile.Email)}
+}
+
+func parseSlackChannel(ch slackChannel, isGroup bool, days []*zip.File, people map[string]Participant, idx zipIndex) (*Conversation, error) {
+	conv := &Conversation{
+		Source:     SourceSlack,
+		ExternalID: "slack:" + ch.ID,
+		Name:       ch.Name,
+		IsGroup:    isGroup,
+		Owner:      ch.Creator,
+	}
+	for _, id := range ch.Members {
+		conv.addSlackParticipant(id, people)
+	}
+
+	for _, day := range days {
+		var msgs []slackMessage
+		rc, err := day.Open()
+		if err != nil {
+			return nil, err
+		}
+		err = decodeJSON(rc, &msgs)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", day.Name, err)
+		}
+
+		for _, m := range msgs {
+			if m.Type != "message" || m.User == "" || slackSkippedSubtypes[m.Subtype] {
+				continue
+			}
+			sentAt, err := parseSlackTS(m.TS)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", day.Name, err)
+			}
+			conv.addSlackParticipant(m.User, people)
+
+			msg := Message{
+				SourceID: m.TS,
+				Author:   m.User,
+				Text:     slackText(m.Text, people),
+				SentAt:   sentAt,
+			}
+			for _, f := range m.Files {
+				if f.Mode == "tombstone" || f.Mode == "hidden_by_limit" {
+					continue
+				}
+				file := File{Name: f.Name, Size: f.Size}
+				if zf, ok := idx[path.Join("__uploads", f.ID, f.Name)]; ok {
+					file = zipFile(zf)
+				}
+				msg.Files = append(msg.Files, file)
+			}
+			if msg.Text == "" && len(msg.Files) == 0 {
+				continue
+			}
+			conv.Messages = append(conv.Messages, msg)
+		}
+	}
+
+	sort.SliceStable(conv.Messages, func(i, j int) bool {
+		return conv.Messages[i].SentAt.Before(conv.Messages[j].SentAt)
+	})
+	return conv, nil
+}
+
+func (c *Conversation) addSlackParticipant(id string, people map[string]Participant) {
+	p := c.participant(id)
+	if known, ok := people[id]; ok {
+		*p = known
+	}
+}
+
+// parseSlackTS converts a message ts such as "1700000000.000200", which is
+// seconds and microseconds since the epoch.
+func parseSlackTS(ts string) (time.Time, error) {
+	secs, micros, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid ts %q", ts)
+	}
+	var us int64
+	if micros != "" {
+		if us, err = strconv.ParseInt(micros, 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("invalid ts %q", ts)
+		}
+	}
+	return time.Unix(s, us*int64(time.Microsecond)).UTC(), nil
+}
+
+var slackMarkup = regexp.MustCompile(`<([^<>]+)>`)
+
+// slackText turns Slack's markup for mentions, channels and links into
+// plain text.
+func slackText(text string, people map[string]Participant) string {
+	text = slackMarkup.ReplaceAllStringFunc(text, func(m string) string {
+		inner := m[1 : len(m)-1]
+		target, label, hasLabel := strings.Cut(inner, "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if p, ok := people[target[1:]]; ok {
+				return "@" + p.Name
+			}
+			if hasLabel {
+				return "@" + label
+			}
+			return target
+		case strings.HasPrefix(target, "#"):
+			if hasLabel {
+				return "#" + label
+			}
+			return target
+		case strings.HasPrefix(target, "!"):
+			// Special mentions such as <!here> or <!subteam^ID|@team>
+			if hasLabel {
+				return label
+			}
+			return "@" + target[1:]
+		case hasLabel && label != target && label != strings.TrimPrefix(target, "mailto:"):
+			return label + " (" + target + ")"
+		case hasLabel:
+			return label
+		}
+		return target
+	})
+	return strings.TrimSpace(html.UnescapeString(text))
+}
@@ -0,0 +1,290 @@
+package importer
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DateOrderAuto = ""
+	DateOrderDMY  = "dmy"
+	DateOrderMDY  = "mdy"
+)
+
+// WhatsAppOptions fill in what a WhatsApp export does not record.
+type WhatsAppOptions struct {
+	// ChatName names the room; by default it is taken from the file name
+	ChatName string
+	// Participants maps display names, as they appear in the chat, to emails
+	Participants map[string]string
+	// DateOrder says how to read dates like 03/04/24. When empty it is
+	// guessed from the dates in the chat, falling back to day first
+	DateOrder string
+	// Location is the time zone of the phone that made the export
+	Location *time.Location
+}
+
+// whatsAppLine matches the first line of a message in both export formats:
+//
+//	12/31/23, 9:41 PM - Alice: Hello          (Android)
+//	[31/12/2023, 21:41:05] Alice: Hello       (iOS)
+var whatsAppLine = regexp.MustCompile(`^\[?(\d{1,2})[/.](\d{1,2})[/.](\d{2,4}),? (\d{1,2}):(\d{2})(?::(\d{2}))?(?: ?([AaPp])\.? ?[Mm]\.?)?(?:\] | - )(.*)$`)
+
+var (
+	iosAttachment     = regexp.MustCompile(`<attached: ([^>]+)>`)
+	androidAttachment = regexp.MustCompile(`^(.+) \(file attached\)$`)
+)
+
+type whatsAppEntry struct {
+	fields [7]string
+	author string
+	text   string
+}
+
+// ParseWhatsAppExport reads a chat exported from WhatsApp, either the .txt
+// on its own or the .zip made by "export with media".
+func ParseWhatsAppExport(fileName string, r io.ReaderAt, size int64, opts WhatsAppOptions) (*Conversation, error) {
+	var chat io.Reader = io.NewSectionReader(r, 0, size)
+	media := map[string]*zip.File{}
+
+	if strings.EqualFold(path.Ext(fileName), ".zip") {
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("not a zip archive: %w", err)
+		}
+		var transcript *zip.File
+		for _, f := range zr.File {
+			name := path.Base(f.Name)
+			if strings.EqualFold(path.Ext(name), ".txt") && (transcript == nil || name == "_chat.txt") {
+				transcript = f
+			}
+			media[name] = f
+		}
+		if transcript == nil {
+			return nil, errors.New("no chat transcript in the archive")
+		}
+		rc, err := transcript.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		chat = rc
+		if opts.ChatName == "" {
+			opts.ChatName = whatsAppChatName(transcript.Name)
+		}
+	}
+	if opts.ChatName == "" {
+		opts.ChatName = whatsAppChatName(fileName)
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+
+	entries, err := readWhatsAppEntries(chat)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no messages found; is this a WhatsApp export?")
+	}
+
+	dayFirst, err := whatsAppDayFirst(entries, opts.DateOrder)
+	if err != nil {
+		return nil, err
+	}
+
+	conv := &Conversation{
+		Source: SourceWhatsApp,
+		Name:   opts.ChatName,
+	}
+	seen := make(map[string]int)
+	for _, e := range entries {
+		sentAt, err := whatsAppTime(e.fields, dayFirst, opts.Location)
+		if err != nil {
+			return nil, err
+		}
+
+		// Identify messages by their content, so exporting the same chat
+		// again later yields the same ids for the messages already imported
+		sum := sha256.Sum256([]byte(sentAt.Format(time.RFC3339) + "\x00" + e.author + "\x00" + e.text))
+		hash := hex.EncodeToString(sum[:12])
+		seen[hash]++
+		if conv.ExternalID == "" {
+			conv.ExternalID = "whatsapp:" + hash
+		}
+
+		msg := Message{
+			SourceID: hash + "-" + strconv.Itoa(seen[hash]),
+			Author:   e.author,
+			SentAt:   sentAt,
+		}
+		msg.Text, msg.Files = whatsAppAttachments(e.text, media)
+		if msg.Text == "" && len(msg.Files) == 0 {
+			continue
+		}
+
+		p := conv.participant(e.author)
+		if email, ok := opts.Participants[e.author]; ok {
+			p.Email = strings.ToLower(email)
+		}
+		conv.Messages = append(conv.Messages, msg)
+	}
+	conv.IsGroup = len(conv.Participants) > 2
+	return conv, nil
+}
+
+func readWhatsAppEntries(r io.Reader) ([]whatsAppEntry, error) {
+	var entries []whatsAppEntry
+	current := -1
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := cleanWhatsAppLine(scanner.Text())
+
+		m := whatsAppLine.FindStringSubmatch(line)
+		if m == nil {
+			// Continuation of a multi-line message
+			if current >= 0 {
+				entries[current].text += "\n" + line
+			}
+			continue
+		}
+
+		author, text, ok := strings.Cut(m[8], ": ")
+		if !ok {
+			// System notices such as "Messages are end-to-end encrypted"
+			current = -1
+			continue
+		}
+		var e whatsAppEntry
+		copy(e.fields[:], m[1:8])
+		e.author = strings.TrimSpace(author)
+		e.text = text
+		entries = append(entries, e)
+		current = len(entries) - 1
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// cleanWhatsAppLine drops the byte order mark and direction marks WhatsApp
+// sprinkles through exports, and normalizes its non-breaking spaces.
+func cleanWhatsAppLine(line string) string {
+	line = strings.NewReplacer("\ufeff", "", "\u200e", "", "\u200f", "", "\u202f", " ", "\u00a0", " ").Replace(line)
+	return strings.TrimRight(line, "\r")
+}
+
+// whatsAppDayFirst decides how to read dates. Any date with a first number
+// above 12 settles it as day first, and any second number above 12 as month
+// first.
+func whatsAppDayFirst(entries []whatsAppEntry, order string) (bool, error) {
+	switch order {
+	case DateOrderDMY:
+		return true, nil
+	case DateOrderMDY:
+		return false, nil
+	case DateOrderAuto:
+	default:
+		return false, fmt.Errorf("unknown date order %q", order)
+	}
+
+	for _, e := range entries {
+		first, _ := strconv.Atoi(e.fields[0])
+		second, _ := strconv.Atoi(e.fields[1])
+		if first > 12 {
+			return true, nil
+		}
+		if second > 12 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func whatsAppTime(f [7]string, dayFirst bool, loc *time.Location) (time.Time, error) {
+	num := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	day, month := num(f[0]), num(f[1])
+	if !dayFirst {
+		day, month = month, day
+	}
+	year := num(f[2])
+	if year < 100 {
+		year += 2000
+	}
+	hour, minute, second := num(f[3]), num(f[4]), num(f[5])
+	switch strings.ToLower(f[6]) {
+	case "a":
+		if hour == 12 {
+			hour = 0
+		}
+	case "p":
+		if hour != 12 {
+			hour += 12
+		}
+	}
+
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || minute > 59 || second > 59 {
+		return time.Time{}, fmt.Errorf("invalid date %s/%s/%s %s:%s", f[0], f[1], f[2], f[3], f[4])
+	}
+	t := time.Date(year, time.Month(month), day, hour, minute, second, 0, loc)
+	if t.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %s/%s/%s", f[0], f[1], f[2])
+	}
+	return t.UTC(), nil
+}
+
+// whatsAppAttachments pulls file references out of a message. Files are
+// only attached when the export includes them.
+func whatsAppAttachments(text string, media map[string]*zip.File) (string, []File) {
+	var names []string
+	text = iosAttachment.ReplaceAllStringFunc(text, func(m string) string {
+		names = append(names, iosAttachment.FindStringSubmatch(m)[1])
+		return ""
+	})
+
+	lines := strings.Split(text, "\n")
+	if m := androidAttachment.FindStringSubmatch(lines[0]); m != nil {
+		names = append(names, m[1])
+		lines = lines[1:]
+	}
+	text = strings.TrimSpace(strings.Join(lines, "\n"))
+
+	var files []File
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if f, ok := media[name]; ok {
+			files = append(files, zipFile(f))
+		} else {
+			files = append(files, File{Name: name})
+		}
+	}
+	return text, files
+}
+
+// whatsAppChatName turns "WhatsApp Chat with Alice.txt" into "Alice".
+func whatsAppChatName(fileName string) string {
+	name := strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+	for _, prefix := range []string{"WhatsApp Chat with ", "WhatsApp Chat - "} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	if name == "" || name == "_chat" {
+		return "WhatsApp chat"
+	}
+	return name
+}
@@ -0,0 +1,67 @@
+package dto
+
+import (
+	"time"
+
+	"mozho_chat/internal/models"
+)
+
+// MentionEntity marks a mention inside the message content. Offset and
+// Length are counted in runes of the plaintext.
+type MentionEntity struct {
+	Type   string `json:"type"`
+	UserID string `json:"user_id,omitempty"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+type MentionResponse struct {
+	ID         string           `json:"id"`
+	MessageID  string           `json:"message_id"`
+	ChatRoomID string           `json:"chat_room_id"`
+	SenderID   string           `json:"sender_id"`
+	Type       string           `json:"type"`
+	Read       bool             `json:"read"`
+	CreatedAt  string           `json:"created_at"`
+	Message    *MessageResponse `json:"message,omitempty"`
+}
+
+type MentionUnreadCount struct {
+	ChatRoomID string `json:"chat_room_id"`
+	Count      int64  `json:"count"`
+}
+
+func toMentionEntities(entities []models.MentionEntity) []MentionEntity {
+	if len(entities) == 0 {
+		return nil
+	}
+	res := make([]MentionEntity, len(entities))
+	for i, e := range entities {
+		res[i] = MentionEntity{
+			Type:   e.Type,
+			Offset: e.Offset,
+			Length: e.Length,
+		}
+		if e.UserID != nil {
+			res[i].UserID = e.UserID.String()
+		}
+	}
+	return res
+}
+
+// NewMentionResponse creates a MentionResponse from a UserMention model
+func NewMentionResponse(m *models.UserMention) MentionResponse {
+	res := MentionResponse{
+		ID:         m.ID.String(),
+		MessageID:  m.MessageID.String(),
+		ChatRoomID: m.ChatRoomID.String(),
+		SenderID:   m.SenderID.String(),
+		Type:       m.Type,
+		Read:       m.ReadAt != nil,
+		CreatedAt:  m.CreatedAt.Format(time.RFC3339),
+	}
+	if m.Message.ID == m.MessageID {
+		res.Message = NewMessageResponse(&m.Message)
+	}
+	return res
+}
@@ -1,8 +1,11 @@
 package dto
 
 import (
+	"encoding/json"
 	"mozho_chat/internal/models"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 type SendMessageRequest struct {
@@ -10,16 +13,31 @@ type SendMessageRequest struct {
 	Content           string `json:"content" form:"content" binding:"required"`
 	Algorithm         string `json:"algorithm" form:"algorithm" binding:"required"`
 	EncryptionKey     string `json:"encryption_key" form:"encryption_key"`
+	Mentions          []MentionEntity `json:"mentions" form:"mentions"`
+	SendAt            *time.Time      `json:"send_at,omitempty" form:"send_at"`
+	ExpiresAt         *time.Time      `json:"expires_at,omitempty" form:"expires_at"`
+	// ClientMsgID lets clients retry a send without creating duplicates
+	ClientMsgID       string          `json:"client_msg_id,omitempty" form:"client_msg_id"`
+	// Type and Payload are used for structured messages such as locations
+	Type              string          `json:"type,omitempty" form:"type"`
+	Payload           json.RawMessage `json:"payload,omitempty" form:"payload"`
 }
 
 type MessageResponse struct {
 	ID          string                   `json:"id"`
 	ChatRoomID  string                   `json:"chat_room_id"`
 	SenderID    string                   `json:"sender_id"`
+	ClientMsgID string                   `json:"client_msg_id,omitempty"`
+	Type        string                   `json:"type"`
 	Content     string                   `json:"content,omitempty"`
 	Encrypted   bool                     `json:"encrypted"`
 	Encryption  *EncryptionMetadata      `json:"encryption,omitempty"`
+	Payload     json.RawMessage          `json:"payload,omitempty"`
 	Attachments []string                 `json:"attachments,omitempty"`
+	Mentions    []MentionEntity          `json:"mentions,omitempty"`
+	ForwardedFrom *ForwardedFrom         `json:"forwarded_from,omitempty"`
+	LinkPreviews  []LinkPreview          `json:"link_previews,omitempty"`
+	ExpiresAt   string                   `json:"expires_at,omitempty"`
 	CreatedAt   string                   `json:"created_at"`
 }
 
@@ -34,12 +52,43 @@ func NewMessageResponse(msg *models.Message) *MessageResponse {
 		ID:         msg.ID.String(),
 		ChatRoomID: msg.ChatRoomID.String(),
 		SenderID:   msg.SenderID.String(),
+		Type:       msg.Type,
 		Content:    msg.Content,
 		CreatedAt:  msg.CreatedAt.Format(time.RFC3339),
+		Mentions:   toMentionEntities(msg.Mentions),
+	}
+
+	if response.Type == "" {
+		response.Type = models.MessageTypeText
+	}
+	if len(msg.Payload) > 0 && string(msg.Payload) != "null" {
+		response.Payload = json.RawMessage(msg.Payload)
+	}
+	if msg.ClientMsgID != nil {
+		response.ClientMsgID = *msg.ClientMsgID
+	}
+	if msg.ExpiresAt != nil {
+		response.ExpiresAt = msg.ExpiresAt.Format(time.RFC3339)
+	}
+	for _, p := range msg.LinkPreviews {
+		response.LinkPreviews = append(response.LinkPreviews, LinkPreview{
+			URL:         p.URL,
+			Title:       p.Title,
+			Description: p.Description,
+			SiteName:    p.SiteName,
+			ImageURL:    p.ImageURL,
+		})
+	}
+	if msg.ForwardedFromMessageID != nil {
+		response.ForwardedFrom = &ForwardedFrom{
+			MessageID:  msg.ForwardedFromMessageID.String(),
+			ChatRoomID: uuidString(msg.ForwardedFromRoomID),
+			SenderID:   uuidString(msg.ForwardedFromSenderID),
+		}
 	}
 
 	// Add encryption metadata if present
-	if msg.EncryptionMetadata.Algorithm != "" {
+	if msg.EncryptionMetadata.Algorithm != "" && msg.EncryptionMetadata.Algorithm != models.EncryptionNone {
 		response.Encrypted = true
 		response.Encryption = &EncryptionMetadata{
 			Algorithm: msg.EncryptionMetadata.Algorithm,
@@ -50,6 +99,21 @@ func NewMessageResponse(msg *models.Message) *MessageResponse {
 	return response
 }
 
+type LinkPreview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	SiteName    string `json:"site_name,omitempty"`
+	ImageURL    string `json:"image_url,omitempty"`
+}
+
+// ForwardedFrom points at the original message a forwarded message was copied from.
+type ForwardedFrom struct {
+	MessageID  string `json:"message_id"`
+	ChatRoomID string `json:"chat_room_id"`
+	SenderID   string `json:"sender_id"`
+}
+
 // ToMessageResponses converts a slice of Message models to MessageResponse DTOs
 func ToMessageResponses(messages []models.Message) []MessageResponse {
 	responses := make([]MessageResponse, len(messages))
@@ -59,6 +123,13 @@ func ToMessageResponses(messages []models.Message) []MessageResponse {
 	return responses
 }
 
+func uuidString(id *uuid.UUID) string {
+	if id == nil {
+		return ""
+	}
+	return id.String()
+}
+
 type GetMessagesQuery struct {
 	ChatRoomID string `form:"chat_room_id" binding:"required,uuid"`
 	Limit      int    `form:"limit,default=50"`
@@ -68,3 +139,10 @@ type GetMessagesQuery struct {
 type MarkReadRequest struct {
 	MessageID string `json:"message_id" binding:"required,uuid"`
 }
+
+// ForwardMessagesRequest copies messages into other rooms. Every message is
+// forwarded to every room.
+type ForwardMessagesRequest struct {
+	MessageIDs  []string `json:"message_ids" binding:"required,min=1,dive,uuid"`
+	ChatRoomIDs []string `json:"chat_room_ids" binding:"required,min=1,dive,uuid"`
+}
@@ -0,0 +1,10 @@
+package dto
+
+// ReceiptResponse is one recipient's status of a message. Read stays
+// false when the recipient's privacy settings hide their read receipts
+// from the sender.
+type ReceiptResponse struct {
+	UserID    string `json:"user_id"`
+	Delivered bool   `json:"delivered"`
+	Read      bool   `json:"read"`
+}
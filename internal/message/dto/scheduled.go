@@ -0,0 +1,68 @@
+package dto
+
+import (
+	"time"
+
+	"mozho_chat/internal/models"
+)
+
+type UpdateScheduledMessageRequest struct {
+	Content       *string         `json:"content,omitempty"`
+	Algorithm     *string         `json:"algorithm,omitempty"`
+	EncryptionKey *string         `json:"encryption_key,omitempty"`
+	Mentions      []MentionEntity `json:"mentions,omitempty"`
+	SendAt        *time.Time      `json:"send_at,omitempty"`
+	ExpiresAt     *time.Time      `json:"expires_at,omitempty"`
+}
+
+type ScheduledMessageResponse struct {
+	ID          string              `json:"id"`
+	ChatRoomID  string              `json:"chat_room_id"`
+	ClientMsgID string              `json:"client_msg_id,omitempty"`
+	Content     string              `json:"content,omitempty"`
+	Encryption  *EncryptionMetadata `json:"encryption,omitempty"`
+	Mentions    []MentionEntity     `json:"mentions,omitempty"`
+	Attachments []string            `json:"attachments,omitempty"`
+	SendAt      string              `json:"send_at"`
+	ExpiresAt   string              `json:"expires_at,omitempty"`
+	Status      string              `json:"status"`
+	MessageID   string              `json:"message_id,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	CreatedAt   string              `json:"created_at"`
+}
+
+// NewScheduledMessageResponse creates a ScheduledMessageResponse from a ScheduledMessage model
+func NewScheduledMessageResponse(msg *models.ScheduledMessage) *ScheduledMessageResponse {
+	response := &ScheduledMessageResponse{
+		ID:         msg.ID.String(),
+		ChatRoomID: msg.ChatRoomID.String(),
+		Content:    msg.Content,
+		Mentions:   toMentionEntities(msg.Mentions),
+		SendAt:     msg.SendAt.Format(time.RFC3339),
+		Status:     msg.Status,
+		CreatedAt:  msg.CreatedAt.Format(time.RFC3339),
+	}
+
+	if msg.EncryptionMetadata.Algorithm != "" {
+		response.Encryption = &EncryptionMetadata{
+			Algorithm: msg.EncryptionMetadata.Algorithm,
+		}
+	}
+	for _, a := range msg.Attachments {
+		response.Attachments = append(response.Attachments, a.FileName)
+	}
+	if msg.ClientMsgID != nil {
+		response.ClientMsgID = *msg.ClientMsgID
+	}
+	if msg.ExpiresAt != nil {
+		response.ExpiresAt = msg.ExpiresAt.Format(time.RFC3339)
+	}
+	if msg.MessageID != nil {
+		response.MessageID = msg.MessageID.String()
+	}
+	if msg.LastError != nil {
+		response.Error = *msg.LastError
+	}
+
+	return response
+}
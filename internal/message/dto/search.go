@@ -0,0 +1,32 @@
+package dto
+
+import "time"
+
+type SearchMessagesQuery struct {
+	Query         string     `form:"q" binding:"required"`
+	ChatRoomID    string     `form:"chat_room_id" binding:"omitempty,uuid"`
+	SenderID      string     `form:"sender_id" binding:"omitempty,uuid"`
+	From          *time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	To            *time.Time `form:"to" time_format:"2006-01-02T15:04:05Z07:00"`
+	HasAttachment *bool      `form:"has_attachment"`
+	Cursor        string     `form:"cursor"`
+	Limit         int        `form:"limit,default=20"`
+}
+
+// Highlight marks a match in SearchResult.Text. Offset and Length are
+// counted in runes, like mention entities.
+type Highlight struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+type SearchResult struct {
+	Message    MessageResponse `json:"message"`
+	Text       string          `json:"text"`
+	Highlights []Highlight     `json:"highlights"`
+}
+
+type SearchMessagesResponse struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
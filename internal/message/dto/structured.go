@@ -0,0 +1,38 @@
+package dto
+
+import "time"
+
+// LocationPayload is the payload of a "location" message.
+type LocationPayload struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Label     string  `json:"label,omitempty"`
+}
+
+// LiveLocationPayload is the payload of a "live_location" message. Clients
+// send the starting position and duration_minutes; the server fills in the
+// rest and keeps it current as the sender moves.
+type LiveLocationPayload struct {
+	Latitude        float64    `json:"latitude"`
+	Longitude       float64    `json:"longitude"`
+	Accuracy        *float64   `json:"accuracy,omitempty"`
+	DurationMinutes int        `json:"duration_minutes"`
+	LiveUntil       time.Time  `json:"live_until"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	StoppedAt       *time.Time `json:"stopped_at,omitempty"`
+}
+
+// ContactPayload is the payload of a "contact" message. Only user_id is read
+// from the client; the username is filled in by the server.
+type ContactPayload struct {
+	UserID   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+type UpdateLiveLocationRequest struct {
+	Latitude  *float64 `json:"latitude"`
+	Longitude *float64 `json:"longitude"`
+	Accuracy  *float64 `json:"accuracy"`
+	// Stop ends sharing before live_until
+	Stop bool `json:"stop"`
+}
@@ -0,0 +1,229 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+)
+
+const (
+	// maxForwardMessages caps how many messages one request may forward.
+	maxForwardMessages = 20
+	// maxForwardRooms caps how many rooms one request may forward to.
+	maxForwardRooms = 5
+)
+
+// ForwardMessages copies messages the user can see into other rooms they
+// belong to. Attachments are not uploaded again: the copies point at the
+// same S3 objects, which the reaper only deletes once the last message
+// using them is gone.
+func (s *messageService) ForwardMessages(userID string, input dto.ForwardMessagesRequest) ([]dto.MessageResponse, error) {
+	messageIDs := uniqueStrings(input.MessageIDs)
+	roomIDs := uniqueStrings(input.ChatRoomIDs)
+	if len(messageIDs) > maxForwardMessages {
+		return nil, errors.New("too many messages to forward")
+	}
+	if len(roomIDs) > maxForwardRooms {
+		return nil, errors.New("too many rooms to forward to")
+	}
+
+	sources, err := s.repo.FindByIDs(messageIDs)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) != len(messageIDs) {
+		return nil, errors.New("message not found")
+	}
+
+	sourceModes := make(map[uuid.UUID]string)
+	for i := range sources {
+		src := &sources[i]
+		if _, ok := sourceModes[src.ChatRoomID]; !ok {
+			mode, err := s.checkForwardSource(userID, src.ChatRoomID.String())
+			if err != nil {
+				return nil, err
+			}
+			sourceModes[src.ChatRoomID] = mode
+		}
+		if err := checkForwardable(src); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	var copies []*models.Message
+	for _, roomID := range roomIDs {
+		inRoom, err := s.roomRepo.IsUserInRoom(roomID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !inRoom {
+			return nil, errors.New("user not in room")
+		}
+		for i := range sources {
+			if err := s.checkForwardTarget(&sources[i], sourceModes[sources[i].ChatRoomID], roomID); err != nil {
+				return nil, err
+			}
+		}
+
+		expiresAt, err := s.messageExpiry(roomID, now, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range sources {
+			// Spread the timestamps so the copies keep their original order
+			createdAt := now.Add(time.Duration(len(copies)) * time.Microsecond)
+			msg, err := forwardCopy(&sources[i], mustParseUUID(roomID), mustParseUUID(userID), createdAt)
+			if err != nil {
+				return nil, err
+			}
+			msg.ExpiresAt = expiresAt
+			copies = append(copies, msg)
+		}
+	}
+
+	if err := s.repo.CreateBatch(context.TODO(), copies); err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.MessageResponse, 0, len(copies))
+	for _, msg := range copies {
+		s.indexForSearch(msg)
+		response, err := s.announceMessage(msg)
+		if err != nil {
+			log.Printf("failed to announce forwarded message %s: %v", msg.ID, err)
+			response = dto.NewMessageResponse(msg)
+		}
+		res = append(res, *response)
+	}
+	return res, nil
+}
+
+// checkForwardSource checks the user may forward out of the room and
+// returns its encryption mode.
+func (s *messageService) checkForwardSource(userID, roomID string) (string, error) {
+	inRoom, err := s.roomRepo.IsUserInRoom(roomID, userID)
+	if err != nil {
+		return "", err
+	}
+	if !inRoom {
+		return "", errors.New("message not found")
+	}
+
+	room, err := s.roomRepo.FindByID(roomID)
+	if err != nil {
+		return "", err
+	}
+	if room.RestrictForwarding {
+		return "", errors.New("forwarding is disabled in this room")
+	}
+	return s.roomRepo.GetEncryptionMode(roomID)
+}
+
+// checkForwardTarget rejects copies the target room would not accept as new
+// messages. Content from end-to-end encrypted rooms also stays out of rooms
+// the server reads, and the other way round.
+func (s *messageService) checkForwardTarget(src *models.Message, sourceMode, roomID string) error {
+	// Structured messages from end-to-end encrypted rooms keep their data in
+	// the content, so they are checked like text
+	if src.Type == "" || src.Type == models.MessageTypeText || len(src.Payload) == 0 {
+		if _, err := s.resolveAlgorithm(roomID, src.EncryptionMetadata.Algorithm); err != nil {
+			return err
+		}
+	} else if err := s.requirePlaintextRoom(roomID); err != nil {
+		return err
+	}
+	mode, err := s.roomRepo.GetEncryptionMode(roomID)
+	if err != nil {
+		return err
+	}
+	if (sourceMode == models.EncryptionModeE2E) != (mode == models.EncryptionModeE2E) {
+		return errors.New("messages cannot be forwarded between end-to-end encrypted rooms and other rooms")
+	}
+	return nil
+}
+
+// checkForwardable rejects messages that only make sense where they were sent.
+func checkForwardable(msg *models.Message) error {
+	switch {
+	case msg.EncryptionMetadata.Algorithm == "RSA":
+		// Encrypted for the original recipient's key, so nobody else could read it
+		return errors.New("end-to-end encrypted messages cannot be forwarded")
+	case msg.Type == models.MessageTypePoll:
+		return errors.New("polls cannot be forwarded")
+	}
+	return nil
+}
+
+// forwardCopy builds the copy of src that is posted to roomID. Provenance
+// always points at the first message in a chain of forwards.
+func forwardCopy(src *models.Message, roomID, senderID uuid.UUID, createdAt time.Time) (*models.Message, error) {
+	msg := &models.Message{
+		ID:                     uuid.New(),
+		ChatRoomID:             roomID,
+		SenderID:               senderID,
+		Type:                   src.Type,
+		Content:                src.Content,
+		Payload:                src.Payload,
+		EncryptionMetadata:     src.EncryptionMetadata,
+		ForwardedFromMessageID: &src.ID,
+		ForwardedFromRoomID:    &src.ChatRoomID,
+		ForwardedFromSenderID:  &src.SenderID,
+		CreatedAt:              createdAt,
+	}
+	if src.ForwardedFromMessageID != nil {
+		msg.ForwardedFromMessageID = src.ForwardedFromMessageID
+		msg.ForwardedFromRoomID = src.ForwardedFromRoomID
+		msg.ForwardedFromSenderID = src.ForwardedFromSenderID
+	}
+	if msg.Type == "" {
+		msg.Type = models.MessageTypeText
+	}
+
+	// A live location is forwarded as a snapshot of where it was last seen
+	if src.Type == models.MessageTypeLiveLocation {
+		var live dto.LiveLocationPayload
+		if err := json.Unmarshal(src.Payload, &live); err != nil {
+			return nil, err
+		}
+		payload, err := json.Marshal(dto.LocationPayload{Latitude: live.Latitude, Longitude: live.Longitude})
+		if err != nil {
+			return nil, err
+		}
+		msg.Type = models.MessageTypeLocation
+		msg.Payload = payload
+	}
+
+	for _, a := range src.Attachments {
+		msg.Attachments = append(msg.Attachments, models.Attachment{
+			ID:        uuid.New(),
+			MessageID: msg.ID,
+			Key:       a.Key,
+			FileName:  a.FileName,
+			MimeType:  a.MimeType,
+			Size:      a.Size,
+			CreatedAt: createdAt,
+		})
+	}
+	return msg, nil
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	return res
+}
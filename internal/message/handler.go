@@ -2,13 +2,19 @@
 package message
 
 import (
+	"encoding/json"
+	"errors"
 	"strconv"
+	"time"
 	"github.com/gin-gonic/gin"
 	"mozho_chat/internal/message/dto"
 	"mozho_chat/pkg/middleware"
 	"net/http"
 )
 
+// maxSendMessageSize caps a send request, attachments included.
+const maxSendMessageSize = 100 << 20
+
 type Handler struct {
 	service Service
 }
@@ -19,21 +25,37 @@ func NewHandler(service Service) *Handler {
 
 func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
 	messages := rg.Group("/messages")
-	messages.Use(middleware.AuthMiddleware())
+	messages.Use(middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(maxSendMessageSize))
 	{
 		messages.POST("/send", h.SendMessage)
+		messages.POST("/forward", h.ForwardMessages)
 		messages.GET("/:chat_room_id", h.GetMessages)
 		messages.POST("/:message_id/read", h.MarkRead)
 		messages.POST("/:message_id/unread", h.MarkUnread)
 		messages.POST("/:message_id/delivered", h.MarkDelivered)
 		messages.POST("/:message_id/undelivered", h.MarkUndelivered)
+		messages.GET("/receipts/:message_id", h.ListReceipts)
 		messages.POST("/generate-key", h.GenerateKey)
+		messages.GET("/mentions", h.GetMentions)
+		messages.GET("/mentions/unread", h.GetMentionUnreadCounts)
+		messages.POST("/mentions/:chat_room_id/read", h.MarkMentionsRead)
+		messages.GET("/scheduled", h.ListScheduledMessages)
+		messages.PATCH("/scheduled/:id", h.UpdateScheduledMessage)
+		messages.DELETE("/scheduled/:id", h.CancelScheduledMessage)
+		messages.PATCH("/:message_id/live-location", h.UpdateLiveLocation)
+	}
+
+	search := rg.Group("/search")
+	search.Use(middleware.AuthMiddleware())
+	{
+		search.GET("/messages", h.SearchMessages)
 	}
 }
 
 func (h *Handler) SendMessage(c *gin.Context) {
 	userID := c.GetString("user_id")
 
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSendMessageSize)
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to parse form"})
 		return
@@ -44,8 +66,49 @@ func (h *Handler) SendMessage(c *gin.Context) {
 	req.Content = c.PostForm("content")
 	req.Algorithm = c.PostForm("algorithm")
 	req.EncryptionKey = c.PostForm("encryption_key")
+	req.ClientMsgID = c.PostForm("client_msg_id")
+	req.Type = c.PostForm("type")
+	if payload := c.PostForm("payload"); payload != "" {
+		if !json.Valid([]byte(payload)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payload"})
+			return
+		}
+		req.Payload = json.RawMessage(payload)
+	}
+	if mentions := c.PostForm("mentions"); mentions != "" {
+		if err := json.Unmarshal([]byte(mentions), &req.Mentions); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid mentions"})
+			return
+		}
+	}
+	if sendAt := c.PostForm("send_at"); sendAt != "" {
+		t, err := time.Parse(time.RFC3339, sendAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "send_at must be an RFC 3339 timestamp"})
+			return
+		}
+		req.SendAt = &t
+	}
+	if expiresAt := c.PostForm("expires_at"); expiresAt != "" {
+		t, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be an RFC 3339 timestamp"})
+			return
+		}
+		req.ExpiresAt = &t
+	}
 	files := c.Request.MultipartForm.File["attachments"]
 
+	if req.SendAt != nil {
+		scheduled, err := h.service.ScheduleMessage(userID, req, files)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusAccepted, scheduled)
+		return
+	}
+
 	message, err := h.service.SendMessage(userID, req, files)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -115,6 +178,22 @@ func (h *Handler) MarkDelivered(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "marked as delivered"})
 }
 
+// ListReceipts shows the sender who received and read their message.
+func (h *Handler) ListReceipts(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	receipts, err := h.service.ListReceipts(userID, c.Param("message_id"))
+	switch {
+	case errors.Is(err, errMessageNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, receipts)
+}
+
 func (h *Handler) MarkUndelivered(c *gin.Context) {
 	userID := c.GetString("user_id")
 	messageID := c.Param("message_id")
@@ -134,3 +213,150 @@ func (h *Handler) GenerateKey(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"key": key})
 }
+
+func (h *Handler) GetMentions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	limitStr := c.DefaultQuery("limit", "20")
+	offsetStr := c.DefaultQuery("offset", "0")
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit parameter"})
+		return
+	}
+
+	offset, err := strconv.Atoi(offsetStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset parameter"})
+		return
+	}
+
+	mentions, err := h.service.GetMentions(userID, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, mentions)
+}
+
+func (h *Handler) GetMentionUnreadCounts(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	counts, err := h.service.GetMentionUnreadCounts(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+func (h *Handler) MarkMentionsRead(c *gin.Context) {
+	userID := c.GetString("user_id")
+	chatRoomID := c.Param("chat_room_id")
+
+	if err := h.service.MarkMentionsRead(userID, chatRoomID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "mentions marked as read"})
+}
+
+func (h *Handler) ListScheduledMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+	chatRoomID := c.Query("chat_room_id")
+
+	scheduled, err := h.service.ListScheduledMessages(userID, chatRoomID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, scheduled)
+}
+
+func (h *Handler) UpdateScheduledMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	scheduledID := c.Param("id")
+
+	var req dto.UpdateScheduledMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	scheduled, err := h.service.UpdateScheduledMessage(userID, scheduledID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, scheduled)
+}
+
+func (h *Handler) CancelScheduledMessage(c *gin.Context) {
+	userID := c.GetString("user_id")
+	scheduledID := c.Param("id")
+
+	if err := h.service.CancelScheduledMessage(userID, scheduledID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) UpdateLiveLocation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	messageID := c.Param("message_id")
+
+	var req dto.UpdateLiveLocationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	message, err := h.service.UpdateLiveLocation(userID, messageID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, message)
+}
+
+func (h *Handler) ForwardMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.ForwardMessagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	messages, err := h.service.ForwardMessages(userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, messages)
+}
+
+func (h *Handler) SearchMessages(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var query dto.SearchMessagesQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.service.SearchMessages(userID, query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
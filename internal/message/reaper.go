@@ -0,0 +1,73 @@
+package message
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"mozho_chat/internal/models"
+)
+
+// reaperBatchSize is how many expired messages are deleted per transaction.
+const reaperBatchSize = 100
+
+// StartReaper hard-deletes expired messages every interval until ctx is done.
+func StartReaper(ctx context.Context, service Service, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := service.PurgeExpiredMessages(ctx); err != nil {
+					log.Printf("reaper: failed to purge expired messages: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// PurgeExpiredMessages deletes expired messages along with their statuses,
+// attachments and S3 objects, and returns how many messages were removed.
+func (s *messageService) PurgeExpiredMessages(ctx context.Context) (int, error) {
+	purged := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+
+		expired, err := s.repo.DeleteExpired(ctx, time.Now(), reaperBatchSize, s.deleteAttachmentObjects)
+		if err != nil {
+			return purged, err
+		}
+		if len(expired) == 0 {
+			return purged, nil
+		}
+		purged += len(expired)
+
+		byRoom := make(map[string][]string)
+		for _, msg := range expired {
+			roomID := msg.ChatRoomID.String()
+			byRoom[roomID] = append(byRoom[roomID], msg.ID.String())
+		}
+		for roomID, ids := range byRoom {
+			s.publishRoomEvent(roomID, "message.expired", map[string]any{"message_ids": ids})
+		}
+
+		if len(expired) < reaperBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+func (s *messageService) deleteAttachmentObjects(attachments []models.Attachment) error {
+	for _, a := range attachments {
+		if err := s.s3Service.DeleteFile(a.Key, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
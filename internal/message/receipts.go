@@ -0,0 +1,60 @@
+package message
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/message/dto"
+)
+
+var errMessageNotFound = errors.New("message not found")
+
+// ListReceipts returns the statuses of a message the caller sent. Reads
+// are only shown where both the reader and the caller share read
+// receipts with each other.
+func (s *messageService) ListReceipts(userID, messageID string) ([]dto.ReceiptResponse, error) {
+	if _, err := uuid.Parse(messageID); err != nil {
+		return nil, errMessageNotFound
+	}
+	msg, err := s.repo.FindByID(messageID)
+	if err != nil || msg.SenderID.String() != userID {
+		return nil, errMessageNotFound
+	}
+	sender, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := s.repo.ListStatuses(messageID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(statuses))
+	for i, st := range statuses {
+		ids[i] = st.UserID.String()
+	}
+	contactIDs, err := s.contactRepo.ListContactIDs(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make(map[string]bool, len(contactIDs))
+	for _, id := range contactIDs {
+		contacts[id] = true
+	}
+
+	res := make([]dto.ReceiptResponse, 0, len(statuses))
+	for _, st := range statuses {
+		if st.UserID.String() == userID {
+			continue
+		}
+		read := st.Read && st.User.ReadReceiptsVisibleTo(sender, contacts[st.UserID.String()])
+		res = append(res, dto.ReceiptResponse{
+			UserID: st.UserID.String(),
+			// A read message was delivered even if only the read was recorded
+			Delivered: st.Delivered || st.Read,
+			Read:      read,
+		})
+	}
+	return res, nil
+}
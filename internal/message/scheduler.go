@@ -0,0 +1,301 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime/multipart"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+)
+
+// maxScheduleAhead is how far in the future a message may be scheduled.
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// StartScheduler publishes due scheduled messages every interval until ctx is done.
+func StartScheduler(ctx context.Context, service Service, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := service.PublishDueMessages(ctx); err != nil {
+					log.Printf("scheduler: failed to publish due messages: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func (s *messageService) ScheduleMessage(senderID string, input dto.SendMessageRequest, files []*multipart.FileHeader) (*dto.ScheduledMessageResponse, error) {
+	chatRoomID := mustParseUUID(input.ReceiverID)
+
+	if input.SendAt == nil {
+		return nil, errors.New("send_at is required")
+	}
+	if input.Type != "" && input.Type != models.MessageTypeText {
+		return nil, errors.New("only text messages can be scheduled")
+	}
+	if err := validateSendAt(*input.SendAt); err != nil {
+		return nil, err
+	}
+	if err := validateScheduledExpiry(*input.SendAt, input.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	if input.ClientMsgID != "" {
+		existing, err := s.findScheduledByClientMsgID(senderID, chatRoomID.String(), input.ClientMsgID)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	inRoom, err := s.roomRepo.IsUserInRoom(chatRoomID.String(), senderID)
+	if err != nil {
+		return nil, err
+	}
+	if !inRoom {
+		return nil, errors.New("user not in room")
+	}
+
+	entities, err := s.resolveMentions(chatRoomID.String(), senderID, input.Content, input.Mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := s.resolveAlgorithm(chatRoomID.String(), input.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedContent, encryptionKey, err := s.encryptMessage(input.Content, algorithm, input.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	scheduled := &models.ScheduledMessage{
+		ID:         uuid.New(),
+		ChatRoomID: chatRoomID,
+		SenderID:   mustParseUUID(senderID),
+		Content:    encryptedContent,
+		EncryptionMetadata: models.EncryptionMetadata{
+			Algorithm: algorithm,
+			Key:       encryptionKey,
+		},
+		Mentions:  entities,
+		SendAt:    input.SendAt.UTC(),
+		ExpiresAt: input.ExpiresAt,
+		Status:    models.ScheduledStatusPending,
+	}
+	if input.ClientMsgID != "" {
+		scheduled.ClientMsgID = &input.ClientMsgID
+	}
+
+	// Files are uploaded right away so nothing can fail at send time
+	for _, file := range files {
+		uploaded, err := s.s3Service.UploadFile(file, "scheduled", scheduled.ID.String(), "attachment", false)
+		if err != nil {
+			s.deleteScheduledAttachments(scheduled)
+			return nil, err
+		}
+		scheduled.Attachments = append(scheduled.Attachments, models.ScheduledAttachment{
+			Key:      uploaded.Key,
+			FileName: file.Filename,
+			MimeType: uploaded.MimeType,
+			Size:     uploaded.Size,
+		})
+	}
+
+	if err := s.scheduledRepo.Create(context.TODO(), scheduled); err != nil {
+		s.deleteScheduledAttachments(scheduled)
+		if errors.Is(err, repository.ErrDuplicateClientMsgID) {
+			return s.findScheduledByClientMsgID(senderID, chatRoomID.String(), input.ClientMsgID)
+		}
+		return nil, err
+	}
+
+	return dto.NewScheduledMessageResponse(scheduled), nil
+}
+
+func (s *messageService) ListScheduledMessages(senderID, chatRoomID string) ([]dto.ScheduledMessageResponse, error) {
+	scheduled, err := s.scheduledRepo.FindPendingBySender(senderID, chatRoomID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.ScheduledMessageResponse, 0, len(scheduled))
+	for i := range scheduled {
+		res = append(res, *dto.NewScheduledMessageResponse(&scheduled[i]))
+	}
+	return res, nil
+}
+
+func (s *messageService) UpdateScheduledMessage(senderID, scheduledID string, input dto.UpdateScheduledMessageRequest) (*dto.ScheduledMessageResponse, error) {
+	scheduled, err := s.findOwnScheduledMessage(senderID, scheduledID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.SendAt != nil {
+		if err := validateSendAt(*input.SendAt); err != nil {
+			return nil, err
+		}
+		scheduled.SendAt = input.SendAt.UTC()
+	}
+	if input.ExpiresAt != nil {
+		scheduled.ExpiresAt = input.ExpiresAt
+	}
+	if err := validateScheduledExpiry(scheduled.SendAt, scheduled.ExpiresAt); err != nil {
+		return nil, err
+	}
+
+	if input.Content != nil {
+		algorithm := scheduled.EncryptionMetadata.Algorithm
+		if input.Algorithm != nil {
+			algorithm = *input.Algorithm
+		}
+		// The room may have switched encryption mode since the message was scheduled
+		algorithm, err := s.resolveAlgorithm(scheduled.ChatRoomID.String(), algorithm)
+		if err != nil {
+			return nil, err
+		}
+		// Keep encrypting with the stored key unless a new one is supplied
+		key := scheduled.EncryptionMetadata.Key
+		if input.EncryptionKey != nil {
+			key = *input.EncryptionKey
+		}
+
+		// Offsets refer to the old text, so mentions are replaced as a whole
+		entities, err := s.resolveMentions(scheduled.ChatRoomID.String(), senderID, *input.Content, input.Mentions)
+		if err != nil {
+			return nil, err
+		}
+
+		encryptedContent, encryptionKey, err := s.encryptMessage(*input.Content, algorithm, key)
+		if err != nil {
+			return nil, err
+		}
+		scheduled.Content = encryptedContent
+		scheduled.EncryptionMetadata = models.EncryptionMetadata{Algorithm: algorithm, Key: encryptionKey}
+		scheduled.Mentions = entities
+	} else if input.Mentions != nil {
+		return nil, errors.New("mentions can only be changed together with content")
+	}
+
+	if err := s.scheduledRepo.UpdatePending(scheduled); err != nil {
+		return nil, err
+	}
+
+	return dto.NewScheduledMessageResponse(scheduled), nil
+}
+
+func (s *messageService) CancelScheduledMessage(senderID, scheduledID string) error {
+	scheduled, err := s.findOwnScheduledMessage(senderID, scheduledID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.scheduledRepo.CancelPending(scheduledID, senderID); err != nil {
+		return err
+	}
+
+	s.deleteScheduledAttachments(scheduled)
+	return nil
+}
+
+// PublishDueMessages sends every scheduled message whose time has come and
+// returns how many were published.
+func (s *messageService) PublishDueMessages(ctx context.Context) (int, error) {
+	published := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return published, err
+		}
+
+		msg, cancelled, err := s.scheduledRepo.PublishNextDue(ctx, time.Now())
+		var failed *repository.ScheduledPublishError
+		if errors.As(err, &failed) {
+			log.Printf("scheduler: attempt %d failed: %v", failed.Scheduled.Attempts, err)
+			if failed.Scheduled.Status == models.ScheduledStatusFailed {
+				s.deleteScheduledAttachments(failed.Scheduled)
+			}
+			continue
+		}
+		if err != nil {
+			return published, err
+		}
+		if cancelled != nil {
+			s.deleteScheduledAttachments(cancelled)
+			continue
+		}
+		if msg == nil {
+			return published, nil
+		}
+
+		published++
+		s.indexForSearch(msg)
+		s.queueLinkPreviews(msg, s.readableText(msg))
+		if _, err := s.announceMessage(msg); err != nil {
+			log.Printf("scheduler: failed to announce message %s: %v", msg.ID, err)
+		}
+	}
+}
+
+func (s *messageService) findScheduledByClientMsgID(senderID, chatRoomID, clientMsgID string) (*dto.ScheduledMessageResponse, error) {
+	if len(clientMsgID) > maxClientMsgIDLength {
+		return nil, errors.New("client_msg_id is too long")
+	}
+	existing, err := s.scheduledRepo.FindByClientMsgID(senderID, chatRoomID, clientMsgID)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	return dto.NewScheduledMessageResponse(existing), nil
+}
+
+func (s *messageService) findOwnScheduledMessage(senderID, scheduledID string) (*models.ScheduledMessage, error) {
+	scheduled, err := s.scheduledRepo.FindByID(scheduledID)
+	if err != nil {
+		return nil, err
+	}
+	if scheduled.SenderID.String() != senderID {
+		return nil, errors.New("scheduled message not found")
+	}
+	if scheduled.Status != models.ScheduledStatusPending {
+		return nil, errors.New("scheduled message is no longer pending")
+	}
+	return scheduled, nil
+}
+
+func (s *messageService) deleteScheduledAttachments(scheduled *models.ScheduledMessage) {
+	for _, a := range scheduled.Attachments {
+		if err := s.s3Service.DeleteFile(a.Key, false); err != nil {
+			log.Printf("failed to delete scheduled attachment %s: %v", a.Key, err)
+		}
+	}
+}
+
+func validateScheduledExpiry(sendAt time.Time, expiresAt *time.Time) error {
+	if expiresAt != nil && !expiresAt.After(sendAt) {
+		return errors.New("expires_at must be after send_at")
+	}
+	return nil
+}
+
+func validateSendAt(sendAt time.Time) error {
+	now := time.Now()
+	if !sendAt.After(now) {
+		return errors.New("send_at must be in the future")
+	}
+	if sendAt.After(now.Add(maxScheduleAhead)) {
+		return errors.New("send_at is too far in the future")
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package message
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/repository"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+	maxSearchQueryLen  = 200
+)
+
+// SearchMessages finds messages matching the query in the caller's rooms.
+// Only rooms in "server" or "none" encryption mode are indexed.
+func (s *messageService) SearchMessages(userID string, input dto.SearchMessagesQuery) (*dto.SearchMessagesResponse, error) {
+	query := strings.TrimSpace(input.Query)
+	if query == "" {
+		return nil, errors.New("q is required")
+	}
+	if utf8.RuneCountInString(query) > maxSearchQueryLen {
+		return nil, errors.New("q is too long")
+	}
+
+	limit := input.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	params := repository.SearchParams{
+		Query:         query,
+		ChatRoomID:    input.ChatRoomID,
+		SenderID:      input.SenderID,
+		From:          input.From,
+		To:            input.To,
+		HasAttachment: input.HasAttachment,
+		Limit:         limit + 1,
+	}
+	if input.Cursor != "" {
+		before, id, err := decodeSearchCursor(input.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		params.BeforeTime = &before
+		params.BeforeID = id
+	}
+
+	if input.ChatRoomID != "" {
+		inRoom, err := s.roomRepo.IsUserInRoom(input.ChatRoomID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !inRoom {
+			return nil, errors.New("user not in room")
+		}
+	}
+
+	msgs, err := s.repo.Search(userID, params)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.SearchMessagesResponse{Results: []dto.SearchResult{}}
+	if len(msgs) > limit {
+		last := msgs[limit-1]
+		res.NextCursor = encodeSearchCursor(last.CreatedAt, last.ID)
+		msgs = msgs[:limit]
+	}
+
+	terms := searchTerms(query)
+	member := make(map[uuid.UUID]bool)
+	for i := range msgs {
+		msg := &msgs[i]
+
+		// The query already joins on membership; this guards against
+		// anything slipping through a future change to it
+		allowed, checked := member[msg.ChatRoomID]
+		if !checked {
+			allowed, err = s.roomRepo.IsUserInRoom(msg.ChatRoomID.String(), userID)
+			if err != nil {
+				return nil, err
+			}
+			member[msg.ChatRoomID] = allowed
+		}
+		if !allowed {
+			continue
+		}
+
+		text := s.readableText(msg)
+		res.Results = append(res.Results, dto.SearchResult{
+			Message:    *dto.NewMessageResponse(msg),
+			Text:       text,
+			Highlights: highlightTerms(text, terms),
+		})
+	}
+	return res, nil
+}
+
+// searchTerms returns the words of a websearch-style query that should be
+// highlighted; excluded words and operators are skipped.
+func searchTerms(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		if strings.HasPrefix(word, "-") || strings.EqualFold(word, "or") {
+			continue
+		}
+		for _, part := range strings.FieldsFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			terms = append(terms, strings.ToLower(part))
+		}
+	}
+	return terms
+}
+
+// highlightTerms finds whole-word, case-insensitive matches of terms in
+// text. Search uses the "simple" configuration, which does not stem, so
+// whole words are exactly what matched.
+func highlightTerms(text string, terms []string) []dto.Highlight {
+	highlights := []dto.Highlight{}
+	if len(terms) == 0 {
+		return highlights
+	}
+
+	runes := []rune(text)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+	isWord := func(i int) bool {
+		return i >= 0 && i < len(lower) && (unicode.IsLetter(lower[i]) || unicode.IsDigit(lower[i]))
+	}
+
+	for start := 0; start < len(lower); start++ {
+		if !isWord(start) || isWord(start-1) {
+			continue
+		}
+		end := start
+		for isWord(end) {
+			end++
+		}
+		word := string(lower[start:end])
+		for _, term := range terms {
+			if word == term {
+				highlights = append(highlights, dto.Highlight{Offset: start, Length: end - start})
+				break
+			}
+		}
+		start = end
+	}
+	return highlights
+}
+
+func encodeSearchCursor(createdAt time.Time, id uuid.UUID) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + id.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSearchCursor(cursor string) (time.Time, string, error) {
+	invalid := errors.New("invalid cursor")
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return time.Time{}, "", invalid
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, "", invalid
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return time.Time{}, "", invalid
+	}
+	return createdAt, id, nil
+}
@@ -4,13 +4,16 @@ import (
 	"context"
 	"encoding/base64"
 	"errors"
+	"log"
 	"mime/multipart"
 	"mozho_chat/internal/message/dto"
 	"mozho_chat/internal/models"
 	"mozho_chat/internal/repository"
 	"mozho_chat/pkg/encryption"
 	s3upload "mozho_chat/pkg/s3"
+	"mozho_chat/pkg/unfurl"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 )
@@ -24,15 +27,51 @@ type Service interface {
 	MarkMessageDelivered(userID, messageID string) error
 	MarkMessageUndelivered(userID, messageID string) error
 	GenerateAESKey() (string, error)
+	GetMentions(userID string, limit, offset int) ([]dto.MentionResponse, error)
+	GetMentionUnreadCounts(userID string) ([]dto.MentionUnreadCount, error)
+	MarkMentionsRead(userID, chatRoomID string) error
+	ScheduleMessage(senderID string, input dto.SendMessageRequest, files []*multipart.FileHeader) (*dto.ScheduledMessageResponse, error)
+	ListScheduledMessages(senderID, chatRoomID string) ([]dto.ScheduledMessageResponse, error)
+	UpdateScheduledMessage(senderID, scheduledID string, input dto.UpdateScheduledMessageRequest) (*dto.ScheduledMessageResponse, error)
+	CancelScheduledMessage(senderID, scheduledID string) error
+	PublishDueMessages(ctx context.Context) (int, error)
+	PurgeExpiredMessages(ctx context.Context) (int, error)
+	UpdateLiveLocation(userID, messageID string, input dto.UpdateLiveLocationRequest) (*dto.MessageResponse, error)
+	ForwardMessages(userID string, input dto.ForwardMessagesRequest) ([]dto.MessageResponse, error)
+	ProcessLinkPreviews(ctx context.Context) (int, error)
+	SearchMessages(userID string, input dto.SearchMessagesQuery) (*dto.SearchMessagesResponse, error)
+	ListReceipts(userID, messageID string) ([]dto.ReceiptResponse, error)
 }
 
+// Events delivers realtime events to rooms and users and tells who is
+// online. *redisdb.RedisClient implements it.
+type Events interface {
+	PublishRoomEvent(roomID, eventType string, data any) error
+	PublishUserEvent(userID, eventType string, data any) error
+	IsUserOnline(userID string) (bool, error)
+}
+
+const (
+	// maxMentionsPerMessage caps how many mention entities a single message may carry.
+	maxMentionsPerMessage = 50
+	// maxClientMsgIDLength matches the client_msg_id column size.
+	maxClientMsgIDLength = 64
+)
+
 type messageService struct {
 	repo         repository.MessageRepository
 	roomRepo     repository.ChatRoomRepository
 	userRepo     repository.UserRepository
 	attachmentRepo repository.AttachmentRepository
+	mentionRepo  repository.MentionRepository
+	scheduledRepo repository.ScheduledMessageRepository
+	linkPreviewRepo repository.LinkPreviewRepository
+	blockRepo    repository.BlockRepository
+	contactRepo  repository.ContactRepository
 	s3Service    s3upload.Service
 	encryption   encryption.EncryptionService
+	unfurler     unfurl.Fetcher
+	events       Events
 }
 
 func NewMessageService(
@@ -40,10 +79,17 @@ func NewMessageService(
 	roomRepo repository.ChatRoomRepository,
 	userRepo repository.UserRepository,
 	attachmentRepo repository.AttachmentRepository,
+	mentionRepo repository.MentionRepository,
+	scheduledRepo repository.ScheduledMessageRepository,
+	linkPreviewRepo repository.LinkPreviewRepository,
+	blockRepo repository.BlockRepository,
+	contactRepo repository.ContactRepository,
 	s3Service s3upload.Service,
 	encryption encryption.EncryptionService,
+	unfurler unfurl.Fetcher,
+	events Events,
 ) Service {
-	return &messageService{repo, roomRepo, userRepo, attachmentRepo, s3Service, encryption}
+	return &messageService{repo, roomRepo, userRepo, attachmentRepo, mentionRepo, scheduledRepo, linkPreviewRepo, blockRepo, contactRepo, s3Service, encryption, unfurler, events}
 }
 
 func (s *messageService) SendMessage(senderID string, input dto.SendMessageRequest, files []*multipart.FileHeader) (*dto.MessageResponse, error) {
@@ -51,8 +97,37 @@ func (s *messageService) SendMessage(senderID string, input dto.SendMessageReque
 	// In a real implementation, you'd look up or create a private room between sender and receiver
 	chatRoomID := mustParseUUID(input.ReceiverID)
 
+	// A retry of a message that already went through returns the original
+	if input.ClientMsgID != "" {
+		existing, err := s.findByClientMsgID(senderID, chatRoomID.String(), input.ClientMsgID)
+		if err != nil || existing != nil {
+			return existing, err
+		}
+	}
+
+	if input.Type != "" && input.Type != models.MessageTypeText {
+		return s.sendStructuredMessage(senderID, chatRoomID, input, files)
+	}
+
+	// Validate mentions against the plaintext before it gets encrypted
+	entities, err := s.resolveMentions(chatRoomID.String(), senderID, input.Content, input.Mentions)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt, err := s.messageExpiry(chatRoomID.String(), now, input.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, err := s.resolveAlgorithm(chatRoomID.String(), input.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
 	// Encrypt the content
-	encryptedContent, encryptionKey, err := s.encryptMessage(input.Content, input.Algorithm, input.EncryptionKey)
+	encryptedContent, encryptionKey, err := s.encryptMessage(input.Content, algorithm, input.EncryptionKey)
 	if err != nil {
 		return nil, err
 	}
@@ -61,15 +136,25 @@ func (s *messageService) SendMessage(senderID string, input dto.SendMessageReque
 		ID:         uuid.New(),
 		ChatRoomID: chatRoomID,
 		SenderID:   mustParseUUID(senderID),
+		Type:       models.MessageTypeText,
 		Content:    encryptedContent,
-		CreatedAt:  time.Now(),
+		ExpiresAt:  expiresAt,
+		CreatedAt:  now,
 		EncryptionMetadata: models.EncryptionMetadata{
-			Algorithm: input.Algorithm,
+			Algorithm: algorithm,
 			Key:       encryptionKey,
 		},
+		Mentions:   entities,
+	}
+	if input.ClientMsgID != "" {
+		msg.ClientMsgID = &input.ClientMsgID
 	}
 
 	if err := s.repo.Create(context.TODO(), msg); err != nil {
+		// A concurrent retry won the race; hand back what it stored
+		if errors.Is(err, repository.ErrDuplicateClientMsgID) {
+			return s.findRetriedMessage(senderID, chatRoomID.String(), input.ClientMsgID)
+		}
 		return nil, err
 	}
 
@@ -90,11 +175,54 @@ func (s *messageService) SendMessage(senderID string, input dto.SendMessageReque
 		s.attachmentRepo.Create(context.TODO(), attachment)
 	}
 
-	return dto.NewMessageResponse(msg), nil
+	s.indexForSearch(msg)
+	s.queueLinkPreviews(msg, input.Content)
+	return s.announceMessage(msg)
+}
+
+func (s *messageService) findByClientMsgID(senderID, chatRoomID, clientMsgID string) (*dto.MessageResponse, error) {
+	if len(clientMsgID) > maxClientMsgIDLength {
+		return nil, errors.New("client_msg_id is too long")
+	}
+	existing, err := s.repo.FindByClientMsgID(senderID, chatRoomID, clientMsgID)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	return dto.NewMessageResponse(existing), nil
+}
+
+// findRetriedMessage returns the message that made Create report a
+// duplicate client_msg_id. It is only missing when that message has expired
+// and is waiting to be purged.
+func (s *messageService) findRetriedMessage(senderID, chatRoomID, clientMsgID string) (*dto.MessageResponse, error) {
+	existing, err := s.findByClientMsgID(senderID, chatRoomID, clientMsgID)
+	if err == nil && existing == nil {
+		return nil, errors.New("client_msg_id was already used for a message that has expired")
+	}
+	return existing, err
+}
+
+// announceMessage broadcasts a freshly stored message to its room and
+// notifies everyone it mentions. The message is already saved, so mention
+// failures are only logged and the error is always nil; failing here would
+// make the client retry and send the message twice.
+func (s *messageService) announceMessage(msg *models.Message) (*dto.MessageResponse, error) {
+	response := dto.NewMessageResponse(msg)
+	s.publishMessageEvent(msg, "message.created", response)
+
+	mentioned, err := s.mentionRecipients(msg.ChatRoomID.String(), msg.SenderID.String(), msg.Mentions)
+	if err != nil {
+		log.Printf("failed to resolve mentions of message %s: %v", msg.ID, err)
+		return response, nil
+	}
+	if err := s.notifyMentions(msg, mentioned, response); err != nil {
+		log.Printf("failed to save mentions of message %s: %v", msg.ID, err)
+	}
+	return response, nil
 }
 
 func (s *messageService) GetMessages(chatRoomID, userID string, limit, offset int) ([]dto.MessageResponse, error) {
-	msgs, err := s.repo.FindByChatRoom(chatRoomID, limit, offset)
+	msgs, err := s.repo.FindByChatRoomForUser(chatRoomID, userID, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -106,7 +234,10 @@ func (s *messageService) MarkAsRead(userID, messageID string) error {
 }
 
 func (s *messageService) MarkMessageRead(userID, messageID string) error {
-	return s.repo.MarkRead(userID, messageID)
+	if err := s.repo.MarkRead(userID, messageID); err != nil {
+		return err
+	}
+	return s.mentionRepo.MarkRead(userID, messageID)
 }
 
 func (s *messageService) MarkMessageUnread(userID, messageID string) error {
@@ -125,6 +256,331 @@ func (s *messageService) GenerateAESKey() (string, error) {
 	return s.encryption.GenerateAESKey()
 }
 
+func (s *messageService) GetMentions(userID string, limit, offset int) ([]dto.MentionResponse, error) {
+	mentions, err := s.mentionRepo.FindByUser(userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.MentionResponse, 0, len(mentions))
+	for i := range mentions {
+		res = append(res, dto.NewMentionResponse(&mentions[i]))
+	}
+	return res, nil
+}
+
+func (s *messageService) GetMentionUnreadCounts(userID string) ([]dto.MentionUnreadCount, error) {
+	counts, err := s.mentionRepo.CountUnreadByRoom(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.MentionUnreadCount, 0, len(counts))
+	for _, c := range counts {
+		res = append(res, dto.MentionUnreadCount{ChatRoomID: c.ChatRoomID, Count: c.Count})
+	}
+	return res, nil
+}
+
+func (s *messageService) MarkMentionsRead(userID, chatRoomID string) error {
+	return s.mentionRepo.MarkRoomRead(userID, chatRoomID)
+}
+
+// messageExpiry works out when a message sent at sentAt disappears. An
+// explicit expiry wins over the room's timer; nil means never.
+func (s *messageService) messageExpiry(chatRoomID string, sentAt time.Time, override *time.Time) (*time.Time, error) {
+	if override != nil {
+		if !override.After(sentAt) {
+			return nil, errors.New("expires_at must be after the message is sent")
+		}
+		t := override.UTC()
+		return &t, nil
+	}
+
+	ttl, err := s.roomRepo.GetMessageTTL(chatRoomID)
+	if err != nil {
+		return nil, err
+	}
+	if ttl == 0 {
+		return nil, nil
+	}
+	t := sentAt.Add(ttl)
+	return &t, nil
+}
+
+// resolveMentions validates the mention entities sent with a message against
+// the plaintext content and the room membership.
+func (s *messageService) resolveMentions(chatRoomID, senderID, content string, input []dto.MentionEntity) ([]models.MentionEntity, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	if len(input) > maxMentionsPerMessage {
+		return nil, errors.New("too many mentions in message")
+	}
+
+	contentLen := utf8.RuneCountInString(content)
+	entities := make([]models.MentionEntity, 0, len(input))
+	senderRole := ""
+
+	for _, m := range input {
+		if m.Offset < 0 || m.Length <= 0 || m.Offset+m.Length > contentLen {
+			return nil, errors.New("mention is outside of the message content")
+		}
+		entity := models.MentionEntity{Type: m.Type, Offset: m.Offset, Length: m.Length}
+
+		switch m.Type {
+		case models.MentionTypeUser:
+			userID, err := uuid.Parse(m.UserID)
+			if err != nil {
+				return nil, errors.New("invalid mentioned user id")
+			}
+			inRoom, err := s.roomRepo.IsUserInRoom(chatRoomID, userID.String())
+			if err != nil {
+				return nil, err
+			}
+			if !inRoom {
+				return nil, errors.New("mentioned user is not a member of this room")
+			}
+			entity.UserID = &userID
+
+		case models.MentionTypeRoom, models.MentionTypeHere:
+			if senderRole == "" {
+				role, err := s.roomRepo.GetMemberRole(chatRoomID, senderID)
+				if err != nil {
+					return nil, errors.New("user not in room")
+				}
+				senderRole = role
+			}
+			if senderRole != models.RoleOwner && senderRole != models.RoleAdmin {
+				return nil, errors.New("only room owners and admins can mention @room or @here")
+			}
+
+		default:
+			return nil, errors.New("unsupported mention type")
+		}
+
+		entities = append(entities, entity)
+	}
+
+	return entities, nil
+}
+
+// mentionRecipients expands validated mention entities into the users that
+// should be notified, keyed by user ID with the mention type as value.
+// Users who are no longer members, the sender and users who blocked the
+// sender are never notified.
+func (s *messageService) mentionRecipients(chatRoomID, senderID string, entities []models.MentionEntity) (map[string]string, error) {
+	if len(entities) == 0 {
+		return nil, nil
+	}
+
+	memberIDs, err := s.roomRepo.ListMemberIDs(chatRoomID)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool, len(memberIDs))
+	for _, id := range memberIDs {
+		members[id] = true
+	}
+
+	recipients := make(map[string]string)
+	for _, e := range entities {
+		if e.Type == models.MentionTypeUser && e.UserID != nil && members[e.UserID.String()] {
+			// A direct mention wins over a broadcast one
+			recipients[e.UserID.String()] = models.MentionTypeUser
+		}
+	}
+	for _, e := range entities {
+		if e.Type != models.MentionTypeRoom && e.Type != models.MentionTypeHere {
+			continue
+		}
+		for _, memberID := range memberIDs {
+			if _, ok := recipients[memberID]; ok {
+				continue
+			}
+			if e.Type == models.MentionTypeHere {
+				online, err := s.events.IsUserOnline(memberID)
+				if err != nil {
+					return nil, err
+				}
+				if !online {
+					continue
+				}
+			}
+			recipients[memberID] = e.Type
+		}
+	}
+
+	delete(recipients, senderID)
+
+	// People who blocked the sender are not told about their messages
+	candidates := make([]string, 0, len(recipients))
+	for id := range recipients {
+		candidates = append(candidates, id)
+	}
+	blockers, err := s.blockRepo.ListBlockerIDs(senderID, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range blockers {
+		delete(recipients, id)
+	}
+	return recipients, nil
+}
+
+// notifyMentions fills the mentions inbox of every mentioned user and pushes
+// a notification to their personal channel.
+func (s *messageService) notifyMentions(msg *models.Message, mentioned map[string]string, response *dto.MessageResponse) error {
+	if len(mentioned) == 0 {
+		return nil
+	}
+
+	rows := make([]models.UserMention, 0, len(mentioned))
+	for userID, mentionType := range mentioned {
+		rows = append(rows, models.UserMention{
+			ID:         uuid.New(),
+			MessageID:  msg.ID,
+			ChatRoomID: msg.ChatRoomID,
+			UserID:     mustParseUUID(userID),
+			SenderID:   msg.SenderID,
+			Type:       mentionType,
+			CreatedAt:  msg.CreatedAt,
+		})
+	}
+	if err := s.mentionRepo.CreateBatch(context.TODO(), rows); err != nil {
+		return err
+	}
+
+	for i := range rows {
+		notification := dto.NewMentionResponse(&rows[i])
+		notification.Message = response
+		if err := s.events.PublishUserEvent(rows[i].UserID.String(), "mention.created", notification); err != nil {
+			log.Printf("failed to publish mention notification: %v", err)
+		}
+	}
+	return nil
+}
+
+// publishRoomEvent broadcasts an event to the room channel. Delivery is best
+// effort: the message is already persisted, so failures are only logged.
+func (s *messageService) publishRoomEvent(chatRoomID, eventType string, data any) {
+	if err := s.events.PublishRoomEvent(chatRoomID, eventType, data); err != nil {
+		log.Printf("failed to publish %s event: %v", eventType, err)
+	}
+}
+
+// publishMessageEvent delivers an event carrying msg to the members of its
+// room through PublishToMembers.
+func (s *messageService) publishMessageEvent(msg *models.Message, eventType string, data any) {
+	PublishToMembers(s.roomRepo, s.blockRepo, s.events, msg.ChatRoomID.String(), msg.SenderID.String(), eventType, data)
+}
+
+// UserEvents delivers an event to the personal channel of a single user.
+type UserEvents interface {
+	PublishUserEvent(userID, eventType string, data any) error
+}
+
+// PublishToMembers delivers an event about something senderID posted to the
+// personal channel of every member of the room, except those who blocked
+// the sender: GetMessages hides the sender's messages from them, and so
+// must events. Delivery is best effort like publishRoomEvent.
+func PublishToMembers(rooms repository.ChatRoomRepository, blocks repository.BlockRepository, events UserEvents, roomID, senderID, eventType string, data any) {
+	memberIDs, err := rooms.ListMemberIDs(roomID)
+	if err != nil {
+		log.Printf("failed to publish %s event: %v", eventType, err)
+		return
+	}
+	blockers, err := blocks.ListBlockerIDs(senderID, memberIDs)
+	if err != nil {
+		log.Printf("failed to publish %s event: %v", eventType, err)
+		return
+	}
+	skip := make(map[string]bool, len(blockers))
+	for _, id := range blockers {
+		skip[id] = true
+	}
+
+	for _, memberID := range memberIDs {
+		if skip[memberID] {
+			continue
+		}
+		if err := events.PublishUserEvent(memberID, eventType, data); err != nil {
+			log.Printf("failed to publish %s event: %v", eventType, err)
+		}
+	}
+}
+
+// resolveAlgorithm applies the room's encryption mode to the algorithm the
+// client asked for. Rooms in "server" or "none" mode pick the algorithm
+// themselves so that their messages stay searchable.
+func (s *messageService) resolveAlgorithm(chatRoomID, algorithm string) (string, error) {
+	mode, err := s.roomRepo.GetEncryptionMode(chatRoomID)
+	if err != nil {
+		return "", err
+	}
+
+	switch mode {
+	case models.EncryptionModeServer:
+		if algorithm != "" && algorithm != "AES" {
+			return "", errors.New("this room only accepts server-encrypted (AES) messages")
+		}
+		return "AES", nil
+	case models.EncryptionModeNone:
+		if algorithm != "" && algorithm != models.EncryptionNone {
+			return "", errors.New("this room only accepts plaintext messages")
+		}
+		return models.EncryptionNone, nil
+	default:
+		if algorithm == models.EncryptionNone {
+			return "", errors.New("plaintext messages are not allowed in end-to-end encrypted rooms")
+		}
+		return algorithm, nil
+	}
+}
+
+func (s *messageService) readableText(msg *models.Message) string {
+	text, _ := ReadableText(s.encryption, msg)
+	return text
+}
+
+// ReadableText returns the plaintext of a stored message. The boolean is
+// false when the server cannot read it, e.g. RSA messages that are
+// end-to-end encrypted.
+func ReadableText(enc encryption.EncryptionService, msg *models.Message) (string, bool) {
+	switch msg.EncryptionMetadata.Algorithm {
+	case models.EncryptionNone:
+		return msg.Content, true
+	case "AES":
+		key, err := base64.StdEncoding.DecodeString(msg.EncryptionMetadata.Key)
+		if err != nil {
+			return "", false
+		}
+		plaintext, err := enc.DecryptWithAES(msg.Content, string(key))
+		if err != nil {
+			return "", false
+		}
+		return plaintext, true
+	}
+	return "", false
+}
+
+// indexForSearch adds a text message to the full-text index when its room
+// allows the server to search messages.
+func (s *messageService) indexForSearch(msg *models.Message) {
+	if msg.Type != models.MessageTypeText {
+		return
+	}
+	mode, err := s.roomRepo.GetEncryptionMode(msg.ChatRoomID.String())
+	if err != nil || mode == models.EncryptionModeE2E {
+		return
+	}
+	text := s.readableText(msg)
+	if text == "" {
+		return
+	}
+	if err := s.repo.IndexForSearch(msg.ID.String(), text); err != nil {
+		log.Printf("failed to index message %s for search: %v", msg.ID, err)
+	}
+}
+
 // encryptMessage encrypts the plaintext content using the specified algorithm
 func (s *messageService) encryptMessage(plaintext, algorithm, providedKey string) (string, string, error) {
 	switch algorithm {
@@ -164,6 +620,10 @@ func (s *messageService) encryptMessage(plaintext, algorithm, providedKey string
 		}
 		return encrypted, keyForStorage, nil
 	
+	case models.EncryptionNone:
+		// Only reachable for rooms whose encryption mode is "none"
+		return plaintext, "", nil
+
 	case "RSA":
 		if providedKey == "" {
 			return "", "", errors.New("RSA public key is required")
@@ -0,0 +1,276 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"mime/multipart"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+)
+
+const (
+	// maxLocationLabelLength caps the label shown under a shared location.
+	maxLocationLabelLength = 200
+	// maxLiveLocationMinutes is the longest a live location can be shared for.
+	maxLiveLocationMinutes = 8 * 60
+)
+
+// sendStructuredMessage stores a message whose data lives in the payload
+// instead of the encrypted content, e.g. a location or a contact card. In
+// end-to-end encrypted rooms the data stays in the content instead, see
+// sealStructuredMessage.
+func (s *messageService) sendStructuredMessage(senderID string, chatRoomID uuid.UUID, input dto.SendMessageRequest, files []*multipart.FileHeader) (*dto.MessageResponse, error) {
+	if len(input.Mentions) > 0 {
+		return nil, errors.New("mentions are only supported in text messages")
+	}
+	if len(files) > 0 {
+		return nil, errors.New("attachments are only supported in text messages")
+	}
+
+	inRoom, err := s.roomRepo.IsUserInRoom(chatRoomID.String(), senderID)
+	if err != nil {
+		return nil, err
+	}
+	if !inRoom {
+		return nil, errors.New("user not in room")
+	}
+	mode, err := s.roomRepo.GetEncryptionMode(chatRoomID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	expiresAt, err := s.messageExpiry(chatRoomID.String(), now, input.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &models.Message{
+		ID:         uuid.New(),
+		ChatRoomID: chatRoomID,
+		SenderID:   mustParseUUID(senderID),
+		Type:       input.Type,
+		ExpiresAt:  expiresAt,
+		CreatedAt:  now,
+	}
+	if mode == models.EncryptionModeE2E {
+		if err := s.sealStructuredMessage(msg, input); err != nil {
+			return nil, err
+		}
+	} else {
+		if input.Content != "" {
+			return nil, errors.New("content must be empty for " + input.Type + " messages")
+		}
+		payload, err := s.buildPayload(input.Type, input.Payload, now)
+		if err != nil {
+			return nil, err
+		}
+		msg.Payload = payload
+		msg.EncryptionMetadata = models.EncryptionMetadata{Algorithm: models.EncryptionNone}
+	}
+	if input.ClientMsgID != "" {
+		msg.ClientMsgID = &input.ClientMsgID
+	}
+
+	if err := s.repo.Create(context.TODO(), msg); err != nil {
+		if errors.Is(err, repository.ErrDuplicateClientMsgID) {
+			return s.findRetriedMessage(senderID, chatRoomID.String(), input.ClientMsgID)
+		}
+		return nil, err
+	}
+
+	return s.announceMessage(msg)
+}
+
+// sealStructuredMessage fills in a structured message for an end-to-end
+// encrypted room. The server may not read the payload there, so the client
+// encrypts it into content like the text of a message, and the payload is
+// left empty. Live locations are refused because the server has to move
+// them.
+func (s *messageService) sealStructuredMessage(msg *models.Message, input dto.SendMessageRequest) error {
+	switch input.Type {
+	case models.MessageTypeLocation, models.MessageTypeContact:
+	case models.MessageTypeLiveLocation:
+		return errors.New("live locations cannot be shared in end-to-end encrypted rooms")
+	default:
+		return errors.New("unsupported message type")
+	}
+	if input.Content == "" || len(input.Payload) > 0 {
+		return errors.New("in end-to-end encrypted rooms " + input.Type + " messages carry their encrypted payload in content")
+	}
+
+	algorithm, err := s.resolveAlgorithm(msg.ChatRoomID.String(), input.Algorithm)
+	if err != nil {
+		return err
+	}
+	content, key, err := s.encryptMessage(input.Content, algorithm, input.EncryptionKey)
+	if err != nil {
+		return err
+	}
+	msg.Content = content
+	msg.EncryptionMetadata = models.EncryptionMetadata{Algorithm: algorithm, Key: key}
+	return nil
+}
+
+// buildPayload validates the client payload for msgType and returns the
+// normalized JSON that gets stored with the message.
+func (s *messageService) buildPayload(msgType string, raw json.RawMessage, now time.Time) (datatypes.JSON, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("payload is required for " + msgType + " messages")
+	}
+
+	var payload any
+	switch msgType {
+	case models.MessageTypeLocation:
+		var location dto.LocationPayload
+		if err := json.Unmarshal(raw, &location); err != nil {
+			return nil, errors.New("invalid location payload")
+		}
+		if err := validateCoordinates(location.Latitude, location.Longitude); err != nil {
+			return nil, err
+		}
+		location.Label = strings.TrimSpace(location.Label)
+		if utf8.RuneCountInString(location.Label) > maxLocationLabelLength {
+			return nil, errors.New("location label is too long")
+		}
+		payload = location
+
+	case models.MessageTypeLiveLocation:
+		var live dto.LiveLocationPayload
+		if err := json.Unmarshal(raw, &live); err != nil {
+			return nil, errors.New("invalid live location payload")
+		}
+		if err := validateCoordinates(live.Latitude, live.Longitude); err != nil {
+			return nil, err
+		}
+		if err := validateAccuracy(live.Accuracy); err != nil {
+			return nil, err
+		}
+		if live.DurationMinutes < 1 || live.DurationMinutes > maxLiveLocationMinutes {
+			return nil, errors.New("duration_minutes must be between 1 and 480")
+		}
+		live.LiveUntil = now.Add(time.Duration(live.DurationMinutes) * time.Minute).UTC()
+		live.UpdatedAt = now.UTC()
+		live.StoppedAt = nil
+		payload = live
+
+	case models.MessageTypeContact:
+		var contact dto.ContactPayload
+		if err := json.Unmarshal(raw, &contact); err != nil {
+			return nil, errors.New("invalid contact payload")
+		}
+		if _, err := uuid.Parse(contact.UserID); err != nil {
+			return nil, errors.New("invalid contact user id")
+		}
+		user, err := s.userRepo.FindByID(contact.UserID)
+		if err != nil {
+			return nil, errors.New("contact user not found")
+		}
+		contact.UserID = user.ID.String()
+		contact.Username = user.Username
+		payload = contact
+
+	default:
+		return nil, errors.New("unsupported message type")
+	}
+
+	return json.Marshal(payload)
+}
+
+// UpdateLiveLocation moves or stops a live location the user is sharing and
+// streams the new position to the room.
+func (s *messageService) UpdateLiveLocation(userID, messageID string, input dto.UpdateLiveLocationRequest) (*dto.MessageResponse, error) {
+	msg, err := s.repo.FindByID(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if msg.SenderID.String() != userID || msg.Type != models.MessageTypeLiveLocation {
+		return nil, errors.New("live location not found")
+	}
+
+	var live dto.LiveLocationPayload
+	if err := json.Unmarshal(msg.Payload, &live); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if live.StoppedAt != nil || !now.Before(live.LiveUntil) {
+		return nil, errors.New("live location has ended")
+	}
+
+	if input.Stop {
+		live.StoppedAt = &now
+	} else {
+		// The room may have switched to end-to-end encryption since sharing began
+		if err := s.requirePlaintextRoom(msg.ChatRoomID.String()); err != nil {
+			return nil, err
+		}
+		if input.Latitude == nil || input.Longitude == nil {
+			return nil, errors.New("latitude and longitude are required")
+		}
+		if err := validateCoordinates(*input.Latitude, *input.Longitude); err != nil {
+			return nil, err
+		}
+		if err := validateAccuracy(input.Accuracy); err != nil {
+			return nil, err
+		}
+		live.Latitude = *input.Latitude
+		live.Longitude = *input.Longitude
+		live.Accuracy = input.Accuracy
+	}
+	live.UpdatedAt = now
+
+	payload, err := json.Marshal(live)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdatePayload(messageID, payload); err != nil {
+		return nil, err
+	}
+	msg.Payload = payload
+
+	response := dto.NewMessageResponse(msg)
+	s.publishMessageEvent(msg, "live_location.updated", response)
+	return response, nil
+}
+
+// requirePlaintextRoom rejects plaintext payloads in rooms whose messages the
+// server may not read. Payloads are validated and updated by the server, so
+// they are stored as plaintext.
+func (s *messageService) requirePlaintextRoom(chatRoomID string) error {
+	mode, err := s.roomRepo.GetEncryptionMode(chatRoomID)
+	if err != nil {
+		return err
+	}
+	if mode == models.EncryptionModeE2E {
+		return errors.New("locations and contacts cannot be sent to end-to-end encrypted rooms")
+	}
+	return nil
+}
+
+func validateCoordinates(lat, lng float64) error {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return errors.New("latitude must be between -90 and 90")
+	}
+	if math.IsNaN(lng) || lng < -180 || lng > 180 {
+		return errors.New("longitude must be between -180 and 180")
+	}
+	return nil
+}
+
+func validateAccuracy(accuracy *float64) error {
+	if accuracy != nil && (math.IsNaN(*accuracy) || *accuracy < 0) {
+		return errors.New("accuracy must be a positive number of meters")
+	}
+	return nil
+}
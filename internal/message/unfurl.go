@@ -0,0 +1,203 @@
+package message
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/pkg/unfurl"
+)
+
+const (
+	// maxPreviewsPerMessage caps how many links in one message get a preview.
+	maxPreviewsPerMessage = 3
+	// unfurlLease is how long a claimed job is hidden from other workers.
+	unfurlLease = 2 * time.Minute
+	// maxUnfurlAttempts is how often a job is tried before it is given up.
+	maxUnfurlAttempts = 3
+	// previewCacheTTL is how long a fetched preview is reused for the same URL.
+	previewCacheTTL = 24 * time.Hour
+)
+
+var previewImageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// StartUnfurler builds link previews for queued messages every interval until ctx is done.
+func StartUnfurler(ctx context.Context, service Service, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := service.ProcessLinkPreviews(ctx); err != nil {
+					log.Printf("unfurler: failed to process link previews: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// queueLinkPreviews schedules previews for the links in a message. Messages
+// in end-to-end encrypted rooms are never unfurled, whatever their algorithm.
+func (s *messageService) queueLinkPreviews(msg *models.Message, plaintext string) {
+	if msg.Type != models.MessageTypeText {
+		return
+	}
+	mode, err := s.roomRepo.GetEncryptionMode(msg.ChatRoomID.String())
+	if err != nil {
+		log.Printf("failed to load encryption mode for message %s: %v", msg.ID, err)
+		return
+	}
+	if mode == models.EncryptionModeE2E {
+		return
+	}
+	urls := unfurl.ExtractURLs(plaintext, maxPreviewsPerMessage)
+	if len(urls) == 0 {
+		return
+	}
+
+	job := &models.LinkPreviewJob{
+		MessageID: msg.ID,
+		URLs:      urls,
+		RunAfter:  time.Now(),
+	}
+	if err := s.linkPreviewRepo.CreateJob(job); err != nil {
+		log.Printf("failed to queue link previews for message %s: %v", msg.ID, err)
+	}
+}
+
+// ProcessLinkPreviews works through the queued unfurl jobs and returns how
+// many messages got previews.
+func (s *messageService) ProcessLinkPreviews(ctx context.Context) (int, error) {
+	processed := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return processed, err
+		}
+
+		job, err := s.linkPreviewRepo.ClaimNextJob(ctx, time.Now(), unfurlLease)
+		if err != nil {
+			return processed, err
+		}
+		if job == nil {
+			return processed, nil
+		}
+
+		previews, failed := s.buildPreviews(ctx, job)
+		// Retry later while every link failed; the lease doubles as back-off
+		if failed == len(job.URLs) && job.Attempts < maxUnfurlAttempts {
+			continue
+		}
+
+		if err := s.linkPreviewRepo.CompleteJob(ctx, job, previews); err != nil {
+			return processed, err
+		}
+		if len(previews) == 0 {
+			continue
+		}
+
+		processed++
+		msg, err := s.repo.FindByID(job.MessageID.String())
+		if err != nil {
+			// The message expired or was deleted in the meantime
+			continue
+		}
+		s.publishMessageEvent(msg, "message.updated", dto.NewMessageResponse(msg))
+	}
+}
+
+// buildPreviews fetches every link of the job and reports how many failed.
+func (s *messageService) buildPreviews(ctx context.Context, job *models.LinkPreviewJob) ([]models.LinkPreview, int) {
+	var previews []models.LinkPreview
+	failed := 0
+
+	for i, url := range job.URLs {
+		preview, err := s.buildPreview(ctx, job.MessageID, url)
+		if err != nil {
+			log.Printf("unfurler: failed to fetch %s: %v", url, err)
+			failed++
+			continue
+		}
+		if preview == nil {
+			continue
+		}
+		preview.Position = i
+		previews = append(previews, *preview)
+	}
+	return previews, failed
+}
+
+// buildPreview returns the preview for a single link, or nil when the page
+// has nothing to show. Recent previews of the same URL are reused so popular
+// links are not fetched and uploaded again.
+func (s *messageService) buildPreview(ctx context.Context, messageID uuid.UUID, url string) (*models.LinkPreview, error) {
+	preview := &models.LinkPreview{
+		ID:        uuid.New(),
+		MessageID: messageID,
+		URL:       url,
+	}
+
+	cached, err := s.linkPreviewRepo.FindRecentByURL(url, time.Now().Add(-previewCacheTTL))
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil {
+		preview.Title = cached.Title
+		preview.Description = cached.Description
+		preview.SiteName = cached.SiteName
+		preview.ImageURL = cached.ImageURL
+		return preview, nil
+	}
+
+	page, err := s.unfurler.Fetch(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if page.Empty() {
+		return nil, nil
+	}
+	preview.Title = page.Title
+	preview.Description = page.Description
+	preview.SiteName = page.SiteName
+
+	if page.ImageURL != "" {
+		// A broken image should not cost us the rest of the preview
+		imageURL, err := s.cachePreviewImage(ctx, messageID, page.ImageURL)
+		if err != nil {
+			log.Printf("unfurler: failed to cache image %s: %v", page.ImageURL, err)
+		}
+		preview.ImageURL = imageURL
+	}
+	return preview, nil
+}
+
+// cachePreviewImage copies a preview image into the public bucket and
+// returns its URL there.
+func (s *messageService) cachePreviewImage(ctx context.Context, messageID uuid.UUID, imageURL string) (string, error) {
+	data, mimeType, err := s.unfurler.FetchImage(ctx, imageURL)
+	if err != nil {
+		return "", err
+	}
+	ext, ok := previewImageExtensions[mimeType]
+	if !ok {
+		return "", unfurl.ErrNotImage
+	}
+
+	uploaded, err := s.s3Service.UploadPhoto(data, "preview"+ext, "link-previews", messageID.String(), "image", true, false)
+	if err != nil {
+		return "", err
+	}
+	return uploaded["original"], nil
+}
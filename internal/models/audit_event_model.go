@@ -0,0 +1,36 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+    "gorm.io/datatypes"
+)
+
+const (
+    AuditEventAccountLocked   = "account_locked"
+    AuditEventAccountUnlocked = "account_unlocked"
+    AuditEventIPLocked        = "ip_locked"
+
+    AuditEventAccountDeletionRequested = "account_deletion_requested"
+    AuditEventAccountDeletionCancelled = "account_deletion_cancelled"
+    AuditEventAccountDeleted           = "account_deleted"
+
+    // AuditEventRoomExportedByNonMember records a compliance or admin user
+    // exporting a room they are not a member of
+    AuditEventRoomExportedByNonMember = "room_exported_by_non_member"
+)
+
+// AuditEvent records a security-relevant change to an account or access to
+// data outside the actor's own rooms.
+type AuditEvent struct {
+    ID        uuid.UUID      `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    Event     string         `gorm:"type:varchar(50);not null;index"`
+    // UserID is the account the event is about, if there is one
+    UserID    *uuid.UUID     `gorm:"type:uuid;index"`
+    // ActorID is who caused the event; empty for automatic events
+    ActorID   *uuid.UUID     `gorm:"type:uuid"`
+    IPAddress string         `gorm:"type:varchar(45)"`
+    Details   datatypes.JSON `gorm:"type:jsonb"`
+    CreatedAt time.Time      `gorm:"autoCreateTime;index"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Block hides BlockedID from BlockerID: the blocked user cannot start a
+// direct message or send a contact request, and the blocker no longer sees
+// their messages, typing or notifications.
+type Block struct {
+    BlockerID   uuid.UUID `gorm:"type:uuid;primaryKey"`
+    BlockedID   uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+    CreatedAt   time.Time `gorm:"autoCreateTime"`
+    BlockedUser User      `gorm:"foreignKey:BlockedID"`
+}
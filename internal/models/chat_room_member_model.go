@@ -6,9 +6,16 @@ import (
     "github.com/google/uuid"
 )
 
+const (
+    RoleOwner  = "owner"
+    RoleAdmin  = "admin"
+    RoleMember = "member"
+)
+
 type ChatRoomMember struct {
     ID         uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
     ChatRoomID uuid.UUID `gorm:"column:chat_room_id;type:uuid;not null;index"`
     UserID     uuid.UUID `gorm:"column:user_id;type:uuid;not null;index"`
+    Role       string    `gorm:"type:varchar(20);default:'member';not null"`
     JoinedAt   time.Time `gorm:"column:joined_at;autoCreateTime"`
 }
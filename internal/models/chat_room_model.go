@@ -6,10 +6,30 @@ import (
     "github.com/google/uuid"
 )
 
+const (
+    // EncryptionModeE2E leaves encryption to the clients; the server cannot read or search messages
+    EncryptionModeE2E    = "e2e"
+    // EncryptionModeServer encrypts messages at rest with server-managed keys
+    EncryptionModeServer = "server"
+    // EncryptionModeNone stores messages as plaintext
+    EncryptionModeNone   = "none"
+)
+
 type ChatRoom struct {
     ID      uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
     Name    string
     Users   []User    `gorm:"many2many:chat_room_members;foreignKey:ID;joinForeignKey:ChatRoomID;References:ID;joinReferences:UserID"`
     IsGroup bool      `gorm:"default:false;not null"`
+    // MessageTTLSeconds makes new messages disappear after this many seconds
+    MessageTTLSeconds *int `gorm:"column:message_ttl_seconds"`
+    // RestrictForwarding stops members from forwarding messages out of the room
+    RestrictForwarding bool `gorm:"default:false;not null"`
+    EncryptionMode    string `gorm:"type:varchar(20);default:'e2e';not null"`
+    // RequestedEncryptionMode is a weaker mode one participant of a direct
+    // message asked for; it applies once the other one asks for it too
+    RequestedEncryptionMode *string    `gorm:"type:varchar(20)"`
+    EncryptionRequestedBy   *uuid.UUID `gorm:"type:uuid"`
+    // ExternalID identifies the imported Slack channel or WhatsApp chat
+    ExternalID *string `gorm:"type:varchar(255)"`
     CreatedAt time.Time `gorm:"autoCreateTime"`
 }
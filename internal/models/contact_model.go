@@ -0,0 +1,35 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    ContactRequestPending  = "pending"
+    ContactRequestAccepted = "accepted"
+    ContactRequestDeclined = "declined"
+)
+
+// ContactRequest asks another user to become a contact. Cancelled requests
+// are deleted; answered ones are kept so declines can be rate-limited.
+type ContactRequest struct {
+    ID          uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    RequesterID uuid.UUID  `gorm:"type:uuid;not null;index"`
+    AddresseeID uuid.UUID  `gorm:"type:uuid;not null;index"`
+    Status      string     `gorm:"type:varchar(20);not null;default:'pending'"`
+    CreatedAt   time.Time  `gorm:"autoCreateTime"`
+    RespondedAt *time.Time
+    Requester   User       `gorm:"foreignKey:RequesterID"`
+    Addressee   User       `gorm:"foreignKey:AddresseeID"`
+}
+
+// Contact is one side of an accepted contact request; every contact is
+// stored once for each of the two users.
+type Contact struct {
+    UserID      uuid.UUID `gorm:"type:uuid;primaryKey"`
+    ContactID   uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+    CreatedAt   time.Time `gorm:"autoCreateTime"`
+    ContactUser User      `gorm:"foreignKey:ContactID"`
+}
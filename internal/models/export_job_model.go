@@ -0,0 +1,34 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    ExportStatusPending   = "pending"
+    ExportStatusRunning   = "running"
+    ExportStatusCompleted = "completed"
+    ExportStatusFailed    = "failed"
+    ExportStatusExpired   = "expired"
+)
+
+// ExportJob builds a downloadable archive of a room's history in the background.
+type ExportJob struct {
+    ID           uuid.UUID  `gorm:"type:uuid;primaryKey"`
+    ChatRoomID   uuid.UUID  `gorm:"type:uuid;not null"`
+    RequestedBy  uuid.UUID  `gorm:"type:uuid;not null;index"`
+    Status       string     `gorm:"type:varchar(20);default:'pending';not null"`
+    // ObjectKey is the archive's key in the private bucket once it is ready
+    ObjectKey    *string
+    MessageCount int        `gorm:"not null;default:0"`
+    Size         int64      `gorm:"not null;default:0"`
+    Error        *string
+    Attempts     int        `gorm:"not null;default:0"`
+    RunAfter     time.Time  `gorm:"not null"`
+    CompletedAt  *time.Time
+    ExpiresAt    *time.Time
+    CreatedAt    time.Time
+    UpdatedAt    time.Time
+}
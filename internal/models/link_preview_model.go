@@ -0,0 +1,30 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+    "gorm.io/datatypes"
+)
+
+type LinkPreview struct {
+    ID          uuid.UUID `gorm:"type:uuid;primaryKey"`
+    MessageID   uuid.UUID `gorm:"type:uuid;not null;index"`
+    URL         string    `gorm:"type:text;not null"`
+    Title       string    `gorm:"type:text;not null"`
+    Description string    `gorm:"type:text;not null"`
+    SiteName    string    `gorm:"type:text;not null"`
+    // ImageURL points at the copy of the preview image in the public bucket
+    ImageURL    string    `gorm:"type:text;not null"`
+    Position    int       `gorm:"not null"`
+    CreatedAt   time.Time
+}
+
+// LinkPreviewJob queues a message whose links still need to be unfurled.
+type LinkPreviewJob struct {
+    MessageID uuid.UUID                   `gorm:"type:uuid;primaryKey"`
+    URLs      datatypes.JSONSlice[string] `gorm:"column:urls;type:jsonb;not null;default:'[]'"`
+    Attempts  int                         `gorm:"not null;default:0"`
+    RunAfter  time.Time                   `gorm:"not null"`
+    CreatedAt time.Time
+}
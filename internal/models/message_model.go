@@ -4,20 +4,43 @@ import (
     "time"
 
     "github.com/google/uuid"
+    "gorm.io/datatypes"
 )
 
+const (
+    MessageTypeText         = "text"
+    MessageTypePoll         = "poll"
+    MessageTypeLocation     = "location"
+    MessageTypeLiveLocation = "live_location"
+    MessageTypeContact      = "contact"
+)
+
+// EncryptionNone marks content that is stored as plaintext.
+const EncryptionNone = "none"
+
 type Message struct {
     ID                uuid.UUID           `gorm:"type:uuid;primaryKey"`
     ChatRoomID        uuid.UUID           `gorm:"type:uuid;not null;index"`
     SenderID          uuid.UUID           `gorm:"type:uuid;not null;index"`
+    Type              string              `gorm:"type:varchar(20);default:'text';not null"`
     Content           string              `gorm:"type:text;not null"`
+    // Payload carries structured data for non-text message types
+    Payload           datatypes.JSON      `gorm:"type:jsonb"`
+    ClientMsgID       *string             `gorm:"type:varchar(64)"`
     EncryptionMetadata EncryptionMetadata `gorm:"embedded"`
+    Mentions          datatypes.JSONSlice[MentionEntity] `gorm:"type:jsonb;not null;default:'[]'"`
+    ExpiresAt         *time.Time          `gorm:"index"`
+    // Forwarded messages point back at the message they were first copied from
+    ForwardedFromMessageID *uuid.UUID     `gorm:"type:uuid"`
+    ForwardedFromRoomID    *uuid.UUID     `gorm:"type:uuid"`
+    ForwardedFromSenderID  *uuid.UUID     `gorm:"type:uuid"`
     CreatedAt         time.Time
     UpdatedAt         time.Time
 
     Sender      User         `gorm:"foreignKey:SenderID"`
     ChatRoom    ChatRoom     `gorm:"foreignKey:ChatRoomID"`
     Attachments []Attachment `gorm:"foreignKey:MessageID"`
+    LinkPreviews []LinkPreview `gorm:"foreignKey:MessageID"`
 }
 
 type EncryptionMetadata struct {
@@ -13,4 +13,5 @@ type MessageStatus struct {
     Delivered  bool      `gorm:"default:false;not null"`
     Read       bool      `gorm:"default:false;not null"`
     UpdatedAt  time.Time `gorm:"autoUpdateTime"`
+    User       User      `gorm:"foreignKey:UserID"`
 }
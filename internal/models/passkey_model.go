@@ -0,0 +1,47 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// Passkey is a WebAuthn credential a user can log in with instead of a
+// password.
+type Passkey struct {
+    ID             uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    UserID         uuid.UUID  `gorm:"type:uuid;not null;index"`
+    CredentialID   []byte     `gorm:"type:bytea;not null;uniqueIndex"`
+    // PublicKey is COSE encoded, as the authenticator sent it
+    PublicKey      []byte     `gorm:"type:bytea;not null"`
+    Algorithm      int64      `gorm:"not null"`
+    SignCount      int64      `gorm:"not null;default:0"`
+    AAGUID         []byte     `gorm:"type:bytea"`
+    // Transports are hints for the browser, comma separated
+    Transports     string
+    Name           string     `gorm:"type:varchar(100);not null"`
+    BackupEligible bool       `gorm:"not null;default:false"`
+    BackedUp       bool       `gorm:"not null;default:false"`
+    // CloneSuspectedAt is set when the signature counter went backwards.
+    // The passkey cannot log in after that
+    CloneSuspectedAt *time.Time
+    LastUsedAt     *time.Time
+    CreatedAt      time.Time  `gorm:"autoCreateTime"`
+}
+
+const (
+    WebAuthnPurposeRegistration = "registration"
+    WebAuthnPurposeLogin        = "login"
+)
+
+// WebAuthnChallenge is an open passkey ceremony. It is deleted when the
+// browser's response comes back, so each challenge is answered once.
+type WebAuthnChallenge struct {
+    ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    // UserID is empty for logins that let the user pick a passkey
+    UserID    *uuid.UUID `gorm:"type:uuid"`
+    Purpose   string     `gorm:"type:varchar(20);not null"`
+    Challenge []byte     `gorm:"type:bytea;not null;uniqueIndex"`
+    ExpiresAt time.Time  `gorm:"not null;index"`
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
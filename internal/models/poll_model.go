@@ -0,0 +1,75 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+    "gorm.io/datatypes"
+)
+
+type Poll struct {
+    ID             uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    MessageID      uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex"`
+    ChatRoomID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+    CreatorID      uuid.UUID  `gorm:"type:uuid;not null"`
+    Question       string     `gorm:"type:text;not null"`
+    MultipleChoice bool       `gorm:"default:false;not null"`
+    Anonymous      bool       `gorm:"default:false;not null"`
+    ClosesAt       *time.Time
+    ClosedAt       *time.Time
+    // FinalResults is the tally snapshot taken when the poll closed
+    FinalResults   datatypes.JSONSlice[PollOptionResult] `gorm:"type:jsonb;not null;default:'[]'"`
+    CreatedAt      time.Time  `gorm:"autoCreateTime"`
+
+    Options []PollOption `gorm:"foreignKey:PollID"`
+}
+
+// IsClosed reports whether the poll no longer accepts votes at the given time.
+func (p *Poll) IsClosed(now time.Time) bool {
+    return p.ClosedAt != nil || (p.ClosesAt != nil && !now.Before(*p.ClosesAt))
+}
+
+// Tally counts votes per option in option order. Voter IDs are only kept for
+// polls that are not anonymous.
+func (p *Poll) Tally(votes []PollVote) []PollOptionResult {
+    results := make([]PollOptionResult, len(p.Options))
+    index := make(map[uuid.UUID]int, len(p.Options))
+    for i, option := range p.Options {
+        results[i].OptionID = option.ID
+        index[option.ID] = i
+    }
+
+    for _, vote := range votes {
+        i, ok := index[vote.OptionID]
+        if !ok {
+            continue
+        }
+        results[i].Votes++
+        if !p.Anonymous {
+            results[i].VoterIDs = append(results[i].VoterIDs, vote.UserID)
+        }
+    }
+    return results
+}
+
+type PollOption struct {
+    ID       uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    PollID   uuid.UUID `gorm:"type:uuid;not null;index"`
+    Text     string    `gorm:"type:text;not null"`
+    Position int       `gorm:"not null"`
+}
+
+type PollVote struct {
+    ID        uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    PollID    uuid.UUID `gorm:"type:uuid;not null;index"`
+    OptionID  uuid.UUID `gorm:"type:uuid;not null;index"`
+    UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+    CreatedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// PollOptionResult is the tally of a single option.
+type PollOptionResult struct {
+    OptionID uuid.UUID   `json:"option_id"`
+    Votes    int64       `json:"votes"`
+    VoterIDs []uuid.UUID `json:"voter_ids,omitempty"`
+}
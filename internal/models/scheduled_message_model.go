@@ -0,0 +1,49 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+    "gorm.io/datatypes"
+)
+
+const (
+    ScheduledStatusPending   = "pending"
+    ScheduledStatusSent      = "sent"
+    ScheduledStatusCancelled = "cancelled"
+    // ScheduledStatusFailed marks a message the scheduler gave up sending
+    ScheduledStatusFailed    = "failed"
+)
+
+// ScheduledMessage holds an already encrypted message until SendAt, when the
+// scheduler turns it into a regular Message.
+type ScheduledMessage struct {
+    ID                 uuid.UUID          `gorm:"type:uuid;primaryKey"`
+    ChatRoomID         uuid.UUID          `gorm:"type:uuid;not null;index"`
+    SenderID           uuid.UUID          `gorm:"type:uuid;not null;index"`
+    Content            string             `gorm:"type:text;not null"`
+    ClientMsgID        *string            `gorm:"type:varchar(64)"`
+    EncryptionMetadata EncryptionMetadata `gorm:"embedded"`
+    Mentions           datatypes.JSONSlice[MentionEntity]       `gorm:"type:jsonb;not null;default:'[]'"`
+    Attachments        datatypes.JSONSlice[ScheduledAttachment] `gorm:"type:jsonb;not null;default:'[]'"`
+    SendAt             time.Time          `gorm:"not null;index"`
+    // ExpiresAt overrides the room's message TTL once the message is sent
+    ExpiresAt          *time.Time
+    Status             string             `gorm:"type:varchar(20);default:'pending';not null"`
+    MessageID          *uuid.UUID         `gorm:"type:uuid"`
+    // Attempts counts failed tries to send the message; LastError says why
+    // the last one failed
+    Attempts           int                `gorm:"not null;default:0"`
+    LastError          *string
+    CreatedAt          time.Time
+    UpdatedAt          time.Time
+}
+
+// ScheduledAttachment describes a file uploaded when the message was
+// scheduled. It becomes an Attachment row once the message is sent.
+type ScheduledAttachment struct {
+    Key      string `json:"key"`
+    FileName string `json:"file_name"`
+    MimeType string `json:"mime_type"`
+    Size     int64  `json:"size"`
+}
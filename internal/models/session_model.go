@@ -6,12 +6,23 @@ import (
     "github.com/google/uuid"
 )
 
+// Session is one refresh token. Tokens issued by rotating a refresh token
+// share the FamilyID of the login that started them.
 type Session struct {
-    ID           uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-    UserID       uuid.UUID `gorm:"type:uuid;not null;index"`
-    RefreshToken string    `gorm:"not null"`
-    Device       string
-    IPAddress    string
-    ExpiresAt    *time.Time
-    CreatedAt    time.Time `gorm:"autoCreateTime"`
+    ID               uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    UserID           uuid.UUID  `gorm:"type:uuid;not null;index"`
+    FamilyID         uuid.UUID  `gorm:"type:uuid;not null;index"`
+    // RefreshTokenHash is the SHA-256 of the token; the token itself is never stored
+    RefreshTokenHash string     `gorm:"not null;uniqueIndex"`
+    Device           string
+    IPAddress        string
+    // AuthenticatedAt is when the user logged in, carried over on rotation
+    AuthenticatedAt  time.Time  `gorm:"not null"`
+    LastUsedAt       *time.Time
+    // RotatedAt is set once the token has been exchanged for ReplacedByID
+    RotatedAt        *time.Time
+    ReplacedByID     *uuid.UUID `gorm:"type:uuid"`
+    RevokedAt        *time.Time
+    ExpiresAt        time.Time  `gorm:"not null;index"`
+    CreatedAt        time.Time  `gorm:"autoCreateTime"`
 }
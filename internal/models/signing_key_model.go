@@ -0,0 +1,16 @@
+package models
+
+import (
+    "time"
+)
+
+// SigningKey is a key access tokens are signed with. The private key is
+// encrypted; the public half is derived from it and served as a JWK.
+type SigningKey struct {
+    ID          string    `gorm:"type:varchar(64);primaryKey"`
+    Algorithm   string    `gorm:"type:varchar(16);not null"`
+    PrivateKey  string    `gorm:"type:text;not null"`
+    // ActivatesAt is when the key starts signing; it is published before
+    ActivatesAt time.Time `gorm:"not null"`
+    CreatedAt   time.Time `gorm:"autoCreateTime"`
+}
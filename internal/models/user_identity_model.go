@@ -0,0 +1,38 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// UserIdentity links a user to their account at an OpenID Connect
+// provider. The provider's subject identifies them from then on, even if
+// their email changes.
+type UserIdentity struct {
+    ID          uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    UserID      uuid.UUID  `gorm:"type:uuid;not null;index"`
+    Provider    string     `gorm:"type:varchar(50);not null;uniqueIndex:idx_user_identities_subject"`
+    Subject     string     `gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identities_subject"`
+    // Email is the address the provider last reported
+    Email       string
+    LastLoginAt *time.Time
+    CreatedAt   time.Time  `gorm:"autoCreateTime"`
+}
+
+// OIDCLoginState is a single sign-on that was started and not finished.
+// It is deleted when the provider sends the user back.
+type OIDCLoginState struct {
+    ID           uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    Provider     string     `gorm:"type:varchar(50);not null"`
+    // StateHash is the SHA-256 of the state parameter
+    StateHash    string     `gorm:"not null;uniqueIndex"`
+    Nonce        string     `gorm:"not null"`
+    CodeVerifier string     `gorm:"not null"`
+    ExpiresAt    time.Time  `gorm:"not null;index"`
+    CreatedAt    time.Time  `gorm:"autoCreateTime"`
+}
+
+func (OIDCLoginState) TableName() string {
+    return "oidc_login_states"
+}
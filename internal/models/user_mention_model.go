@@ -0,0 +1,37 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    MentionTypeUser = "user"
+    MentionTypeRoom = "room"
+    MentionTypeHere = "here"
+)
+
+// MentionEntity is a mention as written by the sender. Offsets and lengths
+// are counted in runes of the plaintext, so they stay meaningful after the
+// content has been encrypted.
+type MentionEntity struct {
+    Type   string     `json:"type"`
+    UserID *uuid.UUID `json:"user_id,omitempty"`
+    Offset int        `json:"offset"`
+    Length int        `json:"length"`
+}
+
+// UserMention is one entry in a user's mentions inbox.
+type UserMention struct {
+    ID         uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    MessageID  uuid.UUID  `gorm:"type:uuid;not null;index"`
+    ChatRoomID uuid.UUID  `gorm:"type:uuid;not null;index"`
+    UserID     uuid.UUID  `gorm:"type:uuid;not null;index"`
+    SenderID   uuid.UUID  `gorm:"type:uuid;not null"`
+    Type       string     `gorm:"type:varchar(10);not null"`
+    ReadAt     *time.Time
+    CreatedAt  time.Time  `gorm:"autoCreateTime"`
+
+    Message Message `gorm:"foreignKey:MessageID"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+// UserTOTP is a user's authenticator app. Two-factor login is on once
+// ConfirmedAt is set.
+type UserTOTP struct {
+    UserID      uuid.UUID  `gorm:"type:uuid;primaryKey"`
+    // Secret is the base32 secret encrypted with AES-GCM
+    Secret      string     `gorm:"not null"`
+    ConfirmedAt *time.Time
+    // LastStep is the time step of the last accepted code, so a code
+    // cannot be used twice
+    LastStep    int64      `gorm:"not null;default:0"`
+    CreatedAt   time.Time  `gorm:"autoCreateTime"`
+}
+
+func (UserTOTP) TableName() string {
+    return "user_totp"
+}
+
+// RecoveryCode is a single-use code for logging in without the authenticator.
+type RecoveryCode struct {
+    ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+    UserID    uuid.UUID  `gorm:"type:uuid;not null;index"`
+    CodeHash  string     `gorm:"not null"`
+    UsedAt    *time.Time
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
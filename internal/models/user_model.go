@@ -1,19 +1,198 @@
 package models
 
 import (
+    "encoding/json"
+    "strings"
     "time"
 
     "github.com/google/uuid"
     "gorm.io/datatypes"
 )
 
+const (
+    UserRoleUser       = "user"
+    UserRoleAdmin      = "admin"
+    // UserRoleCompliance may export rooms it is not a member of
+    UserRoleCompliance = "compliance"
+)
+
+// PlaceholderEmailDomain is used for imported users whose email is unknown.
+// Such accounts can never be claimed by registering.
+const PlaceholderEmailDomain = "import.invalid"
+
+func IsPlaceholderEmail(email string) bool {
+    return strings.HasSuffix(strings.ToLower(email), "@"+PlaceholderEmailDomain)
+}
+
 type User struct {
     ID        uuid.UUID  `gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
     Username  string     `gorm:"unique;not null"`
     ChatRooms []ChatRoom `gorm:"many2many:chat_room_members;joinForeignKey:UserID;joinReferences:RoomID"`
     Email     string     `gorm:"unique;not null"`
+    // DisplayName is shown alongside the username when set
+    DisplayName string   `gorm:"type:varchar(100);not null;default:''"`
+    // Phone is stored in E.164 form, e.g. +4915112345678
+    Phone     *string    `gorm:"type:varchar(16);unique"`
+    // EmailVerifiedAt is set once the user follows a verification link and
+    // cleared when the email changes
+    EmailVerifiedAt *time.Time
     PasswordHash string   `gorm:"not null"`
+    Role      string     `gorm:"type:varchar(20);default:'user';not null"`
+    // Placeholder users were created by an import and cannot log in until
+    // the owner of their email follows a verification or password reset
+    // link. Deleted accounts are placeholders too, but with an email nobody
+    // can receive.
+    Placeholder bool     `gorm:"default:false;not null"`
+    // Profile holds a UserProfile; use ProfileData and SetProfileData
     Profile   datatypes.JSON `gorm:"type:jsonb"`
+    Privacy   PrivacySettings `gorm:"embedded"`
+    // DeletionScheduledAt is when a deletion the user asked for will run;
+    // until then they can cancel it
+    DeletionScheduledAt *time.Time
+    // DeletedAt is set once the account has been anonymized. The row stays
+    // behind as the sender of the messages the user wrote.
+    DeletedAt *time.Time
     CreatedAt time.Time  `gorm:"autoCreateTime"`
     PublicKeys []UserPublicKey `gorm:"foreignKey:UserID"`
 }
+
+// DeletedUserDisplayName is shown instead of the name of a deleted user.
+const DeletedUserDisplayName = "Deleted user"
+
+// Anonymize turns u into the tombstone of a deleted account: everything
+// that identified the user is dropped, and the placeholder email keeps
+// anyone from registering or signing in as them.
+func (u *User) Anonymize(now time.Time) {
+    id := strings.ReplaceAll(u.ID.String(), "-", "")
+    u.Username = "deleted-" + id[:20]
+    u.Email = "deleted-" + id + "@" + PlaceholderEmailDomain
+    u.DisplayName = DeletedUserDisplayName
+    u.Phone = nil
+    u.EmailVerifiedAt = nil
+    u.PasswordHash = ""
+    u.Role = UserRoleUser
+    u.Placeholder = true
+    u.Profile = nil
+    u.Privacy = PrivacySettings{
+        DMsFromContactsOnly:    true,
+        LastSeenVisibility:     VisibilityNobody,
+        OnlineVisibility:       VisibilityNobody,
+        ProfilePhotoVisibility: VisibilityNobody,
+        ReadReceiptsVisibility: VisibilityNobody,
+    }
+    u.DeletionScheduledAt = nil
+    u.DeletedAt = &now
+}
+
+// PrivacySettings controls what other users can find out about an account.
+type PrivacySettings struct {
+    // DiscoverableByEmail lets people find the account by its exact email
+    DiscoverableByEmail bool `gorm:"default:true;not null"`
+    // DiscoverableByPhone lets people find the account by its exact phone
+    // number
+    DiscoverableByPhone bool `gorm:"default:true;not null"`
+    // DMsFromContactsOnly stops people who are not contacts from starting
+    // a direct message
+    DMsFromContactsOnly bool `gorm:"column:dms_from_contacts_only;default:false;not null"`
+    // The visibility settings below hold one of the Visibility constants
+    LastSeenVisibility     string `gorm:"type:varchar(10);default:'everyone';not null"`
+    OnlineVisibility       string `gorm:"type:varchar(10);default:'everyone';not null"`
+    ProfilePhotoVisibility string `gorm:"type:varchar(10);default:'everyone';not null"`
+    ReadReceiptsVisibility string `gorm:"type:varchar(10);default:'everyone';not null"`
+}
+
+// Who can see a piece of information about a user
+const (
+    VisibilityEveryone = "everyone"
+    VisibilityContacts = "contacts"
+    VisibilityNobody   = "nobody"
+)
+
+// VisibilityAllows reports whether a visibility setting lets someone see
+// the information. An unset visibility counts as everyone.
+func VisibilityAllows(visibility string, isContact bool) bool {
+    switch visibility {
+    case "", VisibilityEveryone:
+        return true
+    case VisibilityContacts:
+        return isContact
+    }
+    return false
+}
+
+// LastSeenVisibleTo reports whether viewer may see when u was last active.
+// Like online status and read receipts this is reciprocal: someone who
+// hides their own last seen from a user cannot see that user's either.
+func (u *User) LastSeenVisibleTo(viewer *User, isContact bool) bool {
+    return u.ID == viewer.ID || VisibilityAllows(u.Privacy.LastSeenVisibility, isContact) &&
+        VisibilityAllows(viewer.Privacy.LastSeenVisibility, isContact)
+}
+
+func (u *User) OnlineVisibleTo(viewer *User, isContact bool) bool {
+    return u.ID == viewer.ID || VisibilityAllows(u.Privacy.OnlineVisibility, isContact) &&
+        VisibilityAllows(viewer.Privacy.OnlineVisibility, isContact)
+}
+
+// ReadReceiptsVisibleTo reports whether sender may see that u read their
+// messages.
+func (u *User) ReadReceiptsVisibleTo(sender *User, isContact bool) bool {
+    return u.ID == sender.ID || VisibilityAllows(u.Privacy.ReadReceiptsVisibility, isContact) &&
+        VisibilityAllows(sender.Privacy.ReadReceiptsVisibility, isContact)
+}
+
+// ProfilePhotoVisible reports whether u's avatar may be shown to someone
+// else. Unlike the other settings it is not reciprocal.
+func (u *User) ProfilePhotoVisible(isContact bool) bool {
+    return VisibilityAllows(u.Privacy.ProfilePhotoVisibility, isContact)
+}
+
+// UserProfile is the schema of User.Profile.
+type UserProfile struct {
+    Bio      string `json:"bio,omitempty"`
+    // Timezone is an IANA name such as Europe/Berlin
+    Timezone string `json:"timezone,omitempty"`
+    StatusText string `json:"status_text,omitempty"`
+    // StatusExpiresAt clears the status once it has passed; nil keeps it
+    // until the user changes it
+    StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+    AvatarURL          string `json:"avatar_url,omitempty"`
+    AvatarThumbnailURL string `json:"avatar_thumbnail_url,omitempty"`
+    // AvatarKey and AvatarThumbnailKey locate the avatar in the public
+    // bucket so it can be deleted when replaced
+    AvatarKey          string `json:"avatar_key,omitempty"`
+    AvatarThumbnailKey string `json:"avatar_thumbnail_key,omitempty"`
+}
+
+// StatusActive reports whether the status is set and has not expired.
+func (p UserProfile) StatusActive(now time.Time) bool {
+    return p.StatusText != "" && (p.StatusExpiresAt == nil || p.StatusExpiresAt.After(now))
+}
+
+// ProfileData decodes Profile. Keys that are not part of UserProfile are
+// dropped.
+func (u *User) ProfileData() (UserProfile, error) {
+    var p UserProfile
+    if len(u.Profile) == 0 || string(u.Profile) == "null" {
+        return p, nil
+    }
+    err := json.Unmarshal(u.Profile, &p)
+    return p, err
+}
+
+// AvatarThumbnail is the avatar shown next to the user in lists, or empty.
+func (u *User) AvatarThumbnail() string {
+    p, err := u.ProfileData()
+    if err != nil {
+        return ""
+    }
+    return p.AvatarThumbnailURL
+}
+
+func (u *User) SetProfileData(p UserProfile) error {
+    data, err := json.Marshal(p)
+    if err != nil {
+        return err
+    }
+    u.Profile = datatypes.JSON(data)
+    return nil
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+    "time"
+
+    "github.com/google/uuid"
+)
+
+const (
+    UserTokenPurposeVerifyEmail   = "verify_email"
+    UserTokenPurposeResetPassword = "reset_password"
+    // UserTokenPurposeChangeEmail is mailed to the address a user wants to
+    // move their account to
+    UserTokenPurposeChangeEmail   = "change_email"
+    // UserTokenPurposeMFAChallenge is handed out by a login that still needs
+    // a second factor
+    UserTokenPurposeMFAChallenge  = "mfa_challenge"
+)
+
+// UserToken backs a single-use token handed to a user, such as a link in an
+// email. The token itself is signed; the row records whether it was used.
+type UserToken struct {
+    ID        uuid.UUID  `gorm:"type:uuid;primaryKey"`
+    UserID    uuid.UUID  `gorm:"type:uuid;not null"`
+    Purpose   string     `gorm:"type:varchar(32);not null"`
+    // Email is the address the link was sent to
+    Email     string     `gorm:"not null"`
+    ExpiresAt time.Time  `gorm:"not null;index"`
+    // Attempts counts wrong codes entered against an MFA challenge
+    Attempts  int        `gorm:"not null;default:0"`
+    UsedAt    *time.Time
+    CreatedAt time.Time  `gorm:"autoCreateTime"`
+}
@@ -0,0 +1,59 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type CreatePollRequest struct {
+	ChatRoomID     uuid.UUID  `json:"chat_room_id" binding:"required"`
+	Question       string     `json:"question" binding:"required,max=300"`
+	Options        []string   `json:"options" binding:"required,min=2,max=12,dive,required,max=100"`
+	MultipleChoice bool       `json:"multiple_choice"`
+	Anonymous      bool       `json:"anonymous"`
+	ClosesAt       *time.Time `json:"closes_at,omitempty"`
+}
+
+type VoteRequest struct {
+	OptionIDs []uuid.UUID `json:"option_ids" binding:"required,min=1"`
+}
+
+type PollOptionResponse struct {
+	ID       uuid.UUID   `json:"id"`
+	Text     string      `json:"text"`
+	Votes    int64       `json:"votes"`
+	VoterIDs []uuid.UUID `json:"voter_ids,omitempty"`
+}
+
+type PollResponse struct {
+	ID             uuid.UUID            `json:"id"`
+	MessageID      uuid.UUID            `json:"message_id"`
+	ChatRoomID     uuid.UUID            `json:"chat_room_id"`
+	CreatorID      uuid.UUID            `json:"creator_id"`
+	Question       string               `json:"question"`
+	MultipleChoice bool                 `json:"multiple_choice"`
+	Anonymous      bool                 `json:"anonymous"`
+	ClosesAt       *time.Time           `json:"closes_at,omitempty"`
+	Closed         bool                 `json:"closed"`
+	ClosedAt       *time.Time           `json:"closed_at,omitempty"`
+	TotalVoters    int                  `json:"total_voters"`
+	Options        []PollOptionResponse `json:"options"`
+	// MyVotes is only filled in responses to the voter themselves
+	MyVotes []uuid.UUID `json:"my_votes,omitempty"`
+}
+
+// PollPayload is stored as the payload of the poll's message.
+type PollPayload struct {
+	PollID         uuid.UUID           `json:"poll_id"`
+	Question       string              `json:"question"`
+	Options        []PollOptionPayload `json:"options"`
+	MultipleChoice bool                `json:"multiple_choice"`
+	Anonymous      bool                `json:"anonymous"`
+	ClosesAt       *time.Time          `json:"closes_at,omitempty"`
+}
+
+type PollOptionPayload struct {
+	ID   uuid.UUID `json:"id"`
+	Text string    `json:"text"`
+}
@@ -0,0 +1,115 @@
+package poll
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mozho_chat/internal/poll/dto"
+	"mozho_chat/pkg/middleware"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
+	r := rg.Group("/polls", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(middleware.MaxJSONBodySize))
+	{
+		r.POST("", h.CreatePoll)
+		r.GET("/:id", h.GetPoll)
+		r.POST("/:id/votes", h.Vote)
+		r.DELETE("/:id/votes", h.RetractVote)
+		r.POST("/:id/close", h.ClosePoll)
+	}
+}
+
+func (h *Handler) CreatePoll(c *gin.Context) {
+	var req dto.CreatePollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	poll, err := h.service.CreatePoll(userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, poll)
+}
+
+func (h *Handler) GetPoll(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	poll, err := h.service.GetPoll(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, poll)
+}
+
+func (h *Handler) Vote(c *gin.Context) {
+	var req dto.VoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	poll, err := h.service.Vote(userID, c.Param("id"), req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, poll)
+}
+
+func (h *Handler) RetractVote(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	poll, err := h.service.RetractVote(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, poll)
+}
+
+func (h *Handler) ClosePoll(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+
+	poll, err := h.service.ClosePoll(userID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, poll)
+}
@@ -0,0 +1,321 @@
+package poll
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/datatypes"
+
+	"mozho_chat/internal/message"
+	messagedto "mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/poll/dto"
+	"mozho_chat/internal/repository"
+)
+
+// maxPollDuration is how far in the future a poll may be set to close.
+const maxPollDuration = 365 * 24 * time.Hour
+
+type Service interface {
+	CreatePoll(userID string, input dto.CreatePollRequest) (*dto.PollResponse, error)
+	GetPoll(userID, pollID string) (*dto.PollResponse, error)
+	Vote(userID, pollID string, input dto.VoteRequest) (*dto.PollResponse, error)
+	RetractVote(userID, pollID string) (*dto.PollResponse, error)
+	ClosePoll(userID, pollID string) (*dto.PollResponse, error)
+}
+
+// Events carries poll messages and updated tallies to the personal channel
+// of each room member, so members who blocked the creator can be skipped.
+type Events interface {
+	PublishUserEvent(userID, eventType string, data any) error
+}
+
+type pollService struct {
+	repo      repository.PollRepository
+	roomRepo  repository.ChatRoomRepository
+	blockRepo repository.BlockRepository
+	events    Events
+}
+
+func NewService(repo repository.PollRepository, roomRepo repository.ChatRoomRepository, blockRepo repository.BlockRepository, events Events) Service {
+	return &pollService{repo: repo, roomRepo: roomRepo, blockRepo: blockRepo, events: events}
+}
+
+func (s *pollService) CreatePoll(userID string, input dto.CreatePollRequest) (*dto.PollResponse, error) {
+	roomID := input.ChatRoomID.String()
+	if err := s.requireMember(roomID, userID); err != nil {
+		return nil, err
+	}
+	// The server tallies the votes, so it has to be able to read them
+	mode, err := s.roomRepo.GetEncryptionMode(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if mode == models.EncryptionModeE2E {
+		return nil, errors.New("polls cannot be created in end-to-end encrypted rooms")
+	}
+
+	now := time.Now()
+	if input.ClosesAt != nil {
+		if !input.ClosesAt.After(now) {
+			return nil, errors.New("closes_at must be in the future")
+		}
+		if input.ClosesAt.After(now.Add(maxPollDuration)) {
+			return nil, errors.New("closes_at is too far in the future")
+		}
+	}
+
+	question := strings.TrimSpace(input.Question)
+	if question == "" {
+		return nil, errors.New("question is required")
+	}
+
+	poll := &models.Poll{
+		ID:             uuid.New(),
+		ChatRoomID:     input.ChatRoomID,
+		CreatorID:      uuid.MustParse(userID),
+		Question:       question,
+		MultipleChoice: input.MultipleChoice,
+		Anonymous:      input.Anonymous,
+		ClosesAt:       input.ClosesAt,
+	}
+
+	seen := make(map[string]bool, len(input.Options))
+	payload := dto.PollPayload{
+		PollID:         poll.ID,
+		Question:       poll.Question,
+		MultipleChoice: poll.MultipleChoice,
+		Anonymous:      poll.Anonymous,
+		ClosesAt:       poll.ClosesAt,
+	}
+	for i, text := range input.Options {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return nil, errors.New("poll options cannot be empty")
+		}
+		if seen[strings.ToLower(text)] {
+			return nil, errors.New("poll options must be unique")
+		}
+		seen[strings.ToLower(text)] = true
+
+		option := models.PollOption{ID: uuid.New(), PollID: poll.ID, Text: text, Position: i}
+		poll.Options = append(poll.Options, option)
+		payload.Options = append(payload.Options, dto.PollOptionPayload{ID: option.ID, Text: option.Text})
+	}
+
+	rawPayload, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// Poll messages follow the room's disappearing message timer
+	var expiresAt *time.Time
+	ttl, err := s.roomRepo.GetMessageTTL(roomID)
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		t := now.Add(ttl)
+		expiresAt = &t
+	}
+
+	msg := &models.Message{
+		ID:         uuid.New(),
+		ChatRoomID: input.ChatRoomID,
+		SenderID:   poll.CreatorID,
+		Type:       models.MessageTypePoll,
+		Payload:    datatypes.JSON(rawPayload),
+		EncryptionMetadata: models.EncryptionMetadata{
+			Algorithm: models.EncryptionNone,
+		},
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+	poll.MessageID = msg.ID
+
+	if err := s.repo.CreateWithMessage(context.TODO(), poll, msg); err != nil {
+		return nil, err
+	}
+
+	s.publish(poll, "message.created", messagedto.NewMessageResponse(msg))
+
+	return s.buildResponse(poll, nil, userID), nil
+}
+
+func (s *pollService) GetPoll(userID, pollID string) (*dto.PollResponse, error) {
+	poll, err := s.findPollForMember(userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := s.repo.FindVotes(pollID)
+	if err != nil {
+		return nil, err
+	}
+	return s.buildResponse(poll, votes, userID), nil
+}
+
+func (s *pollService) Vote(userID, pollID string, input dto.VoteRequest) (*dto.PollResponse, error) {
+	poll, err := s.findPollForMember(userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	valid := make(map[uuid.UUID]bool, len(poll.Options))
+	for _, option := range poll.Options {
+		valid[option.ID] = true
+	}
+	optionIDs := make([]uuid.UUID, 0, len(input.OptionIDs))
+	chosen := make(map[uuid.UUID]bool, len(input.OptionIDs))
+	for _, id := range input.OptionIDs {
+		if !valid[id] {
+			return nil, errors.New("option does not belong to this poll")
+		}
+		if !chosen[id] {
+			chosen[id] = true
+			optionIDs = append(optionIDs, id)
+		}
+	}
+	if !poll.MultipleChoice && len(optionIDs) != 1 {
+		return nil, errors.New("this poll allows only one choice")
+	}
+
+	return s.replaceVotes(poll, userID, optionIDs)
+}
+
+func (s *pollService) RetractVote(userID, pollID string) (*dto.PollResponse, error) {
+	poll, err := s.findPollForMember(userID, pollID)
+	if err != nil {
+		return nil, err
+	}
+	return s.replaceVotes(poll, userID, nil)
+}
+
+func (s *pollService) ClosePoll(userID, pollID string) (*dto.PollResponse, error) {
+	poll, err := s.repo.FindByID(pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := s.roomRepo.GetMemberRole(poll.ChatRoomID.String(), userID)
+	if err != nil {
+		return nil, errors.New("user not in room")
+	}
+	if poll.CreatorID.String() != userID && role != models.RoleOwner && role != models.RoleAdmin {
+		return nil, errors.New("only the poll creator or room admins can close this poll")
+	}
+
+	poll, err = s.repo.Close(pollID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := s.repo.FindVotes(pollID)
+	if err != nil {
+		return nil, err
+	}
+	s.publish(poll, "poll.updated", s.buildResponse(poll, votes, ""))
+
+	return s.buildResponse(poll, votes, userID), nil
+}
+
+func (s *pollService) replaceVotes(poll *models.Poll, userID string, optionIDs []uuid.UUID) (*dto.PollResponse, error) {
+	if err := s.repo.ReplaceVotes(poll.ID.String(), userID, optionIDs, time.Now()); err != nil {
+		return nil, err
+	}
+
+	votes, err := s.repo.FindVotes(poll.ID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Everyone in the room gets the new tally right away
+	s.publish(poll, "poll.updated", s.buildResponse(poll, votes, ""))
+
+	return s.buildResponse(poll, votes, userID), nil
+}
+
+// findPollForMember loads the poll after checking the user can see it, and
+// freezes polls whose closing time has passed.
+func (s *pollService) findPollForMember(userID, pollID string) (*models.Poll, error) {
+	poll, err := s.repo.FindByID(pollID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireMember(poll.ChatRoomID.String(), userID); err != nil {
+		return nil, err
+	}
+
+	if poll.ClosedAt == nil && poll.IsClosed(time.Now()) {
+		return s.repo.Close(pollID, time.Now())
+	}
+	return poll, nil
+}
+
+func (s *pollService) requireMember(roomID, userID string) error {
+	inRoom, err := s.roomRepo.IsUserInRoom(roomID, userID)
+	if err != nil {
+		return err
+	}
+	if !inRoom {
+		return errors.New("user not in room")
+	}
+	return nil
+}
+
+// buildResponse renders the poll with live tallies, or with the frozen
+// snapshot once the poll is closed. viewerID may be empty for broadcasts.
+func (s *pollService) buildResponse(poll *models.Poll, votes []models.PollVote, viewerID string) *dto.PollResponse {
+	results := []models.PollOptionResult(poll.FinalResults)
+	if poll.ClosedAt == nil {
+		results = poll.Tally(votes)
+	}
+	byOption := make(map[uuid.UUID]models.PollOptionResult, len(results))
+	for _, r := range results {
+		byOption[r.OptionID] = r
+	}
+
+	res := &dto.PollResponse{
+		ID:             poll.ID,
+		MessageID:      poll.MessageID,
+		ChatRoomID:     poll.ChatRoomID,
+		CreatorID:      poll.CreatorID,
+		Question:       poll.Question,
+		MultipleChoice: poll.MultipleChoice,
+		Anonymous:      poll.Anonymous,
+		ClosesAt:       poll.ClosesAt,
+		Closed:         poll.IsClosed(time.Now()),
+		ClosedAt:       poll.ClosedAt,
+		Options:        make([]dto.PollOptionResponse, 0, len(poll.Options)),
+	}
+	for _, option := range poll.Options {
+		r := byOption[option.ID]
+		res.Options = append(res.Options, dto.PollOptionResponse{
+			ID:       option.ID,
+			Text:     option.Text,
+			Votes:    r.Votes,
+			VoterIDs: r.VoterIDs,
+		})
+	}
+
+	voters := make(map[uuid.UUID]bool)
+	for _, vote := range votes {
+		voters[vote.UserID] = true
+		if viewerID != "" && vote.UserID.String() == viewerID {
+			res.MyVotes = append(res.MyVotes, vote.OptionID)
+		}
+	}
+	res.TotalVoters = len(voters)
+
+	return res
+}
+
+// publish sends a poll event to every member of the poll's room who has
+// not blocked its creator.
+func (s *pollService) publish(poll *models.Poll, eventType string, data any) {
+	message.PublishToMembers(s.roomRepo, s.blockRepo, s.events, poll.ChatRoomID.String(), poll.CreatorID.String(), eventType, data)
+}
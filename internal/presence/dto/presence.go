@@ -0,0 +1,21 @@
+package dto
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PresenceResponse leaves out whatever the user's privacy settings hide
+// from the caller.
+type PresenceResponse struct {
+	UserID   uuid.UUID  `json:"user_id"`
+	Online   *bool      `json:"online,omitempty"`
+	LastSeen *time.Time `json:"last_seen,omitempty"`
+}
+
+// PresenceEvent is sent to a user's contacts when they come online.
+type PresenceEvent struct {
+	UserID string `json:"user_id"`
+	Online bool   `json:"online"`
+}
@@ -0,0 +1,36 @@
+package presence
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mozho_chat/pkg/middleware"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
+	rg.GET("/users/:id/presence", middleware.AuthMiddleware(), h.GetPresence)
+}
+
+func (h *Handler) GetPresence(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	presence, err := h.service.GetPresence(userID, c.Param("id"))
+	switch {
+	case errors.Is(err, errUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, presence)
+}
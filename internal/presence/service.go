@@ -0,0 +1,131 @@
+package presence
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/presence/dto"
+	"mozho_chat/internal/repository"
+)
+
+var errUserNotFound = errors.New("user not found")
+
+// Store keeps online flags and last seen times and delivers events. Online
+// flags expire on their own, so a user whose connection drops goes offline
+// without anyone marking them.
+type Store interface {
+	MarkUserOnline(userID string, ttl time.Duration, now time.Time) (bool, error)
+	IsUserOnline(userID string) (bool, error)
+	LastSeen(userID string) (*time.Time, error)
+	PublishUserEvent(userID, eventType string, data any) error
+}
+
+type Service interface {
+	// SetUserOnline is called by the auth middleware on every request. When
+	// the user was offline, their contacts are told they came online.
+	SetUserOnline(userID string, ttl time.Duration) error
+	GetPresence(viewerID, userID string) (*dto.PresenceResponse, error)
+	// Visible returns the parts of subject's presence viewer may see. A
+	// hidden online status reads as offline and a hidden last seen as nil.
+	Visible(viewer, subject *models.User, isContact bool) (bool, *time.Time, error)
+}
+
+type presenceService struct {
+	store       Store
+	userRepo    repository.UserRepository
+	contactRepo repository.ContactRepository
+	blockRepo   repository.BlockRepository
+}
+
+func NewService(store Store, userRepo repository.UserRepository, contactRepo repository.ContactRepository, blockRepo repository.BlockRepository) Service {
+	return &presenceService{store: store, userRepo: userRepo, contactRepo: contactRepo, blockRepo: blockRepo}
+}
+
+func (s *presenceService) SetUserOnline(userID string, ttl time.Duration) error {
+	cameOnline, err := s.store.MarkUserOnline(userID, ttl, time.Now())
+	if err != nil || !cameOnline {
+		return err
+	}
+
+	user, err := s.userRepo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	contacts, err := s.contactRepo.ListContacts(userID)
+	if err != nil {
+		return err
+	}
+	event := dto.PresenceEvent{UserID: userID, Online: true}
+	for i := range contacts {
+		contact := &contacts[i].ContactUser
+		if !user.OnlineVisibleTo(contact, true) {
+			continue
+		}
+		// Presence is transient; a missed event does no harm
+		if err := s.store.PublishUserEvent(contact.ID.String(), "presence", event); err != nil {
+			log.Printf("failed to publish presence event: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *presenceService) GetPresence(viewerID, userID string) (*dto.PresenceResponse, error) {
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, errUserNotFound
+	}
+	subject, err := s.userRepo.FindByID(userID)
+	if err != nil || subject.Placeholder {
+		return nil, errUserNotFound
+	}
+	viewer, err := s.userRepo.FindByID(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	blocked, err := s.blockRepo.IsBlockedEither(viewerID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if blocked {
+		return nil, errUserNotFound
+	}
+	isContact, err := s.contactRepo.AreContacts(viewerID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.PresenceResponse{UserID: subject.ID}
+	if subject.OnlineVisibleTo(viewer, isContact) {
+		online, err := s.store.IsUserOnline(userID)
+		if err != nil {
+			return nil, err
+		}
+		res.Online = &online
+	}
+	if subject.LastSeenVisibleTo(viewer, isContact) {
+		if res.LastSeen, err = s.store.LastSeen(userID); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (s *presenceService) Visible(viewer, subject *models.User, isContact bool) (bool, *time.Time, error) {
+	var online bool
+	var lastSeen *time.Time
+	var err error
+	if subject.OnlineVisibleTo(viewer, isContact) {
+		if online, err = s.store.IsUserOnline(subject.ID.String()); err != nil {
+			return false, nil, err
+		}
+	}
+	if subject.LastSeenVisibleTo(viewer, isContact) {
+		if lastSeen, err = s.store.LastSeen(subject.ID.String()); err != nil {
+			return false, nil, err
+		}
+	}
+	return online, lastSeen, nil
+}
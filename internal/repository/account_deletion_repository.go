@@ -0,0 +1,247 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+// ErrAccountNotDue is returned by Purge when the deletion was cancelled,
+// has already run, or is being run by another worker.
+var ErrAccountNotDue = errors.New("account is not due for deletion")
+
+// PurgedAccount describes what happened to a deleted user's data.
+type PurgedAccount struct {
+	RoomsLeft            int
+	OwnershipTransferred int
+	MessagesRetained     int64
+	ObjectsDeleted       int
+}
+
+type AccountDeletionRepository interface {
+	// ListDue returns up to limit accounts whose deletion is due, oldest
+	// first
+	ListDue(now time.Time, limit int) ([]models.User, error)
+	Purge(ctx context.Context, userID string, now time.Time, deleteObjects func(keys []string) error) (*PurgedAccount, error)
+}
+
+type accountDeletionRepository struct {
+	db *gorm.DB
+}
+
+func NewAccountDeletionRepository(db *gorm.DB) AccountDeletionRepository {
+	return &accountDeletionRepository{db: db}
+}
+
+func (r *accountDeletionRepository) ListDue(now time.Time, limit int) ([]models.User, error) {
+	var users []models.User
+	err := r.db.
+		Where("deleted_at IS NULL AND deletion_scheduled_at <= ?", now).
+		Order("deletion_scheduled_at").
+		Limit(limit).
+		Find(&users).Error
+	return users, err
+}
+
+// Purge deletes a user's credentials, keys, contacts and files, leaves their
+// rooms and anonymizes the account. Their messages are kept with the
+// anonymized account as sender. Owned rooms pass to the longest-serving
+// admin, or failing that the longest-serving member.
+//
+// deleteObjects is called with the private bucket keys of the user's
+// attachments, scheduled attachments and exports while the rows are still
+// locked; if it fails the transaction is rolled back so the next run
+// retries.
+func (r *accountDeletionRepository) Purge(ctx context.Context, userID string, now time.Time, deleteObjects func(keys []string) error) (*PurgedAccount, error) {
+	result := &PurgedAccount{}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("id = ? AND deleted_at IS NULL AND deletion_scheduled_at <= ?", userID, now).
+			First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAccountNotDue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := leaveRooms(tx, user.ID, result); err != nil {
+			return err
+		}
+
+		keys, err := userObjectKeys(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := deleteObjects(keys); err != nil {
+				return err
+			}
+		}
+		result.ObjectsDeleted = len(keys)
+
+		if err := tx.Model(&models.Message{}).Where("sender_id = ?", user.ID).Count(&result.MessagesRetained).Error; err != nil {
+			return err
+		}
+
+		id := user.ID
+		sent := tx.Model(&models.Message{}).Select("id").Where("sender_id = ?", id)
+		deletes := []struct {
+			model any
+			query string
+			args  []any
+		}{
+			{&models.Attachment{}, "message_id IN (?)", []any{sent}},
+			{&models.ScheduledMessage{}, "sender_id = ?", []any{id}},
+			{&models.ExportJob{}, "requested_by = ?", []any{id}},
+			{&models.Session{}, "user_id = ?", []any{id}},
+			{&models.UserToken{}, "user_id = ?", []any{id}},
+			{&models.UserTOTP{}, "user_id = ?", []any{id}},
+			{&models.RecoveryCode{}, "user_id = ?", []any{id}},
+			{&models.Passkey{}, "user_id = ?", []any{id}},
+			{&models.WebAuthnChallenge{}, "user_id = ?", []any{id}},
+			{&models.UserIdentity{}, "user_id = ?", []any{id}},
+			{&models.UserPublicKey{}, "user_id = ?", []any{id}},
+			{&models.Contact{}, "user_id = ? OR contact_id = ?", []any{id, id}},
+			{&models.ContactRequest{}, "requester_id = ? OR addressee_id = ?", []any{id, id}},
+			{&models.Block{}, "blocker_id = ? OR blocked_id = ?", []any{id, id}},
+			{&models.MessageStatus{}, "user_id = ?", []any{id}},
+			{&models.UserMention{}, "user_id = ?", []any{id}},
+		}
+		for _, d := range deletes {
+			if err := tx.Where(d.query, d.args...).Delete(d.model).Error; err != nil {
+				return err
+			}
+		}
+
+		user.Anonymize(now)
+		return tx.Save(&user).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// leaveRooms removes the user from every room, handing rooms they own to
+// another member first.
+func leaveRooms(tx *gorm.DB, userID uuid.UUID, result *PurgedAccount) error {
+	var memberships []models.ChatRoomMember
+	if err := tx.Where("user_id = ?", userID).Find(&memberships).Error; err != nil {
+		return err
+	}
+
+	for _, m := range memberships {
+		if m.Role != models.RoleOwner {
+			continue
+		}
+		var members []models.ChatRoomMember
+		if err := tx.Where("chat_room_id = ?", m.ChatRoomID).Find(&members).Error; err != nil {
+			return err
+		}
+		successor, ok := OwnerSuccessor(members, userID)
+		if !ok {
+			continue
+		}
+		if err := tx.Model(&successor).Update("role", models.RoleOwner).Error; err != nil {
+			return err
+		}
+		result.OwnershipTransferred++
+	}
+
+	result.RoomsLeft = len(memberships)
+	return tx.Where("user_id = ?", userID).Delete(&models.ChatRoomMember{}).Error
+}
+
+// OwnerSuccessor picks who takes a room over from its departing owner: the
+// longest-serving admin, or failing that the longest-serving member. It
+// reports false when nobody else is left in the room.
+func OwnerSuccessor(members []models.ChatRoomMember, ownerID uuid.UUID) (models.ChatRoomMember, bool) {
+	var successor models.ChatRoomMember
+	found := false
+	for _, m := range members {
+		if m.UserID == ownerID {
+			continue
+		}
+		if !found || outranks(m, successor) {
+			successor, found = m, true
+		}
+	}
+	return successor, found
+}
+
+// outranks reports whether a comes before b in line for ownership. Members
+// who joined at the same moment are ordered by ID so the choice is stable.
+func outranks(a, b models.ChatRoomMember) bool {
+	if aAdmin, bAdmin := a.Role == models.RoleAdmin, b.Role == models.RoleAdmin; aAdmin != bAdmin {
+		return aAdmin
+	}
+	if !a.JoinedAt.Equal(b.JoinedAt) {
+		return a.JoinedAt.Before(b.JoinedAt)
+	}
+	return a.ID.String() < b.ID.String()
+}
+
+// userObjectKeys loads what OwnedObjectKeys needs to pick the user's
+// private bucket objects.
+func userObjectKeys(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	var messageIDs []uuid.UUID
+	if err := tx.Model(&models.Message{}).Where("sender_id = ?", userID).Pluck("id", &messageIDs).Error; err != nil {
+		return nil, err
+	}
+
+	var attachments []models.Attachment
+	var shared []string
+	if len(messageIDs) > 0 {
+		if err := tx.Where("message_id IN ?", messageIDs).Find(&attachments).Error; err != nil {
+			return nil, err
+		}
+		var err error
+		if shared, err = sharedKeys(tx, attachments, messageIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	var scheduled []models.ScheduledMessage
+	if err := tx.Where("sender_id = ?", userID).Find(&scheduled).Error; err != nil {
+		return nil, err
+	}
+
+	var exports []string
+	if err := tx.Model(&models.ExportJob{}).
+		Where("requested_by = ? AND object_key IS NOT NULL", userID).
+		Pluck("object_key", &exports).Error; err != nil {
+		return nil, err
+	}
+	return OwnedObjectKeys(attachments, shared, scheduled, exports), nil
+}
+
+// OwnedObjectKeys picks the private bucket objects that belong to a user
+// alone: attachments of their messages whose key is not in shared (the
+// keys forwarded copies still use), attachments of their messages still
+// waiting to be sent, and exports they requested. The attachments of sent
+// scheduled messages moved to the message, and those of cancelled ones were
+// deleted when they were cancelled.
+func OwnedObjectKeys(attachments []models.Attachment, shared []string, scheduled []models.ScheduledMessage, exports []string) []string {
+	var keys []string
+	for _, a := range unsharedAttachments(attachments, shared) {
+		keys = append(keys, a.Key)
+	}
+	for _, msg := range scheduled {
+		if msg.Status != models.ScheduledStatusPending {
+			continue
+		}
+		for _, a := range msg.Attachments {
+			keys = append(keys, a.Key)
+		}
+	}
+	return append(keys, exports...)
+}
@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+)
+
+// AuditRepository records security events. Events are never changed or
+// deleted by the application.
+type AuditRepository interface {
+	Create(event *models.AuditEvent) error
+}
+
+type auditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepository {
+	return &auditRepository{db: db}
+}
+
+func (r *auditRepository) Create(event *models.AuditEvent) error {
+	return r.db.Create(event).Error
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+type BlockRepository interface {
+	// Create blocks blockedID for blockerID and removes any contact and
+	// open contact request between them. Blocking twice is not an error.
+	Create(blockerID, blockedID string, now time.Time) error
+	Delete(blockerID, blockedID string) (bool, error)
+	ListByBlocker(blockerID string) ([]models.Block, error)
+	// IsBlockedEither reports whether either user blocked the other
+	IsBlockedEither(userID1, userID2 string) (bool, error)
+	// ListBlockerIDs returns which of userIDs blocked blockedID
+	ListBlockerIDs(blockedID string, userIDs []string) ([]string, error)
+}
+
+type blockRepository struct {
+	db *gorm.DB
+}
+
+func NewBlockRepository(db *gorm.DB) BlockRepository {
+	return &blockRepository{db: db}
+}
+
+func (r *blockRepository) Create(blockerID, blockedID string, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		block := models.Block{BlockerID: mustParseUUID(blockerID), BlockedID: mustParseUUID(blockedID), CreatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&block).Error; err != nil {
+			return err
+		}
+		if err := tx.
+			Where("(user_id = ? AND contact_id = ?) OR (user_id = ? AND contact_id = ?)", blockerID, blockedID, blockedID, blockerID).
+			Delete(&models.Contact{}).Error; err != nil {
+			return err
+		}
+		return tx.
+			Where("status = ?", models.ContactRequestPending).
+			Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", blockerID, blockedID, blockedID, blockerID).
+			Delete(&models.ContactRequest{}).Error
+	})
+}
+
+func (r *blockRepository) Delete(blockerID, blockedID string) (bool, error) {
+	res := r.db.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Delete(&models.Block{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *blockRepository) ListByBlocker(blockerID string) ([]models.Block, error) {
+	var blocks []models.Block
+	err := r.db.
+		Preload("BlockedUser").
+		Where("blocker_id = ?", blockerID).
+		Order("created_at DESC").
+		Find(&blocks).Error
+	return blocks, err
+}
+
+func (r *blockRepository) IsBlockedEither(userID1, userID2 string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.Block{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userID1, userID2, userID2, userID1).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *blockRepository) ListBlockerIDs(blockedID string, userIDs []string) ([]string, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	var ids []string
+	err := r.db.Model(&models.Block{}).
+		Where("blocked_id = ? AND blocker_id IN ?", blockedID, userIDs).
+		Pluck("blocker_id", &ids).Error
+	return ids, err
+}
@@ -13,13 +13,20 @@ import (
 type ChatRoomRepository interface {
 	Create(room *models.ChatRoom) error
 	AddUser(roomID, userID string) error
+	AddUserWithRole(roomID, userID, role string) error
 	RemoveUser(roomID, userID string) error
 	FindByID(id string) (*models.ChatRoom, error)
+	FindByExternalID(externalID string) (*models.ChatRoom, error)
 	ListRoomsByUser(userID string) ([]models.ChatRoom, error)
 	Delete(room *models.ChatRoom) error
 	CountUsers(roomID string) (int64, error)
 	IsUserInRoom(roomID, userID string) (bool, error)
+	GetMemberRole(roomID, userID string) (string, error)
+	ListMemberIDs(roomID string) ([]string, error)
 	FindRoomBetweenUsers(userID1, userID2 string) (*models.ChatRoom, error)
+	GetMessageTTL(roomID string) (time.Duration, error)
+	GetEncryptionMode(roomID string) (string, error)
+	UpdateSettings(roomID string, settings map[string]any) error
 }
 
 type chatRoomRepo struct {
@@ -35,9 +42,14 @@ func (r *chatRoomRepo) Create(room *models.ChatRoom) error {
 }
 
 func (r *chatRoomRepo) AddUser(roomID, userID string) error {
+	return r.AddUserWithRole(roomID, userID, models.RoleMember)
+}
+
+func (r *chatRoomRepo) AddUserWithRole(roomID, userID, role string) error {
 	cru := models.ChatRoomMember{
 		ChatRoomID: uuidFromString(roomID),
 		UserID:     uuidFromString(userID),
+		Role:       role,
 		JoinedAt:   time.Now(),
 	}
 	return r.db.Create(&cru).Error
@@ -56,6 +68,20 @@ func (r *chatRoomRepo) FindByID(id string) (*models.ChatRoom, error) {
 	return &room, nil
 }
 
+// FindByExternalID returns the room an external conversation was imported
+// into, or nil if it has not been imported yet.
+func (r *chatRoomRepo) FindByExternalID(externalID string) (*models.ChatRoom, error) {
+	var room models.ChatRoom
+	err := r.db.First(&room, "external_id = ?", externalID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
 func (r *chatRoomRepo) ListRoomsByUser(userID string) ([]models.ChatRoom, error) {
 	var rooms []models.ChatRoom
 	err := r.db.Joins("JOIN chat_room_members crm ON crm.chat_room_id = chat_rooms.id").
@@ -84,6 +110,24 @@ func (r *chatRoomRepo) IsUserInRoom(roomID, userID string) (bool, error) {
 	return err == nil, err
 }
 
+// GetMemberRole returns the user's role in the room, or gorm.ErrRecordNotFound
+// if the user is not a member.
+func (r *chatRoomRepo) GetMemberRole(roomID, userID string) (string, error) {
+	var crm models.ChatRoomMember
+	if err := r.db.Select("role").First(&crm, "chat_room_id = ? AND user_id = ?", roomID, userID).Error; err != nil {
+		return "", err
+	}
+	return crm.Role, nil
+}
+
+func (r *chatRoomRepo) ListMemberIDs(roomID string) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&models.ChatRoomMember{}).
+		Where("chat_room_id = ?", roomID).
+		Pluck("user_id", &ids).Error
+	return ids, err
+}
+
 func (r *chatRoomRepo) FindRoomBetweenUsers(userID1, userID2 string) (*models.ChatRoom, error) {
 	var room models.ChatRoom
 	// Find rooms where both users are members and it's not a group chat
@@ -103,6 +147,34 @@ func (r *chatRoomRepo) FindRoomBetweenUsers(userID1, userID2 string) (*models.Ch
 	return &room, nil
 }
 
+// GetMessageTTL returns the room's disappearing message timer, or zero when
+// messages are kept forever.
+func (r *chatRoomRepo) GetMessageTTL(roomID string) (time.Duration, error) {
+	var room models.ChatRoom
+	if err := r.db.Select("id", "message_ttl_seconds").First(&room, "id = ?", roomID).Error; err != nil {
+		return 0, err
+	}
+	if room.MessageTTLSeconds == nil {
+		return 0, nil
+	}
+	return time.Duration(*room.MessageTTLSeconds) * time.Second, nil
+}
+
+func (r *chatRoomRepo) GetEncryptionMode(roomID string) (string, error) {
+	var room models.ChatRoom
+	if err := r.db.Select("id", "encryption_mode").First(&room, "id = ?", roomID).Error; err != nil {
+		return "", err
+	}
+	if room.EncryptionMode == "" {
+		return models.EncryptionModeE2E, nil
+	}
+	return room.EncryptionMode, nil
+}
+
+func (r *chatRoomRepo) UpdateSettings(roomID string, settings map[string]any) error {
+	return r.db.Model(&models.ChatRoom{}).Where("id = ?", roomID).Updates(settings).Error
+}
+
 func uuidFromString(id string) (u uuid.UUID) {
 	u, _ = uuid.Parse(id)
 	return
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+type ContactRepository interface {
+	CreateRequest(req *models.ContactRequest) error
+	FindRequest(id string) (*models.ContactRequest, error)
+	// FindPendingRequest returns the open request between two users, sent
+	// either way
+	FindPendingRequest(userID1, userID2 string) (*models.ContactRequest, error)
+	// FindLatestRequest returns the newest request requesterID sent to
+	// addresseeID
+	FindLatestRequest(requesterID, addresseeID string) (*models.ContactRequest, error)
+	ListPendingRequests(userID string, incoming bool) ([]models.ContactRequest, error)
+	AcceptRequest(id string, now time.Time) (bool, error)
+	DeclineRequest(id string, now time.Time) (bool, error)
+	CancelRequest(id, requesterID string) (bool, error)
+	AreContacts(userID1, userID2 string) (bool, error)
+	ListContacts(userID string) ([]models.Contact, error)
+	// ListContactIDs returns which of userIDs are contacts of userID
+	ListContactIDs(userID string, userIDs []string) ([]string, error)
+	RemoveContact(userID, contactID string) (bool, error)
+}
+
+type contactRepository struct {
+	db *gorm.DB
+}
+
+func NewContactRepository(db *gorm.DB) ContactRepository {
+	return &contactRepository{db: db}
+}
+
+func (r *contactRepository) CreateRequest(req *models.ContactRequest) error {
+	return r.db.Create(req).Error
+}
+
+func (r *contactRepository) FindRequest(id string) (*models.ContactRequest, error) {
+	var req models.ContactRequest
+	err := r.db.Preload("Requester").Preload("Addressee").First(&req, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (r *contactRepository) FindPendingRequest(userID1, userID2 string) (*models.ContactRequest, error) {
+	var req models.ContactRequest
+	err := r.db.
+		Where("status = ?", models.ContactRequestPending).
+		Where("(requester_id = ? AND addressee_id = ?) OR (requester_id = ? AND addressee_id = ?)", userID1, userID2, userID2, userID1).
+		First(&req).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func (r *contactRepository) FindLatestRequest(requesterID, addresseeID string) (*models.ContactRequest, error) {
+	var req models.ContactRequest
+	err := r.db.
+		Where("requester_id = ? AND addressee_id = ?", requesterID, addresseeID).
+		Order("created_at DESC").
+		First(&req).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+// ListPendingRequests returns the open requests sent to the user, or sent
+// by them if incoming is false, newest first.
+func (r *contactRepository) ListPendingRequests(userID string, incoming bool) ([]models.ContactRequest, error) {
+	column := "requester_id"
+	if incoming {
+		column = "addressee_id"
+	}
+	var reqs []models.ContactRequest
+	err := r.db.
+		Preload("Requester").
+		Preload("Addressee").
+		Where(column+" = ? AND status = ?", userID, models.ContactRequestPending).
+		Order("created_at DESC").
+		Find(&reqs).Error
+	return reqs, err
+}
+
+// AcceptRequest marks a pending request accepted and adds both users to
+// each other's contacts. It reports false if the request was not pending.
+func (r *contactRepository) AcceptRequest(id string, now time.Time) (bool, error) {
+	accepted := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var req models.ContactRequest
+		res := tx.Model(&req).
+			Clauses(clause.Returning{}).
+			Where("id = ? AND status = ?", id, models.ContactRequestPending).
+			Updates(map[string]any{"status": models.ContactRequestAccepted, "responded_at": now})
+		if res.Error != nil || res.RowsAffected == 0 {
+			return res.Error
+		}
+		contacts := []models.Contact{
+			{UserID: req.RequesterID, ContactID: req.AddresseeID, CreatedAt: now},
+			{UserID: req.AddresseeID, ContactID: req.RequesterID, CreatedAt: now},
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&contacts).Error; err != nil {
+			return err
+		}
+		accepted = true
+		return nil
+	})
+	return accepted, err
+}
+
+func (r *contactRepository) DeclineRequest(id string, now time.Time) (bool, error) {
+	res := r.db.Model(&models.ContactRequest{}).
+		Where("id = ? AND status = ?", id, models.ContactRequestPending).
+		Updates(map[string]any{"status": models.ContactRequestDeclined, "responded_at": now})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *contactRepository) CancelRequest(id, requesterID string) (bool, error) {
+	res := r.db.
+		Where("id = ? AND requester_id = ? AND status = ?", id, requesterID, models.ContactRequestPending).
+		Delete(&models.ContactRequest{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *contactRepository) AreContacts(userID1, userID2 string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.Contact{}).
+		Where("user_id = ? AND contact_id = ?", userID1, userID2).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (r *contactRepository) ListContacts(userID string) ([]models.Contact, error) {
+	var contacts []models.Contact
+	err := r.db.
+		Joins("ContactUser").
+		Where("contacts.user_id = ?", userID).
+		Order(`"ContactUser".username`).
+		Find(&contacts).Error
+	return contacts, err
+}
+
+func (r *contactRepository) ListContactIDs(userID string, userIDs []string) ([]string, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	var ids []string
+	err := r.db.Model(&models.Contact{}).
+		Where("user_id = ? AND contact_id IN ?", userID, userIDs).
+		Pluck("contact_id", &ids).Error
+	return ids, err
+}
+
+// RemoveContact deletes the contact for both users.
+func (r *contactRepository) RemoveContact(userID, contactID string) (bool, error) {
+	res := r.db.
+		Where("(user_id = ? AND contact_id = ?) OR (user_id = ? AND contact_id = ?)", userID, contactID, contactID, userID).
+		Delete(&models.Contact{})
+	return res.RowsAffected > 0, res.Error
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+type ExportJobRepository interface {
+	Create(job *models.ExportJob) error
+	FindByID(id string) (*models.ExportJob, error)
+	FindActive(chatRoomID, userID string) (*models.ExportJob, error)
+	FindByRequester(userID string, limit int) ([]models.ExportJob, error)
+	ClaimNext(ctx context.Context, now time.Time, lease time.Duration) (*models.ExportJob, error)
+	Complete(job *models.ExportJob) error
+	Retry(id string, reason string, runAfter time.Time) error
+	Fail(id string, reason string) error
+	FindExpired(now time.Time, limit int) ([]models.ExportJob, error)
+	MarkExpired(id string) error
+}
+
+type exportJobRepository struct {
+	db *gorm.DB
+}
+
+func NewExportJobRepository(db *gorm.DB) ExportJobRepository {
+	return &exportJobRepository{db: db}
+}
+
+func (r *exportJobRepository) Create(job *models.ExportJob) error {
+	return r.db.Create(job).Error
+}
+
+func (r *exportJobRepository) FindByID(id string) (*models.ExportJob, error) {
+	var job models.ExportJob
+	if err := r.db.First(&job, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FindActive returns the user's export of the room that is still pending or
+// running, or nil if there is none.
+func (r *exportJobRepository) FindActive(chatRoomID, userID string) (*models.ExportJob, error) {
+	var job models.ExportJob
+	err := r.db.
+		Where("chat_room_id = ? AND requested_by = ? AND status IN ?", chatRoomID, userID,
+			[]string{models.ExportStatusPending, models.ExportStatusRunning}).
+		First(&job).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *exportJobRepository) FindByRequester(userID string, limit int) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	err := r.db.
+		Where("requested_by = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+// ClaimNext leases the oldest runnable export. Running jobs whose lease ran
+// out are picked up again, so a crashed instance does not strand them.
+// Returns nil when there is nothing to do.
+func (r *exportJobRepository) ClaimNext(ctx context.Context, now time.Time, lease time.Duration) (*models.ExportJob, error) {
+	var job models.ExportJob
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND run_after <= ?", []string{models.ExportStatusPending, models.ExportStatusRunning}, now).
+			Order("run_after").
+			First(&job).Error; err != nil {
+			return err
+		}
+
+		job.Status = models.ExportStatusRunning
+		job.Attempts++
+		job.RunAfter = now.Add(lease)
+		return tx.Model(&job).Updates(map[string]any{
+			"status":     job.Status,
+			"attempts":   job.Attempts,
+			"run_after":  job.RunAfter,
+			"updated_at": now,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *exportJobRepository) Complete(job *models.ExportJob) error {
+	return r.db.Model(&models.ExportJob{}).
+		Where("id = ?", job.ID).
+		Updates(map[string]any{
+			"status":        models.ExportStatusCompleted,
+			"object_key":    job.ObjectKey,
+			"message_count": job.MessageCount,
+			"size":          job.Size,
+			"error":         nil,
+			"completed_at":  job.CompletedAt,
+			"expires_at":    job.ExpiresAt,
+			"updated_at":    time.Now(),
+		}).Error
+}
+
+// Retry puts a failed attempt back in the queue.
+func (r *exportJobRepository) Retry(id string, reason string, runAfter time.Time) error {
+	return r.db.Model(&models.ExportJob{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":     models.ExportStatusPending,
+			"error":      reason,
+			"run_after":  runAfter,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+func (r *exportJobRepository) Fail(id string, reason string) error {
+	return r.db.Model(&models.ExportJob{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"status":     models.ExportStatusFailed,
+			"error":      reason,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// FindExpired lists completed exports whose archive should be deleted.
+func (r *exportJobRepository) FindExpired(now time.Time, limit int) ([]models.ExportJob, error) {
+	var jobs []models.ExportJob
+	err := r.db.
+		Where("status = ? AND expires_at <= ?", models.ExportStatusCompleted, now).
+		Order("expires_at").
+		Limit(limit).
+		Find(&jobs).Error
+	return jobs, err
+}
+
+func (r *exportJobRepository) MarkExpired(id string) error {
+	return r.db.Model(&models.ExportJob{}).
+		Where("id = ? AND status = ?", id, models.ExportStatusCompleted).
+		Updates(map[string]any{
+			"status":     models.ExportStatusExpired,
+			"object_key": nil,
+			"updated_at": time.Now(),
+		}).Error
+}
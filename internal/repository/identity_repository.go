@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+// IdentityRepository stores accounts linked at OpenID Connect providers and
+// single sign-ons in progress.
+type IdentityRepository interface {
+	Find(provider, subject string) (*models.UserIdentity, error)
+	Create(identity *models.UserIdentity) error
+	ListByUser(userID string) ([]models.UserIdentity, error)
+	RecordLogin(id, email string, now time.Time) error
+	CreateLoginState(state *models.OIDCLoginState) error
+	ConsumeLoginState(stateHash string, now time.Time) (*models.OIDCLoginState, error)
+	DeleteExpiredLoginStates(ctx context.Context, now time.Time) (int64, error)
+}
+
+type identityRepository struct {
+	db *gorm.DB
+}
+
+func NewIdentityRepository(db *gorm.DB) IdentityRepository {
+	return &identityRepository{db: db}
+}
+
+// Find returns the identity the provider knows as subject, or nil.
+func (r *identityRepository) Find(provider, subject string) (*models.UserIdentity, error) {
+	var identity models.UserIdentity
+	err := r.db.First(&identity, "provider = ? AND subject = ?", provider, subject).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (r *identityRepository) Create(identity *models.UserIdentity) error {
+	return r.db.Create(identity).Error
+}
+
+func (r *identityRepository) ListByUser(userID string) ([]models.UserIdentity, error) {
+	var identities []models.UserIdentity
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&identities).Error
+	return identities, err
+}
+
+func (r *identityRepository) RecordLogin(id, email string, now time.Time) error {
+	return r.db.Model(&models.UserIdentity{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"email": email, "last_login_at": now}).Error
+}
+
+func (r *identityRepository) CreateLoginState(state *models.OIDCLoginState) error {
+	return r.db.Create(state).Error
+}
+
+// ConsumeLoginState deletes a sign-on in progress and returns it, or nil if
+// it does not exist, has expired or was already finished.
+func (r *identityRepository) ConsumeLoginState(stateHash string, now time.Time) (*models.OIDCLoginState, error) {
+	var consumed []models.OIDCLoginState
+	err := r.db.Clauses(clause.Returning{}).
+		Where("state_hash = ? AND expires_at > ?", stateHash, now).
+		Delete(&consumed).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(consumed) == 0 {
+		return nil, nil
+	}
+	return &consumed[0], nil
+}
+
+// DeleteExpiredLoginStates removes sign-ons that were never finished.
+func (r *identityRepository) DeleteExpiredLoginStates(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.OIDCLoginState{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+type LinkPreviewRepository interface {
+	CreateJob(job *models.LinkPreviewJob) error
+	ClaimNextJob(ctx context.Context, now time.Time, lease time.Duration) (*models.LinkPreviewJob, error)
+	CompleteJob(ctx context.Context, job *models.LinkPreviewJob, previews []models.LinkPreview) error
+	FindRecentByURL(url string, since time.Time) (*models.LinkPreview, error)
+}
+
+type linkPreviewRepository struct {
+	db *gorm.DB
+}
+
+func NewLinkPreviewRepository(db *gorm.DB) LinkPreviewRepository {
+	return &linkPreviewRepository{db: db}
+}
+
+func (r *linkPreviewRepository) CreateJob(job *models.LinkPreviewJob) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(job).Error
+}
+
+// ClaimNextJob leases the oldest runnable job. The lease pushes run_after
+// into the future, so other instances skip the job while it is being worked
+// on and it becomes runnable again if this instance dies halfway. Returns
+// nil when there is nothing to do.
+func (r *linkPreviewRepository) ClaimNextJob(ctx context.Context, now time.Time, lease time.Duration) (*models.LinkPreviewJob, error) {
+	var job models.LinkPreviewJob
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("run_after <= ?", now).
+			Order("run_after").
+			First(&job).Error; err != nil {
+			return err
+		}
+
+		job.Attempts++
+		job.RunAfter = now.Add(lease)
+		return tx.Model(&job).Updates(map[string]any{
+			"attempts":  job.Attempts,
+			"run_after": job.RunAfter,
+		}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// CompleteJob stores the previews and removes the job in one transaction.
+func (r *linkPreviewRepository) CompleteJob(ctx context.Context, job *models.LinkPreviewJob, previews []models.LinkPreview) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(previews) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&previews).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&models.LinkPreviewJob{}, "message_id = ?", job.MessageID).Error
+	})
+}
+
+// FindRecentByURL returns the newest preview of url created after since, or
+// nil if there is none.
+func (r *linkPreviewRepository) FindRecentByURL(url string, since time.Time) (*models.LinkPreview, error) {
+	var preview models.LinkPreview
+	err := r.db.
+		Where("url = ? AND created_at > ?", url, since).
+		Order("created_at DESC").
+		First(&preview).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &preview, nil
+}
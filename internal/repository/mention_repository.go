@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+type MentionUnreadCount struct {
+	ChatRoomID string
+	Count      int64
+}
+
+type MentionRepository interface {
+	CreateBatch(ctx context.Context, mentions []models.UserMention) error
+	FindByUser(userID string, limit, offset int) ([]models.UserMention, error)
+	CountUnreadByRoom(userID string) ([]MentionUnreadCount, error)
+	MarkRead(userID, messageID string) error
+	MarkRoomRead(userID, chatRoomID string) error
+}
+
+type mentionRepository struct {
+	db *gorm.DB
+}
+
+func NewMentionRepository(db *gorm.DB) MentionRepository {
+	return &mentionRepository{db: db}
+}
+
+func (r *mentionRepository) CreateBatch(ctx context.Context, mentions []models.UserMention) error {
+	if len(mentions) == 0 {
+		return nil
+	}
+	// A user mentioned twice in one message (e.g. by name and via @room)
+	// only gets a single inbox entry.
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&mentions).Error
+}
+
+func (r *mentionRepository) FindByUser(userID string, limit, offset int) ([]models.UserMention, error) {
+	var mentions []models.UserMention
+	err := r.db.
+		Preload("Message").
+		Joins("JOIN messages ON messages.id = user_mentions.message_id").
+		Where("user_mentions.user_id = ?", userID).
+		Where("messages.expires_at IS NULL OR messages.expires_at > ?", time.Now()).
+		Order("user_mentions.created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&mentions).Error
+	return mentions, err
+}
+
+func (r *mentionRepository) CountUnreadByRoom(userID string) ([]MentionUnreadCount, error) {
+	var counts []MentionUnreadCount
+	err := r.db.Model(&models.UserMention{}).
+		Select("user_mentions.chat_room_id, COUNT(*) AS count").
+		Joins("JOIN messages ON messages.id = user_mentions.message_id").
+		Where("user_mentions.user_id = ? AND user_mentions.read_at IS NULL", userID).
+		Where("messages.expires_at IS NULL OR messages.expires_at > ?", time.Now()).
+		Group("user_mentions.chat_room_id").
+		Scan(&counts).Error
+	return counts, err
+}
+
+func (r *mentionRepository) MarkRead(userID, messageID string) error {
+	return r.db.Model(&models.UserMention{}).
+		Where("user_id = ? AND message_id = ? AND read_at IS NULL", userID, messageID).
+		Update("read_at", time.Now()).Error
+}
+
+func (r *mentionRepository) MarkRoomRead(userID, chatRoomID string) error {
+	return r.db.Model(&models.UserMention{}).
+		Where("user_id = ? AND chat_room_id = ? AND read_at IS NULL", userID, chatRoomID).
+		Update("read_at", time.Now()).Error
+}
@@ -3,19 +3,56 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"mozho_chat/internal/models"
+	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/datatypes"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrDuplicateClientMsgID is returned by Create when the sender already sent
+// a message with the same client_msg_id to the room.
+var ErrDuplicateClientMsgID = errors.New("duplicate client_msg_id")
+
+// SearchParams narrows a full-text message search. Zero values mean no filter.
+type SearchParams struct {
+	Query         string
+	ChatRoomID    string
+	SenderID      string
+	From          *time.Time
+	To            *time.Time
+	HasAttachment *bool
+	// Results continue after this position (newest first)
+	BeforeTime *time.Time
+	BeforeID   string
+	Limit      int
+}
+
 type MessageRepository interface {
 	Create(ctx context.Context, message *models.Message) error
+	FindByClientMsgID(senderID, chatRoomID, clientMsgID string) (*models.Message, error)
+	ListClientMsgIDs(chatRoomID, prefix string) ([]string, error)
+	FindByID(id string) (*models.Message, error)
+	FindByIDs(ids []string) ([]models.Message, error)
+	CreateBatch(ctx context.Context, messages []*models.Message) error
+	UpdatePayload(id string, payload datatypes.JSON) error
+	IndexForSearch(id, text string) error
+	Search(userID string, params SearchParams) ([]models.Message, error)
 	FindByChatRoom(chatRoomID string, limit, offset int) ([]models.Message, error)
+	// FindByChatRoomForUser is FindByChatRoom without the messages of
+	// senders the user blocked
+	FindByChatRoomForUser(chatRoomID, userID string, limit, offset int) ([]models.Message, error)
 	MarkRead(userID, messageID string) error
 	MarkUnread(userID, messageID string) error
 	MarkDelivered(userID, messageID string) error
 	MarkUndelivered(userID, messageID string) error
+	// ListStatuses returns the delivery and read statuses of a message with
+	// their users
+	ListStatuses(messageID string) ([]models.MessageStatus, error)
+	DeleteExpired(ctx context.Context, now time.Time, limit int, deleteObjects func([]models.Attachment) error) ([]models.Message, error)
 }
 
 type messageRepository struct {
@@ -27,13 +64,158 @@ func NewMessageRepository(db *gorm.DB) MessageRepository {
 }
 
 func (r *messageRepository) Create(ctx context.Context, message *models.Message) error {
-	return r.db.WithContext(ctx).Create(message).Error
+	err := r.db.WithContext(ctx).Create(message).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) && message.ClientMsgID != nil {
+		return ErrDuplicateClientMsgID
+	}
+	return err
 }
 
-func (r *messageRepository) FindByChatRoom(chatRoomID string, limit, offset int) ([]models.Message, error) {
+// FindByClientMsgID returns the message the sender already sent with this
+// client_msg_id, or nil if there is none or it has expired.
+func (r *messageRepository) FindByClientMsgID(senderID, chatRoomID, clientMsgID string) (*models.Message, error) {
+	var message models.Message
+	err := r.db.
+		Preload("Attachments").
+		Where("sender_id = ? AND chat_room_id = ? AND client_msg_id = ?", senderID, chatRoomID, clientMsgID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// ListClientMsgIDs returns the client_msg_ids in a room that start with
+// prefix, including those of expired messages. prefix must not contain LIKE
+// wildcards.
+func (r *messageRepository) ListClientMsgIDs(chatRoomID, prefix string) ([]string, error) {
+	var ids []string
+	err := r.db.Model(&models.Message{}).
+		Where("chat_room_id = ? AND client_msg_id LIKE ?", chatRoomID, prefix+"%").
+		Pluck("client_msg_id", &ids).Error
+	return ids, err
+}
+
+func (r *messageRepository) FindByID(id string) (*models.Message, error) {
+	var message models.Message
+	err := r.db.
+		Preload("Attachments").
+		Preload("LinkPreviews", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&message, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// FindByIDs loads the messages that still exist, oldest first.
+func (r *messageRepository) FindByIDs(ids []string) ([]models.Message, error) {
 	var messages []models.Message
 	err := r.db.
+		Preload("Attachments").
+		Where("id IN ?", ids).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Order("created_at").
+		Find(&messages).Error
+	return messages, err
+}
+
+// CreateBatch stores several messages together with their attachments in a
+// single transaction.
+func (r *messageRepository) CreateBatch(ctx context.Context, messages []*models.Message) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, message := range messages {
+			if err := tx.Create(message).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *messageRepository) UpdatePayload(id string, payload datatypes.JSON) error {
+	return r.db.Model(&models.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"payload":    payload,
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// IndexForSearch stores the search vector of a message. The plaintext itself
+// is not stored.
+func (r *messageRepository) IndexForSearch(id, text string) error {
+	return r.db.Exec("UPDATE messages SET search_vector = to_tsvector('simple', ?) WHERE id = ?", text, id).Error
+}
+
+// Search runs a full-text query over the indexed messages of rooms the user
+// belongs to, newest first. Messages from senders the user blocked are left
+// out.
+func (r *messageRepository) Search(userID string, params SearchParams) ([]models.Message, error) {
+	memberRooms := r.db.Model(&models.ChatRoomMember{}).Select("chat_room_id").Where("user_id = ?", userID)
+
+	query := r.db.
+		Preload("Attachments").
+		Where("search_vector @@ websearch_to_tsquery('simple', ?)", params.Query).
+		Where("chat_room_id IN (?)", memberRooms).
+		Where(notBlockedBy, userID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now())
+
+	if params.ChatRoomID != "" {
+		query = query.Where("chat_room_id = ?", params.ChatRoomID)
+	}
+	if params.SenderID != "" {
+		query = query.Where("sender_id = ?", params.SenderID)
+	}
+	if params.From != nil {
+		query = query.Where("created_at >= ?", *params.From)
+	}
+	if params.To != nil {
+		query = query.Where("created_at < ?", *params.To)
+	}
+	if params.HasAttachment != nil {
+		hasAttachment := "EXISTS (SELECT 1 FROM attachments WHERE attachments.message_id = messages.id)"
+		if *params.HasAttachment {
+			query = query.Where(hasAttachment)
+		} else {
+			query = query.Where("NOT " + hasAttachment)
+		}
+	}
+	if params.BeforeTime != nil {
+		query = query.Where("(created_at, id) < (?, ?)", *params.BeforeTime, params.BeforeID)
+	}
+
+	var messages []models.Message
+	err := query.
+		Order("created_at DESC, id DESC").
+		Limit(params.Limit).
+		Find(&messages).Error
+	return messages, err
+}
+
+// notBlockedBy leaves out messages whose sender the user blocked.
+const notBlockedBy = "NOT EXISTS (SELECT 1 FROM blocks WHERE blocks.blocker_id = ? AND blocks.blocked_id = messages.sender_id)"
+
+func (r *messageRepository) FindByChatRoom(chatRoomID string, limit, offset int) ([]models.Message, error) {
+	return r.findByChatRoom(r.db, chatRoomID, limit, offset)
+}
+
+func (r *messageRepository) FindByChatRoomForUser(chatRoomID, userID string, limit, offset int) ([]models.Message, error) {
+	return r.findByChatRoom(r.db.Where(notBlockedBy, userID), chatRoomID, limit, offset)
+}
+
+func (r *messageRepository) findByChatRoom(db *gorm.DB, chatRoomID string, limit, offset int) ([]models.Message, error) {
+	var messages []models.Message
+	err := db.
+		Preload("Attachments").
+		Preload("LinkPreviews", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
 		Where("chat_room_id = ?", chatRoomID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
 		Order("created_at DESC").
 		Limit(limit).
 		Offset(offset).
@@ -75,6 +257,110 @@ func (r *messageRepository) MarkUndelivered(userID, messageID string) error {
 		Update("delivered", false).Error
 }
 
+func (r *messageRepository) ListStatuses(messageID string) ([]models.MessageStatus, error) {
+	var statuses []models.MessageStatus
+	err := r.db.
+		Preload("User").
+		Where("message_id = ?", messageID).
+		Order("updated_at").
+		Find(&statuses).Error
+	return statuses, err
+}
+
+// DeleteExpired hard-deletes up to limit expired messages together with their
+// statuses and attachments. deleteObjects is called with the attachments
+// before the rows are removed, while the rows are still locked; if it fails
+// the transaction is rolled back so the next run retries. Rows are claimed
+// with FOR UPDATE SKIP LOCKED so concurrent reapers split the work.
+func (r *messageRepository) DeleteExpired(ctx context.Context, now time.Time, limit int, deleteObjects func([]models.Attachment) error) ([]models.Message, error) {
+	var expired []models.Message
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Select("id", "chat_room_id").
+			Where("expires_at IS NOT NULL AND expires_at <= ?", now).
+			Order("expires_at").
+			Limit(limit).
+			Find(&expired).Error; err != nil {
+			return err
+		}
+		if len(expired) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, len(expired))
+		for i, msg := range expired {
+			ids[i] = msg.ID
+		}
+
+		var attachments []models.Attachment
+		if err := tx.Where("message_id IN ?", ids).Find(&attachments).Error; err != nil {
+			return err
+		}
+		shared, err := sharedKeys(tx, attachments, ids)
+		if err != nil {
+			return err
+		}
+		orphaned := unsharedAttachments(attachments, shared)
+		if len(orphaned) > 0 {
+			if err := deleteObjects(orphaned); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("message_id IN ?", ids).Delete(&models.MessageStatus{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id IN ?", ids).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN ?", ids).Delete(&models.Message{}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// sharedKeys returns the keys of attachments whose S3 object is still used
+// by a message outside of messageIDs. Forwarded messages share objects, so
+// an object may only be deleted together with its last reference.
+func sharedKeys(tx *gorm.DB, attachments []models.Attachment, messageIDs []uuid.UUID) ([]string, error) {
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(attachments))
+	for i, a := range attachments {
+		keys[i] = a.Key
+	}
+
+	var shared []string
+	err := tx.Model(&models.Attachment{}).
+		Distinct("key").
+		Where("key IN ? AND message_id NOT IN ?", keys, messageIDs).
+		Pluck("key", &shared).Error
+	return shared, err
+}
+
+// unsharedAttachments drops attachments whose key is in shared.
+func unsharedAttachments(attachments []models.Attachment, shared []string) []models.Attachment {
+	inUse := make(map[string]bool, len(shared))
+	for _, key := range shared {
+		inUse[key] = true
+	}
+
+	// The same key can appear twice in one batch; delete it only once
+	orphaned := make([]models.Attachment, 0, len(attachments))
+	for _, a := range attachments {
+		if !inUse[a.Key] {
+			inUse[a.Key] = true
+			orphaned = append(orphaned, a)
+		}
+	}
+	return orphaned
+}
+
 // Helper function to parse UUID from string
 func mustParseUUID(s string) uuid.UUID {
 	id, err := uuid.Parse(s)
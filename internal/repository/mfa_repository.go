@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+// MFARepository stores authenticator secrets and recovery codes.
+type MFARepository interface {
+	FindTOTP(userID string) (*models.UserTOTP, error)
+	SaveTOTP(totp *models.UserTOTP) error
+	ConfirmTOTP(userID string, step int64, recoveryCodeHashes []string, now time.Time) error
+	UseTOTPStep(userID string, step int64) (bool, error)
+	Disable(userID string) error
+	ReplaceRecoveryCodes(userID string, hashes []string) error
+	UseRecoveryCode(userID, hash string, now time.Time) (bool, error)
+	CountRecoveryCodes(userID string) (int64, error)
+}
+
+type mfaRepository struct {
+	db *gorm.DB
+}
+
+func NewMFARepository(db *gorm.DB) MFARepository {
+	return &mfaRepository{db: db}
+}
+
+// FindTOTP returns the user's authenticator, or nil if they have none.
+func (r *mfaRepository) FindTOTP(userID string) (*models.UserTOTP, error) {
+	var totp models.UserTOTP
+	err := r.db.First(&totp, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// SaveTOTP starts an enrollment, replacing an unconfirmed one.
+func (r *mfaRepository) SaveTOTP(totp *models.UserTOTP) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"secret", "confirmed_at", "last_step", "created_at"}),
+	}).Create(totp).Error
+}
+
+// ConfirmTOTP turns two-factor login on and stores the first recovery codes.
+func (r *mfaRepository) ConfirmTOTP(userID string, step int64, recoveryCodeHashes []string, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.UserTOTP{}).
+			Where("user_id = ? AND confirmed_at IS NULL", userID).
+			Updates(map[string]any{"confirmed_at": now, "last_step": step})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("no pending two-factor enrollment")
+		}
+		return replaceRecoveryCodes(tx, userID, recoveryCodeHashes)
+	})
+}
+
+// UseTOTPStep records that a code from step was accepted. It reports false
+// if a code from that step or a later one was already used.
+func (r *mfaRepository) UseTOTPStep(userID string, step int64) (bool, error) {
+	res := r.db.Model(&models.UserTOTP{}).
+		Where("user_id = ? AND last_step < ?", userID, step).
+		Update("last_step", step)
+	return res.RowsAffected > 0, res.Error
+}
+
+// Disable removes the authenticator and the recovery codes.
+func (r *mfaRepository) Disable(userID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&models.UserTOTP{}).Error
+	})
+}
+
+func (r *mfaRepository) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(tx, userID, hashes)
+	})
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID string, hashes []string) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return err
+	}
+	codes := make([]models.RecoveryCode, 0, len(hashes))
+	for _, hash := range hashes {
+		codes = append(codes, models.RecoveryCode{UserID: uid, CodeHash: hash})
+	}
+	return tx.Create(&codes).Error
+}
+
+// UseRecoveryCode spends a recovery code. It reports false if the user has
+// no unused code with that hash.
+func (r *mfaRepository) UseRecoveryCode(userID, hash string, now time.Time) (bool, error) {
+	res := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", now)
+	return res.RowsAffected > 0, res.Error
+}
+
+// CountRecoveryCodes returns how many unused recovery codes the user has.
+func (r *mfaRepository) CountRecoveryCodes(userID string) (int64, error) {
+	var n int64
+	err := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&n).Error
+	return n, err
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+// PasskeyRepository stores users' WebAuthn credentials and the challenges
+// of ceremonies in progress.
+type PasskeyRepository interface {
+	Create(passkey *models.Passkey) error
+	ListByUser(userID string) ([]models.Passkey, error)
+	FindByCredentialID(credentialID []byte) (*models.Passkey, error)
+	RecordLogin(id string, signCount int64, backedUp bool, now time.Time) (bool, error)
+	MarkCloneSuspected(id string, now time.Time) error
+	Rename(userID, id, name string) (bool, error)
+	Delete(userID, id string) (bool, error)
+	CreateChallenge(challenge *models.WebAuthnChallenge) error
+	ConsumeChallenge(challenge []byte, purpose string, now time.Time) (*models.WebAuthnChallenge, error)
+	DeleteExpiredChallenges(ctx context.Context, now time.Time) (int64, error)
+}
+
+type passkeyRepository struct {
+	db *gorm.DB
+}
+
+func NewPasskeyRepository(db *gorm.DB) PasskeyRepository {
+	return &passkeyRepository{db: db}
+}
+
+func (r *passkeyRepository) Create(passkey *models.Passkey) error {
+	return r.db.Create(passkey).Error
+}
+
+func (r *passkeyRepository) ListByUser(userID string) ([]models.Passkey, error) {
+	var passkeys []models.Passkey
+	err := r.db.Where("user_id = ?", userID).Order("created_at").Find(&passkeys).Error
+	return passkeys, err
+}
+
+// FindByCredentialID returns the passkey with that credential id, or nil.
+func (r *passkeyRepository) FindByCredentialID(credentialID []byte) (*models.Passkey, error) {
+	var passkey models.Passkey
+	err := r.db.First(&passkey, "credential_id = ?", credentialID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &passkey, nil
+}
+
+// RecordLogin stores the counter from a verified login. It reports false if
+// the stored counter has already reached signCount, which happens when two
+// logins race with the same counter value or a cloned key is used. Counters
+// of zero are never compared.
+func (r *passkeyRepository) RecordLogin(id string, signCount int64, backedUp bool, now time.Time) (bool, error) {
+	res := r.db.Model(&models.Passkey{}).
+		Where("id = ? AND clone_suspected_at IS NULL AND (sign_count < ? OR ? = 0)", id, signCount, signCount).
+		Updates(map[string]any{"sign_count": signCount, "backed_up": backedUp, "last_used_at": now})
+	return res.RowsAffected > 0, res.Error
+}
+
+// MarkCloneSuspected stops the passkey from being used to log in.
+func (r *passkeyRepository) MarkCloneSuspected(id string, now time.Time) error {
+	return r.db.Model(&models.Passkey{}).
+		Where("id = ? AND clone_suspected_at IS NULL", id).
+		Update("clone_suspected_at", now).Error
+}
+
+func (r *passkeyRepository) Rename(userID, id, name string) (bool, error) {
+	res := r.db.Model(&models.Passkey{}).
+		Where("id = ? AND user_id = ?", id, userID).
+		Update("name", name)
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *passkeyRepository) Delete(userID, id string) (bool, error) {
+	res := r.db.Where("id = ? AND user_id = ?", id, userID).Delete(&models.Passkey{})
+	return res.RowsAffected > 0, res.Error
+}
+
+func (r *passkeyRepository) CreateChallenge(challenge *models.WebAuthnChallenge) error {
+	return r.db.Create(challenge).Error
+}
+
+// ConsumeChallenge deletes an open ceremony and returns it, or nil if it
+// does not exist, has expired or was already answered.
+func (r *passkeyRepository) ConsumeChallenge(challenge []byte, purpose string, now time.Time) (*models.WebAuthnChallenge, error) {
+	var consumed []models.WebAuthnChallenge
+	err := r.db.Clauses(clause.Returning{}).
+		Where("challenge = ? AND purpose = ? AND expires_at > ?", challenge, purpose, now).
+		Delete(&consumed).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(consumed) == 0 {
+		return nil, nil
+	}
+	return &consumed[0], nil
+}
+
+// DeleteExpiredChallenges removes ceremonies that were never finished.
+func (r *passkeyRepository) DeleteExpiredChallenges(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.WebAuthnChallenge{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+var ErrPollClosed = errors.New("poll is closed")
+
+type PollRepository interface {
+	CreateWithMessage(ctx context.Context, poll *models.Poll, message *models.Message) error
+	FindByID(id string) (*models.Poll, error)
+	ReplaceVotes(pollID, userID string, optionIDs []uuid.UUID, now time.Time) error
+	FindVotes(pollID string) ([]models.PollVote, error)
+	Close(pollID string, now time.Time) (*models.Poll, error)
+}
+
+type pollRepository struct {
+	db *gorm.DB
+}
+
+func NewPollRepository(db *gorm.DB) PollRepository {
+	return &pollRepository{db: db}
+}
+
+// CreateWithMessage stores the poll message, the poll and its options atomically.
+func (r *pollRepository) CreateWithMessage(ctx context.Context, poll *models.Poll, message *models.Message) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		return tx.Create(poll).Error
+	})
+}
+
+func (r *pollRepository) FindByID(id string) (*models.Poll, error) {
+	var poll models.Poll
+	err := r.db.
+		Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+		First(&poll, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// ReplaceVotes swaps the user's current votes for optionIDs; an empty slice
+// retracts the vote. The poll row is locked for the duration so votes cannot
+// race with each other or with closing the poll.
+func (r *pollRepository) ReplaceVotes(pollID, userID string, optionIDs []uuid.UUID, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var poll models.Poll
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&poll, "id = ?", pollID).Error; err != nil {
+			return err
+		}
+		if poll.IsClosed(now) {
+			return ErrPollClosed
+		}
+
+		if err := tx.Where("poll_id = ? AND user_id = ?", pollID, userID).Delete(&models.PollVote{}).Error; err != nil {
+			return err
+		}
+		if len(optionIDs) == 0 {
+			return nil
+		}
+
+		votes := make([]models.PollVote, len(optionIDs))
+		for i, optionID := range optionIDs {
+			votes[i] = models.PollVote{
+				ID:       uuid.New(),
+				PollID:   poll.ID,
+				OptionID: optionID,
+				UserID:   uuidFromString(userID),
+			}
+		}
+		return tx.Create(&votes).Error
+	})
+}
+
+func (r *pollRepository) FindVotes(pollID string) ([]models.PollVote, error) {
+	var votes []models.PollVote
+	err := r.db.Where("poll_id = ?", pollID).Order("created_at").Find(&votes).Error
+	return votes, err
+}
+
+// Close freezes the poll: it stores a snapshot of the current tallies and
+// marks it closed. Closing an already frozen poll is a no-op.
+func (r *pollRepository) Close(pollID string, now time.Time) (*models.Poll, error) {
+	var poll models.Poll
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+			First(&poll, "id = ?", pollID).Error; err != nil {
+			return err
+		}
+		if poll.ClosedAt != nil {
+			return nil
+		}
+
+		var votes []models.PollVote
+		if err := tx.Where("poll_id = ?", pollID).Order("created_at").Find(&votes).Error; err != nil {
+			return err
+		}
+
+		// A poll that ran out of time closes at its deadline, not when it is frozen
+		closedAt := now
+		if poll.ClosesAt != nil && poll.ClosesAt.Before(now) {
+			closedAt = *poll.ClosesAt
+		}
+		poll.ClosedAt = &closedAt
+		poll.FinalResults = poll.Tally(votes)
+
+		return tx.Model(&poll).Updates(map[string]any{
+			"closed_at":     poll.ClosedAt,
+			"final_results": poll.FinalResults,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
@@ -0,0 +1,286 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"mozho_chat/internal/models"
+)
+
+var ErrScheduledMessageNotPending = errors.New("scheduled message is no longer pending")
+
+const (
+	// maxScheduledAttempts is how often a scheduled message is tried before
+	// it is marked failed.
+	maxScheduledAttempts = 5
+	// scheduledRetryDelay is multiplied by the attempt count to push back a
+	// scheduled message that could not be sent.
+	scheduledRetryDelay = time.Minute
+)
+
+// ScheduledPublishError is returned by PublishNextDue when the claimed row
+// could not be published. The failure is recorded on Scheduled, which was
+// either pushed back or marked failed, so the caller can go on with the
+// next due row.
+type ScheduledPublishError struct {
+	Scheduled *models.ScheduledMessage
+	Err       error
+}
+
+func (e *ScheduledPublishError) Error() string {
+	return fmt.Sprintf("scheduled message %s: %v", e.Scheduled.ID, e.Err)
+}
+
+func (e *ScheduledPublishError) Unwrap() error {
+	return e.Err
+}
+
+type ScheduledMessageRepository interface {
+	Create(ctx context.Context, message *models.ScheduledMessage) error
+	FindByClientMsgID(senderID, chatRoomID, clientMsgID string) (*models.ScheduledMessage, error)
+	FindByID(id string) (*models.ScheduledMessage, error)
+	FindPendingBySender(senderID, chatRoomID string) ([]models.ScheduledMessage, error)
+	UpdatePending(message *models.ScheduledMessage) error
+	CancelPending(id, senderID string) error
+	PublishNextDue(ctx context.Context, now time.Time) (*models.Message, *models.ScheduledMessage, error)
+}
+
+type scheduledMessageRepository struct {
+	db *gorm.DB
+}
+
+func NewScheduledMessageRepository(db *gorm.DB) ScheduledMessageRepository {
+	return &scheduledMessageRepository{db: db}
+}
+
+func (r *scheduledMessageRepository) Create(ctx context.Context, message *models.ScheduledMessage) error {
+	err := r.db.WithContext(ctx).Create(message).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) && message.ClientMsgID != nil {
+		return ErrDuplicateClientMsgID
+	}
+	return err
+}
+
+func (r *scheduledMessageRepository) FindByClientMsgID(senderID, chatRoomID, clientMsgID string) (*models.ScheduledMessage, error) {
+	var message models.ScheduledMessage
+	err := r.db.
+		Where("sender_id = ? AND chat_room_id = ? AND client_msg_id = ?", senderID, chatRoomID, clientMsgID).
+		First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func (r *scheduledMessageRepository) FindByID(id string) (*models.ScheduledMessage, error) {
+	var message models.ScheduledMessage
+	if err := r.db.First(&message, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// FindPendingBySender lists the sender's pending messages, optionally
+// restricted to a single room.
+func (r *scheduledMessageRepository) FindPendingBySender(senderID, chatRoomID string) ([]models.ScheduledMessage, error) {
+	var messages []models.ScheduledMessage
+	query := r.db.Where("sender_id = ? AND status = ?", senderID, models.ScheduledStatusPending)
+	if chatRoomID != "" {
+		query = query.Where("chat_room_id = ?", chatRoomID)
+	}
+	err := query.Order("send_at").Find(&messages).Error
+	return messages, err
+}
+
+// UpdatePending saves the editable fields of a scheduled message, but only
+// while the scheduler has not picked it up yet.
+func (r *scheduledMessageRepository) UpdatePending(message *models.ScheduledMessage) error {
+	res := r.db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND status = ?", message.ID, models.ScheduledStatusPending).
+		Updates(map[string]any{
+			"content":    message.Content,
+			"algorithm":  message.EncryptionMetadata.Algorithm,
+			"key":        message.EncryptionMetadata.Key,
+			"mentions":   message.Mentions,
+			"send_at":    message.SendAt,
+			"expires_at": message.ExpiresAt,
+			"updated_at": time.Now(),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrScheduledMessageNotPending
+	}
+	return nil
+}
+
+func (r *scheduledMessageRepository) CancelPending(id, senderID string) error {
+	res := r.db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND sender_id = ? AND status = ?", id, senderID, models.ScheduledStatusPending).
+		Updates(map[string]any{
+			"status":     models.ScheduledStatusCancelled,
+			"updated_at": time.Now(),
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrScheduledMessageNotPending
+	}
+	return nil
+}
+
+// PublishNextDue turns the oldest due scheduled message into a regular message
+// inside a single transaction. The row is claimed with FOR UPDATE SKIP LOCKED,
+// so several server instances can run the scheduler without publishing the
+// same message twice. When the claimed row had to be cancelled instead, it
+// is returned in place of the message so the caller can delete its
+// attachments; both are nil when nothing is due.
+//
+// A row that fails to publish must not hold up the rows behind it: the
+// work is rolled back to a savepoint, the attempt is recorded and a
+// *ScheduledPublishError is returned.
+func (r *scheduledMessageRepository) PublishNextDue(ctx context.Context, now time.Time) (*models.Message, *models.ScheduledMessage, error) {
+	var published *models.Message
+	var cancelled *models.ScheduledMessage
+	var failure *ScheduledPublishError
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var scheduled models.ScheduledMessage
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND send_at <= ?", models.ScheduledStatusPending, now).
+			Order("send_at").
+			First(&scheduled).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.Transaction(func(tx *gorm.DB) error {
+			var err error
+			published, cancelled, err = publishScheduled(tx, &scheduled, now)
+			return err
+		})
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		published, cancelled = nil, nil
+		failure = &ScheduledPublishError{Scheduled: &scheduled, Err: err}
+		FailScheduledAttempt(&scheduled, err, now)
+		return tx.Model(&scheduled).Updates(map[string]any{
+			"attempts":   scheduled.Attempts,
+			"last_error": scheduled.LastError,
+			"status":     scheduled.Status,
+			"send_at":    scheduled.SendAt,
+			"updated_at": now,
+		}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if failure != nil {
+		return nil, nil, failure
+	}
+	return published, cancelled, nil
+}
+
+// FailScheduledAttempt counts a failed attempt to send scheduled and pushes
+// it back, or marks it failed once it has used up its attempts.
+func FailScheduledAttempt(scheduled *models.ScheduledMessage, cause error, now time.Time) {
+	reason := cause.Error()
+	scheduled.Attempts++
+	scheduled.LastError = &reason
+	if scheduled.Attempts >= maxScheduledAttempts {
+		scheduled.Status = models.ScheduledStatusFailed
+		return
+	}
+	scheduled.SendAt = now.Add(time.Duration(scheduled.Attempts) * scheduledRetryDelay)
+}
+
+// publishScheduled does the work of PublishNextDue for a claimed row.
+func publishScheduled(tx *gorm.DB, scheduled *models.ScheduledMessage, now time.Time) (*models.Message, *models.ScheduledMessage, error) {
+	// Senders who left the room in the meantime can no longer post there
+	var membership int64
+	if err := tx.Model(&models.ChatRoomMember{}).
+		Where("chat_room_id = ? AND user_id = ?", scheduled.ChatRoomID, scheduled.SenderID).
+		Count(&membership).Error; err != nil {
+		return nil, nil, err
+	}
+	if membership == 0 {
+		if err := tx.Model(scheduled).Updates(map[string]any{
+			"status":     models.ScheduledStatusCancelled,
+			"updated_at": now,
+		}).Error; err != nil {
+			return nil, nil, err
+		}
+		return nil, scheduled, nil
+	}
+
+	// Without an explicit expiry the room's timer applies from the send time
+	expiresAt := scheduled.ExpiresAt
+	if expiresAt == nil {
+		var room models.ChatRoom
+		if err := tx.Select("id", "message_ttl_seconds").First(&room, "id = ?", scheduled.ChatRoomID).Error; err != nil {
+			return nil, nil, err
+		}
+		if room.MessageTTLSeconds != nil {
+			t := now.Add(time.Duration(*room.MessageTTLSeconds) * time.Second)
+			expiresAt = &t
+		}
+	}
+
+	// client_msg_id stays on the scheduled row: retries were deduplicated
+	// there, and copying it could collide with an immediate send
+	msg := &models.Message{
+		ID:                 uuid.New(),
+		ChatRoomID:         scheduled.ChatRoomID,
+		SenderID:           scheduled.SenderID,
+		Type:               models.MessageTypeText,
+		Content:            scheduled.Content,
+		EncryptionMetadata: scheduled.EncryptionMetadata,
+		Mentions:           scheduled.Mentions,
+		ExpiresAt:          expiresAt,
+		CreatedAt:          now,
+	}
+	if err := tx.Create(msg).Error; err != nil {
+		return nil, nil, err
+	}
+
+	for _, file := range scheduled.Attachments {
+		attachment := models.Attachment{
+			ID:        uuid.New(),
+			MessageID: msg.ID,
+			Key:       file.Key,
+			FileName:  file.FileName,
+			MimeType:  file.MimeType,
+			Size:      file.Size,
+			CreatedAt: now,
+		}
+		if err := tx.Create(&attachment).Error; err != nil {
+			return nil, nil, err
+		}
+		msg.Attachments = append(msg.Attachments, attachment)
+	}
+
+	if err := tx.Model(scheduled).Updates(map[string]any{
+		"status":     models.ScheduledStatusSent,
+		"message_id": msg.ID,
+		"updated_at": now,
+	}).Error; err != nil {
+		return nil, nil, err
+	}
+	return msg, nil, nil
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+)
+
+// ErrSessionRotated is returned when a refresh token was already exchanged
+// or revoked by the time it is rotated.
+var ErrSessionRotated = errors.New("session already rotated")
+
+type SessionRepository interface {
+	Create(session *models.Session) error
+	FindByTokenHash(hash string) (*models.Session, error)
+	Rotate(ctx context.Context, current, next *models.Session, now time.Time) error
+	RevokeFamily(familyID string, now time.Time) error
+	ListActive(userID string, now time.Time) ([]models.Session, error)
+	RevokeUserFamily(userID, familyID string, now time.Time) (bool, error)
+	RevokeAllForUser(userID string, now time.Time) ([]string, error)
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type sessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) SessionRepository {
+	return &sessionRepository{db: db}
+}
+
+func (r *sessionRepository) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+// FindByTokenHash returns the session of a refresh token, or nil if the
+// token is unknown.
+func (r *sessionRepository) FindByTokenHash(hash string) (*models.Session, error) {
+	var session models.Session
+	err := r.db.First(&session, "refresh_token_hash = ?", hash).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Rotate retires current in favour of next. Only one of two concurrent
+// rotations of the same token can win; the other gets ErrSessionRotated.
+func (r *sessionRepository) Rotate(ctx context.Context, current, next *models.Session, now time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.Session{}).
+			Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", current.ID).
+			Updates(map[string]any{
+				"rotated_at":     now,
+				"replaced_by_id": next.ID,
+				"last_used_at":   now,
+			})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrSessionRotated
+		}
+		return tx.Create(next).Error
+	})
+}
+
+// RevokeFamily revokes every refresh token issued from the same login.
+func (r *sessionRepository) RevokeFamily(familyID string, now time.Time) error {
+	return r.db.Model(&models.Session{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error
+}
+
+// ListActive returns the current refresh token of each of the user's
+// sessions, most recently used first.
+func (r *sessionRepository) ListActive(userID string, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.
+		Where("user_id = ? AND rotated_at IS NULL AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeUserFamily revokes one of the user's sessions. It reports false when
+// the user has no such session that is still active.
+func (r *sessionRepository) RevokeUserFamily(userID, familyID string, now time.Time) (bool, error) {
+	res := r.db.Model(&models.Session{}).
+		Where("user_id = ? AND family_id = ? AND revoked_at IS NULL", userID, familyID).
+		Update("revoked_at", now)
+	return res.RowsAffected > 0, res.Error
+}
+
+// RevokeAllForUser revokes every session of the user and returns their ids.
+func (r *sessionRepository) RevokeAllForUser(userID string, now time.Time) ([]string, error) {
+	var familyIDs []string
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Distinct().
+			Pluck("family_id", &familyIDs).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", now).Error
+	})
+	return familyIDs, err
+}
+
+// DeleteExpired removes refresh tokens that can no longer be used.
+func (r *sessionRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.Session{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/pkg/auth"
+)
+
+// SigningKeyRepository stores the keys access tokens are signed with. It is
+// the auth.KeyStore used by every instance.
+type SigningKeyRepository interface {
+	LoadKeys(ctx context.Context) ([]auth.KeyRecord, error)
+	SaveKey(ctx context.Context, key auth.KeyRecord) error
+	DeleteKey(ctx context.Context, id string) error
+}
+
+type signingKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewSigningKeyRepository(db *gorm.DB) SigningKeyRepository {
+	return &signingKeyRepository{db: db}
+}
+
+func (r *signingKeyRepository) LoadKeys(ctx context.Context) ([]auth.KeyRecord, error) {
+	var keys []models.SigningKey
+	if err := r.db.WithContext(ctx).Order("activates_at, id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	records := make([]auth.KeyRecord, 0, len(keys))
+	for _, key := range keys {
+		records = append(records, auth.KeyRecord{
+			ID:          key.ID,
+			Algorithm:   key.Algorithm,
+			PrivateKey:  key.PrivateKey,
+			ActivatesAt: key.ActivatesAt,
+			CreatedAt:   key.CreatedAt,
+		})
+	}
+	return records, nil
+}
+
+func (r *signingKeyRepository) SaveKey(ctx context.Context, key auth.KeyRecord) error {
+	return r.db.WithContext(ctx).Create(&models.SigningKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		PrivateKey:  key.PrivateKey,
+		ActivatesAt: key.ActivatesAt,
+		CreatedAt:   key.CreatedAt,
+	}).Error
+}
+
+func (r *signingKeyRepository) DeleteKey(ctx context.Context, id string) error {
+	return r.db.WithContext(ctx).Delete(&models.SigningKey{}, "id = ?", id).Error
+}
@@ -1,15 +1,34 @@
 package repository
 
 import (
+	"strings"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 	"mozho_chat/internal/models"
 )
 
+// UserSearchParams describes a people search. Empty fields are not matched.
+type UserSearchParams struct {
+	// Name matches usernames and display names by prefix or trigram
+	// similarity. It must be lower case.
+	Name string
+	// Email and Phone match exactly, and only accounts that allow it
+	Email string
+	Phone string
+	// ExcludeID leaves out the user who is searching, along with anyone
+	// they blocked or who blocked them
+	ExcludeID string
+	Limit     int
+	Offset    int
+}
+
 type UserRepository interface {
 	Create(user *models.User) error
 	FindByEmail(email string) (*models.User, error)
 	FindByID(id string) (*models.User, error)
 	Update(user *models.User) error
+	Search(params UserSearchParams) ([]models.User, error)
 }
 
 type userRepo struct {
@@ -43,3 +62,60 @@ func (r *userRepo) FindByID(id string) (*models.User, error) {
 func (r *userRepo) Update(user *models.User) error {
 	return r.db.Save(user).Error
 }
+
+// Search finds accounts matching any of the given fields, best matches
+// first: exact usernames, then prefixes, then the most similar names.
+// Placeholder accounts are never returned.
+func (r *userRepo) Search(params UserSearchParams) ([]models.User, error) {
+	var (
+		conditions []string
+		args       []any
+	)
+	if params.Name != "" {
+		prefix := escapeLike(params.Name) + "%"
+		conditions = append(conditions, "lower(username) LIKE ? OR lower(display_name) LIKE ? OR lower(username) % ? OR lower(display_name) % ?")
+		args = append(args, prefix, prefix, params.Name, params.Name)
+	}
+	if params.Email != "" {
+		conditions = append(conditions, "(lower(email) = ? AND discoverable_by_email)")
+		args = append(args, params.Email)
+	}
+	if params.Phone != "" {
+		conditions = append(conditions, "(phone = ? AND discoverable_by_phone)")
+		args = append(args, params.Phone)
+	}
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	query := r.db.
+		Where("("+strings.Join(conditions, " OR ")+")", args...).
+		Where("placeholder = ?", false)
+	if params.ExcludeID != "" {
+		query = query.
+			Where("id <> ?", params.ExcludeID).
+			Where("NOT EXISTS (SELECT 1 FROM blocks WHERE (blocker_id = ? AND blocked_id = users.id) OR (blocker_id = users.id AND blocked_id = ?))", params.ExcludeID, params.ExcludeID)
+	}
+	order := clause.OrderBy{Expression: clause.Expr{SQL: "username, id"}}
+	if params.Name != "" {
+		prefix := escapeLike(params.Name) + "%"
+		order.Expression = clause.Expr{
+			SQL: "lower(username) = ? DESC, (lower(username) LIKE ? OR lower(display_name) LIKE ?) DESC, " +
+				"greatest(similarity(lower(username), ?), similarity(lower(display_name), ?)) DESC, username, id",
+			Vars: []any{params.Name, prefix, prefix, params.Name, params.Name},
+		}
+	}
+
+	var users []models.User
+	err := query.
+		Order(order).
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Find(&users).Error
+	return users, err
+}
+
+// escapeLike escapes the LIKE wildcards in s, so it only matches itself.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
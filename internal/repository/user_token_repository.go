@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+)
+
+type UserTokenRepository interface {
+	Create(token *models.UserToken) error
+	Find(id, purpose string, now time.Time) (*models.UserToken, error)
+	Consume(ctx context.Context, id, purpose string, now time.Time) (*models.UserToken, error)
+	RecordFailedAttempt(id string) (int, error)
+	InvalidateForUser(userID, purpose string, now time.Time) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+}
+
+type userTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewUserTokenRepository(db *gorm.DB) UserTokenRepository {
+	return &userTokenRepository{db: db}
+}
+
+func (r *userTokenRepository) Create(token *models.UserToken) error {
+	return r.db.Create(token).Error
+}
+
+// Find returns a token that can still be used, or nil.
+func (r *userTokenRepository) Find(id, purpose string, now time.Time) (*models.UserToken, error) {
+	var token models.UserToken
+	err := r.db.First(&token, "id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", id, purpose, now).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// Consume marks a token as used and returns it, or nil if it was already
+// used, has expired or does not exist. Only one of two concurrent uses wins.
+func (r *userTokenRepository) Consume(ctx context.Context, id, purpose string, now time.Time) (*models.UserToken, error) {
+	var token models.UserToken
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&models.UserToken{}).
+			Where("id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", id, purpose, now).
+			Update("used_at", now)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.First(&token, "id = ?", id).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RecordFailedAttempt counts a wrong code entered against the token and
+// returns the new count.
+func (r *userTokenRepository) RecordFailedAttempt(id string) (int, error) {
+	var attempts int
+	err := r.db.Raw("UPDATE user_tokens SET attempts = attempts + 1 WHERE id = ? RETURNING attempts", id).Scan(&attempts).Error
+	return attempts, err
+}
+
+// InvalidateForUser retires the user's unused tokens for purpose, so only
+// the latest link sent works.
+func (r *userTokenRepository) InvalidateForUser(userID, purpose string, now time.Time) error {
+	return r.db.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error
+}
+
+// DeleteExpired removes tokens whose links can no longer be used.
+func (r *userTokenRepository) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&models.UserToken{})
+	return res.RowsAffected, res.Error
+}
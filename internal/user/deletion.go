@@ -0,0 +1,212 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+const (
+	// accountDeletionGracePeriod is how long a user has to change their mind
+	// after asking for their account to be deleted.
+	accountDeletionGracePeriod = 30 * 24 * time.Hour
+	// accountDeletionBatchSize caps how many accounts one run purges.
+	accountDeletionBatchSize = 20
+	// recentSignInWindow is how long after signing in a user without a
+	// password or second factor may make sensitive changes without
+	// confirming them otherwise.
+	recentSignInWindow = 10 * time.Minute
+)
+
+var (
+	errDeletionNotScheduled = errors.New("account deletion is not scheduled")
+	errSignInRequired       = errors.New("sign in again to confirm this change")
+)
+
+// StartAccountReaper purges accounts whose deletion grace period has ended
+// every interval until ctx is done.
+func StartAccountReaper(ctx context.Context, service Service, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := service.PurgeDeletedAccounts(ctx); err != nil {
+					log.Printf("account reaper: failed to purge deleted accounts: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// RequestAccountDeletion schedules the account to be deleted once the grace
+// period is over. Asking again keeps the original date.
+//
+// Users confirm with their password and, when two-factor authentication is
+// on, a code. Users who sign in through SSO or passkeys have no password:
+// the code is enough for them, and without two-factor authentication the
+// session they ask from must have signed in within recentSignInWindow.
+func (s *userService) RequestAccountDeletion(userID, sessionID string, input dto.DeleteAccountRequest, client dto.ClientInfo) (*dto.AccountDeletionResponse, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.confirmIdentity(user, sessionID, input.Password, input.Code, input.RecoveryCode); err != nil {
+		return nil, err
+	}
+	if user.DeletionScheduledAt != nil {
+		return &dto.AccountDeletionResponse{DeletionScheduledAt: *user.DeletionScheduledAt}, nil
+	}
+
+	scheduledAt := s.now().Add(accountDeletionGracePeriod).UTC()
+	user.DeletionScheduledAt = &scheduledAt
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+	if err := s.audit(models.AuditEventAccountDeletionRequested, &user.ID, &user.ID, client.IPAddress, map[string]any{
+		"scheduled_for": scheduledAt,
+	}); err != nil {
+		return nil, err
+	}
+	return &dto.AccountDeletionResponse{DeletionScheduledAt: scheduledAt}, nil
+}
+
+// confirmIdentity asks for the password and, when two-factor
+// authentication is on, a second factor. Users without a password give the
+// second factor alone, or without one must have signed in from sessionID
+// within recentSignInWindow.
+func (s *userService) confirmIdentity(user *models.User, sessionID, password, code, recoveryCode string) error {
+	if user.PasswordHash != "" {
+		if err := auth.CheckPasswordHash(password, user.PasswordHash); err != nil {
+			return errors.New("password is incorrect")
+		}
+	}
+	err := s.checkSecondFactor(user.ID.String(), code, recoveryCode)
+	switch {
+	case errors.Is(err, errMFANotEnabled):
+		if user.PasswordHash == "" {
+			return s.checkRecentSignIn(user.ID.String(), sessionID)
+		}
+		return nil
+	default:
+		return err
+	}
+}
+
+// checkRecentSignIn makes sure the session signed in within
+// recentSignInWindow. Refreshing a token does not count as signing in.
+func (s *userService) checkRecentSignIn(userID, sessionID string) error {
+	sessions, err := s.sessionRepo.ListActive(userID, s.now())
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session.FamilyID.String() == sessionID {
+			if s.now().Sub(session.AuthenticatedAt) > recentSignInWindow {
+				return errSignInRequired
+			}
+			return nil
+		}
+	}
+	return errSignInRequired
+}
+
+func (s *userService) CancelAccountDeletion(userID string, client dto.ClientInfo) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.DeletionScheduledAt == nil {
+		return errDeletionNotScheduled
+	}
+
+	scheduledAt := *user.DeletionScheduledAt
+	user.DeletionScheduledAt = nil
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	return s.audit(models.AuditEventAccountDeletionCancelled, &user.ID, &user.ID, client.IPAddress, map[string]any{
+		"scheduled_for": scheduledAt,
+	})
+}
+
+// PurgeDeletedAccounts deletes the accounts whose grace period has ended.
+// A failed account is logged and retried on the next run.
+func (s *userService) PurgeDeletedAccounts(ctx context.Context) (int, error) {
+	due, err := s.deletionRepo.ListDue(s.now(), accountDeletionBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for i := range due {
+		if err := ctx.Err(); err != nil {
+			return purged, err
+		}
+		ok, err := s.purgeAccount(ctx, &due[i])
+		if err != nil {
+			log.Printf("account reaper: failed to delete user %s: %v", due[i].ID, err)
+			continue
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// purgeAccount signs the user out everywhere, then deletes their data and
+// anonymizes the account. It reports false if the deletion was cancelled
+// or taken by another worker in the meantime.
+func (s *userService) purgeAccount(ctx context.Context, user *models.User) (bool, error) {
+	profile, err := user.ProfileData()
+	if err != nil {
+		log.Printf("failed to decode profile of user %s: %v", user.ID, err)
+	}
+	if _, err := s.RevokeAllSessions(user.ID.String()); err != nil {
+		return false, err
+	}
+
+	result, err := s.deletionRepo.Purge(ctx, user.ID.String(), s.now(), s.deletePrivateObjects)
+	if errors.Is(err, repository.ErrAccountNotDue) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The avatar is public and only referenced by the profile that is gone
+	s.deleteAvatarObjects(profile)
+
+	if err := s.audit(models.AuditEventAccountDeleted, &user.ID, nil, "", map[string]any{
+		"scheduled_for":         user.DeletionScheduledAt,
+		"rooms_left":            result.RoomsLeft,
+		"ownership_transferred": result.OwnershipTransferred,
+		"messages_retained":     result.MessagesRetained,
+		"objects_deleted":       result.ObjectsDeleted,
+	}); err != nil {
+		// The account is already gone, so this must not count as a failure
+		// to retry
+		log.Printf("failed to record deletion of user %s: %v", user.ID, err)
+	}
+	return true, nil
+}
+
+func (s *userService) deletePrivateObjects(keys []string) error {
+	for _, key := range keys {
+		if err := s.s3Service.DeleteFile(key, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
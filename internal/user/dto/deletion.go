@@ -0,0 +1,16 @@
+package dto
+
+import "time"
+
+// DeleteAccountRequest confirms a deletion with the password, and with a
+// second factor when two-factor authentication is on. Accounts without a
+// password leave Password empty.
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type AccountDeletionResponse struct {
+	DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+}
@@ -0,0 +1,24 @@
+package dto
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ChangeEmailRequest asks to move the account to Email. It is confirmed like
+// DeleteAccountRequest: with the password, and a second factor when
+// two-factor authentication is on.
+type ChangeEmailRequest struct {
+	Email        string `json:"email" binding:"required,email"`
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=6"`
+}
@@ -1,13 +1,23 @@
 package dto
 
+import "time"
+
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
+	// DeviceName labels the session; the User-Agent is used when it is empty
+	DeviceName string `json:"device_name" binding:"max=255"`
 }
 
 type UserResponse struct {
-	ID       string `json:"id"`
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Profile  any    `json:"profile"`
+	ID            string           `json:"id"`
+	Username      string           `json:"username"`
+	DisplayName   string           `json:"display_name"`
+	Email         string           `json:"email"`
+	EmailVerified bool             `json:"email_verified"`
+	Phone         string           `json:"phone,omitempty"`
+	Profile       *ProfileResponse `json:"profile"`
+	// DeletionScheduledAt is set while a requested account deletion is
+	// pending
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
 }
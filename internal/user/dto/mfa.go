@@ -0,0 +1,50 @@
+package dto
+
+import "time"
+
+// LoginResponse holds the tokens of a completed login. When the account has
+// two-factor authentication on, it instead holds a challenge to pass to
+// POST /auth/mfa/verify with a code.
+type LoginResponse struct {
+	*TokenResponse
+	MFARequired  bool       `json:"mfa_required,omitempty"`
+	MFAToken     string     `json:"mfa_token,omitempty"`
+	MFAExpiresAt *time.Time `json:"mfa_expires_at,omitempty"`
+}
+
+// MFAVerifyRequest completes a login with either an authenticator code or
+// a recovery code.
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+	DeviceName   string `json:"device_name" binding:"max=255"`
+}
+
+type MFAStatusResponse struct {
+	TOTPEnabled            bool  `json:"totp_enabled"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+// TOTPEnrollResponse is shown once, usually as a QR code of OTPAuthURI.
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// RecoveryCodesResponse lists new recovery codes. They are only shown once.
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// DisableTOTPRequest confirms turning two-factor authentication off.
+// Accounts without a password leave Password empty.
+type DisableTOTPRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
@@ -0,0 +1,32 @@
+package dto
+
+import "time"
+
+type OIDCProviderResponse struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// OIDCAuthorizeResponse starts a single sign-on. The web app keeps State
+// and sends the browser to AuthorizationURL.
+type OIDCAuthorizeResponse struct {
+	AuthorizationURL string    `json:"authorization_url"`
+	State            string    `json:"state"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+// OIDCCallbackRequest carries the query parameters the provider sent the
+// browser back with.
+type OIDCCallbackRequest struct {
+	State      string `json:"state" binding:"required"`
+	Code       string `json:"code" binding:"required"`
+	DeviceName string `json:"device_name" binding:"max=255"`
+}
+
+type IdentityResponse struct {
+	ID          string     `json:"id"`
+	Provider    string     `json:"provider"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
@@ -0,0 +1,129 @@
+package dto
+
+import "time"
+
+// Binary WebAuthn values (challenges, credential ids, client data and so on)
+// travel as unpadded base64url strings, as in the browser's toJSON() and
+// parseCreationOptionsFromJSON() helpers.
+
+type RelyingParty struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type PasskeyUser struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type PublicKeyCredentialParameters struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type       string   `json:"type"`
+	ID         string   `json:"id"`
+	Transports []string `json:"transports,omitempty"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// PasskeyCreationOptions is passed to navigator.credentials.create().
+type PasskeyCreationOptions struct {
+	PublicKey PublicKeyCredentialCreationOptions `json:"publicKey"`
+}
+
+type PublicKeyCredentialCreationOptions struct {
+	RP                     RelyingParty                    `json:"rp"`
+	User                   PasskeyUser                     `json:"user"`
+	Challenge              string                          `json:"challenge"`
+	PubKeyCredParams       []PublicKeyCredentialParameters `json:"pubKeyCredParams"`
+	Timeout                int64                           `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor          `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection          `json:"authenticatorSelection"`
+	Attestation            string                          `json:"attestation"`
+}
+
+// PasskeyRequestOptions is passed to navigator.credentials.get().
+type PasskeyRequestOptions struct {
+	PublicKey PublicKeyCredentialRequestOptions `json:"publicKey"`
+}
+
+type PublicKeyCredentialRequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// PasskeyRegistrationOptionsRequest confirms adding a passkey like
+// DeleteAccountRequest: with the password, and a second factor when
+// two-factor authentication is on. Accounts without a password leave
+// Password empty.
+type PasskeyRegistrationOptionsRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// PasskeyLoginOptionsRequest starts a passkey login. Without an email the
+// browser offers every passkey it has for this site.
+type PasskeyLoginOptionsRequest struct {
+	Email string `json:"email" binding:"omitempty,email"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON" binding:"required"`
+	AttestationObject string   `json:"attestationObject" binding:"required"`
+	Transports        []string `json:"transports"`
+}
+
+// AttestationCredential is the result of navigator.credentials.create().
+type AttestationCredential struct {
+	ID       string              `json:"id" binding:"required"`
+	Type     string              `json:"type" binding:"required,eq=public-key"`
+	Response AttestationResponse `json:"response" binding:"required"`
+}
+
+type RegisterPasskeyRequest struct {
+	Name       string                `json:"name" binding:"max=100"`
+	Credential AttestationCredential `json:"credential" binding:"required"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+	AuthenticatorData string `json:"authenticatorData" binding:"required"`
+	Signature         string `json:"signature" binding:"required"`
+	UserHandle        string `json:"userHandle"`
+}
+
+// AssertionCredential is the result of navigator.credentials.get().
+type AssertionCredential struct {
+	ID       string            `json:"id" binding:"required"`
+	Type     string            `json:"type" binding:"required,eq=public-key"`
+	Response AssertionResponse `json:"response" binding:"required"`
+}
+
+type PasskeyLoginRequest struct {
+	Credential AssertionCredential `json:"credential" binding:"required"`
+	DeviceName string              `json:"device_name" binding:"max=255"`
+}
+
+type PasskeyResponse struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	BackedUp       bool       `json:"backed_up"`
+	CloneSuspected bool       `json:"clone_suspected"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastUsedAt     *time.Time `json:"last_used_at"`
+}
+
+type RenamePasskeyRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
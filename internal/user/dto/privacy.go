@@ -0,0 +1,22 @@
+package dto
+
+type PrivacySettingsResponse struct {
+	DiscoverableByEmail bool `json:"discoverable_by_email"`
+	DiscoverableByPhone bool `json:"discoverable_by_phone"`
+	DMsFromContactsOnly bool `json:"dms_from_contacts_only"`
+	// Each visibility is "everyone", "contacts" or "nobody"
+	LastSeen     string `json:"last_seen"`
+	Online       string `json:"online"`
+	ProfilePhoto string `json:"profile_photo"`
+	ReadReceipts string `json:"read_receipts"`
+}
+
+type UpdatePrivacySettingsRequest struct {
+	DiscoverableByEmail *bool   `json:"discoverable_by_email,omitempty"`
+	DiscoverableByPhone *bool   `json:"discoverable_by_phone,omitempty"`
+	DMsFromContactsOnly *bool   `json:"dms_from_contacts_only,omitempty"`
+	LastSeen            *string `json:"last_seen,omitempty" binding:"omitempty,oneof=everyone contacts nobody"`
+	Online              *string `json:"online,omitempty" binding:"omitempty,oneof=everyone contacts nobody"`
+	ProfilePhoto        *string `json:"profile_photo,omitempty" binding:"omitempty,oneof=everyone contacts nobody"`
+	ReadReceipts        *string `json:"read_receipts,omitempty" binding:"omitempty,oneof=everyone contacts nobody"`
+}
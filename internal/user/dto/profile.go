@@ -0,0 +1,24 @@
+package dto
+
+import "time"
+
+// ProfileInput changes the profile fields that are not columns of their
+// own. Fields left out are kept and an empty string clears one.
+type ProfileInput struct {
+	Bio *string `json:"bio,omitempty" binding:"omitempty,max=500"`
+	// Timezone is an IANA name such as Europe/Berlin
+	Timezone *string `json:"timezone,omitempty" binding:"omitempty,timezone"`
+	// StatusText replaces the status together with StatusExpiresAt; leaving
+	// StatusExpiresAt out keeps the new status until it is changed
+	StatusText      *string    `json:"status_text,omitempty" binding:"omitempty,max=140"`
+	StatusExpiresAt *time.Time `json:"status_expires_at,omitempty"`
+}
+
+type ProfileResponse struct {
+	Bio                string     `json:"bio,omitempty"`
+	Timezone           string     `json:"timezone,omitempty"`
+	StatusText         string     `json:"status_text,omitempty"`
+	StatusExpiresAt    *time.Time `json:"status_expires_at,omitempty"`
+	AvatarURL          string     `json:"avatar_url,omitempty"`
+	AvatarThumbnailURL string     `json:"avatar_thumbnail_url,omitempty"`
+}
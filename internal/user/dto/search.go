@@ -0,0 +1,23 @@
+package dto
+
+import "github.com/google/uuid"
+
+type SearchUsersQuery struct {
+	Query  string `form:"q" binding:"required"`
+	Limit  int    `form:"limit,default=20"`
+	Offset int    `form:"offset"`
+}
+
+type UserBasic struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name,omitempty"`
+	// AvatarURL is the thumbnail, left out if the user hides their photo
+	AvatarURL string `json:"avatar_url,omitempty"`
+}
+
+type SearchUsersResponse struct {
+	Users []UserBasic `json:"users"`
+	// NextOffset is set when there are more results
+	NextOffset *int `json:"next_offset,omitempty"`
+}
@@ -0,0 +1,40 @@
+package dto
+
+import "time"
+
+// ClientInfo describes where a login or refresh came from.
+type ClientInfo struct {
+	Device    string
+	IPAddress string
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// TokenResponse is returned by login and refresh. The refresh token can be
+// used once; each refresh returns a new one.
+type TokenResponse struct {
+	Token                 string    `json:"token"`
+	TokenType             string    `json:"token_type"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// SessionResponse describes a logged-in device. The id is the session's
+// token family, which stays the same across refreshes.
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	Device     string    `json:"device"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current marks the session the request was made with
+	Current bool `json:"current"`
+}
@@ -2,7 +2,10 @@ package dto
 
 type UpdateUserRequest struct {
     Username *string          `json:"username,omitempty"`
-    Email    *string          `json:"email,omitempty"`
+    DisplayName *string       `json:"display_name,omitempty" binding:"omitempty,max=100"`
+    // Phone is an international number starting with +; empty removes it
+    Phone    *string          `json:"phone,omitempty"`
+    Profile  *ProfileInput    `json:"profile,omitempty"`
 }
 
 type UpdatePasswordRequest struct {
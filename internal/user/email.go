@@ -0,0 +1,349 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	htmltemplate "html/template"
+	"log"
+	"net/url"
+	"os"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/mailer"
+)
+
+var (
+	errSameEmail  = errors.New("the account already has this email")
+	errEmailTaken = errors.New("email is already in use")
+)
+
+const (
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = time.Hour
+	emailChangeTTL       = 24 * time.Hour
+	// emailSendTimeout bounds how long a request waits for the mail server
+	emailSendTimeout = 30 * time.Second
+)
+
+type emailTemplate struct {
+	subject string
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+type emailData struct {
+	Username  string
+	Link      string
+	ExpiresIn string
+}
+
+var verifyEmailTemplate = emailTemplate{
+	subject: "Confirm your email address",
+	text: texttemplate.Must(texttemplate.New("verify").Parse(`Hi {{.Username}},
+
+Please confirm your email address by opening this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}}. If you did not sign up for mozho, you can ignore this email.
+`)),
+	html: htmltemplate.Must(htmltemplate.New("verify").Parse(`<p>Hi {{.Username}},</p>
+<p>Please confirm your email address:</p>
+<p><a href="{{.Link}}">Confirm email address</a></p>
+<p>The link expires in {{.ExpiresIn}}. If you did not sign up for mozho, you can ignore this email.</p>
+`)),
+}
+
+var resetPasswordTemplate = emailTemplate{
+	subject: "Reset your password",
+	text: texttemplate.Must(texttemplate.New("reset").Parse(`Hi {{.Username}},
+
+Someone asked to reset the password of your mozho account. To choose a new password, open this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}} and works once. Resetting your password signs you out on every device. If you did not ask for this, you can ignore this email.
+`)),
+	html: htmltemplate.Must(htmltemplate.New("reset").Parse(`<p>Hi {{.Username}},</p>
+<p>Someone asked to reset the password of your mozho account.</p>
+<p><a href="{{.Link}}">Choose a new password</a></p>
+<p>The link expires in {{.ExpiresIn}} and works once. Resetting your password signs you out on every device. If you did not ask for this, you can ignore this email.</p>
+`)),
+}
+
+var claimAccountTemplate = emailTemplate{
+	subject: "Set up your mozho account",
+	text: texttemplate.Must(texttemplate.New("claim").Parse(`Hi {{.Username}},
+
+Someone signed up for mozho with this email address. Conversations you were part of have already been imported into an account for it. To take it over, choose a password here:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}} and works once. If you did not sign up for mozho, you can ignore this email.
+`)),
+	html: htmltemplate.Must(htmltemplate.New("claim").Parse(`<p>Hi {{.Username}},</p>
+<p>Someone signed up for mozho with this email address. Conversations you were part of have already been imported into an account for it.</p>
+<p><a href="{{.Link}}">Choose a password</a></p>
+<p>The link expires in {{.ExpiresIn}} and works once. If you did not sign up for mozho, you can ignore this email.</p>
+`)),
+}
+
+var changeEmailTemplate = emailTemplate{
+	subject: "Confirm your new email address",
+	text: texttemplate.Must(texttemplate.New("change").Parse(`Hi {{.Username}},
+
+You asked to move your mozho account to this email address. To confirm, open this link:
+
+{{.Link}}
+
+The link expires in {{.ExpiresIn}} and works once. Until then your account keeps its old address. If you did not ask for this, you can ignore this email.
+`)),
+	html: htmltemplate.Must(htmltemplate.New("change").Parse(`<p>Hi {{.Username}},</p>
+<p>You asked to move your mozho account to this email address.</p>
+<p><a href="{{.Link}}">Confirm new email address</a></p>
+<p>The link expires in {{.ExpiresIn}} and works once. Until then your account keeps its old address. If you did not ask for this, you can ignore this email.</p>
+`)),
+}
+
+func (t emailTemplate) render(to string, data emailData) (mailer.Message, error) {
+	var text, html bytes.Buffer
+	if err := t.text.Execute(&text, data); err != nil {
+		return mailer.Message{}, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return mailer.Message{}, err
+	}
+	return mailer.Message{To: to, Subject: t.subject, Text: text.String(), HTML: html.String()}, nil
+}
+
+// appLink builds a link to the web app (APP_URL) carrying a token.
+func appLink(path, token string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return base + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendUserToken stores a single-use token for the user's current email and
+// mails them a link to use it. Earlier links for the same purpose stop
+// working.
+func (s *userService) sendUserToken(user *models.User, purpose string, ttl time.Duration, tmpl emailTemplate, path string) error {
+	return s.sendUserTokenTo(user, user.Email, purpose, ttl, tmpl, path)
+}
+
+// sendUserTokenTo is sendUserToken for an address the account does not have
+// yet.
+func (s *userService) sendUserTokenTo(user *models.User, email, purpose string, ttl time.Duration, tmpl emailTemplate, path string) error {
+	now := s.now()
+	if err := s.tokenRepo.InvalidateForUser(user.ID.String(), purpose, now); err != nil {
+		return err
+	}
+	token := &models.UserToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		Email:     email,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := s.tokenRepo.Create(token); err != nil {
+		return err
+	}
+
+	msg, err := tmpl.render(email, emailData{
+		Username:  user.Username,
+		Link:      appLink(path, auth.SignUserToken(token.ID, purpose, token.ExpiresAt)),
+		ExpiresIn: ttl.String(),
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+	defer cancel()
+	return s.mailer.Send(ctx, msg)
+}
+
+func (s *userService) sendVerificationEmail(user *models.User) error {
+	return s.sendUserToken(user, models.UserTokenPurposeVerifyEmail, emailVerificationTTL, verifyEmailTemplate, "/verify-email")
+}
+
+// consumeUserToken checks a token from an email link and marks it used. The
+// token is refused if the user's email changed since it was sent.
+func (s *userService) consumeUserToken(tokenStr, purpose string) (*models.User, error) {
+	user, token, err := s.consumeToken(tokenStr, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if user.Email != token.Email {
+		return nil, auth.ErrInvalidUserToken
+	}
+	return user, nil
+}
+
+// consumeToken checks a token from an email link, marks it used and loads
+// the user it was sent to.
+func (s *userService) consumeToken(tokenStr, purpose string) (*models.User, *models.UserToken, error) {
+	now := s.now()
+	id, err := auth.VerifyUserToken(tokenStr, purpose, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	token, err := s.tokenRepo.Consume(context.TODO(), id.String(), purpose, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token == nil {
+		return nil, nil, auth.ErrInvalidUserToken
+	}
+	user, err := s.repo.FindByID(token.UserID.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, token, nil
+}
+
+// SendVerificationEmail sends the user a new verification link.
+func (s *userService) SendVerificationEmail(userID string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt != nil {
+		return errors.New("email is already verified")
+	}
+	return s.sendVerificationEmail(user)
+}
+
+func (s *userService) VerifyEmail(input dto.VerifyEmailRequest) error {
+	user, err := s.consumeUserToken(input.Token, models.UserTokenPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	// Imported accounts are only claimed through ResetPassword, which sets
+	// the password of whoever read the email
+	if user.Placeholder {
+		return auth.ErrInvalidUserToken
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil
+	}
+	now := s.now()
+	user.EmailVerifiedAt = &now
+	return s.repo.Update(user)
+}
+
+// ChangeEmail mails a confirmation link to the new address. The account
+// keeps its current email until ConfirmEmailChange is called with the link,
+// so a stolen access token cannot move it to an address the attacker reads.
+// The user confirms the request like an account deletion.
+func (s *userService) ChangeEmail(userID, sessionID string, input dto.ChangeEmailRequest) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := s.confirmIdentity(user, sessionID, input.Password, input.Code, input.RecoveryCode); err != nil {
+		return err
+	}
+	if input.Email == user.Email {
+		return errSameEmail
+	}
+	if _, err := s.repo.FindByEmail(input.Email); err == nil {
+		return errEmailTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return s.sendUserTokenTo(user, input.Email, models.UserTokenPurposeChangeEmail, emailChangeTTL, changeEmailTemplate, "/confirm-email")
+}
+
+// ConfirmEmailChange moves the account to the address the link was mailed
+// to. Following the link proves the user reads it, so it counts as
+// verified. Links sent to the old address stop working.
+func (s *userService) ConfirmEmailChange(input dto.VerifyEmailRequest) error {
+	user, token, err := s.consumeToken(input.Token, models.UserTokenPurposeChangeEmail)
+	if err != nil {
+		return err
+	}
+	if user.Placeholder {
+		return auth.ErrInvalidUserToken
+	}
+	now := s.now()
+	user.Email = token.Email
+	user.EmailVerifiedAt = &now
+	return s.repo.Update(user)
+}
+
+// ForgotPassword emails a reset link if the address belongs to an account,
+// including an imported one nobody has claimed yet. The lookup and email
+// happen in the background so the response does not reveal whether the
+// account exists.
+func (s *userService) ForgotPassword(input dto.ForgotPasswordRequest) {
+	go func() {
+		user, err := s.repo.FindByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.Printf("password reset: failed to look up user: %v", err)
+			}
+			return
+		}
+		if models.IsPlaceholderEmail(user.Email) {
+			return
+		}
+		if err := s.sendUserToken(user, models.UserTokenPurposeResetPassword, passwordResetTTL, resetPasswordTemplate, "/reset-password"); err != nil {
+			log.Printf("password reset: failed to send email to user %s: %v", user.ID, err)
+		}
+	}()
+}
+
+// ResetPassword sets a new password from a reset link and signs the user
+// out everywhere. Following the link also proves they own the email, which
+// claims the account if it was imported.
+func (s *userService) ResetPassword(input dto.ResetPasswordRequest) error {
+	user, err := s.consumeUserToken(input.Token, models.UserTokenPurposeResetPassword)
+	if err != nil {
+		return err
+	}
+	hashed, err := auth.HashPassword(input.NewPassword)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hashed
+	if user.EmailVerifiedAt == nil {
+		now := s.now()
+		user.EmailVerifiedAt = &now
+	}
+	user.Placeholder = false
+	if err := s.repo.Update(user); err != nil {
+		return err
+	}
+	// The owner proved they can read the account's email, so earlier
+	// failed logins no longer count against them
+	if err := s.clearLoginFailures(user.Email); err != nil {
+		return err
+	}
+	_, err = s.RevokeAllSessions(user.ID.String())
+	return err
+}
+
+// PurgeExpiredTokens removes expired email and login tokens, and passkey
+// ceremonies and single sign-ons that were never finished.
+func (s *userService) PurgeExpiredTokens(ctx context.Context) (int, error) {
+	now := s.now()
+	n, err := s.tokenRepo.DeleteExpired(ctx, now)
+	if err != nil {
+		return int(n), err
+	}
+	challenges, err := s.passkeyRepo.DeleteExpiredChallenges(ctx, now)
+	if err != nil {
+		return int(n + challenges), err
+	}
+	logins, err := s.identityRepo.DeleteExpiredLoginStates(ctx, now)
+	return int(n + challenges + logins), err
+}
@@ -1,10 +1,16 @@
 package user
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"math"
 	"mozho_chat/internal/user/dto"
 	"mozho_chat/pkg/middleware"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 type Handler struct {
@@ -20,9 +26,65 @@ func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
 	{
 		users.POST("/register", h.Register)
 		users.POST("/login", h.Login)
+		users.GET("/search", middleware.AuthMiddleware(), h.SearchUsers)
 		users.GET("/me", middleware.AuthMiddleware(), h.Profile)
 		users.PATCH("/me", middleware.AuthMiddleware(), h.UpdateProfile)
+		users.DELETE("/me", middleware.AuthMiddleware(), h.DeleteAccount)
+		users.DELETE("/me/deletion", middleware.AuthMiddleware(), h.CancelAccountDeletion)
+		users.PUT("/me/avatar", middleware.AuthMiddleware(), h.UpdateAvatar)
+		users.DELETE("/me/avatar", middleware.AuthMiddleware(), h.DeleteAvatar)
+		users.GET("/me/privacy", middleware.AuthMiddleware(), h.PrivacySettings)
+		users.PATCH("/me/privacy", middleware.AuthMiddleware(), h.UpdatePrivacySettings)
 		users.PATCH("/password", middleware.AuthMiddleware(), h.UpdatePassword)
+		users.GET("/me/sessions", middleware.AuthMiddleware(), h.ListSessions)
+		users.DELETE("/me/sessions", middleware.AuthMiddleware(), h.RevokeAllSessions)
+		users.DELETE("/me/sessions/:id", middleware.AuthMiddleware(), h.RevokeSession)
+		users.POST("/me/verification-email", middleware.AuthMiddleware(), h.SendVerificationEmail)
+		users.POST("/me/email", middleware.AuthMiddleware(), h.ChangeEmail)
+		users.GET("/me/mfa", middleware.AuthMiddleware(), h.MFAStatus)
+		users.POST("/me/mfa/totp", middleware.AuthMiddleware(), h.EnrollTOTP)
+		users.POST("/me/mfa/totp/confirm", middleware.AuthMiddleware(), h.ConfirmTOTP)
+		users.DELETE("/me/mfa/totp", middleware.AuthMiddleware(), h.DisableTOTP)
+		users.POST("/me/mfa/recovery-codes", middleware.AuthMiddleware(), h.RegenerateRecoveryCodes)
+		users.GET("/me/passkeys", middleware.AuthMiddleware(), h.ListPasskeys)
+		users.POST("/me/passkeys/options", middleware.AuthMiddleware(), h.BeginPasskeyRegistration)
+		users.POST("/me/passkeys", middleware.AuthMiddleware(), h.FinishPasskeyRegistration)
+		users.PATCH("/me/passkeys/:id", middleware.AuthMiddleware(), h.RenamePasskey)
+		users.DELETE("/me/passkeys/:id", middleware.AuthMiddleware(), h.DeletePasskey)
+		users.GET("/me/identities", middleware.AuthMiddleware(), h.ListIdentities)
+	}
+
+	admin := rg.Group("/admin/users", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(middleware.MaxJSONBodySize))
+	{
+		admin.POST("/:id/unlock", h.UnlockUser)
+	}
+
+	authGroup := rg.Group("/auth")
+	{
+		authGroup.POST("/refresh", h.Refresh)
+		authGroup.POST("/logout", h.Logout)
+		authGroup.POST("/mfa/verify", h.VerifyMFA)
+		authGroup.POST("/passkeys/options", h.BeginPasskeyLogin)
+		authGroup.POST("/passkeys/verify", h.FinishPasskeyLogin)
+		authGroup.GET("/oidc/providers", h.ListOIDCProviders)
+		authGroup.POST("/oidc/:provider/authorize", h.StartOIDCLogin)
+		authGroup.POST("/oidc/callback", h.FinishOIDCLogin)
+		authGroup.POST("/verify-email", h.VerifyEmail)
+		authGroup.POST("/confirm-email", h.ConfirmEmailChange)
+		authGroup.POST("/forgot-password", h.ForgotPassword)
+		authGroup.POST("/reset-password", h.ResetPassword)
+	}
+}
+
+// setRetryAfter tells the client how many seconds to wait, rounded up.
+func setRetryAfter(c *gin.Context, d time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(d.Seconds()))))
+}
+
+func clientInfo(c *gin.Context) dto.ClientInfo {
+	return dto.ClientInfo{
+		Device:    c.GetHeader("User-Agent"),
+		IPAddress: c.ClientIP(),
 	}
 }
 
@@ -46,12 +108,48 @@ func (h *Handler) Login(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	token, err := h.service.Login(req)
+	tokens, err := h.service.Login(req, clientInfo(c))
+	var throttled *loginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		setRetryAfter(c, throttled.retryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errInvalidCredentials):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) Refresh(c *gin.Context) {
+	var req dto.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := h.service.Refresh(c.Request.Context(), req, clientInfo(c))
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) Logout(c *gin.Context) {
+	var req dto.LogoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.Logout(req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
 func (h *Handler) Profile(c *gin.Context) {
@@ -79,14 +177,102 @@ func (h *Handler) UpdateProfile(c *gin.Context) {
 	}
 
 	updated, err := h.service.UpdateProfile(userID, req)
+	switch {
+	case errors.Is(err, errInvalidPhone), errors.Is(err, errStatusExpiry):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": "username or phone is already in use"})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// UpdateAvatar takes the image as the "avatar" field of a multipart form.
+func (h *Handler) UpdateAvatar(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	// Leave room for the rest of the multipart body
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarSize+1<<20)
+	header, err := c.FormFile("avatar")
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "avatar is required"})
+		return
+	}
+	if header.Size > maxAvatarSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "avatar must be at most 5 MB"})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.service.UpdateAvatar(userID, data)
+	switch {
+	case errors.Is(err, errInvalidAvatar):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	c.JSON(http.StatusOK, updated)
+}
 
+func (h *Handler) DeleteAvatar(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	updated, err := h.service.DeleteAvatar(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, updated)
 }
 
+// DeleteAccount schedules the account for deletion after a grace period.
+func (h *Handler) DeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := h.service.RequestAccountDeletion(userID, c.GetString("session_id"), req, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, res)
+}
+
+func (h *Handler) CancelAccountDeletion(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	err := h.service.CancelAccountDeletion(userID, clientInfo(c))
+	switch {
+	case errors.Is(err, errDeletionNotScheduled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
 func (h *Handler) UpdatePassword(c *gin.Context) {
 	userID := c.GetString("user_id")
 
@@ -103,3 +289,413 @@ func (h *Handler) UpdatePassword(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "password updated successfully"})
 }
+
+func (h *Handler) ListSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	sessions, err := h.service.ListSessions(userID, c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *Handler) RevokeSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.RevokeSession(userID, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) RevokeAllSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	revoked, err := h.service.RevokeAllSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "revoked": revoked})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+func (h *Handler) SendVerificationEmail(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.SendVerificationEmail(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	var req dto.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.VerifyEmail(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+}
+
+// ChangeEmail mails a confirmation link to the new address; the email only
+// changes once the link is used.
+func (h *Handler) ChangeEmail(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := h.service.ChangeEmail(userID, c.GetString("session_id"), req)
+	switch {
+	case errors.Is(err, errSameEmail):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, errEmailTaken):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"message": "a confirmation link has been sent to the new email"})
+	}
+}
+
+func (h *Handler) ConfirmEmailChange(c *gin.Context) {
+	var req dto.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err := h.service.ConfirmEmailChange(req)
+	switch {
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		c.JSON(http.StatusConflict, gin.H{"error": errEmailTaken.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, gin.H{"message": "email changed"})
+	}
+}
+
+// ForgotPassword always answers 202 so it cannot be used to find out
+// which emails have accounts.
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req dto.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	h.service.ForgotPassword(req)
+	c.JSON(http.StatusAccepted, gin.H{"message": "if the email belongs to an account, a reset link has been sent"})
+}
+
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req dto.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.ResetPassword(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "password reset; please log in again"})
+}
+
+func (h *Handler) VerifyMFA(c *gin.Context) {
+	var req dto.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := h.service.VerifyMFA(req, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) MFAStatus(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	status, err := h.service.GetMFAStatus(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (h *Handler) EnrollTOTP(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	enrollment, err := h.service.EnrollTOTP(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+func (h *Handler) ConfirmTOTP(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codes, err := h.service.ConfirmTOTP(userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.TOTPCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	codes, err := h.service.RegenerateRecoveryCodes(userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, codes)
+}
+
+func (h *Handler) DisableTOTP(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.DisableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.DisableTOTP(userID, c.GetString("session_id"), req); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) BeginPasskeyLogin(c *gin.Context) {
+	var req dto.PasskeyLoginOptionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	options, err := h.service.BeginPasskeyLogin(req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *Handler) FinishPasskeyLogin(c *gin.Context) {
+	var req dto.PasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	tokens, err := h.service.FinishPasskeyLogin(req, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (h *Handler) ListPasskeys(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	passkeys, err := h.service.ListPasskeys(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, passkeys)
+}
+
+func (h *Handler) BeginPasskeyRegistration(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.PasskeyRegistrationOptionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	options, err := h.service.BeginPasskeyRegistration(userID, c.GetString("session_id"), req)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, options)
+}
+
+func (h *Handler) FinishPasskeyRegistration(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.RegisterPasskeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	passkey, err := h.service.FinishPasskeyRegistration(userID, req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, passkey)
+}
+
+func (h *Handler) RenamePasskey(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.RenamePasskeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.RenamePasskey(userID, c.Param("id"), req); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) DeletePasskey(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	if err := h.service.DeletePasskey(userID, c.Param("id")); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListOIDCProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.ListOIDCProviders())
+}
+
+func (h *Handler) StartOIDCLogin(c *gin.Context) {
+	res, err := h.service.StartOIDCLogin(c.Param("provider"))
+	if err != nil {
+		if errors.Is(err, errOIDCProviderNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) FinishOIDCLogin(c *gin.Context) {
+	var req dto.OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	res, err := h.service.FinishOIDCLogin(req, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+func (h *Handler) ListIdentities(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	identities, err := h.service.ListIdentities(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, identities)
+}
+
+func (h *Handler) UnlockUser(c *gin.Context) {
+	adminID := c.GetString("user_id")
+
+	err := h.service.UnlockUser(adminID, c.Param("id"))
+	switch {
+	case errors.Is(err, errAdminRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, errUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) SearchUsers(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var query dto.SearchUsersQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.service.SearchUsers(userID, query)
+	var limited *rateLimitedError
+	switch {
+	case errors.As(err, &limited):
+		setRetryAfter(c, limited.retryAfter)
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+func (h *Handler) PrivacySettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	settings, err := h.service.GetPrivacySettings(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+func (h *Handler) UpdatePrivacySettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	var req dto.UpdatePrivacySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.service.UpdatePrivacySettings(userID, req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,223 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/pkg/auth"
+)
+
+// RateLimiter keeps the counters and locks that slow down password
+// guessing and people search. They have to be shared by every instance of
+// the server, or an attacker could spread guesses across them.
+type RateLimiter interface {
+	HitSlidingWindow(key string, now time.Time, window time.Duration) (int64, error)
+	SlidingWindow(key string, now time.Time, window time.Duration) (int64, time.Time, error)
+	ResetSlidingWindow(key string) error
+	Lock(key string, ttl time.Duration) error
+	LockedFor(key string) (time.Duration, error)
+	Unlock(key string) error
+}
+
+const (
+	// loginFailureWindow is how long a failed login counts against an
+	// email or IP
+	loginFailureWindow = 15 * time.Minute
+	// After loginDelayAfter failures each attempt has to wait twice as
+	// long as the one before, up to maxLoginDelay
+	loginDelayAfter = 3
+	maxLoginDelay   = time.Minute
+	// accountLockThreshold failures lock the email, ipLockThreshold lock
+	// the IP, both for loginLockout
+	accountLockThreshold = 10
+	ipLockThreshold      = 50
+	loginLockout         = 15 * time.Minute
+)
+
+var (
+	errInvalidCredentials = errors.New("invalid credentials")
+	errAdminRequired      = errors.New("admin access required")
+	errUserNotFound       = errors.New("user not found")
+)
+
+// loginThrottledError refuses a login attempt without checking the
+// password.
+type loginThrottledError struct {
+	retryAfter time.Duration
+}
+
+func (e *loginThrottledError) Error() string {
+	return "too many failed login attempts; try again later"
+}
+
+func accountLoginKey(email string) string {
+	return "login:account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLoginKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// checkDummyPassword takes as long as checking a real password, so
+// response times do not tell which emails have accounts.
+func checkDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		hash, err := auth.HashPassword("not a real password")
+		if err != nil {
+			log.Printf("failed to hash dummy password: %v", err)
+		}
+		dummyHash = hash
+	})
+	_ = auth.CheckPasswordHash(password, dummyHash)
+}
+
+// loginDelay is how long to wait after the latest of failures failed
+// logins before trying again.
+func loginDelay(failures int64) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	delay := maxLoginDelay
+	if shift := failures - loginDelayAfter; shift < 6 {
+		delay = min(time.Second<<shift, maxLoginDelay)
+	}
+	return delay
+}
+
+// checkLoginAllowed refuses attempts while the email or IP is locked, or
+// before the delay after recent failures has passed. The answer is the
+// same whether or not the email has an account.
+func (s *userService) checkLoginAllowed(email, ip string, now time.Time) error {
+	keys := []string{accountLoginKey(email)}
+	if ip != "" {
+		keys = append(keys, ipLoginKey(ip))
+	}
+	for _, key := range keys {
+		locked, err := s.limiter.LockedFor(key)
+		if err != nil {
+			return err
+		}
+		if locked > 0 {
+			return &loginThrottledError{retryAfter: locked}
+		}
+	}
+
+	failures, last, err := s.limiter.SlidingWindow(accountLoginKey(email), now, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+	if until := last.Add(loginDelay(failures)); now.Before(until) {
+		return &loginThrottledError{retryAfter: until.Sub(now)}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login against the email and the IP
+// and locks whichever passed its threshold. user is nil when the email has
+// no account.
+func (s *userService) recordLoginFailure(email, ip string, user *models.User, now time.Time) error {
+	var userID *uuid.UUID
+	if user != nil {
+		userID = &user.ID
+	}
+
+	key := accountLoginKey(email)
+	failures, err := s.limiter.HitSlidingWindow(key, now, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+	if failures >= accountLockThreshold {
+		details := map[string]any{"email": email, "failures": failures}
+		if err := s.lockLogin(key, models.AuditEventAccountLocked, userID, ip, details, now); err != nil {
+			return err
+		}
+	}
+
+	if ip == "" {
+		return nil
+	}
+	key = ipLoginKey(ip)
+	failures, err = s.limiter.HitSlidingWindow(key, now, loginFailureWindow)
+	if err != nil {
+		return err
+	}
+	if failures >= ipLockThreshold {
+		details := map[string]any{"failures": failures}
+		return s.lockLogin(key, models.AuditEventIPLocked, nil, ip, details, now)
+	}
+	return nil
+}
+
+// lockLogin locks key and records why. The failures are forgotten, so the
+// count starts again when the lock ends.
+func (s *userService) lockLogin(key, event string, userID *uuid.UUID, ip string, details map[string]any, now time.Time) error {
+	if err := s.limiter.Lock(key, loginLockout); err != nil {
+		return err
+	}
+	if err := s.limiter.ResetSlidingWindow(key); err != nil {
+		return err
+	}
+	details["locked_until"] = now.Add(loginLockout)
+	return s.audit(event, userID, nil, ip, details)
+}
+
+func (s *userService) audit(event string, userID, actorID *uuid.UUID, ip string, details map[string]any) error {
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	return s.auditRepo.Create(&models.AuditEvent{
+		Event:     event,
+		UserID:    userID,
+		ActorID:   actorID,
+		IPAddress: ip,
+		Details:   raw,
+	})
+}
+
+// clearLoginFailures forgets failed logins and any lock on the email.
+func (s *userService) clearLoginFailures(email string) error {
+	key := accountLoginKey(email)
+	if err := s.limiter.Unlock(key); err != nil {
+		return err
+	}
+	return s.limiter.ResetSlidingWindow(key)
+}
+
+// UnlockUser lets an admin end an account's lockout early.
+func (s *userService) UnlockUser(adminID, userID string) error {
+	admin, err := s.repo.FindByID(adminID)
+	if err != nil || admin.Role != models.UserRoleAdmin {
+		return errAdminRequired
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return errUserNotFound
+	}
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return errUserNotFound
+	}
+	locked, err := s.limiter.LockedFor(accountLoginKey(user.Email))
+	if err != nil {
+		return err
+	}
+	if err := s.clearLoginFailures(user.Email); err != nil {
+		return err
+	}
+	return s.audit(models.AuditEventAccountUnlocked, &user.ID, &admin.ID, "", map[string]any{
+		"email":      user.Email,
+		"was_locked": locked > 0,
+	})
+}
@@ -0,0 +1,262 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+const (
+	totpIssuer        = "mozho"
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+	// maxMFAAttempts wrong codes end a login challenge
+	maxMFAAttempts = 5
+)
+
+var (
+	errInvalidMFACode = errors.New("invalid two-factor code")
+	errMFANotEnabled  = errors.New("two-factor authentication is not enabled")
+)
+
+// totpKey is the AES key TOTP secrets are encrypted with.
+func totpKey() string {
+	return auth.EncryptionKey("totp")
+}
+
+// mfaChallenge stands in for tokens when the password was right but a
+// second factor is still needed.
+func (s *userService) mfaChallenge(user *models.User) (*dto.LoginResponse, error) {
+	challenge := &models.UserToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Purpose:   models.UserTokenPurposeMFAChallenge,
+		Email:     user.Email,
+		ExpiresAt: s.now().Add(mfaChallengeTTL),
+	}
+	if err := s.tokenRepo.Create(challenge); err != nil {
+		return nil, err
+	}
+	return &dto.LoginResponse{
+		MFARequired:  true,
+		MFAToken:     auth.SignUserToken(challenge.ID, challenge.Purpose, challenge.ExpiresAt),
+		MFAExpiresAt: &challenge.ExpiresAt,
+	}, nil
+}
+
+// VerifyMFA finishes a login that needed a second factor. A challenge
+// survives a few wrong codes, then the user has to log in again.
+func (s *userService) VerifyMFA(input dto.MFAVerifyRequest, client dto.ClientInfo) (*dto.TokenResponse, error) {
+	now := s.now()
+	purpose := models.UserTokenPurposeMFAChallenge
+	id, err := auth.VerifyUserToken(input.MFAToken, purpose, now)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.tokenRepo.Find(id.String(), purpose, now)
+	if err != nil {
+		return nil, err
+	}
+	if challenge == nil {
+		return nil, auth.ErrInvalidUserToken
+	}
+
+	if err := s.checkSecondFactor(challenge.UserID.String(), input.Code, input.RecoveryCode); err != nil {
+		if !errors.Is(err, errInvalidMFACode) {
+			return nil, err
+		}
+		attempts, attemptErr := s.tokenRepo.RecordFailedAttempt(id.String())
+		if attemptErr != nil {
+			return nil, attemptErr
+		}
+		if attempts >= maxMFAAttempts {
+			if err := s.tokenRepo.InvalidateForUser(challenge.UserID.String(), purpose, now); err != nil {
+				return nil, err
+			}
+			return nil, errors.New("too many wrong codes; please log in again")
+		}
+		return nil, err
+	}
+
+	// Spend the challenge so it cannot start a second session
+	consumed, err := s.tokenRepo.Consume(context.TODO(), id.String(), purpose, now)
+	if err != nil {
+		return nil, err
+	}
+	if consumed == nil {
+		return nil, auth.ErrInvalidUserToken
+	}
+
+	if input.DeviceName != "" {
+		client.Device = input.DeviceName
+	}
+	return s.startSession(challenge.UserID, client)
+}
+
+// checkSecondFactor accepts a current authenticator code or an unused
+// recovery code. Either works only once.
+func (s *userService) checkSecondFactor(userID, code, recoveryCode string) error {
+	totp, err := s.mfaRepo.FindTOTP(userID)
+	if err != nil {
+		return err
+	}
+	if totp == nil || totp.ConfirmedAt == nil {
+		return errMFANotEnabled
+	}
+
+	switch {
+	case code != "":
+		secret, err := s.encryption.DecryptWithAES(totp.Secret, totpKey())
+		if err != nil {
+			return err
+		}
+		step, ok := auth.ValidateTOTP(secret, code, s.now())
+		if !ok {
+			return errInvalidMFACode
+		}
+		fresh, err := s.mfaRepo.UseTOTPStep(userID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return errInvalidMFACode
+		}
+		return nil
+	case recoveryCode != "":
+		used, err := s.mfaRepo.UseRecoveryCode(userID, auth.HashRecoveryCode(recoveryCode), s.now())
+		if err != nil {
+			return err
+		}
+		if !used {
+			return errInvalidMFACode
+		}
+		return nil
+	}
+	return errors.New("code or recovery_code is required")
+}
+
+func (s *userService) GetMFAStatus(userID string) (*dto.MFAStatusResponse, error) {
+	totp, err := s.mfaRepo.FindTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := &dto.MFAStatusResponse{TOTPEnabled: totp != nil && totp.ConfirmedAt != nil}
+	if res.TOTPEnabled {
+		if res.RecoveryCodesRemaining, err = s.mfaRepo.CountRecoveryCodes(userID); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// EnrollTOTP creates a secret for the user's authenticator app. Two-factor
+// login only starts once a code from the app is confirmed.
+func (s *userService) EnrollTOTP(userID string) (*dto.TOTPEnrollResponse, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.mfaRepo.FindTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && existing.ConfirmedAt != nil {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.encryption.EncryptWithAES(secret, totpKey())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfaRepo.SaveTOTP(&models.UserTOTP{
+		UserID:    user.ID,
+		Secret:    encrypted,
+		CreatedAt: s.now(),
+	}); err != nil {
+		return nil, err
+	}
+	return &dto.TOTPEnrollResponse{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(totpIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTP turns two-factor login on once the user shows a code from
+// their app, and returns their recovery codes.
+func (s *userService) ConfirmTOTP(userID string, input dto.TOTPCodeRequest) (*dto.RecoveryCodesResponse, error) {
+	totp, err := s.mfaRepo.FindTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	if totp == nil {
+		return nil, errors.New("start two-factor enrollment first")
+	}
+	if totp.ConfirmedAt != nil {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	secret, err := s.encryption.DecryptWithAES(totp.Secret, totpKey())
+	if err != nil {
+		return nil, err
+	}
+	step, ok := auth.ValidateTOTP(secret, input.Code, s.now())
+	if !ok {
+		return nil, errInvalidMFACode
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfaRepo.ConfirmTOTP(userID, step, hashes, s.now()); err != nil {
+		return nil, err
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, used or not.
+func (s *userService) RegenerateRecoveryCodes(userID string, input dto.TOTPCodeRequest) (*dto.RecoveryCodesResponse, error) {
+	if err := s.checkSecondFactor(userID, input.Code, ""); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mfaRepo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+		return nil, err
+	}
+	return &dto.RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// DisableTOTP turns two-factor login off. A stolen access token is not
+// enough: the user has to give their password and a second factor again.
+// Users without a password give the second factor from a session that
+// signed in within recentSignInWindow, so a code read off a stolen phone is
+// not enough either.
+func (s *userService) DisableTOTP(userID, sessionID string, input dto.DisableTOTPRequest) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" {
+		if err := auth.CheckPasswordHash(input.Password, user.PasswordHash); err != nil {
+			return errors.New("password is incorrect")
+		}
+	} else if err := s.checkRecentSignIn(userID, sessionID); err != nil {
+		return err
+	}
+	if err := s.checkSecondFactor(userID, input.Code, input.RecoveryCode); err != nil {
+		return err
+	}
+	return s.mfaRepo.Disable(userID)
+}
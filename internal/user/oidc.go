@@ -0,0 +1,224 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+const (
+	// oidcLoginTTL is how long the user has to log in at the provider
+	oidcLoginTTL      = 10 * time.Minute
+	maxUsernameLength = 30
+)
+
+var (
+	errOIDCProviderNotFound = errors.New("identity provider not found")
+	errInvalidOIDCState     = errors.New("single sign-on is invalid or has expired; please try again")
+)
+
+func (s *userService) ListOIDCProviders() []dto.OIDCProviderResponse {
+	res := []dto.OIDCProviderResponse{}
+	for _, p := range s.oidc.List() {
+		cfg := p.Config()
+		res = append(res, dto.OIDCProviderResponse{Name: cfg.Name, DisplayName: cfg.DisplayName})
+	}
+	return res
+}
+
+// StartOIDCLogin returns where to send the browser to log in at the
+// provider.
+func (s *userService) StartOIDCLogin(providerName string) (*dto.OIDCAuthorizeResponse, error) {
+	provider := s.oidc.Get(providerName)
+	if provider == nil {
+		return nil, errOIDCProviderNotFound
+	}
+	login, err := auth.NewOIDCLogin()
+	if err != nil {
+		return nil, err
+	}
+	authURL, err := provider.AuthorizationURL(context.TODO(), s.oidc.RedirectURL, login)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &models.OIDCLoginState{
+		Provider:     providerName,
+		StateHash:    auth.HashOIDCState(login.State),
+		Nonce:        login.Nonce,
+		CodeVerifier: login.CodeVerifier,
+		ExpiresAt:    s.now().Add(oidcLoginTTL),
+	}
+	if err := s.identityRepo.CreateLoginState(state); err != nil {
+		return nil, err
+	}
+	return &dto.OIDCAuthorizeResponse{AuthorizationURL: authURL, State: login.State, ExpiresAt: state.ExpiresAt}, nil
+}
+
+// FinishOIDCLogin exchanges the code the provider sent back and logs the
+// user in. A two-factor challenge is still asked for when the account has
+// TOTP on.
+func (s *userService) FinishOIDCLogin(input dto.OIDCCallbackRequest, client dto.ClientInfo) (*dto.LoginResponse, error) {
+	state, err := s.identityRepo.ConsumeLoginState(auth.HashOIDCState(input.State), s.now())
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, errInvalidOIDCState
+	}
+	provider := s.oidc.Get(state.Provider)
+	if provider == nil {
+		return nil, errOIDCProviderNotFound
+	}
+
+	claims, err := provider.Exchange(context.TODO(), s.oidc.RedirectURL, input.Code, &auth.OIDCLogin{
+		State:        input.State,
+		Nonce:        state.Nonce,
+		CodeVerifier: state.CodeVerifier,
+	})
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.oidcUser(provider.Config(), claims)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeLogin(user, input.DeviceName, client)
+}
+
+// oidcUser finds the account a provider's user logs in to. Known
+// identities are matched by subject. Otherwise the provider's verified
+// email links an existing account or creates a new one.
+func (s *userService) oidcUser(cfg auth.OIDCProviderConfig, claims *auth.OIDCClaims) (*models.User, error) {
+	now := s.now()
+	identity, err := s.identityRepo.Find(cfg.Name, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if identity != nil {
+		if err := s.identityRepo.RecordLogin(identity.ID.String(), claims.Email, now); err != nil {
+			return nil, err
+		}
+		return s.repo.FindByID(identity.UserID.String())
+	}
+
+	if !claims.HasVerifiedEmail() {
+		return nil, fmt.Errorf("%s did not confirm your email address", cfg.DisplayName)
+	}
+	if !cfg.AllowsEmail(claims.Email) || models.IsPlaceholderEmail(claims.Email) {
+		return nil, fmt.Errorf("%s cannot be used to sign in with %s", cfg.DisplayName, claims.Email)
+	}
+
+	user, err := s.repo.FindByEmail(claims.Email)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if user, err = s.provisionOIDCUser(claims, now); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case user.Placeholder || user.EmailVerifiedAt == nil:
+		// The provider has proved the email, so an imported account can be
+		// taken over. An unverified account may have been registered by
+		// someone else in the owner's name, so its password and sessions
+		// are dropped
+		unverified := !user.Placeholder
+		user.Placeholder = false
+		user.EmailVerifiedAt = &now
+		user.PasswordHash = ""
+		if err := s.repo.Update(user); err != nil {
+			return nil, err
+		}
+		if unverified {
+			if _, err := s.RevokeAllSessions(user.ID.String()); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := s.identityRepo.Create(&models.UserIdentity{
+		UserID:      user.ID,
+		Provider:    cfg.Name,
+		Subject:     claims.Subject,
+		Email:       claims.Email,
+		LastLoginAt: &now,
+	}); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionOIDCUser creates an account for someone who has only ever
+// signed in through a provider. It has no password until they reset one.
+func (s *userService) provisionOIDCUser(claims *auth.OIDCClaims, now time.Time) (*models.User, error) {
+	base := usernameFromClaims(claims)
+	for attempt := 0; ; attempt++ {
+		username := base
+		if attempt > 0 {
+			suffix := make([]byte, 2)
+			if _, err := rand.Read(suffix); err != nil {
+				return nil, err
+			}
+			username = truncate(base, maxUsernameLength-5) + "-" + hex.EncodeToString(suffix)
+		}
+		user := &models.User{
+			Username:        username,
+			Email:           claims.Email,
+			EmailVerifiedAt: &now,
+		}
+		err := s.repo.Create(user)
+		if err == nil {
+			return user, nil
+		}
+		// The email was free a moment ago, so the username is taken
+		if !errors.Is(err, gorm.ErrDuplicatedKey) || attempt == 3 {
+			return nil, err
+		}
+	}
+}
+
+func usernameFromClaims(claims *auth.OIDCClaims) string {
+	name := claims.PreferredUsername
+	if name == "" || strings.Contains(name, "@") {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		name = "user"
+	}
+	return truncate(name, maxUsernameLength)
+}
+
+func (s *userService) ListIdentities(userID string) ([]dto.IdentityResponse, error) {
+	identities, err := s.identityRepo.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.IdentityResponse, 0, len(identities))
+	for _, identity := range identities {
+		res = append(res, dto.IdentityResponse{
+			ID:          identity.ID.String(),
+			Provider:    identity.Provider,
+			Email:       identity.Email,
+			CreatedAt:   identity.CreatedAt,
+			LastLoginAt: identity.LastLoginAt,
+		})
+	}
+	return res, nil
+}
@@ -0,0 +1,358 @@
+package user
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+const (
+	passkeyChallengeTTL = 5 * time.Minute
+	defaultPasskeyName  = "Passkey"
+)
+
+var (
+	errPasskeyNotFound         = errors.New("passkey not found")
+	errInvalidPasskey          = errors.New("passkey is not valid for this account")
+	errInvalidPasskeyChallenge = errors.New("passkey request is invalid or has expired; please try again")
+	errPasskeyDisabled         = errors.New("this passkey was disabled because it may have been copied; remove it and register it again")
+)
+
+// Passkeys always need user verification (a PIN or biometric), so a
+// passkey login counts as two factors and skips the TOTP challenge.
+const passkeyUserVerification = "required"
+
+func passkeyAlgorithms() []dto.PublicKeyCredentialParameters {
+	return []dto.PublicKeyCredentialParameters{
+		{Type: "public-key", Alg: auth.COSEAlgEdDSA},
+		{Type: "public-key", Alg: auth.COSEAlgES256},
+		{Type: "public-key", Alg: auth.COSEAlgRS256},
+	}
+}
+
+func encodeBase64URL(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func passkeyDescriptors(passkeys []models.Passkey) []dto.CredentialDescriptor {
+	descriptors := make([]dto.CredentialDescriptor, 0, len(passkeys))
+	for _, p := range passkeys {
+		d := dto.CredentialDescriptor{Type: "public-key", ID: encodeBase64URL(p.CredentialID)}
+		if p.Transports != "" {
+			d.Transports = strings.Split(p.Transports, ",")
+		}
+		descriptors = append(descriptors, d)
+	}
+	return descriptors
+}
+
+func toPasskeyResponse(p models.Passkey) dto.PasskeyResponse {
+	return dto.PasskeyResponse{
+		ID:             p.ID.String(),
+		Name:           p.Name,
+		BackedUp:       p.BackedUp,
+		CloneSuspected: p.CloneSuspectedAt != nil,
+		CreatedAt:      p.CreatedAt,
+		LastUsedAt:     p.LastUsedAt,
+	}
+}
+
+// newPasskeyChallenge opens a ceremony. userID is nil for logins where the
+// browser lets the user pick any of their passkeys.
+func (s *userService) newPasskeyChallenge(userID *uuid.UUID, purpose string) ([]byte, error) {
+	challenge, err := auth.NewWebAuthnChallenge()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.passkeyRepo.CreateChallenge(&models.WebAuthnChallenge{
+		UserID:    userID,
+		Purpose:   purpose,
+		Challenge: challenge,
+		ExpiresAt: s.now().Add(passkeyChallengeTTL),
+	}); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// consumePasskeyChallenge finds the ceremony a response answers and closes
+// it, so a response cannot be replayed.
+func (s *userService) consumePasskeyChallenge(clientDataJSON []byte, purpose string) (*models.WebAuthnChallenge, error) {
+	challenge, err := auth.WebAuthnChallengeFromClientData(clientDataJSON)
+	if err != nil {
+		return nil, errInvalidPasskeyChallenge
+	}
+	ch, err := s.passkeyRepo.ConsumeChallenge(challenge, purpose, s.now())
+	if err != nil {
+		return nil, err
+	}
+	if ch == nil {
+		return nil, errInvalidPasskeyChallenge
+	}
+	return ch, nil
+}
+
+// BeginPasskeyRegistration returns the options for adding a passkey to the
+// user's account. A passkey signs in on its own, so a stolen access token
+// must not be able to add one: the user confirms it with confirmIdentity.
+// FinishPasskeyRegistration only takes the challenge handed out here.
+func (s *userService) BeginPasskeyRegistration(userID, sessionID string, input dto.PasskeyRegistrationOptionsRequest) (*dto.PasskeyCreationOptions, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.confirmIdentity(user, sessionID, input.Password, input.Code, input.RecoveryCode); err != nil {
+		return nil, err
+	}
+	existing, err := s.passkeyRepo.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.newPasskeyChallenge(&user.ID, models.WebAuthnPurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dto.PasskeyCreationOptions{PublicKey: dto.PublicKeyCredentialCreationOptions{
+		RP: dto.RelyingParty{ID: s.webauthn.RPID, Name: s.webauthn.RPName},
+		// The user handle is the account id, so a passkey picked without an
+		// email still tells us whose it is
+		User: dto.PasskeyUser{
+			ID:          encodeBase64URL(user.ID[:]),
+			Name:        user.Email,
+			DisplayName: user.Username,
+		},
+		Challenge:          encodeBase64URL(challenge),
+		PubKeyCredParams:   passkeyAlgorithms(),
+		Timeout:            passkeyChallengeTTL.Milliseconds(),
+		ExcludeCredentials: passkeyDescriptors(existing),
+		AuthenticatorSelection: dto.AuthenticatorSelection{
+			ResidentKey:      "required",
+			UserVerification: passkeyUserVerification,
+		},
+		Attestation: "none",
+	}}, nil
+}
+
+// FinishPasskeyRegistration verifies the authenticator's response and
+// stores the new passkey.
+func (s *userService) FinishPasskeyRegistration(userID string, input dto.RegisterPasskeyRequest) (*dto.PasskeyResponse, error) {
+	clientDataJSON, err := auth.DecodeBase64URL(input.Credential.Response.ClientDataJSON)
+	if err != nil {
+		return nil, errors.New("invalid clientDataJSON")
+	}
+	attestationObject, err := auth.DecodeBase64URL(input.Credential.Response.AttestationObject)
+	if err != nil {
+		return nil, errors.New("invalid attestationObject")
+	}
+
+	ch, err := s.consumePasskeyChallenge(clientDataJSON, models.WebAuthnPurposeRegistration)
+	if err != nil {
+		return nil, err
+	}
+	if ch.UserID == nil || ch.UserID.String() != userID {
+		return nil, errInvalidPasskeyChallenge
+	}
+
+	credential, err := s.webauthn.VerifyWebAuthnRegistration(ch.Challenge, clientDataJSON, attestationObject, true)
+	if err != nil {
+		return nil, err
+	}
+	if input.Credential.ID != encodeBase64URL(credential.ID) {
+		return nil, errors.New("credential id does not match the attestation")
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = defaultPasskeyName
+	}
+	passkey := &models.Passkey{
+		UserID:         *ch.UserID,
+		CredentialID:   credential.ID,
+		PublicKey:      credential.PublicKey,
+		Algorithm:      credential.Algorithm,
+		SignCount:      int64(credential.SignCount),
+		AAGUID:         credential.AAGUID,
+		Transports:     strings.Join(input.Credential.Response.Transports, ","),
+		Name:           name,
+		BackupEligible: credential.BackupEligible,
+		BackedUp:       credential.BackedUp,
+	}
+	if err := s.passkeyRepo.Create(passkey); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, errors.New("this passkey is already registered")
+		}
+		return nil, err
+	}
+	res := toPasskeyResponse(*passkey)
+	return &res, nil
+}
+
+// BeginPasskeyLogin returns the options for logging in with a passkey.
+// Emails without an account get the same answer as no email, so the
+// endpoint does not reveal who has signed up.
+func (s *userService) BeginPasskeyLogin(input dto.PasskeyLoginOptionsRequest) (*dto.PasskeyRequestOptions, error) {
+	var userID *uuid.UUID
+	allow := []dto.CredentialDescriptor{}
+	if input.Email != "" {
+		if user, err := s.repo.FindByEmail(input.Email); err == nil && !user.Placeholder {
+			passkeys, err := s.passkeyRepo.ListByUser(user.ID.String())
+			if err != nil {
+				return nil, err
+			}
+			if len(passkeys) > 0 {
+				userID = &user.ID
+				allow = passkeyDescriptors(passkeys)
+			}
+		}
+	}
+
+	challenge, err := s.newPasskeyChallenge(userID, models.WebAuthnPurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.PasskeyRequestOptions{PublicKey: dto.PublicKeyCredentialRequestOptions{
+		Challenge:        encodeBase64URL(challenge),
+		Timeout:          passkeyChallengeTTL.Milliseconds(),
+		RPID:             s.webauthn.RPID,
+		AllowCredentials: allow,
+		UserVerification: passkeyUserVerification,
+	}}, nil
+}
+
+// FinishPasskeyLogin verifies a passkey assertion and starts a session.
+// A signature counter that goes backwards means the key was probably
+// copied, so the passkey is disabled until the user replaces it.
+func (s *userService) FinishPasskeyLogin(input dto.PasskeyLoginRequest, client dto.ClientInfo) (*dto.TokenResponse, error) {
+	res := input.Credential.Response
+	clientDataJSON, err := auth.DecodeBase64URL(res.ClientDataJSON)
+	if err != nil {
+		return nil, errors.New("invalid clientDataJSON")
+	}
+	authenticatorData, err := auth.DecodeBase64URL(res.AuthenticatorData)
+	if err != nil {
+		return nil, errors.New("invalid authenticatorData")
+	}
+	signature, err := auth.DecodeBase64URL(res.Signature)
+	if err != nil {
+		return nil, errors.New("invalid signature")
+	}
+	credentialID, err := auth.DecodeBase64URL(input.Credential.ID)
+	if err != nil {
+		return nil, errInvalidPasskey
+	}
+
+	ch, err := s.consumePasskeyChallenge(clientDataJSON, models.WebAuthnPurposeLogin)
+	if err != nil {
+		return nil, err
+	}
+	passkey, err := s.passkeyRepo.FindByCredentialID(credentialID)
+	if err != nil {
+		return nil, err
+	}
+	if passkey == nil {
+		return nil, errInvalidPasskey
+	}
+	if ch.UserID != nil && *ch.UserID != passkey.UserID {
+		return nil, errInvalidPasskey
+	}
+	if res.UserHandle != "" {
+		handle, err := auth.DecodeBase64URL(res.UserHandle)
+		if err != nil || !bytes.Equal(handle, passkey.UserID[:]) {
+			return nil, errInvalidPasskey
+		}
+	}
+	if passkey.CloneSuspectedAt != nil {
+		return nil, errPasskeyDisabled
+	}
+
+	assertion, err := s.webauthn.VerifyWebAuthnAssertion(ch.Challenge, passkey.PublicKey, uint32(passkey.SignCount), clientDataJSON, authenticatorData, signature, true)
+	if errors.Is(err, auth.ErrSignCountRegressed) {
+		return nil, s.disableClonedPasskey(passkey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := s.passkeyRepo.RecordLogin(passkey.ID.String(), int64(assertion.SignCount), assertion.BackedUp, s.now())
+	if err != nil {
+		return nil, err
+	}
+	if !recorded {
+		// Another login got a counter at least as high in the meantime
+		return nil, s.disableClonedPasskey(passkey)
+	}
+
+	user, err := s.repo.FindByID(passkey.UserID.String())
+	if err != nil {
+		return nil, err
+	}
+	if user.Placeholder {
+		return nil, errInvalidPasskey
+	}
+	if input.DeviceName != "" {
+		client.Device = input.DeviceName
+	}
+	return s.startSession(user.ID, client)
+}
+
+func (s *userService) disableClonedPasskey(passkey *models.Passkey) error {
+	log.Printf("passkey %s of user %s reported a stale signature counter; disabling it", passkey.ID, passkey.UserID)
+	if err := s.passkeyRepo.MarkCloneSuspected(passkey.ID.String(), s.now()); err != nil {
+		return err
+	}
+	return errPasskeyDisabled
+}
+
+func (s *userService) ListPasskeys(userID string) ([]dto.PasskeyResponse, error) {
+	passkeys, err := s.passkeyRepo.ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.PasskeyResponse, 0, len(passkeys))
+	for _, p := range passkeys {
+		res = append(res, toPasskeyResponse(p))
+	}
+	return res, nil
+}
+
+func (s *userService) RenamePasskey(userID, passkeyID string, input dto.RenamePasskeyRequest) error {
+	if _, err := uuid.Parse(passkeyID); err != nil {
+		return errPasskeyNotFound
+	}
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	renamed, err := s.passkeyRepo.Rename(userID, passkeyID, name)
+	if err != nil {
+		return err
+	}
+	if !renamed {
+		return errPasskeyNotFound
+	}
+	return nil
+}
+
+func (s *userService) DeletePasskey(userID, passkeyID string) error {
+	if _, err := uuid.Parse(passkeyID); err != nil {
+		return errPasskeyNotFound
+	}
+	deleted, err := s.passkeyRepo.Delete(userID, passkeyID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return errPasskeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user/dto"
+)
+
+const (
+	// maxAvatarSize caps the upload before it is decoded.
+	maxAvatarSize = 5 << 20
+	// maxAvatarDimension stops small files that decode to huge images.
+	maxAvatarDimension = 4096
+)
+
+var avatarExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+var (
+	errStatusExpiry  = errors.New("status_expires_at must be in the future and needs status_text")
+	errInvalidAvatar = errors.New("avatar must be a PNG, JPEG or GIF image of at most 4096x4096 pixels")
+)
+
+// applyProfileInput copies the fields set in input onto profile.
+func applyProfileInput(profile *models.UserProfile, input *dto.ProfileInput, now time.Time) error {
+	if input.Bio != nil {
+		profile.Bio = strings.TrimSpace(*input.Bio)
+	}
+	if input.Timezone != nil {
+		profile.Timezone = *input.Timezone
+	}
+	if input.StatusText == nil {
+		if input.StatusExpiresAt != nil {
+			return errStatusExpiry
+		}
+		return nil
+	}
+	if input.StatusExpiresAt != nil && !input.StatusExpiresAt.After(now) {
+		return errStatusExpiry
+	}
+	profile.StatusText = strings.TrimSpace(*input.StatusText)
+	profile.StatusExpiresAt = nil
+	if profile.StatusText != "" && input.StatusExpiresAt != nil {
+		expiresAt := input.StatusExpiresAt.UTC()
+		profile.StatusExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// UpdateAvatar replaces the user's avatar with an uploaded image. The old
+// one is deleted from the bucket once the new one is saved.
+func (s *userService) UpdateAvatar(userID string, data []byte) (*dto.UserResponse, error) {
+	ext, err := avatarExtension(data)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := user.ProfileData()
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded, err := s.s3Service.UploadPhoto(data, "avatar"+ext, "users", user.ID.String(), "avatar", true, true)
+	if err != nil {
+		return nil, err
+	}
+	old := profile
+	profile.AvatarURL = uploaded["original"]
+	profile.AvatarThumbnailURL = uploaded["thumbnail"]
+	profile.AvatarKey = uploaded["originalKey"]
+	profile.AvatarThumbnailKey = uploaded["thumbnailKey"]
+	if err := user.SetProfileData(profile); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(user); err != nil {
+		s.deleteAvatarObjects(profile)
+		return nil, err
+	}
+
+	s.deleteAvatarObjects(old)
+	return toUserResponse(user), nil
+}
+
+func (s *userService) DeleteAvatar(userID string) (*dto.UserResponse, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := user.ProfileData()
+	if err != nil {
+		return nil, err
+	}
+	if profile.AvatarURL == "" {
+		return toUserResponse(user), nil
+	}
+
+	old := profile
+	profile.AvatarURL = ""
+	profile.AvatarThumbnailURL = ""
+	profile.AvatarKey = ""
+	profile.AvatarThumbnailKey = ""
+	if err := user.SetProfileData(profile); err != nil {
+		return nil, err
+	}
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+
+	s.deleteAvatarObjects(old)
+	return toUserResponse(user), nil
+}
+
+// deleteAvatarObjects removes an avatar from the public bucket. A failure
+// only leaves an unreferenced object behind, so it is logged.
+func (s *userService) deleteAvatarObjects(profile models.UserProfile) {
+	for _, key := range []string{profile.AvatarKey, profile.AvatarThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := s.s3Service.DeleteFile(key, true); err != nil {
+			log.Printf("failed to delete avatar object %s: %v", key, err)
+		}
+	}
+}
+
+// avatarExtension checks that data is an image we accept and returns the
+// file extension to store it under.
+func avatarExtension(data []byte) (string, error) {
+	ext, ok := avatarExtensions[http.DetectContentType(data)]
+	if !ok {
+		return "", errInvalidAvatar
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width > maxAvatarDimension || cfg.Height > maxAvatarDimension {
+		return "", errInvalidAvatar
+	}
+	return ext, nil
+}
+
+// toProfileResponse leaves out the bucket keys and a status that has
+// expired.
+func toProfileResponse(user *models.User, now time.Time) *dto.ProfileResponse {
+	profile, err := user.ProfileData()
+	if err != nil {
+		log.Printf("failed to decode profile of user %s: %v", user.ID, err)
+	}
+	res := &dto.ProfileResponse{
+		Bio:                profile.Bio,
+		Timezone:           profile.Timezone,
+		AvatarURL:          profile.AvatarURL,
+		AvatarThumbnailURL: profile.AvatarThumbnailURL,
+	}
+	if profile.StatusActive(now) {
+		res.StatusText = profile.StatusText
+		res.StatusExpiresAt = profile.StatusExpiresAt
+	}
+	return res
+}
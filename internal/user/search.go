@@ -0,0 +1,211 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/internal/user/dto"
+)
+
+const (
+	defaultUserSearchLimit = 20
+	maxUserSearchLimit     = 50
+	// maxUserSearchOffset stops paging deep into broad queries, which
+	// would otherwise list every account
+	maxUserSearchOffset   = 200
+	minUserSearchNameLen  = 2
+	maxUserSearchQueryLen = 100
+)
+
+// userSearchLimits cap how many searches one user can run, so the search
+// cannot be used to check long lists of emails or phone numbers.
+var userSearchLimits = []struct {
+	window time.Duration
+	max    int64
+}{
+	{time.Minute, 30},
+	{time.Hour, 300},
+}
+
+var errInvalidPhone = errors.New("phone must be an international number starting with +")
+
+// rateLimitedError refuses a request until retryAfter has passed.
+type rateLimitedError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitedError) Error() string {
+	return "too many requests; try again later"
+}
+
+// normalizePhone turns a number like "+49 (151) 1234-5678" into E.164
+// form. Numbers without a country code are rejected.
+func normalizePhone(raw string) (string, bool) {
+	raw = strings.TrimSpace(raw)
+	if !strings.HasPrefix(raw, "+") {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteByte('+')
+	for _, r := range raw[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+		default:
+			return "", false
+		}
+	}
+	phone := b.String()
+	if len(phone) < 9 || len(phone) > 16 || phone[1] == '0' {
+		return "", false
+	}
+	return phone, true
+}
+
+// SearchUsers finds people by username or display name. A query that is
+// a whole email address or phone number finds the account it belongs to,
+// if the owner allows it.
+func (s *userService) SearchUsers(userID string, input dto.SearchUsersQuery) (*dto.SearchUsersResponse, error) {
+	query := strings.ToLower(strings.TrimSpace(input.Query))
+	if query == "" {
+		return nil, errors.New("q is required")
+	}
+	if utf8.RuneCountInString(query) > maxUserSearchQueryLen {
+		return nil, errors.New("q is too long")
+	}
+
+	limit := input.Limit
+	if limit <= 0 {
+		limit = defaultUserSearchLimit
+	}
+	if limit > maxUserSearchLimit {
+		limit = maxUserSearchLimit
+	}
+	offset := max(input.Offset, 0)
+	if offset > maxUserSearchOffset {
+		return nil, errors.New("offset is too large; narrow the search instead")
+	}
+
+	params := repository.UserSearchParams{ExcludeID: userID, Limit: limit + 1, Offset: offset}
+	if strings.Contains(query, "@") {
+		params.Email = query
+	} else {
+		if phone, ok := normalizePhone(query); ok {
+			params.Phone = phone
+		}
+		if utf8.RuneCountInString(query) >= minUserSearchNameLen {
+			params.Name = query
+		}
+	}
+	if params.Name == "" && params.Email == "" && params.Phone == "" {
+		return nil, errors.New("q is too short")
+	}
+
+	now := s.now()
+	for _, l := range userSearchLimits {
+		n, err := s.limiter.HitSlidingWindow("search:user:"+userID+":"+l.window.String(), now, l.window)
+		if err != nil {
+			return nil, err
+		}
+		if n > l.max {
+			return nil, &rateLimitedError{retryAfter: l.window}
+		}
+	}
+
+	users, err := s.repo.Search(params)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.SearchUsersResponse{Users: []dto.UserBasic{}}
+	if len(users) > limit {
+		next := offset + limit
+		res.NextOffset = &next
+		users = users[:limit]
+	}
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID.String()
+	}
+	contactIDs, err := s.contactRepo.ListContactIDs(userID, ids)
+	if err != nil {
+		return nil, err
+	}
+	contacts := make(map[string]bool, len(contactIDs))
+	for _, id := range contactIDs {
+		contacts[id] = true
+	}
+	for i := range users {
+		u := &users[i]
+		basic := dto.UserBasic{ID: u.ID, Username: u.Username, DisplayName: u.DisplayName}
+		if u.ProfilePhotoVisible(contacts[u.ID.String()]) {
+			basic.AvatarURL = u.AvatarThumbnail()
+		}
+		res.Users = append(res.Users, basic)
+	}
+	return res, nil
+}
+
+func (s *userService) GetPrivacySettings(userID string) (*dto.PrivacySettingsResponse, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return toPrivacySettingsResponse(user.Privacy), nil
+}
+
+func (s *userService) UpdatePrivacySettings(userID string, input dto.UpdatePrivacySettingsRequest) (*dto.PrivacySettingsResponse, error) {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if input.DiscoverableByEmail != nil {
+		user.Privacy.DiscoverableByEmail = *input.DiscoverableByEmail
+	}
+	if input.DiscoverableByPhone != nil {
+		user.Privacy.DiscoverableByPhone = *input.DiscoverableByPhone
+	}
+	if input.DMsFromContactsOnly != nil {
+		user.Privacy.DMsFromContactsOnly = *input.DMsFromContactsOnly
+	}
+	if input.LastSeen != nil {
+		user.Privacy.LastSeenVisibility = *input.LastSeen
+	}
+	if input.Online != nil {
+		user.Privacy.OnlineVisibility = *input.Online
+	}
+	if input.ProfilePhoto != nil {
+		user.Privacy.ProfilePhotoVisibility = *input.ProfilePhoto
+	}
+	if input.ReadReceipts != nil {
+		user.Privacy.ReadReceiptsVisibility = *input.ReadReceipts
+	}
+	if err := s.repo.Update(user); err != nil {
+		return nil, err
+	}
+	return toPrivacySettingsResponse(user.Privacy), nil
+}
+
+func toPrivacySettingsResponse(p models.PrivacySettings) *dto.PrivacySettingsResponse {
+	return &dto.PrivacySettingsResponse{
+		DiscoverableByEmail: p.DiscoverableByEmail,
+		DiscoverableByPhone: p.DiscoverableByPhone,
+		DMsFromContactsOnly: p.DMsFromContactsOnly,
+		LastSeen:            visibilityOrDefault(p.LastSeenVisibility),
+		Online:              visibilityOrDefault(p.OnlineVisibility),
+		ProfilePhoto:        visibilityOrDefault(p.ProfilePhotoVisibility),
+		ReadReceipts:        visibilityOrDefault(p.ReadReceiptsVisibility),
+	}
+}
+
+func visibilityOrDefault(v string) string {
+	if v == "" {
+		return models.VisibilityEveryone
+	}
+	return v
+}
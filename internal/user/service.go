@@ -1,28 +1,144 @@
 package user
 
 import (
+	"context"
 	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
 	"mozho_chat/internal/models"
 	"mozho_chat/internal/repository"
 	"mozho_chat/internal/user/dto"
 	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/mailer"
+	s3upload "mozho_chat/pkg/s3"
 )
 
 type Service interface {
 	Register(input dto.CreateUserRequest) (*dto.UserResponse, error)
-	Login(input dto.LoginRequest) (string, error)
+	Login(input dto.LoginRequest, client dto.ClientInfo) (*dto.LoginResponse, error)
+	VerifyMFA(input dto.MFAVerifyRequest, client dto.ClientInfo) (*dto.TokenResponse, error)
+	Refresh(ctx context.Context, input dto.RefreshRequest, client dto.ClientInfo) (*dto.TokenResponse, error)
+	Logout(input dto.LogoutRequest) error
+	PurgeExpiredSessions(ctx context.Context) (int, error)
+	ListSessions(userID, currentSessionID string) ([]dto.SessionResponse, error)
+	RevokeSession(userID, sessionID string) error
+	RevokeAllSessions(userID string) (int, error)
 	GetProfile(userID string) (*dto.UserResponse, error)
 
 	UpdateProfile(userID string, input dto.UpdateUserRequest) (*dto.UserResponse, error)
 	UpdatePassword(userID string, input dto.UpdatePasswordRequest) error
+	UpdateAvatar(userID string, data []byte) (*dto.UserResponse, error)
+	DeleteAvatar(userID string) (*dto.UserResponse, error)
+
+	SendVerificationEmail(userID string) error
+	VerifyEmail(input dto.VerifyEmailRequest) error
+	ForgotPassword(input dto.ForgotPasswordRequest)
+	ResetPassword(input dto.ResetPasswordRequest) error
+	ChangeEmail(userID, sessionID string, input dto.ChangeEmailRequest) error
+	ConfirmEmailChange(input dto.VerifyEmailRequest) error
+	PurgeExpiredTokens(ctx context.Context) (int, error)
+
+	GetMFAStatus(userID string) (*dto.MFAStatusResponse, error)
+	EnrollTOTP(userID string) (*dto.TOTPEnrollResponse, error)
+	ConfirmTOTP(userID string, input dto.TOTPCodeRequest) (*dto.RecoveryCodesResponse, error)
+	RegenerateRecoveryCodes(userID string, input dto.TOTPCodeRequest) (*dto.RecoveryCodesResponse, error)
+	DisableTOTP(userID, sessionID string, input dto.DisableTOTPRequest) error
+
+	BeginPasskeyRegistration(userID, sessionID string, input dto.PasskeyRegistrationOptionsRequest) (*dto.PasskeyCreationOptions, error)
+	FinishPasskeyRegistration(userID string, input dto.RegisterPasskeyRequest) (*dto.PasskeyResponse, error)
+	BeginPasskeyLogin(input dto.PasskeyLoginOptionsRequest) (*dto.PasskeyRequestOptions, error)
+	FinishPasskeyLogin(input dto.PasskeyLoginRequest, client dto.ClientInfo) (*dto.TokenResponse, error)
+	ListPasskeys(userID string) ([]dto.PasskeyResponse, error)
+	RenamePasskey(userID, passkeyID string, input dto.RenamePasskeyRequest) error
+	DeletePasskey(userID, passkeyID string) error
+
+	ListOIDCProviders() []dto.OIDCProviderResponse
+	StartOIDCLogin(provider string) (*dto.OIDCAuthorizeResponse, error)
+	FinishOIDCLogin(input dto.OIDCCallbackRequest, client dto.ClientInfo) (*dto.LoginResponse, error)
+	ListIdentities(userID string) ([]dto.IdentityResponse, error)
+
+	SearchUsers(userID string, input dto.SearchUsersQuery) (*dto.SearchUsersResponse, error)
+	GetPrivacySettings(userID string) (*dto.PrivacySettingsResponse, error)
+	UpdatePrivacySettings(userID string, input dto.UpdatePrivacySettingsRequest) (*dto.PrivacySettingsResponse, error)
+
+	RequestAccountDeletion(userID, sessionID string, input dto.DeleteAccountRequest, client dto.ClientInfo) (*dto.AccountDeletionResponse, error)
+	CancelAccountDeletion(userID string, client dto.ClientInfo) error
+	PurgeDeletedAccounts(ctx context.Context) (int, error)
+
+	UnlockUser(adminID, userID string) error
 }
 
 type userService struct {
-	repo repository.UserRepository
+	repo         repository.UserRepository
+	sessionRepo  repository.SessionRepository
+	tokenRepo    repository.UserTokenRepository
+	mfaRepo      repository.MFARepository
+	passkeyRepo  repository.PasskeyRepository
+	webauthn     auth.WebAuthnConfig
+	identityRepo repository.IdentityRepository
+	oidc         *auth.OIDCProviders
+	auditRepo    repository.AuditRepository
+	limiter      RateLimiter
+	contactRepo  repository.ContactRepository
+	deletionRepo repository.AccountDeletionRepository
+	mailer       mailer.Mailer
+	encryption   encryption.EncryptionService
+	s3Service    s3upload.Service
+	revoker      SessionRevoker
+	now          func() time.Time
 }
 
-func NewUserService(repo repository.UserRepository) Service {
-	return &userService{repo: repo}
+// Deps holds what the user service is built from.
+type Deps struct {
+	Repo         repository.UserRepository
+	SessionRepo  repository.SessionRepository
+	TokenRepo    repository.UserTokenRepository
+	MFARepo      repository.MFARepository
+	PasskeyRepo  repository.PasskeyRepository
+	WebAuthn     auth.WebAuthnConfig
+	IdentityRepo repository.IdentityRepository
+	OIDC         *auth.OIDCProviders
+	AuditRepo    repository.AuditRepository
+	Limiter      RateLimiter
+	ContactRepo  repository.ContactRepository
+	DeletionRepo repository.AccountDeletionRepository
+	Mailer       mailer.Mailer
+	Encryption   encryption.EncryptionService
+	S3Service    s3upload.Service
+	Revoker      SessionRevoker
+	// Now tells the time; it defaults to time.Now
+	Now func() time.Time
+}
+
+func NewUserService(deps Deps) Service {
+	now := deps.Now
+	if now == nil {
+		now = time.Now
+	}
+	return &userService{
+		repo:         deps.Repo,
+		sessionRepo:  deps.SessionRepo,
+		tokenRepo:    deps.TokenRepo,
+		mfaRepo:      deps.MFARepo,
+		passkeyRepo:  deps.PasskeyRepo,
+		webauthn:     deps.WebAuthn,
+		identityRepo: deps.IdentityRepo,
+		oidc:         deps.OIDC,
+		auditRepo:    deps.AuditRepo,
+		limiter:      deps.Limiter,
+		contactRepo:  deps.ContactRepo,
+		deletionRepo: deps.DeletionRepo,
+		mailer:       deps.Mailer,
+		encryption:   deps.Encryption,
+		s3Service:    deps.S3Service,
+		revoker:      deps.Revoker,
+		now:          now,
+	}
 }
 
 func (s *userService) Register(input dto.CreateUserRequest) (*dto.UserResponse, error) {
@@ -31,40 +147,87 @@ func (s *userService) Register(input dto.CreateUserRequest) (*dto.UserResponse,
 		return nil, err
 	}
 
+	// Imports create placeholder accounts for people who have not signed up
+	// yet. Registering with the same email changes nothing: anyone could
+	// type that email, so the password is only set from the link mailed to
+	// it. The reply looks like any other signup.
+	if existing, err := s.repo.FindByEmail(input.Email); err == nil && existing.Placeholder && !models.IsPlaceholderEmail(input.Email) {
+		if err := s.sendUserToken(existing, models.UserTokenPurposeResetPassword, passwordResetTTL, claimAccountTemplate, "/reset-password"); err != nil {
+			log.Printf("failed to send claim email to user %s: %v", existing.ID, err)
+		}
+		return toUserResponse(&models.User{
+			ID:        existing.ID,
+			Username:  input.Username,
+			Email:     input.Email,
+		}), nil
+	}
+
 	user := models.User{
-		Username:     input.Username,
-		Email:        input.Email,
+		Username:      input.Username,
+		Email:         input.Email,
 		PasswordHash: hashed,
 	}
 
 	if err := s.repo.Create(&user); err != nil {
 		return nil, err
 	}
+	// Registration succeeds even if the email cannot be sent; the user can
+	// ask for another one
+	if err := s.sendVerificationEmail(&user); err != nil {
+		log.Printf("failed to send verification email to user %s: %v", user.ID, err)
+	}
 
-	return &dto.UserResponse{
-		ID:       user.ID.String(),
-		Username: user.Username,
-		Email:    user.Email,
-		Profile:  user.Profile,
-	}, nil
+	return toUserResponse(&user), nil
 }
 
-func (s *userService) Login(input dto.LoginRequest) (string, error) {
+// Login checks an email and password. Unknown emails, placeholder accounts
+// and wrong passwords all fail the same way, and repeated failures slow
+// down and then lock further attempts.
+func (s *userService) Login(input dto.LoginRequest, client dto.ClientInfo) (*dto.LoginResponse, error) {
+	now := s.now()
+	if err := s.checkLoginAllowed(input.Email, client.IPAddress, now); err != nil {
+		return nil, err
+	}
+
 	user, err := s.repo.FindByEmail(input.Email)
-	if err != nil {
-		return "", err
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if user == nil || user.Placeholder {
+		checkDummyPassword(input.Password)
+	}
+	if user == nil || user.Placeholder || auth.CheckPasswordHash(input.Password, user.PasswordHash) != nil {
+		if err := s.recordLoginFailure(input.Email, client.IPAddress, user, now); err != nil {
+			return nil, err
+		}
+		return nil, errInvalidCredentials
 	}
 
-	if err := auth.CheckPasswordHash(input.Password, user.PasswordHash); err != nil {
-		return "", errors.New("invalid credentials")
+	if err := s.limiter.ResetSlidingWindow(accountLoginKey(input.Email)); err != nil {
+		return nil, err
 	}
+	return s.completeLogin(user, input.DeviceName, client)
+}
 
-	token, err := auth.GenerateJWT(user.ID)
+// completeLogin starts a session for a user who proved who they are, or
+// asks for their second factor first.
+func (s *userService) completeLogin(user *models.User, deviceName string, client dto.ClientInfo) (*dto.LoginResponse, error) {
+	totp, err := s.mfaRepo.FindTOTP(user.ID.String())
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if totp != nil && totp.ConfirmedAt != nil {
+		return s.mfaChallenge(user)
 	}
 
-	return token, nil
+	if deviceName != "" {
+		client.Device = deviceName
+	}
+	tokens, err := s.startSession(user.ID, client)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.LoginResponse{TokenResponse: tokens}, nil
 }
 
 func (s *userService) GetProfile(userID string) (*dto.UserResponse, error) {
@@ -73,12 +236,7 @@ func (s *userService) GetProfile(userID string) (*dto.UserResponse, error) {
 		return nil, err
 	}
 
-	return &dto.UserResponse{
-		ID:       user.ID.String(),
-		Username: user.Username,
-		Email:    user.Email,
-		Profile:  user.Profile,
-	}, nil
+	return toUserResponse(user), nil
 }
 
 func (s *userService) UpdateProfile(userID string, input dto.UpdateUserRequest) (*dto.UserResponse, error) {
@@ -90,20 +248,42 @@ func (s *userService) UpdateProfile(userID string, input dto.UpdateUserRequest)
     if input.Username != nil {
         user.Username = *input.Username
     }
-    if input.Email != nil {
-        user.Email = *input.Email
+    if input.DisplayName != nil {
+        user.DisplayName = strings.TrimSpace(*input.DisplayName)
+    }
+    if input.Phone != nil {
+        user.Phone = nil
+        if *input.Phone != "" {
+            phone, ok := normalizePhone(*input.Phone)
+            if !ok {
+                return nil, errInvalidPhone
+            }
+            user.Phone = &phone
+        }
+    }
+    if input.Profile != nil {
+        profile, err := user.ProfileData()
+        if err != nil {
+            return nil, err
+        }
+        now := s.now()
+        if !profile.StatusActive(now) {
+            profile.StatusText = ""
+            profile.StatusExpiresAt = nil
+        }
+        if err := applyProfileInput(&profile, input.Profile, now); err != nil {
+            return nil, err
+        }
+        if err := user.SetProfileData(profile); err != nil {
+            return nil, err
+        }
     }
 
 	if err := s.repo.Update(user); err != nil {
         return nil, err
     }
 
-    return &dto.UserResponse{
-        ID:       user.ID.String(),
-        Username: user.Username,
-        Email:    user.Email,
-        Profile:  user.Profile,
-    }, nil
+    return toUserResponse(user), nil
 }
 
 func (s *userService) UpdatePassword(userID string, input dto.UpdatePasswordRequest) error {
@@ -127,3 +307,20 @@ func (s *userService) UpdatePassword(userID string, input dto.UpdatePasswordRequ
 
 	return s.repo.Update(user)
 }
+
+func toUserResponse(user *models.User) *dto.UserResponse {
+	res := &dto.UserResponse{
+		ID:            user.ID.String(),
+		Username:      user.Username,
+		DisplayName:   user.DisplayName,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		Profile:       toProfileResponse(user, time.Now()),
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}
+	if user.Phone != nil {
+		res.Phone = *user.Phone
+	}
+	return res
+}
@@ -0,0 +1,243 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+// SessionRevoker puts signed out sessions on the list AuthMiddleware checks,
+// so their access tokens stop working before they expire.
+type SessionRevoker interface {
+	RevokeSession(sessionID, userID string, ttl time.Duration) error
+}
+
+// maxDeviceLength matches what is worth keeping of a User-Agent.
+const maxDeviceLength = 255
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errSessionNotFound     = errors.New("session not found")
+)
+
+// StartSessionReaper deletes expired refresh tokens and email links every
+// interval until ctx is done.
+func StartSessionReaper(ctx context.Context, service Service, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := service.PurgeExpiredSessions(ctx); err != nil {
+					log.Printf("session reaper: failed to purge expired sessions: %v", err)
+				}
+				if _, err := service.PurgeExpiredTokens(ctx); err != nil {
+					log.Printf("session reaper: failed to purge expired tokens: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// startSession begins a new refresh token family for a login.
+func (s *userService) startSession(userID uuid.UUID, client dto.ClientInfo) (*dto.TokenResponse, error) {
+	now := s.now()
+	session := &models.Session{
+		ID:              uuid.New(),
+		UserID:          userID,
+		FamilyID:        uuid.New(),
+		AuthenticatedAt: now,
+	}
+	token, err := s.issueRefreshToken(session, client, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sessionRepo.Create(session); err != nil {
+		return nil, err
+	}
+	return s.tokenResponse(session, token)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token. A token can only be exchanged once: presenting it again
+// means it was copied, so every token of that login is revoked.
+func (s *userService) Refresh(ctx context.Context, input dto.RefreshRequest, client dto.ClientInfo) (*dto.TokenResponse, error) {
+	current, err := s.sessionRepo.FindByTokenHash(auth.HashRefreshToken(input.RefreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || current.RevokedAt != nil {
+		return nil, errInvalidRefreshToken
+	}
+	if current.RotatedAt != nil {
+		return nil, s.revokeReusedFamily(current)
+	}
+
+	now := s.now()
+	if !current.ExpiresAt.After(now) {
+		return nil, errors.New("refresh token expired")
+	}
+
+	next := &models.Session{
+		ID:              uuid.New(),
+		UserID:          current.UserID,
+		FamilyID:        current.FamilyID,
+		AuthenticatedAt: current.AuthenticatedAt,
+	}
+	// Keep the label chosen at login rather than the refreshing User-Agent
+	client.Device = current.Device
+	token, err := s.issueRefreshToken(next, client, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.sessionRepo.Rotate(ctx, current, next, now); err != nil {
+		if errors.Is(err, repository.ErrSessionRotated) {
+			// Lost a race against another use of the same token
+			return nil, s.revokeReusedFamily(current)
+		}
+		return nil, err
+	}
+	return s.tokenResponse(next, token)
+}
+
+func (s *userService) revokeReusedFamily(session *models.Session) error {
+	log.Printf("refresh token reuse detected for user %s, revoking session %s", session.UserID, session.FamilyID)
+	if err := s.sessionRepo.RevokeFamily(session.FamilyID.String(), s.now()); err != nil {
+		return err
+	}
+	if err := s.revokeAccessTokens(session.UserID.String(), session.FamilyID.String()); err != nil {
+		log.Printf("failed to revoke access tokens of session %s: %v", session.FamilyID, err)
+	}
+	return errors.New("refresh token reuse detected; please log in again")
+}
+
+// Logout revokes the refresh token and every token rotated from the same
+// login. Unknown tokens are ignored so logging out twice is harmless.
+func (s *userService) Logout(input dto.LogoutRequest) error {
+	session, err := s.sessionRepo.FindByTokenHash(auth.HashRefreshToken(input.RefreshToken))
+	if err != nil {
+		return err
+	}
+	if session == nil {
+		return nil
+	}
+	if err := s.sessionRepo.RevokeFamily(session.FamilyID.String(), s.now()); err != nil {
+		return err
+	}
+	return s.revokeAccessTokens(session.UserID.String(), session.FamilyID.String())
+}
+
+// ListSessions returns the devices the user is logged in on.
+func (s *userService) ListSessions(userID, currentSessionID string) ([]dto.SessionResponse, error) {
+	sessions, err := s.sessionRepo.ListActive(userID, s.now())
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		res = append(res, dto.SessionResponse{
+			ID:        session.FamilyID.String(),
+			Device:    session.Device,
+			IPAddress: session.IPAddress,
+			CreatedAt: session.AuthenticatedAt,
+			// The current token was issued by the latest login or refresh
+			LastUsedAt: session.CreatedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.FamilyID.String() == currentSessionID,
+		})
+	}
+	return res, nil
+}
+
+// RevokeSession signs one of the user's devices out. Its refresh token stops
+// working and so do access tokens already issued to it.
+func (s *userService) RevokeSession(userID, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return errSessionNotFound
+	}
+	revoked, err := s.sessionRepo.RevokeUserFamily(userID, sessionID, s.now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return errSessionNotFound
+	}
+	return s.revokeAccessTokens(userID, sessionID)
+}
+
+// RevokeAllSessions signs the user out everywhere, including the device
+// making the request, and returns how many sessions ended. The refresh
+// tokens are already gone when revoking an access token fails, so the
+// others are still revoked and the failures are returned together.
+func (s *userService) RevokeAllSessions(userID string) (int, error) {
+	familyIDs, err := s.sessionRepo.RevokeAllForUser(userID, s.now())
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for _, familyID := range familyIDs {
+		if err := s.revokeAccessTokens(userID, familyID); err != nil {
+			errs = append(errs, fmt.Errorf("revoke access tokens of session %s: %w", familyID, err))
+		}
+	}
+	return len(familyIDs), errors.Join(errs...)
+}
+
+// revokeAccessTokens puts the session on the revocation list that
+// AuthMiddleware checks. Entries only need to outlive the access tokens.
+func (s *userService) revokeAccessTokens(userID, sessionID string) error {
+	return s.revoker.RevokeSession(sessionID, userID, auth.AccessTokenTTL())
+}
+
+func (s *userService) PurgeExpiredSessions(ctx context.Context) (int, error) {
+	n, err := s.sessionRepo.DeleteExpired(ctx, s.now())
+	return int(n), err
+}
+
+func (s *userService) issueRefreshToken(session *models.Session, client dto.ClientInfo, now time.Time) (string, error) {
+	token, hash, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	session.RefreshTokenHash = hash
+	session.Device = truncate(client.Device, maxDeviceLength)
+	session.IPAddress = client.IPAddress
+	session.ExpiresAt = now.Add(auth.RefreshTokenTTL())
+	return token, nil
+}
+
+func (s *userService) tokenResponse(session *models.Session, refreshToken string) (*dto.TokenResponse, error) {
+	accessToken, expiresAt, err := auth.GenerateJWT(session.UserID, session.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.TokenResponse{
+		Token:                 accessToken,
+		TokenType:             "Bearer",
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,34 @@
+package wellknown
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"mozho_chat/pkg/auth"
+)
+
+// jwksMaxAge is how long clients may cache the key set. New keys are
+// published well ahead of signing anything, so this can be generous.
+const jwksMaxAge = "public, max-age=300"
+
+// Handler serves documents other services discover mozho through.
+type Handler struct {
+	keys *auth.KeyManager
+}
+
+func NewHandler(keys *auth.KeyManager) *Handler {
+	return &Handler{keys: keys}
+}
+
+func (h *Handler) RegisterRoutes(rg *gin.RouterGroup) {
+	r := rg.Group("/.well-known")
+	{
+		r.GET("/jwks.json", h.JWKS)
+	}
+}
+
+// JWKS lists the public keys access tokens can be verified with.
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", jwksMaxAge)
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
@@ -0,0 +1 @@
+ALTER TABLE chat_room_members DROP COLUMN IF EXISTS role;
@@ -0,0 +1,2 @@
+ALTER TABLE chat_room_members
+  ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'member';
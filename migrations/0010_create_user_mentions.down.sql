@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_mentions;
+ALTER TABLE messages DROP COLUMN IF EXISTS mentions;
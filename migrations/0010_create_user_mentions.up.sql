@@ -0,0 +1,16 @@
+ALTER TABLE messages ADD COLUMN mentions JSONB NOT NULL DEFAULT '[]';
+
+CREATE TABLE user_mentions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL REFERENCES messages(id) ON DELETE CASCADE,
+    chat_room_id UUID NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    sender_id UUID NOT NULL REFERENCES users(id),
+    type VARCHAR(10) NOT NULL,
+    read_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    CONSTRAINT unique_message_mention UNIQUE (message_id, user_id)
+);
+
+CREATE INDEX idx_user_mentions_user_id ON user_mentions(user_id, created_at DESC);
+CREATE INDEX idx_user_mentions_unread ON user_mentions(user_id, chat_room_id) WHERE read_at IS NULL;
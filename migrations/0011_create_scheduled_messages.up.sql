@@ -0,0 +1,18 @@
+CREATE TABLE scheduled_messages (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    chat_room_id UUID NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    sender_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    content TEXT NOT NULL,
+    algorithm VARCHAR(20) DEFAULT 'RSA',
+    key TEXT,
+    mentions JSONB NOT NULL DEFAULT '[]',
+    attachments JSONB NOT NULL DEFAULT '[]',
+    send_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX idx_scheduled_messages_sender_id ON scheduled_messages(sender_id);
+CREATE INDEX idx_scheduled_messages_due ON scheduled_messages(send_at) WHERE status = 'pending';
@@ -0,0 +1,4 @@
+ALTER TABLE scheduled_messages DROP COLUMN IF EXISTS expires_at;
+DROP INDEX IF EXISTS idx_messages_expires_at;
+ALTER TABLE messages DROP COLUMN IF EXISTS expires_at;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS message_ttl_seconds;
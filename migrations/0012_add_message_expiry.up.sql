@@ -0,0 +1,6 @@
+ALTER TABLE chat_rooms ADD COLUMN message_ttl_seconds INTEGER;
+
+ALTER TABLE messages ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE;
+CREATE INDEX idx_messages_expires_at ON messages(expires_at) WHERE expires_at IS NOT NULL;
+
+ALTER TABLE scheduled_messages ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_scheduled_messages_client_msg_id;
+ALTER TABLE scheduled_messages DROP COLUMN IF EXISTS client_msg_id;
+DROP INDEX IF EXISTS idx_messages_client_msg_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS client_msg_id;
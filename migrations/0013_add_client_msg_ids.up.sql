@@ -0,0 +1,9 @@
+ALTER TABLE messages ADD COLUMN client_msg_id VARCHAR(64);
+CREATE UNIQUE INDEX idx_messages_client_msg_id
+  ON messages(sender_id, chat_room_id, client_msg_id)
+  WHERE client_msg_id IS NOT NULL;
+
+ALTER TABLE scheduled_messages ADD COLUMN client_msg_id VARCHAR(64);
+CREATE UNIQUE INDEX idx_scheduled_messages_client_msg_id
+  ON scheduled_messages(sender_id, chat_room_id, client_msg_id)
+  WHERE client_msg_id IS NOT NULL;
@@ -0,0 +1,39 @@
+ALTER TABLE messages ADD COLUMN type VARCHAR(20) NOT NULL DEFAULT 'text';
+ALTER TABLE messages ADD COLUMN payload JSONB;
+
+CREATE TABLE polls (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    message_id UUID NOT NULL UNIQUE REFERENCES messages(id) ON DELETE CASCADE,
+    chat_room_id UUID NOT NULL REFERENCES chat_rooms(id) ON DELETE CASCADE,
+    creator_id UUID NOT NULL REFERENCES users(id),
+    question TEXT NOT NULL,
+    multiple_choice BOOLEAN NOT NULL DEFAULT FALSE,
+    anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    closes_at TIMESTAMP WITH TIME ZONE,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    final_results JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE INDEX idx_polls_chat_room_id ON polls(chat_room_id);
+
+CREATE TABLE poll_options (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    text TEXT NOT NULL,
+    position INTEGER NOT NULL
+);
+
+CREATE INDEX idx_poll_options_poll_id ON poll_options(poll_id);
+
+CREATE TABLE poll_votes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    poll_id UUID NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    option_id UUID NOT NULL REFERENCES poll_options(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    CONSTRAINT unique_poll_vote UNIQUE (poll_id, option_id, user_id)
+);
+
+CREATE INDEX idx_poll_votes_poll_id ON poll_votes(poll_id);
+CREATE INDEX idx_poll_votes_user_id ON poll_votes(user_id);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_attachments_key;
+
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_sender_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_room_id;
+ALTER TABLE messages DROP COLUMN IF EXISTS forwarded_from_message_id;
+
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS restrict_forwarding;
@@ -0,0 +1,8 @@
+ALTER TABLE chat_rooms ADD COLUMN restrict_forwarding BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE messages ADD COLUMN forwarded_from_message_id UUID;
+ALTER TABLE messages ADD COLUMN forwarded_from_room_id UUID;
+ALTER TABLE messages ADD COLUMN forwarded_from_sender_id UUID;
+
+-- Forwarded attachments share S3 objects, so objects are reference counted by key
+CREATE INDEX idx_attachments_key ON attachments(key);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS link_preview_jobs;
+DROP TABLE IF EXISTS link_previews;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_messages_room_created_at;
+DROP INDEX IF EXISTS idx_messages_search_vector;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
+
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS encryption_mode;
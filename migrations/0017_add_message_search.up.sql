@@ -0,0 +1,6 @@
+ALTER TABLE chat_rooms ADD COLUMN encryption_mode VARCHAR(20) NOT NULL DEFAULT 'e2e';
+
+-- Only filled for messages in rooms whose encryption mode is "server" or "none"
+ALTER TABLE messages ADD COLUMN search_vector TSVECTOR;
+CREATE INDEX idx_messages_search_vector ON messages USING GIN (search_vector);
+CREATE INDEX idx_messages_room_created_at ON messages(chat_room_id, created_at DESC, id DESC);
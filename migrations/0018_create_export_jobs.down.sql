@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS export_jobs;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_chat_rooms_external_id;
+ALTER TABLE chat_rooms DROP COLUMN IF EXISTS external_id;
+
+ALTER TABLE users DROP COLUMN IF EXISTS placeholder;
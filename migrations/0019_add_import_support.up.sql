@@ -0,0 +1,8 @@
+-- Placeholder users are created by chat imports for people who have no
+-- account yet; registering with the same email claims the account
+ALTER TABLE users ADD COLUMN placeholder BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Identifies the room a Slack channel or WhatsApp chat was imported into,
+-- so running an import again updates it instead of creating a copy
+ALTER TABLE chat_rooms ADD COLUMN external_id VARCHAR(255);
+CREATE UNIQUE INDEX idx_chat_rooms_external_id ON chat_rooms(external_id) WHERE external_id IS NOT NULL;
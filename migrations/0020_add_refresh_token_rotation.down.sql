@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_sessions_expires_at;
+DROP INDEX IF EXISTS idx_sessions_family_id;
+DROP INDEX IF EXISTS idx_sessions_refresh_token_hash;
+
+ALTER TABLE sessions ALTER COLUMN expires_at DROP NOT NULL;
+ALTER TABLE sessions DROP COLUMN IF EXISTS revoked_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS replaced_by_id;
+ALTER TABLE sessions DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS authenticated_at;
+ALTER TABLE sessions DROP COLUMN IF EXISTS family_id;
+
+ALTER TABLE sessions RENAME COLUMN refresh_token_hash TO refresh_token;
@@ -0,0 +1,19 @@
+-- Refresh tokens were never issued, so there is nothing worth keeping, and
+-- tokens are now stored hashed
+DELETE FROM sessions;
+
+ALTER TABLE sessions RENAME COLUMN refresh_token TO refresh_token_hash;
+
+-- Every refresh token is a row. Rotation adds a row to the same family and
+-- marks the old one as rotated; presenting a rotated token revokes the family
+ALTER TABLE sessions ADD COLUMN family_id UUID NOT NULL;
+ALTER TABLE sessions ADD COLUMN authenticated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+ALTER TABLE sessions ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE sessions ADD COLUMN rotated_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE sessions ADD COLUMN replaced_by_id UUID;
+ALTER TABLE sessions ADD COLUMN revoked_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE sessions ALTER COLUMN expires_at SET NOT NULL;
+
+CREATE UNIQUE INDEX idx_sessions_refresh_token_hash ON sessions(refresh_token_hash);
+CREATE INDEX idx_sessions_family_id ON sessions(family_id);
+CREATE INDEX idx_sessions_expires_at ON sessions(expires_at);
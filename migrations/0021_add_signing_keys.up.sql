@@ -0,0 +1,9 @@
+-- Access tokens are signed with asymmetric keys that rotate. Private keys
+-- are encrypted with JWT_SECRET; public keys are served from the JWKS
+CREATE TABLE signing_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    private_key TEXT NOT NULL,
+    activates_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
@@ -0,0 +1,4 @@
+ALTER TABLE user_tokens DROP COLUMN IF EXISTS attempts;
+
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_totp;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS web_authn_challenges;
+DROP TABLE IF EXISTS passkeys;
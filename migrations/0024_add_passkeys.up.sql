@@ -0,0 +1,32 @@
+CREATE TABLE passkeys (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    credential_id BYTEA NOT NULL,
+    public_key BYTEA NOT NULL,
+    algorithm BIGINT NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    aaguid BYTEA,
+    transports TEXT,
+    name VARCHAR(100) NOT NULL,
+    backup_eligible BOOLEAN NOT NULL DEFAULT false,
+    backed_up BOOLEAN NOT NULL DEFAULT false,
+    clone_suspected_at TIMESTAMP WITH TIME ZONE,
+    last_used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE UNIQUE INDEX idx_passkeys_credential_id ON passkeys(credential_id);
+CREATE INDEX idx_passkeys_user_id ON passkeys(user_id);
+
+-- Open registration and login ceremonies
+CREATE TABLE web_authn_challenges (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL,
+    challenge BYTEA NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now()
+);
+
+CREATE UNIQUE INDEX idx_web_authn_challenges_challenge ON web_authn_challenges(challenge);
+CREATE INDEX idx_web_authn_challenges_expires_at ON web_authn_challenges(expires_at);
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS idx_users_email_lower;
+DROP INDEX IF EXISTS idx_users_display_name_trgm;
+DROP INDEX IF EXISTS idx_users_username_trgm;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS discoverable_by_phone,
+    DROP COLUMN IF EXISTS discoverable_by_email,
+    DROP COLUMN IF EXISTS phone,
+    DROP COLUMN IF EXISTS display_name;
@@ -0,0 +1,12 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+ALTER TABLE users
+    ADD COLUMN display_name VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN phone VARCHAR(16) UNIQUE,
+    ADD COLUMN discoverable_by_email BOOLEAN NOT NULL DEFAULT TRUE,
+    ADD COLUMN discoverable_by_phone BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Trigram indexes serve both prefix (LIKE 'abc%') and similarity (%) matches
+CREATE INDEX idx_users_username_trgm ON users USING GIN (lower(username) gin_trgm_ops);
+CREATE INDEX idx_users_display_name_trgm ON users USING GIN (lower(display_name) gin_trgm_ops);
+CREATE INDEX idx_users_email_lower ON users (lower(email));
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN IF EXISTS dms_from_contacts_only;
+
+DROP TABLE IF EXISTS contacts;
+DROP TABLE IF EXISTS contact_requests;
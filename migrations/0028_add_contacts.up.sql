@@ -0,0 +1,27 @@
+CREATE TABLE contact_requests (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    requester_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    addressee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    responded_at TIMESTAMP WITH TIME ZONE,
+    CHECK (requester_id <> addressee_id)
+);
+
+CREATE INDEX idx_contact_requests_requester_id ON contact_requests(requester_id);
+CREATE INDEX idx_contact_requests_addressee_id ON contact_requests(addressee_id);
+-- Only one open request per pair, whichever way it was sent
+CREATE UNIQUE INDEX idx_contact_requests_pending_pair ON contact_requests (
+    LEAST(requester_id, addressee_id), GREATEST(requester_id, addressee_id)
+) WHERE status = 'pending';
+
+CREATE TABLE contacts (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    contact_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT now(),
+    PRIMARY KEY (user_id, contact_id)
+);
+
+CREATE INDEX idx_contacts_contact_id ON contacts(contact_id);
+
+ALTER TABLE users ADD COLUMN dms_from_contacts_only BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,5 @@
+ALTER TABLE users
+    DROP COLUMN IF EXISTS read_receipts_visibility,
+    DROP COLUMN IF EXISTS profile_photo_visibility,
+    DROP COLUMN IF EXISTS online_visibility,
+    DROP COLUMN IF EXISTS last_seen_visibility;
@@ -0,0 +1,9 @@
+ALTER TABLE users
+    ADD COLUMN last_seen_visibility VARCHAR(10) NOT NULL DEFAULT 'everyone'
+        CHECK (last_seen_visibility IN ('everyone', 'contacts', 'nobody')),
+    ADD COLUMN online_visibility VARCHAR(10) NOT NULL DEFAULT 'everyone'
+        CHECK (online_visibility IN ('everyone', 'contacts', 'nobody')),
+    ADD COLUMN profile_photo_visibility VARCHAR(10) NOT NULL DEFAULT 'everyone'
+        CHECK (profile_photo_visibility IN ('everyone', 'contacts', 'nobody')),
+    ADD COLUMN read_receipts_visibility VARCHAR(10) NOT NULL DEFAULT 'everyone'
+        CHECK (read_receipts_visibility IN ('everyone', 'contacts', 'nobody'));
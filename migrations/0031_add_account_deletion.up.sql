@@ -0,0 +1,7 @@
+ALTER TABLE users
+    ADD COLUMN deletion_scheduled_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- Only accounts waiting for deletion are looked up by this column
+CREATE INDEX idx_users_deletion_scheduled_at ON users(deletion_scheduled_at)
+    WHERE deletion_scheduled_at IS NOT NULL;
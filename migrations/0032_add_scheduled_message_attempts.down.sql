@@ -0,0 +1,3 @@
+ALTER TABLE scheduled_messages
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS attempts;
@@ -0,0 +1,3 @@
+ALTER TABLE chat_rooms
+    DROP COLUMN IF EXISTS encryption_requested_by,
+    DROP COLUMN IF EXISTS requested_encryption_mode;
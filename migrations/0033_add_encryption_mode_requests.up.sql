@@ -0,0 +1,3 @@
+ALTER TABLE chat_rooms
+    ADD COLUMN requested_encryption_mode VARCHAR(20),
+    ADD COLUMN encryption_requested_by UUID REFERENCES users(id) ON DELETE SET NULL;
@@ -1,6 +1,7 @@
 package auth
 
 import (
+    "errors"
     "os"
     "time"
     "log"
@@ -10,6 +11,8 @@ import (
 
 type Claims struct {
     UserID string `json:"user_id"`
+    // SessionID is the refresh token family the access token was issued for
+    SessionID string `json:"sid,omitempty"`
     jwt.RegisteredClaims
 }
 
@@ -21,38 +24,65 @@ func getJWTSecret() []byte {
     return []byte(secret)
 }
 
-func getJWTExpiryDuration() time.Duration {
-    durationStr := os.Getenv("JWT_EXPIRES_IN")
+func getDuration(key, fallback string) time.Duration {
+    durationStr := os.Getenv(key)
     if durationStr == "" {
-        durationStr = "24h"
+        durationStr = fallback
     }
     duration, err := time.ParseDuration(durationStr)
     if err != nil {
-        log.Fatalf("Invalid JWT_EXPIRES_IN format: %v", err)
+        log.Fatalf("Invalid %s format: %v", key, err)
     }
     return duration
 }
 
-func GenerateJWT(userID uuid.UUID) (string, error) {
+// AccessTokenTTL is how long an access token is valid (JWT_EXPIRES_IN).
+func AccessTokenTTL() time.Duration {
+    return getDuration("JWT_EXPIRES_IN", "15m")
+}
+
+// RefreshTokenTTL is how long a refresh token is valid
+// (REFRESH_TOKEN_EXPIRES_IN). Every refresh issues a new one.
+func RefreshTokenTTL() time.Duration {
+    return getDuration("REFRESH_TOKEN_EXPIRES_IN", "720h")
+}
+
+var keys *KeyManager
+
+// UseKeyManager sets the keys GenerateJWT signs with and ParseJWT verifies
+// with.
+func UseKeyManager(m *KeyManager) {
+    keys = m
+}
+
+var errNoKeyManager = errors.New("no signing keys configured")
+
+// GenerateJWT issues an access token for a session and returns it with its
+// expiry.
+func GenerateJWT(userID, sessionID uuid.UUID) (string, time.Time, error) {
+    if keys == nil {
+        return "", time.Time{}, errNoKeyManager
+    }
+    now := time.Now()
+    expiresAt := now.Add(AccessTokenTTL())
     claims := Claims{
-        UserID: userID.String(),
+        UserID:    userID.String(),
+        SessionID: sessionID.String(),
         RegisteredClaims: jwt.RegisteredClaims{
-            ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+            Subject:   userID.String(),
+            IssuedAt:  jwt.NewNumericDate(now),
+            ExpiresAt: jwt.NewNumericDate(expiresAt),
         },
     }
 
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    return token.SignedString(getJWTSecret())
+    signed, err := keys.sign(&claims)
+    return signed, expiresAt, err
 }
 
+// ParseJWT verifies a token's signature, expiry, issuer and audience.
 func ParseJWT(tokenStr string) (*Claims, error) {
-    token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
-        return getJWTSecret(), nil
-    })
-
-    if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-        return claims, nil
+    if keys == nil {
+        return nil, errNoKeyManager
     }
-
-    return nil, err
+    return keys.parse(tokenStr)
 }
@@ -0,0 +1,520 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"mozho_chat/pkg/encryption"
+)
+
+// Algorithms access tokens can be signed with.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	rsaKeyBits = 2048
+	// keyRetirementGrace keeps a retired key verifying a little longer than
+	// the tokens it signed, for instances that have not reloaded keys yet.
+	keyRetirementGrace = 5 * time.Minute
+	// minKeyReloadInterval limits reloads triggered by unknown key ids.
+	minKeyReloadInterval = 10 * time.Second
+)
+
+// KeyRecord is a signing key as it is stored. PrivateKey is a PKCS#8 PEM
+// encrypted with the manager's secret.
+type KeyRecord struct {
+	ID          string
+	Algorithm   string
+	PrivateKey  string
+	ActivatesAt time.Time
+	CreatedAt   time.Time
+}
+
+// KeyStore persists signing keys so every instance signs with the same key.
+type KeyStore interface {
+	LoadKeys(ctx context.Context) ([]KeyRecord, error)
+	SaveKey(ctx context.Context, key KeyRecord) error
+	DeleteKey(ctx context.Context, id string) error
+}
+
+// KeyManagerOptions configure how tokens are signed and keys rotated.
+type KeyManagerOptions struct {
+	// Algorithm new keys are created for, RS256 or EdDSA
+	Algorithm string
+	Issuer    string
+	Audience  string
+	// RotationInterval is how long a key signs before it is replaced
+	RotationInterval time.Duration
+	// PublishAhead is how long a new key is listed in the JWKS before it
+	// signs anything, so other services have fetched it by then
+	PublishAhead time.Duration
+	// TokenTTL is how long a replaced key keeps verifying tokens
+	TokenTTL time.Duration
+	// Secret encrypts private keys at rest
+	Secret string
+}
+
+// KeyManagerOptionsFromEnv reads JWT_ALGORITHM, JWT_ISSUER, JWT_AUDIENCE,
+// JWT_KEY_ROTATION_INTERVAL and JWT_KEY_PUBLISH_AHEAD. Private keys are
+// encrypted with JWT_SECRET.
+func KeyManagerOptionsFromEnv() KeyManagerOptions {
+	return KeyManagerOptions{
+		Algorithm:        getEnv("JWT_ALGORITHM", AlgorithmRS256),
+		Issuer:           getEnv("JWT_ISSUER", "mozho_chat"),
+		Audience:         getEnv("JWT_AUDIENCE", "mozho_chat"),
+		RotationInterval: getDuration("JWT_KEY_ROTATION_INTERVAL", "720h"),
+		PublishAhead:     getDuration("JWT_KEY_PUBLISH_AHEAD", "1h"),
+		TokenTTL:         AccessTokenTTL(),
+		Secret:           string(getJWTSecret()),
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+type signingKey struct {
+	id          string
+	alg         string
+	private     crypto.Signer
+	public      crypto.PublicKey
+	activatesAt time.Time
+}
+
+// KeyManager signs access tokens with the current key and verifies them
+// with any key that may still have unexpired tokens.
+type KeyManager struct {
+	store      KeyStore
+	opts       KeyManagerOptions
+	encryption encryption.EncryptionService
+	secret     string
+
+	mu       sync.RWMutex
+	keys     []*signingKey // oldest first
+	loadedAt time.Time
+}
+
+// NewKeyManager loads the stored keys, creating the first one if needed.
+func NewKeyManager(ctx context.Context, store KeyStore, opts KeyManagerOptions) (*KeyManager, error) {
+	if opts.Algorithm != AlgorithmRS256 && opts.Algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", opts.Algorithm)
+	}
+	if opts.Secret == "" {
+		return nil, errors.New("a secret is required to encrypt signing keys")
+	}
+	if opts.RotationInterval <= opts.PublishAhead {
+		return nil, errors.New("key rotation interval must be longer than the publish ahead time")
+	}
+
+	sum := sha256.Sum256([]byte(opts.Secret))
+	m := &KeyManager{
+		store:      store,
+		opts:       opts,
+		encryption: encryption.NewEncryptionService(),
+		secret:     string(sum[:]),
+	}
+	if err := m.Rotate(ctx); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StartKeyRotation picks up keys created by other instances and rotates the
+// signing key when it is due, every interval until ctx is done.
+func StartKeyRotation(ctx context.Context, manager *KeyManager, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := manager.Rotate(ctx); err != nil {
+					log.Printf("key rotation: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Rotate reloads the keys, publishes a new key when the current one is due
+// for replacement and deletes keys that can no longer have valid tokens.
+//
+// Instances rotating at the same moment may both add a key. That is
+// harmless: they all settle on the same one on their next reload.
+func (m *KeyManager) Rotate(ctx context.Context) error {
+	if err := m.Reload(ctx); err != nil {
+		return err
+	}
+	now := time.Now()
+
+	m.mu.RLock()
+	var latest *signingKey
+	if len(m.keys) > 0 {
+		latest = m.keys[len(m.keys)-1]
+	}
+	m.mu.RUnlock()
+
+	switch {
+	case latest == nil:
+		// Nothing to publish ahead of; the first key signs right away
+		if err := m.addKey(ctx, now); err != nil {
+			return err
+		}
+	case latest.activatesAt.After(now):
+		// The next key is already published
+	case latest.alg != m.opts.Algorithm,
+		!now.Before(latest.activatesAt.Add(m.opts.RotationInterval - m.opts.PublishAhead)):
+		if err := m.addKey(ctx, now.Add(m.opts.PublishAhead)); err != nil {
+			return err
+		}
+	}
+	return m.prune(ctx, now)
+}
+
+// Reload reads the keys from the store.
+func (m *KeyManager) Reload(ctx context.Context) error {
+	records, err := m.store.LoadKeys(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make([]*signingKey, 0, len(records))
+	for _, record := range records {
+		key, err := m.decodeKey(record)
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", record.ID, err)
+		}
+		keys = append(keys, key)
+	}
+	sortKeys(keys)
+
+	m.mu.Lock()
+	m.keys = keys
+	m.loadedAt = time.Now()
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *KeyManager) addKey(ctx context.Context, activatesAt time.Time) error {
+	private, err := generatePrivateKey(m.opts.Algorithm)
+	if err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+	encrypted, err := m.encryption.EncryptWithAES(string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), m.secret)
+	if err != nil {
+		return err
+	}
+
+	record := KeyRecord{
+		ID:          uuid.NewString(),
+		Algorithm:   m.opts.Algorithm,
+		PrivateKey:  encrypted,
+		ActivatesAt: activatesAt,
+		CreatedAt:   time.Now(),
+	}
+	if err := m.store.SaveKey(ctx, record); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, &signingKey{
+		id:          record.ID,
+		alg:         record.Algorithm,
+		private:     private,
+		public:      private.Public(),
+		activatesAt: activatesAt,
+	})
+	sortKeys(m.keys)
+	return nil
+}
+
+func (m *KeyManager) prune(ctx context.Context, now time.Time) error {
+	m.mu.Lock()
+	var expired []string
+	kept := m.keys[:0]
+	for i, key := range m.keys {
+		if m.expired(i, now) {
+			expired = append(expired, key.id)
+			continue
+		}
+		kept = append(kept, key)
+	}
+	m.keys = kept
+	m.mu.Unlock()
+
+	for _, id := range expired {
+		if err := m.store.DeleteKey(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expired reports whether the key at i was replaced long enough ago that
+// every token it signed has expired. Callers hold mu.
+func (m *KeyManager) expired(i int, now time.Time) bool {
+	if i+1 >= len(m.keys) {
+		return false
+	}
+	replacedAt := m.keys[i+1].activatesAt
+	if replacedAt.After(now) {
+		return false
+	}
+	return now.After(replacedAt.Add(m.opts.TokenTTL + keyRetirementGrace))
+}
+
+// signingKey returns the newest key that has been published long enough.
+func (m *KeyManager) signingKey(now time.Time) (*signingKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i := len(m.keys) - 1; i >= 0; i-- {
+		if !m.keys[i].activatesAt.After(now) {
+			return m.keys[i], nil
+		}
+	}
+	return nil, errors.New("no active signing key")
+}
+
+// verificationKey looks a key up by id, reloading once in a while in case
+// another instance created it.
+func (m *KeyManager) verificationKey(ctx context.Context, kid string) (*signingKey, error) {
+	if key := m.findKey(kid); key != nil {
+		return key, nil
+	}
+
+	m.mu.RLock()
+	stale := time.Since(m.loadedAt) > minKeyReloadInterval
+	m.mu.RUnlock()
+	if stale {
+		if err := m.Reload(ctx); err != nil {
+			return nil, err
+		}
+		if key := m.findKey(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (m *KeyManager) findKey(kid string) *signingKey {
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for i, key := range m.keys {
+		if key.id == kid && !m.expired(i, now) {
+			return key
+		}
+	}
+	return nil
+}
+
+func (m *KeyManager) sign(claims *Claims) (string, error) {
+	key, err := m.signingKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+	claims.Issuer = m.opts.Issuer
+	claims.Audience = jwt.ClaimStrings{m.opts.Audience}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.alg), claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+func (m *KeyManager) parse(tokenStr string) (*Claims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no key id")
+		}
+		key, err := m.verificationKey(context.Background(), kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("key %q does not sign with %s", kid, t.Method.Alg())
+		}
+		return key.public, nil
+	},
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}),
+		jwt.WithIssuer(m.opts.Issuer),
+		jwt.WithAudience(m.opts.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS lists the public keys tokens may be verified with, including a key
+// that is published but not signing yet.
+func (m *KeyManager) JWKS() JWKSet {
+	now := time.Now()
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JWKSet{Keys: make([]JWK, 0, len(m.keys))}
+	for i, key := range m.keys {
+		if m.expired(i, now) {
+			continue
+		}
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.alg}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func (m *KeyManager) decodeKey(record KeyRecord) (*signingKey, error) {
+	decrypted, err := m.encryption.DecryptWithAES(record.PrivateKey, m.secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt private key; was JWT_SECRET changed? %w", err)
+	}
+	block, _ := pem.Decode([]byte(decrypted))
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var private crypto.Signer
+	switch record.Algorithm {
+	case AlgorithmRS256:
+		private, _ = parsed.(*rsa.PrivateKey)
+	case AlgorithmEdDSA:
+		if key, ok := parsed.(ed25519.PrivateKey); ok {
+			private = key
+		}
+	}
+	if private == nil {
+		return nil, fmt.Errorf("private key does not match algorithm %s", record.Algorithm)
+	}
+	return &signingKey{
+		id:          record.ID,
+		alg:         record.Algorithm,
+		private:     private,
+		public:      private.Public(),
+		activatesAt: record.ActivatesAt,
+	}, nil
+}
+
+func generatePrivateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case AlgorithmRS256:
+		return rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	case AlgorithmEdDSA:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	}
+	return nil, fmt.Errorf("unsupported JWT algorithm %q", alg)
+}
+
+func sortKeys(keys []*signingKey) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		if !keys[i].activatesAt.Equal(keys[j].activatesAt) {
+			return keys[i].activatesAt.Before(keys[j].activatesAt)
+		}
+		return keys[i].id < keys[j].id
+	})
+}
+
+type memoryKeyStore struct {
+	mu   sync.Mutex
+	keys map[string]KeyRecord
+}
+
+// NewMemoryKeyStore keeps keys in memory. Keys are lost on restart, so it is
+// only suitable for tests and tools that run a single instance.
+func NewMemoryKeyStore() KeyStore {
+	return &memoryKeyStore{keys: make(map[string]KeyRecord)}
+}
+
+func (s *memoryKeyStore) LoadKeys(ctx context.Context) ([]KeyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]KeyRecord, 0, len(s.keys))
+	for _, record := range s.keys {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (s *memoryKeyStore) SaveKey(ctx context.Context, key KeyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.ID] = key
+	return nil
+}
+
+func (s *memoryKeyStore) DeleteKey(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.keys, id)
+	return nil
+}
@@ -0,0 +1,494 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcMetadataTTL is how long discovered provider metadata is trusted
+	oidcMetadataTTL = 24 * time.Hour
+	// oidcMaxResponse caps what is read from a provider
+	oidcMaxResponse = 1 << 20
+	oidcClockSkew   = time.Minute
+)
+
+// OIDCProviderConfig is an OpenID Connect identity provider users can log
+// in with.
+type OIDCProviderConfig struct {
+	// Name identifies the provider in URLs, e.g. "corp"
+	Name         string
+	DisplayName  string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// AllowedDomains limits which email domains may sign up or link an
+	// account through the provider. Empty allows any
+	AllowedDomains []string
+}
+
+// AllowsEmail reports whether the provider may vouch for email.
+func (cfg OIDCProviderConfig) AllowsEmail(email string) bool {
+	if len(cfg.AllowedDomains) == 0 {
+		return true
+	}
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	for _, allowed := range cfg.AllowedDomains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// OIDCProviderConfigsFromEnv reads OIDC_PROVIDERS, a comma separated list
+// of provider names, and for each name N: OIDC_N_ISSUER, OIDC_N_CLIENT_ID,
+// OIDC_N_CLIENT_SECRET, OIDC_N_DISPLAY_NAME, OIDC_N_SCOPES and
+// OIDC_N_ALLOWED_DOMAINS.
+func OIDCProviderConfigsFromEnv() []OIDCProviderConfig {
+	var configs []OIDCProviderConfig
+	for _, name := range splitList(os.Getenv("OIDC_PROVIDERS")) {
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		configs = append(configs, OIDCProviderConfig{
+			Name:           name,
+			DisplayName:    getEnv(prefix+"DISPLAY_NAME", name),
+			Issuer:         os.Getenv(prefix + "ISSUER"),
+			ClientID:       os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret:   os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:         strings.Fields(getEnv(prefix+"SCOPES", "openid email profile")),
+			AllowedDomains: splitList(os.Getenv(prefix + "ALLOWED_DOMAINS")),
+		})
+	}
+	return configs
+}
+
+// OIDCProviders are the identity providers configured for single sign-on.
+type OIDCProviders struct {
+	// RedirectURL is the page of the web app providers send users back to
+	RedirectURL string
+	providers   map[string]*OIDCProvider
+	order       []string
+}
+
+func NewOIDCProviders(redirectURL string, configs []OIDCProviderConfig, client *http.Client) *OIDCProviders {
+	p := &OIDCProviders{RedirectURL: redirectURL, providers: map[string]*OIDCProvider{}}
+	for _, cfg := range configs {
+		if _, dup := p.providers[cfg.Name]; dup {
+			continue
+		}
+		p.providers[cfg.Name] = NewOIDCProvider(cfg, client)
+		p.order = append(p.order, cfg.Name)
+	}
+	return p
+}
+
+// OIDCProvidersFromEnv configures providers from the environment. Users
+// are sent back to OIDC_REDIRECT_URL, by default APP_URL/oidc/callback.
+func OIDCProvidersFromEnv() *OIDCProviders {
+	redirectURL := os.Getenv("OIDC_REDIRECT_URL")
+	if redirectURL == "" {
+		redirectURL = getEnv("APP_URL", "http://localhost:3000") + "/oidc/callback"
+	}
+	return NewOIDCProviders(redirectURL, OIDCProviderConfigsFromEnv(), &http.Client{Timeout: 10 * time.Second})
+}
+
+// Get returns the named provider, or nil.
+func (p *OIDCProviders) Get(name string) *OIDCProvider {
+	if p == nil {
+		return nil
+	}
+	return p.providers[name]
+}
+
+// List returns the providers in the order they were configured.
+func (p *OIDCProviders) List() []*OIDCProvider {
+	if p == nil {
+		return nil
+	}
+	list := make([]*OIDCProvider, 0, len(p.order))
+	for _, name := range p.order {
+		list = append(list, p.providers[name])
+	}
+	return list
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcKey struct {
+	alg    string
+	public crypto.PublicKey
+}
+
+// OIDCProvider logs users in with the authorization code flow and PKCE.
+// Its metadata and signing keys are discovered from the issuer.
+type OIDCProvider struct {
+	cfg    OIDCProviderConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	meta          *oidcMetadata
+	metaFetchedAt time.Time
+	keys          map[string]oidcKey
+	keysFetchedAt time.Time
+}
+
+func NewOIDCProvider(cfg OIDCProviderConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &OIDCProvider{cfg: cfg, client: client}
+}
+
+func (p *OIDCProvider) Config() OIDCProviderConfig {
+	return p.cfg
+}
+
+// OIDCLogin holds the secrets of one login attempt. State and Nonce tie
+// the provider's answer to the attempt; CodeVerifier proves we started it.
+type OIDCLogin struct {
+	State         string
+	Nonce         string
+	CodeVerifier  string
+	CodeChallenge string
+}
+
+func NewOIDCLogin() (*OIDCLogin, error) {
+	login := &OIDCLogin{}
+	for _, field := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		*field = base64.RawURLEncoding.EncodeToString(b)
+	}
+	login.CodeChallenge = PKCEChallenge(login.CodeVerifier)
+	return login, nil
+}
+
+// PKCEChallenge derives the S256 code challenge for a verifier (RFC 7636).
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// HashOIDCState is how a login's state is stored, so a database leak does
+// not hand out logins in progress.
+func HashOIDCState(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return fmt.Sprintf("%x", sum)
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", endpoint, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, oidcMaxResponse)).Decode(v)
+}
+
+// metadata returns the provider's discovery document, fetching it when
+// missing or stale.
+func (p *OIDCProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil && time.Since(p.metaFetchedAt) < oidcMetadataTTL {
+		return p.meta, nil
+	}
+
+	var meta oidcMetadata
+	endpoint := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, endpoint, &meta); err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", p.cfg.Name, err)
+	}
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("discovering %s: issuer is %q, expected %q", p.cfg.Name, meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("discovering %s: metadata is incomplete", p.cfg.Name)
+	}
+	p.meta, p.metaFetchedAt = &meta, time.Now()
+	return p.meta, nil
+}
+
+// verificationKey returns the provider key with id kid, fetching the
+// provider's JWKS again when the key is not known yet.
+func (p *OIDCProvider) verificationKey(ctx context.Context, meta *oidcMetadata, kid string) (oidcKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if p.keys != nil && time.Since(p.keysFetchedAt) < minKeyReloadInterval {
+		return oidcKey{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := p.getJSON(ctx, meta.JWKSURI, &set); err != nil {
+		return oidcKey{}, fmt.Errorf("fetching keys of %s: %w", p.cfg.Name, err)
+	}
+	keys := map[string]oidcKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		public, alg, err := jwk.publicKey()
+		if err != nil {
+			// Providers may publish key types we do not use
+			continue
+		}
+		keys[jwk.Kid] = oidcKey{alg: alg, public: public}
+	}
+	p.keys, p.keysFetchedAt = keys, time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return oidcKey{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a key by id. Tokens without a key id are accepted when
+// the provider has a single key.
+func (p *OIDCProvider) lookupKey(kid string) (oidcKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// publicKey parses a provider's signing key. alg is empty when the key
+// does not name its algorithm.
+func (k JWK) publicKey() (crypto.PublicKey, string, error) {
+	decode := func(s string) *big.Int {
+		b, err := DecodeBase64URL(s)
+		if err != nil || len(b) == 0 {
+			return nil
+		}
+		return new(big.Int).SetBytes(b)
+	}
+	switch k.Kty {
+	case "RSA":
+		n, e := decode(k.N), decode(k.E)
+		if n == nil || e == nil || n.BitLen() < 2048 || !e.IsInt64() {
+			return nil, "", errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, k.Alg, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, "", fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, y := decode(k.X), decode(k.Y)
+		if x == nil || y == nil || !elliptic.P256().IsOnCurve(x, y) {
+			return nil, "", errors.New("invalid EC key")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, k.Alg, nil
+	case "OKP":
+		x, err := DecodeBase64URL(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, "", errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), k.Alg, nil
+	}
+	return nil, "", fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// AuthorizationURL is where the user's browser is sent to log in.
+func (p *OIDCProvider) AuthorizationURL(ctx context.Context, redirectURI string, login *OIDCLogin) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", login.State)
+	q.Set("nonce", login.Nonce)
+	q.Set("code_challenge", login.CodeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// OIDCClaims are the claims of a verified ID token.
+type OIDCClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string    `json:"nonce"`
+	AuthorizedParty   string    `json:"azp"`
+	Email             string    `json:"email"`
+	EmailVerified     looseBool `json:"email_verified"`
+	Name              string    `json:"name"`
+	PreferredUsername string    `json:"preferred_username"`
+}
+
+// HasVerifiedEmail reports whether the provider vouches for the email.
+func (c *OIDCClaims) HasVerifiedEmail() bool {
+	return c.Email != "" && bool(c.EmailVerified)
+}
+
+// looseBool accepts the "true" some providers send instead of true.
+type looseBool bool
+
+func (b *looseBool) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case bool:
+		*b = looseBool(v)
+	case string:
+		*b = looseBool(v == "true")
+	}
+	return nil
+}
+
+// Exchange trades an authorization code for the user's verified claims.
+func (p *OIDCProvider) Exchange(ctx context.Context, redirectURI, code string, login *OIDCLogin) (*OIDCClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {login.CodeVerifier},
+		"client_id":     {p.cfg.ClientID},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, oidcMaxResponse)).Decode(&body); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("invalid token response from %s: %w", p.cfg.Name, err)
+	}
+	if res.StatusCode != http.StatusOK || body.Error != "" {
+		if body.Error == "" {
+			body.Error = res.Status
+		}
+		return nil, fmt.Errorf("%s refused the login: %s %s", p.cfg.Name, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%s did not return an ID token", p.cfg.Name)
+	}
+	return p.VerifyIDToken(ctx, body.IDToken, login.Nonce)
+}
+
+// VerifyIDToken checks an ID token's signature, issuer, audience, expiry
+// and nonce (OpenID Connect Core §3.1.3.7).
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwt.ParseWithClaims(rawIDToken, &OIDCClaims{}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := p.verificationKey(ctx, meta, kid)
+		if err != nil {
+			return nil, err
+		}
+		if key.alg != "" && t.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("key %q does not sign with %s", kid, t.Method.Alg())
+		}
+		return key.public, nil
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	claims, ok := token.Claims.(*OIDCClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid ID token")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: no subject")
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, errors.New("invalid ID token: nonce does not match")
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, errors.New("invalid ID token: issued to another client")
+	}
+	return claims, nil
+}
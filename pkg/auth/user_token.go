@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidUserToken is returned for email verification and password
+// reset tokens that are forged, mangled, expired or meant for something else.
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+// SignUserToken returns the token emailed to a user for a single-use action.
+// It carries the id of the stored token, which records whether it was used.
+func SignUserToken(id uuid.UUID, purpose string, expiresAt time.Time) string {
+	payload := purpose + "." + id.String() + "." + strconv.FormatInt(expiresAt.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(userTokenMAC(payload))
+}
+
+// VerifyUserToken checks the signature, purpose and expiry of a token and
+// returns the id of the stored token.
+func VerifyUserToken(token, purpose string, now time.Time) (uuid.UUID, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, userTokenMAC(string(payload))) {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 || parts[0] != purpose {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	return id, nil
+}
+
+func userTokenMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, getJWTSecret())
+	mac.Write([]byte("user-token\x00" + payload))
+	return mac.Sum(nil)
+}
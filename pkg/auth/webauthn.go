@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// COSE algorithms accepted for passkeys, in order of preference.
+const (
+	COSEAlgEdDSA = -8
+	COSEAlgES256 = -7
+	COSEAlgRS256 = -257
+)
+
+// Authenticator data flags (WebAuthn §6.1).
+const (
+	flagUserPresent    = 0x01
+	flagUserVerified   = 0x04
+	flagBackupEligible = 0x08
+	flagBackedUp       = 0x10
+	flagAttestedData   = 0x40
+)
+
+// ErrSignCountRegressed means an authenticator reported a signature count
+// that did not go up, which suggests the credential was cloned.
+var ErrSignCountRegressed = errors.New("authenticator signature counter went backwards; the credential may have been cloned")
+
+// WebAuthnConfig identifies this relying party to authenticators.
+type WebAuthnConfig struct {
+	// RPID is the domain credentials are scoped to, e.g. "chat.example.com"
+	RPID   string
+	RPName string
+	// Origins are the web origins ceremonies may come from
+	Origins []string
+}
+
+// WebAuthnConfigFromEnv reads WEBAUTHN_RP_ID, WEBAUTHN_RP_NAME and
+// WEBAUTHN_ORIGINS (comma separated).
+func WebAuthnConfigFromEnv() WebAuthnConfig {
+	cfg := WebAuthnConfig{
+		RPID:   getEnv("WEBAUTHN_RP_ID", "localhost"),
+		RPName: getEnv("WEBAUTHN_RP_NAME", "mozho"),
+	}
+	for _, origin := range strings.Split(getEnv("WEBAUTHN_ORIGINS", "http://localhost:3000"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			cfg.Origins = append(cfg.Origins, origin)
+		}
+	}
+	return cfg
+}
+
+// NewWebAuthnChallenge returns a random challenge for a ceremony.
+func NewWebAuthnChallenge() ([]byte, error) {
+	challenge := make([]byte, 32)
+	_, err := rand.Read(challenge)
+	return challenge, err
+}
+
+// WebAuthnCredential is a verified new credential, ready to be stored.
+type WebAuthnCredential struct {
+	ID []byte
+	// PublicKey is the COSE encoded key the authenticator signs with
+	PublicKey      []byte
+	Algorithm      int64
+	SignCount      uint32
+	AAGUID         []byte
+	BackupEligible bool
+	BackedUp       bool
+}
+
+// WebAuthnAssertion is the outcome of a verified login.
+type WebAuthnAssertion struct {
+	SignCount    uint32
+	UserVerified bool
+	BackedUp     bool
+}
+
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// WebAuthnChallengeFromClientData returns the challenge a response claims
+// to answer, so the ceremony it belongs to can be looked up. The response
+// still has to be verified against it.
+func WebAuthnChallengeFromClientData(clientDataJSON []byte) ([]byte, error) {
+	var cd clientData
+	if err := json.Unmarshal(clientDataJSON, &cd); err != nil {
+		return nil, fmt.Errorf("invalid client data: %w", err)
+	}
+	return DecodeBase64URL(cd.Challenge)
+}
+
+// DecodeBase64URL decodes the unpadded base64url WebAuthn uses in JSON,
+// tolerating padding.
+func DecodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+func (cfg WebAuthnConfig) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return fmt.Errorf("invalid client data: %w", err)
+	}
+	if cd.Type != ceremony {
+		return fmt.Errorf("client data is for %q, not %q", cd.Type, ceremony)
+	}
+	got, err := DecodeBase64URL(cd.Challenge)
+	if err != nil || !bytes.Equal(got, challenge) {
+		return errors.New("challenge does not match")
+	}
+	if cd.CrossOrigin {
+		return errors.New("cross-origin ceremonies are not allowed")
+	}
+	for _, origin := range cfg.Origins {
+		if cd.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed", cd.Origin)
+}
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	// Only present at registration
+	aaguid       []byte
+	credentialID []byte
+	publicKey    []byte
+}
+
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+	ad := &authenticatorData{
+		rpIDHash:  data[:32],
+		flags:     data[32],
+		signCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	rest := data[37:]
+	if ad.flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data is too short")
+		}
+		ad.aaguid = rest[:16]
+		idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if len(rest) < idLen {
+			return nil, errors.New("credential id is truncated")
+		}
+		ad.credentialID = rest[:idLen]
+		rest = rest[idLen:]
+
+		_, after, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, fmt.Errorf("invalid credential public key: %w", err)
+		}
+		ad.publicKey = rest[:len(rest)-len(after)]
+	}
+	return ad, nil
+}
+
+func (cfg WebAuthnConfig) verifyAuthenticatorData(ad *authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(cfg.RPID))
+	if !bytes.Equal(ad.rpIDHash, rpIDHash[:]) {
+		return errors.New("credential is for another relying party")
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return errors.New("user presence was not confirmed")
+	}
+	if requireUV && ad.flags&flagUserVerified == 0 {
+		return errors.New("user verification is required")
+	}
+	return nil
+}
+
+// VerifyWebAuthnRegistration checks a navigator.credentials.create()
+// response. Attestation statements are not verified: options ask for
+// "none", so the authenticator's make and model are not vouched for.
+func (cfg WebAuthnConfig) VerifyWebAuthnRegistration(challenge, clientDataJSON, attestationObject []byte, requireUV bool) (*WebAuthnCredential, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %w", err)
+	}
+	att, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, errors.New("invalid attestation object")
+	}
+	rawAuthData, ok := att["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("attestation object has no authenticator data")
+	}
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyAuthenticatorData(ad, requireUV); err != nil {
+		return nil, err
+	}
+	if ad.credentialID == nil {
+		return nil, errors.New("no credential in the attestation")
+	}
+	if len(ad.credentialID) > 1023 {
+		return nil, errors.New("credential id is too long")
+	}
+
+	_, alg, err := parseCOSEKey(ad.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &WebAuthnCredential{
+		ID:             append([]byte(nil), ad.credentialID...),
+		PublicKey:      append([]byte(nil), ad.publicKey...),
+		Algorithm:      alg,
+		SignCount:      ad.signCount,
+		AAGUID:         append([]byte(nil), ad.aaguid...),
+		BackupEligible: ad.flags&flagBackupEligible != 0,
+		BackedUp:       ad.flags&flagBackedUp != 0,
+	}, nil
+}
+
+// VerifyWebAuthnAssertion checks a navigator.credentials.get() response
+// against a stored credential. storedSignCount is the last counter seen;
+// a counter that does not go up returns ErrSignCountRegressed.
+// Authenticators that do not count always report zero and are let through.
+func (cfg WebAuthnConfig) VerifyWebAuthnAssertion(challenge, publicKey []byte, storedSignCount uint32, clientDataJSON, authenticatorData, signature []byte, requireUV bool) (*WebAuthnAssertion, error) {
+	if err := cfg.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return nil, err
+	}
+	ad, err := parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.verifyAuthenticatorData(ad, requireUV); err != nil {
+		return nil, err
+	}
+
+	key, alg, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authenticatorData...), clientDataHash[:]...)
+	if err := verifyCOSESignature(key, alg, signed, signature); err != nil {
+		return nil, err
+	}
+
+	if (ad.signCount != 0 || storedSignCount != 0) && ad.signCount <= storedSignCount {
+		return nil, ErrSignCountRegressed
+	}
+	return &WebAuthnAssertion{
+		SignCount:    ad.signCount,
+		UserVerified: ad.flags&flagUserVerified != 0,
+		BackedUp:     ad.flags&flagBackedUp != 0,
+	}, nil
+}
+
+// parseCOSEKey reads a COSE_Key (RFC 9053) for one of the accepted
+// algorithms.
+func parseCOSEKey(data []byte) (crypto.PublicKey, int64, error) {
+	decoded, rest, err := decodeCBOR(data)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(rest) != 0 {
+		return nil, 0, errors.New("invalid public key: trailing data")
+	}
+	m, ok := decoded.(map[any]any)
+	if !ok {
+		return nil, 0, errors.New("invalid public key")
+	}
+	kty, _ := m[int64(1)].(int64)
+	alg, _ := m[int64(3)].(int64)
+	param := func(label int64) []byte {
+		b, _ := m[label].([]byte)
+		return b
+	}
+
+	switch {
+	case alg == COSEAlgES256 && kty == 2:
+		if crv, _ := m[int64(-1)].(int64); crv != 1 {
+			return nil, 0, errors.New("ES256 keys must use P-256")
+		}
+		x, y := param(-2), param(-3)
+		if len(x) != 32 || len(y) != 32 {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, 0, errors.New("invalid P-256 key")
+		}
+		return key, alg, nil
+	case alg == COSEAlgEdDSA && kty == 1:
+		if crv, _ := m[int64(-1)].(int64); crv != 6 {
+			return nil, 0, errors.New("EdDSA keys must use Ed25519")
+		}
+		x := param(-2)
+		if len(x) != ed25519.PublicKeySize {
+			return nil, 0, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), alg, nil
+	case alg == COSEAlgRS256 && kty == 3:
+		n, e := param(-1), param(-2)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported key type %d with algorithm %d", kty, alg)
+}
+
+func verifyCOSESignature(key crypto.PublicKey, alg int64, data, signature []byte) error {
+	digest := sha256.Sum256(data)
+	var ok bool
+	switch alg {
+	case COSEAlgES256:
+		ok = ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), digest[:], signature)
+	case COSEAlgEdDSA:
+		ok = ed25519.Verify(key.(ed25519.PublicKey), data, signature)
+	case COSEAlgRS256:
+		ok = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package mailer
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Message is an email with a plain text body and an optional HTML
+// alternative.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer sends transactional email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv returns an SMTP mailer configured by SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD and MAIL_FROM. Without SMTP_HOST emails are
+// only logged, which is enough for local development.
+func NewFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Println("SMTP_HOST is not set; emails will be logged instead of sent")
+		return NewLogMailer()
+	}
+	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	if err != nil {
+		port = 587
+	}
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "mozho <no-reply@" + host + ">"
+	}
+	return NewSMTPMailer(SMTPConfig{
+		Host:     host,
+		Port:     port,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     from,
+	})
+}
+
+type logMailer struct{}
+
+// NewLogMailer logs the recipient and subject of every email instead of
+// sending it.
+func NewLogMailer() Mailer {
+	return logMailer{}
+}
+
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mailer: not sending %q to %s (SMTP is not configured)\n%s", msg.Subject, msg.To, msg.Text)
+	return nil
+}
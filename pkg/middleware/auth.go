@@ -1,13 +1,45 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"mozho_chat/pkg/auth"
 )
 
+// SessionRevocations reports sessions that were signed out before their
+// access tokens expired.
+type SessionRevocations interface {
+	IsSessionRevoked(sessionID string) (bool, error)
+}
+
+var revocations SessionRevocations
+
+// UseSessionRevocations makes AuthMiddleware reject access tokens of
+// revoked sessions.
+func UseSessionRevocations(r SessionRevocations) {
+	revocations = r
+}
+
+// PresenceTracker records which users are active.
+type PresenceTracker interface {
+	SetUserOnline(userID string, ttl time.Duration) error
+}
+
+// onlineTTL is how long a user counts as online after their last request.
+const onlineTTL = 2 * time.Minute
+
+var presence PresenceTracker
+
+// UsePresence makes AuthMiddleware mark users online while they make
+// requests.
+func UsePresence(p PresenceTracker) {
+	presence = p
+}
+
 // AuthMiddleware validates JWT tokens
 func AuthMiddleware() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
@@ -36,8 +68,30 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if claims.SessionID != "" && revocations != nil {
+			revoked, err := revocations.IsSessionRevoked(claims.SessionID)
+			if err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{"error": "session check unavailable"})
+				c.Abort()
+				return
+			}
+			if revoked {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+				c.Abort()
+				return
+			}
+		}
+
+		if presence != nil {
+			// Presence is best effort; a failed write must not fail the request
+			if err := presence.SetUserOnline(claims.UserID, onlineTTL); err != nil {
+				log.Printf("failed to record presence of user %s: %v", claims.UserID, err)
+			}
+		}
+
 		// Set user ID in context
 		c.Set("user_id", claims.UserID)
+		c.Set("session_id", claims.SessionID)
 		c.Next()
 	})
 }
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// IdempotencyHeader is the request header clients use to make a POST safe to retry.
+	IdempotencyHeader = "Idempotency-Key"
+	// MaxJSONBodySize is the body limit for routes that only take JSON.
+	MaxJSONBodySize = 1 << 20
+
+	// idempotencyFormMemory is how much of a multipart body is kept in
+	// memory while fingerprinting it; the rest goes to temporary files
+	idempotencyFormMemory = 32 << 20
+
+	idempotencyKeyMaxLength = 255
+	idempotencyLockTTL      = 5 * time.Minute
+	idempotencyRecordTTL    = 24 * time.Hour
+)
+
+// IdempotencyStore persists idempotency records between requests.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(key, value string, ttl time.Duration) (bool, error)
+	GetIdempotencyRecord(key string) (string, error)
+	SaveIdempotencyRecord(key, value string, ttl time.Duration) error
+	DeleteIdempotencyKey(key string) error
+}
+
+var idempotencyStore IdempotencyStore
+
+// UseIdempotencyStore enables IdempotencyMiddleware. Without a store it lets
+// every request through.
+func UseIdempotencyStore(s IdempotencyStore) {
+	idempotencyStore = s
+}
+
+type idempotencyRecord struct {
+	Completed   bool   `json:"completed"`
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware replays the stored response when a POST request is
+// retried with the same Idempotency-Key. It must run after AuthMiddleware:
+// keys are scoped to the authenticated user, so a retry made after a token
+// refresh still matches. Reusing a key for a different request is rejected.
+// Requests without the header pass through untouched.
+//
+// The body is read to tell retries apart from new requests, so maxBodySize
+// should be the largest body the route's handlers accept. Multipart forms
+// are compared by their fields and file contents, since the boundary
+// changes on every retry.
+func IdempotencyMiddleware(maxBodySize int64) gin.HandlerFunc {
+	return gin.HandlerFunc(func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		userID := c.GetString("user_id")
+		store := idempotencyStore
+		if c.Request.Method != http.MethodPost || key == "" || userID == "" || store == nil {
+			c.Next()
+			return
+		}
+		if len(key) > idempotencyKeyMaxLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
+		body, err := requestFingerprint(c.Request)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body is too large"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+			c.Abort()
+			return
+		}
+
+		storeKey := hashParts(userID, key)
+		fingerprint := hashParts(c.Request.Method, c.Request.URL.Path, body)
+
+		pending, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+		reserved, err := store.ReserveIdempotencyKey(storeKey, string(pending), idempotencyLockTTL)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "idempotency check unavailable"})
+			c.Abort()
+			return
+		}
+		if !reserved {
+			replayIdempotentResponse(c, store, storeKey, fingerprint)
+			return
+		}
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		// Server errors are not remembered so the client can retry them
+		if writer.Status() >= http.StatusInternalServerError {
+			store.DeleteIdempotencyKey(storeKey)
+			return
+		}
+
+		record, _ := json.Marshal(idempotencyRecord{
+			Completed:   true,
+			Fingerprint: fingerprint,
+			Status:      writer.Status(),
+			ContentType: writer.Header().Get("Content-Type"),
+			Body:        writer.body.Bytes(),
+		})
+		store.SaveIdempotencyRecord(storeKey, string(record), idempotencyRecordTTL)
+	})
+}
+
+func replayIdempotentResponse(c *gin.Context, store IdempotencyStore, storeKey, fingerprint string) {
+	raw, err := store.GetIdempotencyRecord(storeKey)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "idempotency check unavailable"})
+		c.Abort()
+		return
+	}
+
+	var record idempotencyRecord
+	if raw == "" || json.Unmarshal([]byte(raw), &record) != nil || !record.Completed {
+		c.JSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+		c.Abort()
+		return
+	}
+	if record.Fingerprint != fingerprint {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+		c.Abort()
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.Status, record.ContentType, record.Body)
+	c.Abort()
+}
+
+// requestFingerprint hashes what the handler will read from the request. A
+// multipart form is parsed here, which later ParseMultipartForm calls reuse;
+// any other body is read and put back.
+func requestFingerprint(r *http.Request) (string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		return hashParts(string(body)), nil
+	}
+
+	if err := r.ParseMultipartForm(idempotencyFormMemory); err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, name := range sortedKeys(r.MultipartForm.Value) {
+		for _, value := range r.MultipartForm.Value[name] {
+			parts = append(parts, "field", name, value)
+		}
+	}
+	for _, name := range sortedKeys(r.MultipartForm.File) {
+		for _, header := range r.MultipartForm.File[name] {
+			digest, err := fileDigest(header)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, "file", name, header.Filename, digest)
+		}
+	}
+	return hashParts(parts...), nil
+}
+
+func fileDigest(header *multipart.FileHeader) (string, error) {
+	file, err := header.Open()
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func hashParts(parts ...string) string {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
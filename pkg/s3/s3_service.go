@@ -24,6 +24,11 @@ import (
 // Service interface for S3 operations
 type Service interface {
 	UploadFile(file *multipart.FileHeader, entityType, entityId, fileType string, isPublic bool) (*UploadResult, error)
+	UploadPhoto(buffer []byte, originalName, entityType, entityId, fileType string, isPublic bool, withThumbnail bool) (map[string]string, error)
+	UploadObject(key string, body io.ReadSeeker, contentType string, isPublic bool) error
+	OpenFile(key string, isPublic bool) (io.ReadCloser, error)
+	GetPrivateSignedUrl(key string, expiresIn time.Duration) (string, error)
+	DeleteFile(key string, isPublic bool) error
 }
 
 type UploadResult struct {
@@ -111,12 +116,15 @@ func (s *S3Service) UploadFile(file *multipart.FileHeader, entityType, entityId,
 		return nil, err
 	}
 
-	return &UploadResult{
-		Key:      result["key"],
+	res := &UploadResult{
 		URL:      result["url"],
 		Size:     int64(len(buffer)),
 		MimeType: result["mimeType"],
-	}, nil
+	}
+	if !isPublic {
+		res.Key = result["key"] // only return key for private
+	}
+	return res, nil
 }
 
 func (s *S3Service) uploadFileBytes(buffer []byte, originalName, entityType, entityId, fileType string, isPublic bool) (map[string]string, error) {
@@ -154,13 +162,12 @@ func (s *S3Service) uploadFileBytes(buffer []byte, originalName, entityType, ent
 		"size":     fmt.Sprintf("%d", len(buffer)),
 	}
 
-	if isPublic {
-		delete(result, "key") // only return key for private
-	}
-
 	return result, nil
 }
 
+// UploadPhoto stores an image and, if asked, a 200x200 PNG thumbnail of it.
+// The result holds the "original" and "thumbnail" URLs and their object
+// keys as "originalKey" and "thumbnailKey", so callers can delete them later.
 func (s *S3Service) UploadPhoto(buffer []byte, originalName, entityType, entityId, fileType string, isPublic bool, withThumbnail bool) (map[string]string, error) {
 	original, err := s.uploadFileBytes(buffer, originalName, entityType, entityId, fileType, isPublic)
 	if err != nil {
@@ -168,7 +175,8 @@ func (s *S3Service) UploadPhoto(buffer []byte, originalName, entityType, entityI
 	}
 
 	result := map[string]string{
-		"original": original["url"],
+		"original":    original["url"],
+		"originalKey": original["key"],
 	}
 
 	if withThumbnail {
@@ -188,6 +196,7 @@ func (s *S3Service) UploadPhoto(buffer []byte, originalName, entityType, entityI
 			return nil, err
 		}
 		result["thumbnail"] = thumbRes["url"]
+		result["thumbnailKey"] = thumbRes["key"]
 	}
 
 	return result, nil
@@ -207,6 +216,55 @@ func (s *S3Service) GetPrivateSignedUrl(key string, expiresIn time.Duration) (st
 	return req.URL, nil
 }
 
+// UploadObject stores body under an exact key, for files the server
+// generates itself such as export archives.
+func (s *S3Service) UploadObject(key string, body io.ReadSeeker, contentType string, isPublic bool) error {
+	bucket := s.private
+	if isPublic {
+		bucket = s.public
+	}
+
+	_, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+// OpenFile streams an object from the public or private bucket. The caller
+// must close the returned reader.
+func (s *S3Service) OpenFile(key string, isPublic bool) (io.ReadCloser, error) {
+	bucket := s.private
+	if isPublic {
+		bucket = s.public
+	}
+
+	out, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// DeleteFile removes an object from the public or private bucket
+func (s *S3Service) DeleteFile(key string, isPublic bool) error {
+	bucket := s.private
+	if isPublic {
+		bucket = s.public
+	}
+
+	_, err := s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
 // simple MIME type detection
 func httpDetectMimeType(buffer []byte) string {
 	if len(buffer) >= 512 {
@@ -0,0 +1,237 @@
+package unfurl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultTimeout       = 5 * time.Second
+	defaultMaxPageBytes  = 1 << 20
+	defaultMaxImageBytes = 5 << 20
+	maxRedirects         = 3
+	userAgent            = "MozhoChatBot/1.0 (+link preview)"
+)
+
+// Options configures an HTTPFetcher. Zero values fall back to safe defaults.
+type Options struct {
+	Timeout       time.Duration
+	MaxPageBytes  int64
+	MaxImageBytes int64
+	// AllowPrivateNetworks disables the SSRF guard. Only tests should set it.
+	AllowPrivateNetworks bool
+}
+
+// HTTPFetcher fetches pages over HTTP. Every connection is checked after DNS
+// resolution, so hostnames pointing at loopback, private or link-local
+// addresses (including cloud metadata endpoints) are refused, redirects
+// included.
+type HTTPFetcher struct {
+	client        *http.Client
+	maxPageBytes  int64
+	maxImageBytes int64
+}
+
+func NewHTTPFetcher(opts Options) *HTTPFetcher {
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.MaxPageBytes == 0 {
+		opts.MaxPageBytes = defaultMaxPageBytes
+	}
+	if opts.MaxImageBytes == 0 {
+		opts.MaxImageBytes = defaultMaxImageBytes
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivateNetworks {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return ErrBlockedAddress
+			}
+			return nil
+		}
+	}
+
+	transport := &http.Transport{
+		// Never go through a proxy: the address check must see the real target
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   opts.Timeout,
+		ResponseHeaderTimeout: opts.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+
+	return &HTTPFetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return errors.New("unfurl: too many redirects")
+				}
+				return checkScheme(req.URL)
+			},
+		},
+		maxPageBytes:  opts.MaxPageBytes,
+		maxImageBytes: opts.MaxImageBytes,
+	}
+}
+
+func (f *HTTPFetcher) Fetch(ctx context.Context, rawURL string) (*Preview, error) {
+	body, contentType, finalURL, err := f.get(ctx, rawURL, "text/html,application/xhtml+xml", f.maxPageBytes)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	page := parseHTML(body, finalURL)
+	preview := &page.Preview
+	preview.URL = rawURL
+
+	// oEmbed fills in whatever the page itself did not declare
+	if page.OEmbedURL != "" && (preview.Title == "" || preview.ImageURL == "") {
+		if oembed, err := f.fetchOEmbed(ctx, page.OEmbedURL); err == nil {
+			mergeOEmbed(preview, oembed)
+		}
+	}
+	return preview, nil
+}
+
+func (f *HTTPFetcher) FetchImage(ctx context.Context, rawURL string) ([]byte, string, error) {
+	body, _, _, err := f.get(ctx, rawURL, "image/*", f.maxImageBytes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Trust the bytes rather than the Content-Type header
+	mimeType := http.DetectContentType(body)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, "", ErrNotImage
+	}
+	return body, mimeType, nil
+}
+
+type oembedResponse struct {
+	Title        string `json:"title"`
+	AuthorName   string `json:"author_name"`
+	ProviderName string `json:"provider_name"`
+	ThumbnailURL string `json:"thumbnail_url"`
+}
+
+func (f *HTTPFetcher) fetchOEmbed(ctx context.Context, rawURL string) (*oembedResponse, error) {
+	body, _, _, err := f.get(ctx, rawURL, "application/json", f.maxPageBytes)
+	if err != nil {
+		return nil, err
+	}
+	var res oembedResponse
+	if err := json.Unmarshal(body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func mergeOEmbed(preview *Preview, oembed *oembedResponse) {
+	if preview.Title == "" {
+		preview.Title = oembed.Title
+	}
+	if preview.Description == "" && oembed.AuthorName != "" {
+		preview.Description = oembed.AuthorName
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = oembed.ProviderName
+	}
+	if preview.ImageURL == "" {
+		preview.ImageURL = oembed.ThumbnailURL
+	}
+}
+
+// get performs a GET request and reads at most limit bytes of the body. It
+// returns the body, the Content-Type header and the URL after redirects.
+func (f *HTTPFetcher) get(ctx context.Context, rawURL, accept string, limit int64) ([]byte, string, *url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if err := checkScheme(u); err != nil {
+		return nil, "", nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", accept)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", nil, fmt.Errorf("unfurl: unexpected status %d", resp.StatusCode)
+	}
+	if resp.ContentLength > limit {
+		return nil, "", nil, ErrResponseTooLarge
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, "", nil, ErrResponseTooLarge
+	}
+	return body, resp.Header.Get("Content-Type"), resp.Request.URL, nil
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrUnsupportedURL
+	}
+	if u.User != nil {
+		return ErrUnsupportedURL
+	}
+	return nil
+}
+
+// isPublicIP reports whether ip is a globally routable unicast address.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		// Carrier-grade NAT (100.64.0.0/10) and the benchmarking range (198.18.0.0/15)
+		if ip4[0] == 100 && ip4[1]&0xc0 == 64 {
+			return false
+		}
+		if ip4[0] == 198 && ip4[1]&0xfe == 18 {
+			return false
+		}
+		if ip4[0] == 0 || ip4[0] >= 240 {
+			return false
+		}
+	}
+	return true
+}
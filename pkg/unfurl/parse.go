@@ -0,0 +1,129 @@
+package unfurl
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	maxTitleLength       = 300
+	maxDescriptionLength = 1000
+)
+
+type page struct {
+	Preview
+	OEmbedURL string
+}
+
+// parseHTML reads Open Graph tags from the document head, falling back to
+// <title> and the description meta tag. Relative URLs are resolved against base.
+func parseHTML(body []byte, base *url.URL) page {
+	var (
+		p           page
+		title       string
+		description string
+		inTitle     bool
+	)
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+loop:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break loop
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "title":
+				inTitle = title == ""
+			case "meta":
+				key := strings.ToLower(attr(tok, "property"))
+				if key == "" {
+					key = strings.ToLower(attr(tok, "name"))
+				}
+				content := strings.TrimSpace(attr(tok, "content"))
+				switch key {
+				case "og:title":
+					p.Title = content
+				case "og:description":
+					p.Description = content
+				case "og:site_name":
+					p.SiteName = content
+				case "og:image", "og:image:url":
+					if p.ImageURL == "" {
+						p.ImageURL = resolve(base, content)
+					}
+				case "description":
+					description = content
+				}
+			case "link":
+				if strings.EqualFold(attr(tok, "rel"), "alternate") &&
+					strings.EqualFold(attr(tok, "type"), "application/json+oembed") {
+					p.OEmbedURL = resolve(base, attr(tok, "href"))
+				}
+			case "body":
+				// Everything we need lives in the head
+				break loop
+			}
+
+		case html.TextToken:
+			if inTitle {
+				title = strings.TrimSpace(string(z.Text()))
+				inTitle = false
+			}
+
+		case html.EndTagToken:
+			if tok := z.Token(); tok.Data == "head" {
+				break loop
+			}
+		}
+	}
+
+	if p.Title == "" {
+		p.Title = title
+	}
+	if p.Description == "" {
+		p.Description = description
+	}
+	p.Title = truncate(p.Title, maxTitleLength)
+	p.Description = truncate(p.Description, maxDescriptionLength)
+	return p
+}
+
+func attr(tok html.Token, name string) string {
+	for _, a := range tok.Attr {
+		if strings.EqualFold(a.Key, name) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func resolve(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	return u.String()
+}
+
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max])
+}
@@ -0,0 +1,61 @@
+// Package unfurl fetches link preview metadata (Open Graph and oEmbed) for
+// URLs found in messages.
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrBlockedAddress   = errors.New("unfurl: address is not allowed")
+	ErrUnsupportedURL   = errors.New("unfurl: only http and https URLs are supported")
+	ErrResponseTooLarge = errors.New("unfurl: response is too large")
+	ErrNotHTML          = errors.New("unfurl: response is not an HTML page")
+	ErrNotImage         = errors.New("unfurl: response is not an image")
+)
+
+// Preview is the metadata shown for a link.
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	SiteName    string
+	ImageURL    string
+}
+
+// Empty reports whether the page had nothing worth showing.
+func (p *Preview) Empty() bool {
+	return p.Title == "" && p.Description == "" && p.ImageURL == ""
+}
+
+// Fetcher loads link metadata and preview images. The server uses
+// HTTPFetcher; tests can swap in their own implementation.
+type Fetcher interface {
+	Fetch(ctx context.Context, rawURL string) (*Preview, error)
+	FetchImage(ctx context.Context, rawURL string) ([]byte, string, error)
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// ExtractURLs returns up to max distinct http(s) URLs in the order they
+// appear in text.
+func ExtractURLs(text string, max int) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		// Punctuation right after a link usually belongs to the sentence
+		match = strings.TrimRight(match, ".,;:!?)]}")
+		if seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+		if len(urls) == max {
+			break
+		}
+	}
+	return urls
+}
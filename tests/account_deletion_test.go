@@ -0,0 +1,415 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/internal/user"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/mailer"
+)
+
+// fakeAccountDeletionRepo anonymizes accounts in a fakeUserRepo and purges
+// the memberships, messages, scheduled messages and exports it holds,
+// choosing successors and objects the way the real repository does.
+type fakeAccountDeletionRepo struct {
+	users     *fakeUserRepo
+	members   []models.ChatRoomMember
+	messages  []models.Message
+	scheduled []models.ScheduledMessage
+	exports   []models.ExportJob
+}
+
+func (r *fakeAccountDeletionRepo) ListDue(now time.Time, limit int) ([]models.User, error) {
+	r.users.mu.Lock()
+	defer r.users.mu.Unlock()
+	var due []models.User
+	for _, u := range r.users.users {
+		if u.DeletedAt == nil && u.DeletionScheduledAt != nil && !u.DeletionScheduledAt.After(now) {
+			due = append(due, u)
+		}
+	}
+	return due, nil
+}
+
+func (r *fakeAccountDeletionRepo) Purge(ctx context.Context, userID string, now time.Time, deleteObjects func([]string) error) (*repository.PurgedAccount, error) {
+	u, err := r.users.FindByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.DeletedAt != nil || u.DeletionScheduledAt == nil || u.DeletionScheduledAt.After(now) {
+		return nil, repository.ErrAccountNotDue
+	}
+	result := &repository.PurgedAccount{}
+
+	var kept []models.ChatRoomMember
+	for _, m := range r.members {
+		if m.UserID != u.ID {
+			kept = append(kept, m)
+		}
+	}
+	for _, m := range r.members {
+		if m.UserID != u.ID {
+			continue
+		}
+		result.RoomsLeft++
+		if m.Role != models.RoleOwner {
+			continue
+		}
+		var room []models.ChatRoomMember
+		for _, other := range r.members {
+			if other.ChatRoomID == m.ChatRoomID {
+				room = append(room, other)
+			}
+		}
+		successor, ok := repository.OwnerSuccessor(room, u.ID)
+		if !ok {
+			continue
+		}
+		for i := range kept {
+			if kept[i].ID == successor.ID {
+				kept[i].Role = models.RoleOwner
+			}
+		}
+		result.OwnershipTransferred++
+	}
+
+	var attachments []models.Attachment
+	var shared []string
+	for _, msg := range r.messages {
+		if msg.SenderID != u.ID {
+			for _, a := range msg.Attachments {
+				shared = append(shared, a.Key)
+			}
+			continue
+		}
+		result.MessagesRetained++
+		attachments = append(attachments, msg.Attachments...)
+	}
+	var scheduled []models.ScheduledMessage
+	for _, msg := range r.scheduled {
+		if msg.SenderID == u.ID {
+			scheduled = append(scheduled, msg)
+		}
+	}
+	var exports []string
+	for _, job := range r.exports {
+		if job.RequestedBy == u.ID && job.ObjectKey != nil {
+			exports = append(exports, *job.ObjectKey)
+		}
+	}
+	keys := repository.OwnedObjectKeys(attachments, shared, scheduled, exports)
+	if len(keys) > 0 {
+		if err := deleteObjects(keys); err != nil {
+			return nil, err
+		}
+	}
+	result.ObjectsDeleted = len(keys)
+
+	r.members = kept
+	u.Anonymize(now)
+	return result, r.users.Update(u)
+}
+
+type deletionFixture struct {
+	service  user.Service
+	clock    *fakeClock
+	users    *fakeUserRepo
+	sessions *fakeSessionRepo
+	audit    *fakeAuditRepo
+	s3       *fakeS3Service
+	deletion *fakeAccountDeletionRepo
+}
+
+func newDeletionFixture(t *testing.T) *deletionFixture {
+	t.Setenv("JWT_SECRET", "test-secret")
+	f := &deletionFixture{
+		clock:    newFakeClock(),
+		users:    &fakeUserRepo{users: map[uuid.UUID]models.User{}},
+		sessions: &fakeSessionRepo{},
+		audit:    &fakeAuditRepo{},
+		s3:       &fakeS3Service{},
+	}
+	f.deletion = &fakeAccountDeletionRepo{users: f.users}
+	f.service = user.NewUserService(user.Deps{
+		Repo:         f.users,
+		SessionRepo:  f.sessions,
+		TokenRepo:    &fakeUserTokenRepo{tokens: map[uuid.UUID]models.UserToken{}},
+		MFARepo:      &fakeMFARepo{codes: map[string]map[string]bool{}},
+		PasskeyRepo:  &fakePasskeyRepo{},
+		WebAuthn:     testWebAuthnConfig,
+		IdentityRepo: &fakeIdentityRepo{},
+		AuditRepo:    f.audit,
+		Limiter:      newFakeRateLimiter(time.Now),
+		ContactRepo:  &fakeContactRepo{users: f.users},
+		DeletionRepo: f.deletion,
+		Mailer:       mailer.NewMemoryMailer(),
+		Encryption:   encryption.NewEncryptionService(),
+		S3Service:    f.s3,
+		Revoker:      fakeRevocations{},
+		Now:          f.clock.now,
+	})
+	return f
+}
+
+func (f *deletionFixture) events(name string) []models.AuditEvent {
+	var events []models.AuditEvent
+	for _, e := range f.audit.events {
+		if e.Event == name {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// makeDue moves a scheduled deletion into the past.
+func (f *deletionFixture) makeDue(t *testing.T, userID string) {
+	stored, err := f.users.FindByID(userID)
+	require.NoError(t, err)
+	require.NotNil(t, stored.DeletionScheduledAt)
+	past := f.clock.now().Add(-time.Minute)
+	stored.DeletionScheduledAt = &past
+	require.NoError(t, f.users.Update(stored))
+}
+
+func TestAccountDeletionCanBeCancelledDuringGracePeriod(t *testing.T) {
+	f := newDeletionFixture(t)
+	registered, err := f.service.Register(dto.CreateUserRequest{Username: "ada", Email: "ada@example.com", Password: "secret123"})
+	require.NoError(t, err)
+	client := dto.ClientInfo{IPAddress: "203.0.113.9"}
+
+	_, err = f.service.RequestAccountDeletion(registered.ID, "", dto.DeleteAccountRequest{Password: "wrong"}, client)
+	assert.Error(t, err)
+
+	scheduled, err := f.service.RequestAccountDeletion(registered.ID, "", dto.DeleteAccountRequest{Password: "secret123"}, client)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), scheduled.DeletionScheduledAt, time.Minute)
+
+	again, err := f.service.RequestAccountDeletion(registered.ID, "", dto.DeleteAccountRequest{Password: "secret123"}, client)
+	require.NoError(t, err)
+	assert.Equal(t, scheduled.DeletionScheduledAt, again.DeletionScheduledAt, "asking again keeps the date")
+
+	profile, err := f.service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	require.NotNil(t, profile.DeletionScheduledAt)
+
+	purged, err := f.service.PurgeDeletedAccounts(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, purged, "nothing is deleted during the grace period")
+
+	require.NoError(t, f.service.CancelAccountDeletion(registered.ID, client))
+	assert.Error(t, f.service.CancelAccountDeletion(registered.ID, client))
+
+	profile, err = f.service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	assert.Nil(t, profile.DeletionScheduledAt)
+
+	requested := f.events(models.AuditEventAccountDeletionRequested)
+	require.Len(t, requested, 1)
+	assert.Equal(t, "203.0.113.9", requested[0].IPAddress)
+	assert.Len(t, f.events(models.AuditEventAccountDeletionCancelled), 1)
+}
+
+func TestPurgeDeletedAccountsAnonymizesAndAudits(t *testing.T) {
+	f := newDeletionFixture(t)
+	registered, err := f.service.Register(dto.CreateUserRequest{Username: "bea", Email: "bea@example.com", Password: "secret123"})
+	require.NoError(t, err)
+	_, err = f.service.UpdateAvatar(registered.ID, testPNG(t, 32, 32))
+	require.NoError(t, err)
+	bea := uuid.MustParse(registered.ID)
+	cal, dan, eve := uuid.New(), uuid.New(), uuid.New()
+	owned, alone, joined := uuid.New(), uuid.New(), uuid.New()
+	start := f.clock.now().Add(-time.Hour)
+	member := func(room, userID uuid.UUID, role string, joinedAt time.Time) models.ChatRoomMember {
+		return models.ChatRoomMember{ID: uuid.New(), ChatRoomID: room, UserID: userID, Role: role, JoinedAt: joinedAt}
+	}
+	f.deletion.members = []models.ChatRoomMember{
+		member(owned, bea, models.RoleOwner, start),
+		member(owned, cal, models.RoleMember, start.Add(time.Minute)),
+		member(owned, eve, models.RoleAdmin, start.Add(3*time.Minute)),
+		member(owned, dan, models.RoleAdmin, start.Add(2*time.Minute)),
+		member(alone, bea, models.RoleOwner, start),
+		member(joined, cal, models.RoleOwner, start),
+		member(joined, bea, models.RoleMember, start.Add(time.Minute)),
+	}
+	f.deletion.messages = []models.Message{
+		{ID: uuid.New(), SenderID: bea, Attachments: []models.Attachment{{Key: "attachments/own.pdf"}, {Key: "attachments/forwarded.jpg"}}},
+		{ID: uuid.New(), SenderID: bea},
+		{ID: uuid.New(), SenderID: cal, Attachments: []models.Attachment{{Key: "attachments/forwarded.jpg"}}},
+	}
+	f.deletion.scheduled = []models.ScheduledMessage{
+		{SenderID: bea, Status: models.ScheduledStatusPending, Attachments: []models.ScheduledAttachment{{Key: "scheduled/pending.png"}}},
+		{SenderID: bea, Status: models.ScheduledStatusSent, Attachments: []models.ScheduledAttachment{{Key: "attachments/own.pdf"}}},
+	}
+	beaExport, calExport := "exports/bea.zip", "exports/cal.zip"
+	f.deletion.exports = []models.ExportJob{{RequestedBy: bea, ObjectKey: &beaExport}, {RequestedBy: cal, ObjectKey: &calExport}}
+	_, err = f.service.RequestAccountDeletion(registered.ID, "", dto.DeleteAccountRequest{Password: "secret123"}, dto.ClientInfo{})
+	require.NoError(t, err)
+	f.makeDue(t, registered.ID)
+
+	// A storage failure leaves the account for the next run
+	f.s3.failDeletes = true
+	purged, err := f.service.PurgeDeletedAccounts(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	stored, err := f.users.FindByID(registered.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.DeletedAt)
+	f.s3.failDeletes = false
+
+	purged, err = f.service.PurgeDeletedAccounts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+
+	stored, err = f.users.FindByID(registered.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.DeletedAt)
+	assert.Nil(t, stored.DeletionScheduledAt)
+	assert.Equal(t, models.DeletedUserDisplayName, stored.DisplayName)
+	assert.NotEqual(t, "bea@example.com", stored.Email)
+	assert.Empty(t, stored.PasswordHash)
+	assert.True(t, stored.Placeholder)
+	assert.Contains(t, f.sessions.revokedAll, registered.ID)
+	assert.Zero(t, f.s3.stored(), "the avatar is deleted")
+	assert.Subset(t, f.s3.deleted, []string{"attachments/own.pdf", "scheduled/pending.png", "exports/bea.zip"})
+	assert.NotContains(t, f.s3.deleted, "attachments/forwarded.jpg", "cal's forwarded copy still uses it")
+	assert.NotContains(t, f.s3.deleted, "exports/cal.zip")
+
+	roles := map[uuid.UUID]map[uuid.UUID]string{}
+	for _, m := range f.deletion.members {
+		assert.NotEqual(t, bea, m.UserID)
+		if roles[m.ChatRoomID] == nil {
+			roles[m.ChatRoomID] = map[uuid.UUID]string{}
+		}
+		roles[m.ChatRoomID][m.UserID] = m.Role
+	}
+	assert.Equal(t, map[uuid.UUID]string{cal: models.RoleMember, dan: models.RoleOwner, eve: models.RoleAdmin}, roles[owned],
+		"the longest-serving admin takes over")
+	assert.Equal(t, map[uuid.UUID]string{cal: models.RoleOwner}, roles[joined])
+	assert.NotContains(t, roles, alone)
+
+	_, err = f.service.Login(dto.LoginRequest{Email: "bea@example.com", Password: "secret123"}, dto.ClientInfo{})
+	assert.Error(t, err)
+	_, err = f.service.Login(dto.LoginRequest{Email: stored.Email, Password: ""}, dto.ClientInfo{})
+	assert.Error(t, err)
+
+	deleted := f.events(models.AuditEventAccountDeleted)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, stored.ID, *deleted[0].UserID)
+	assert.Nil(t, deleted[0].ActorID)
+	var details map[string]any
+	require.NoError(t, json.Unmarshal(deleted[0].Details, &details))
+	assert.Equal(t, map[string]any{
+		"rooms_left":            3.0,
+		"ownership_transferred": 1.0,
+		"messages_retained":     2.0,
+		"objects_deleted":       3.0,
+	}, map[string]any{
+		"rooms_left":            details["rooms_left"],
+		"ownership_transferred": details["ownership_transferred"],
+		"messages_retained":     details["messages_retained"],
+		"objects_deleted":       details["objects_deleted"],
+	})
+
+	purged, err = f.service.PurgeDeletedAccounts(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+
+	// The email is free to sign up with again, as a new account
+	again, err := f.service.Register(dto.CreateUserRequest{Username: "bea2", Email: "bea@example.com", Password: "secret123"})
+	require.NoError(t, err)
+	assert.NotEqual(t, registered.ID, again.ID)
+}
+
+func TestAccountsWithoutPasswordConfirmDeletionBySigningIn(t *testing.T) {
+	f := newDeletionFixture(t)
+	sso := &models.User{Username: "cleo", Email: "cleo@example.com"}
+	require.NoError(t, f.users.Create(sso))
+	userID := sso.ID.String()
+	session := models.Session{
+		ID:              uuid.New(),
+		UserID:          sso.ID,
+		FamilyID:        uuid.New(),
+		AuthenticatedAt: f.clock.now(),
+		ExpiresAt:       f.clock.now().Add(30 * 24 * time.Hour),
+	}
+	require.NoError(t, f.sessions.Create(&session))
+	sessionID := session.FamilyID.String()
+
+	_, err := f.service.RequestAccountDeletion(userID, uuid.NewString(), dto.DeleteAccountRequest{}, dto.ClientInfo{})
+	assert.Error(t, err, "another session's sign-in does not count")
+
+	f.clock.advance(11 * time.Minute)
+	_, err = f.service.RequestAccountDeletion(userID, sessionID, dto.DeleteAccountRequest{}, dto.ClientInfo{})
+	assert.Error(t, err, "the sign-in is too old")
+	_, err = f.service.RequestAccountDeletion(userID, sessionID, dto.DeleteAccountRequest{Code: "123456"}, dto.ClientInfo{})
+	assert.Error(t, err, "a code is no use without two-factor authentication")
+
+	stored, err := f.users.FindByID(userID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.DeletionScheduledAt)
+
+	f.clock.advance(-5 * time.Minute)
+	scheduled, err := f.service.RequestAccountDeletion(userID, sessionID, dto.DeleteAccountRequest{}, dto.ClientInfo{})
+	require.NoError(t, err)
+	assert.WithinDuration(t, f.clock.now().Add(30*24*time.Hour), scheduled.DeletionScheduledAt, time.Second)
+}
+
+func TestOwnerSuccessorPrefersLongestServingAdmin(t *testing.T) {
+	owner := uuid.New()
+	start := time.Now()
+	member := func(role string, joinedAt time.Time) models.ChatRoomMember {
+		return models.ChatRoomMember{ID: uuid.New(), UserID: uuid.New(), Role: role, JoinedAt: joinedAt}
+	}
+	first := member(models.RoleMember, start.Add(time.Minute))
+	laterAdmin := member(models.RoleAdmin, start.Add(3*time.Minute))
+	admin := member(models.RoleAdmin, start.Add(2*time.Minute))
+	self := models.ChatRoomMember{ID: uuid.New(), UserID: owner, Role: models.RoleOwner, JoinedAt: start}
+
+	successor, ok := repository.OwnerSuccessor([]models.ChatRoomMember{self, first, laterAdmin, admin}, owner)
+	require.True(t, ok)
+	assert.Equal(t, admin.ID, successor.ID)
+
+	successor, ok = repository.OwnerSuccessor([]models.ChatRoomMember{self, member(models.RoleMember, start.Add(time.Hour)), first}, owner)
+	require.True(t, ok)
+	assert.Equal(t, first.ID, successor.ID, "without admins the longest-serving member takes over")
+
+	a, b := member(models.RoleAdmin, start), member(models.RoleAdmin, start)
+	if b.ID.String() < a.ID.String() {
+		a, b = b, a
+	}
+	for _, order := range [][]models.ChatRoomMember{{a, b}, {b, a}} {
+		successor, ok = repository.OwnerSuccessor(order, owner)
+		require.True(t, ok)
+		assert.Equal(t, a.ID, successor.ID, "ties are broken the same way whatever the order")
+	}
+
+	_, ok = repository.OwnerSuccessor([]models.ChatRoomMember{self}, owner)
+	assert.False(t, ok)
+}
+
+func TestOwnedObjectKeys(t *testing.T) {
+	attachments := []models.Attachment{
+		{Key: "attachments/own.pdf"},
+		{Key: "attachments/forwarded.jpg"},
+		{Key: "attachments/own.pdf"},
+	}
+	scheduled := []models.ScheduledMessage{
+		{Status: models.ScheduledStatusPending, Attachments: []models.ScheduledAttachment{{Key: "scheduled/a.png"}, {Key: "scheduled/b.png"}}},
+		{Status: models.ScheduledStatusSent, Attachments: []models.ScheduledAttachment{{Key: "attachments/sent.png"}}},
+		{Status: models.ScheduledStatusCancelled, Attachments: []models.ScheduledAttachment{{Key: "scheduled/cancelled.png"}}},
+	}
+
+	keys := repository.OwnedObjectKeys(attachments, []string{"attachments/forwarded.jpg"}, scheduled, []string{"exports/a.zip"})
+	assert.Equal(t, []string{"attachments/own.pdf", "scheduled/a.png", "scheduled/b.png", "exports/a.zip"}, keys)
+	assert.Empty(t, repository.OwnedObjectKeys(nil, nil, nil, nil))
+}
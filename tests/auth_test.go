@@ -0,0 +1,242 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/middleware"
+)
+
+func testKeyOptions(alg string) auth.KeyManagerOptions {
+	return auth.KeyManagerOptions{
+		Algorithm:        alg,
+		Issuer:           "mozho_chat",
+		Audience:         "mozho_chat",
+		RotationInterval: time.Hour,
+		TokenTTL:         15 * time.Minute,
+		Secret:           "test-secret",
+	}
+}
+
+// useTestKeys signs tokens with fresh in-memory keys for the test.
+func useTestKeys(t *testing.T, opts auth.KeyManagerOptions) *auth.KeyManager {
+	keys, err := auth.NewKeyManager(context.Background(), auth.NewMemoryKeyStore(), opts)
+	require.NoError(t, err)
+	auth.UseKeyManager(keys)
+	t.Cleanup(func() { auth.UseKeyManager(nil) })
+	return keys
+}
+
+func tokenKeyID(t *testing.T, token string) string {
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &auth.Claims{})
+	require.NoError(t, err)
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+func TestAccessTokenCarriesSession(t *testing.T) {
+	t.Setenv("JWT_EXPIRES_IN", "5m")
+	useTestKeys(t, testKeyOptions(auth.AlgorithmRS256))
+
+	userID, sessionID := uuid.New(), uuid.New()
+	token, expiresAt, err := auth.GenerateJWT(userID, sessionID)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), expiresAt, 2*time.Second)
+
+	claims, err := auth.ParseJWT(token)
+	require.NoError(t, err)
+	assert.Equal(t, userID.String(), claims.UserID)
+	assert.Equal(t, sessionID.String(), claims.SessionID)
+	assert.Equal(t, "mozho_chat", claims.Issuer)
+	assert.NotEmpty(t, tokenKeyID(t, token))
+}
+
+func TestJWKSVerifiesAccessTokens(t *testing.T) {
+	keys := useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+
+	token, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 1)
+	jwk := set.Keys[0]
+	assert.Equal(t, "OKP", jwk.Kty)
+	assert.Equal(t, tokenKeyID(t, token), jwk.Kid)
+
+	// What another service would do with the published key
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	require.NoError(t, err)
+	_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		return ed25519.PublicKey(x), nil
+	}, jwt.WithValidMethods([]string{"EdDSA"}), jwt.WithAudience("mozho_chat"))
+	assert.NoError(t, err)
+}
+
+func TestKeyRotationKeepsOldTokensValid(t *testing.T) {
+	opts := testKeyOptions(auth.AlgorithmRS256)
+	opts.RotationInterval = time.Millisecond
+	keys := useTestKeys(t, opts)
+
+	before, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+	require.NoError(t, keys.Rotate(context.Background()))
+
+	after, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+	assert.NotEqual(t, tokenKeyID(t, before), tokenKeyID(t, after))
+	assert.Len(t, keys.JWKS().Keys, 2)
+
+	_, err = auth.ParseJWT(before)
+	assert.NoError(t, err, "tokens signed before the rotation still verify")
+	_, err = auth.ParseJWT(after)
+	assert.NoError(t, err)
+}
+
+func TestNewKeyIsPublishedBeforeSigning(t *testing.T) {
+	opts := testKeyOptions(auth.AlgorithmRS256)
+	opts.RotationInterval = 2 * time.Hour
+	opts.PublishAhead = time.Hour
+	store := auth.NewMemoryKeyStore()
+	keys, err := auth.NewKeyManager(context.Background(), store, opts)
+	require.NoError(t, err)
+	auth.UseKeyManager(keys)
+	t.Cleanup(func() { auth.UseKeyManager(nil) })
+
+	first, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+
+	// Changing the algorithm rotates right away, but only publishes the key
+	opts.Algorithm = auth.AlgorithmEdDSA
+	keys, err = auth.NewKeyManager(context.Background(), store, opts)
+	require.NoError(t, err)
+	auth.UseKeyManager(keys)
+
+	set := keys.JWKS()
+	require.Len(t, set.Keys, 2)
+	assert.Equal(t, "OKP", set.Keys[1].Kty)
+
+	second, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+	assert.Equal(t, tokenKeyID(t, first), tokenKeyID(t, second), "the current key signs until the new one activates")
+	_, err = auth.ParseJWT(first)
+	assert.NoError(t, err)
+}
+
+func TestTokensForOtherAudiencesAreRejected(t *testing.T) {
+	opts := testKeyOptions(auth.AlgorithmEdDSA)
+	store := auth.NewMemoryKeyStore()
+	other, err := auth.NewKeyManager(context.Background(), store, func() auth.KeyManagerOptions {
+		o := opts
+		o.Audience = "billing"
+		return o
+	}())
+	require.NoError(t, err)
+	auth.UseKeyManager(other)
+	token, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+
+	keys, err := auth.NewKeyManager(context.Background(), store, opts)
+	require.NoError(t, err)
+	auth.UseKeyManager(keys)
+	t.Cleanup(func() { auth.UseKeyManager(nil) })
+
+	_, err = auth.ParseJWT(token)
+	assert.Error(t, err, "same key, wrong audience")
+}
+
+func TestSharedSecretTokensAreRejected(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmRS256))
+
+	hs256, err := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		UserID: uuid.NewString(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "mozho_chat",
+			Audience:  jwt.ClaimStrings{"mozho_chat"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}).SignedString([]byte("test-secret"))
+	require.NoError(t, err)
+
+	_, err = auth.ParseJWT(hs256)
+	assert.Error(t, err)
+}
+
+func TestExpiredAccessTokenIsRejected(t *testing.T) {
+	t.Setenv("JWT_EXPIRES_IN", "-1m")
+	useTestKeys(t, testKeyOptions(auth.AlgorithmRS256))
+
+	token, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+
+	_, err = auth.ParseJWT(token)
+	assert.Error(t, err)
+}
+
+func TestRefreshTokensAreStoredHashed(t *testing.T) {
+	token, hash, err := auth.GenerateRefreshToken()
+	require.NoError(t, err)
+
+	assert.NotEqual(t, token, hash)
+	assert.Equal(t, hash, auth.HashRefreshToken(token))
+
+	other, _, err := auth.GenerateRefreshToken()
+	require.NoError(t, err)
+	assert.NotEqual(t, token, other)
+}
+
+// fakeRevocations is the set of revoked session ids. It is both the
+// middleware's SessionRevocations and the user service's SessionRevoker.
+type fakeRevocations map[string]bool
+
+func (f fakeRevocations) IsSessionRevoked(sessionID string) (bool, error) {
+	return f[sessionID], nil
+}
+
+func (f fakeRevocations) RevokeSession(sessionID, userID string, ttl time.Duration) error {
+	f[sessionID] = true
+	return nil
+}
+
+func TestAuthMiddlewareRejectsRevokedSessions(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	gin.SetMode(gin.TestMode)
+
+	revoked, active := uuid.New(), uuid.New()
+	middleware.UseSessionRevocations(fakeRevocations{revoked.String(): true})
+	t.Cleanup(func() { middleware.UseSessionRevocations(nil) })
+
+	r := gin.New()
+	r.GET("/me", middleware.AuthMiddleware(), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("session_id"))
+	})
+
+	request := func(sessionID uuid.UUID) *httptest.ResponseRecorder {
+		token, _, err := auth.GenerateJWT(uuid.New(), sessionID)
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := request(active)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, active.String(), w.Body.String())
+
+	assert.Equal(t, http.StatusUnauthorized, request(revoked).Code)
+}
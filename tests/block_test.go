@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	chatroomdto "mozho_chat/internal/chatroom/dto"
+	"mozho_chat/internal/contact/dto"
+	"mozho_chat/internal/models"
+)
+
+type fakeBlockRepo struct {
+	mu       sync.Mutex
+	users    *fakeUserRepo
+	contacts *fakeContactRepo
+	blocks   []models.Block
+}
+
+func (r *fakeBlockRepo) Create(blockerID, blockedID string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.blocked(blockerID, blockedID) {
+		return nil
+	}
+	blocked, _ := r.users.FindByID(blockedID)
+	r.blocks = append(r.blocks, models.Block{BlockerID: uuid.MustParse(blockerID), BlockedID: blocked.ID, CreatedAt: now, BlockedUser: *blocked})
+
+	r.contacts.RemoveContact(blockerID, blockedID)
+	r.contacts.mu.Lock()
+	defer r.contacts.mu.Unlock()
+	kept := r.contacts.requests[:0]
+	for _, req := range r.contacts.requests {
+		pair := (req.RequesterID.String() == blockerID && req.AddresseeID.String() == blockedID) ||
+			(req.RequesterID.String() == blockedID && req.AddresseeID.String() == blockerID)
+		if pair && req.Status == models.ContactRequestPending {
+			continue
+		}
+		kept = append(kept, req)
+	}
+	r.contacts.requests = kept
+	return nil
+}
+
+func (r *fakeBlockRepo) blocked(blockerID, blockedID string) bool {
+	for _, b := range r.blocks {
+		if b.BlockerID.String() == blockerID && b.BlockedID.String() == blockedID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *fakeBlockRepo) Delete(blockerID, blockedID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, b := range r.blocks {
+		if b.BlockerID.String() == blockerID && b.BlockedID.String() == blockedID {
+			r.blocks = append(r.blocks[:i], r.blocks[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeBlockRepo) ListByBlocker(blockerID string) ([]models.Block, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.Block
+	for _, b := range r.blocks {
+		if b.BlockerID.String() == blockerID {
+			found = append(found, b)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeBlockRepo) IsBlockedEither(userID1, userID2 string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.blocked(userID1, userID2) || r.blocked(userID2, userID1), nil
+}
+
+func (r *fakeBlockRepo) ListBlockerIDs(blockedID string, userIDs []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []string
+	for _, id := range userIDs {
+		if r.blocked(id, blockedID) {
+			found = append(found, id)
+		}
+	}
+	return found, nil
+}
+
+type fakeUserEvents struct {
+	mu         sync.Mutex
+	recipients []string
+}
+
+func (e *fakeUserEvents) PublishUserEvent(userID, eventType string, data any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.recipients = append(e.recipients, userID)
+	return nil
+}
+
+func TestBlockingRemovesContactsAndStopsRequests(t *testing.T) {
+	f := newContactFixture()
+	xena, yuri := f.addUser(t, "xena"), f.addUser(t, "yuri")
+
+	sent, err := f.contacts.SendRequest(yuri.ID.String(), dto.SendContactRequest{UserID: xena.ID})
+	require.NoError(t, err)
+	_, err = f.contacts.AcceptRequest(xena.ID.String(), sent.ID.String())
+	require.NoError(t, err)
+
+	require.NoError(t, f.contacts.BlockUser(xena.ID.String(), yuri.ID.String()))
+	require.NoError(t, f.contacts.BlockUser(xena.ID.String(), yuri.ID.String()), "blocking twice is fine")
+	assert.Error(t, f.contacts.BlockUser(xena.ID.String(), xena.ID.String()))
+
+	list, err := f.contacts.ListContacts(xena.ID.String())
+	require.NoError(t, err)
+	assert.Empty(t, list)
+	blocks, err := f.contacts.ListBlocks(xena.ID.String())
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	assert.Equal(t, "yuri", blocks[0].User.Username)
+
+	_, err = f.contacts.SendRequest(yuri.ID.String(), dto.SendContactRequest{UserID: xena.ID})
+	assert.EqualError(t, err, "user not found", "the block is not revealed")
+
+	require.NoError(t, f.contacts.UnblockUser(xena.ID.String(), yuri.ID.String()))
+	assert.Error(t, f.contacts.UnblockUser(xena.ID.String(), yuri.ID.String()))
+	_, err = f.contacts.SendRequest(yuri.ID.String(), dto.SendContactRequest{UserID: xena.ID})
+	assert.NoError(t, err)
+}
+
+func TestBlockedUsersCannotStartDirectMessages(t *testing.T) {
+	f := newContactFixture()
+	zoe, abe := f.addUser(t, "zoe"), f.addUser(t, "abe")
+	require.NoError(t, f.contacts.BlockUser(zoe.ID.String(), abe.ID.String()))
+
+	_, err := f.rooms.CreateRoom(abe.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: zoe.ID})
+	assert.Error(t, err)
+	_, err = f.rooms.CreateRoom(zoe.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: abe.ID})
+	assert.Error(t, err, "the blocker has to unblock first")
+
+	require.NoError(t, f.contacts.UnblockUser(zoe.ID.String(), abe.ID.String()))
+	_, err = f.rooms.CreateRoom(abe.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: zoe.ID})
+	assert.NoError(t, err)
+}
+
+func TestTypingIsHiddenFromBlockers(t *testing.T) {
+	f := newContactFixture()
+	bea, cal := f.addUser(t, "bea"), f.addUser(t, "cal")
+	room, err := f.rooms.CreateRoom(bea.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: cal.ID})
+	require.NoError(t, err)
+
+	require.NoError(t, f.rooms.SendTyping(cal.ID.String(), room.ID.String()))
+	assert.Equal(t, []string{bea.ID.String()}, f.events.recipients)
+
+	require.NoError(t, f.contacts.BlockUser(bea.ID.String(), cal.ID.String()))
+	require.NoError(t, f.rooms.SendTyping(cal.ID.String(), room.ID.String()))
+	assert.Len(t, f.events.recipients, 1, "bea no longer sees cal typing")
+
+	stranger := f.addUser(t, "dev")
+	assert.Error(t, f.rooms.SendTyping(stranger.ID.String(), room.ID.String()))
+}
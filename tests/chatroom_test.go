@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/chatroom"
+	"mozho_chat/internal/chatroom/dto"
+	"mozho_chat/internal/models"
+)
+
+func (r *fakeChatRoomRepo) UpdateSettings(roomID string, settings map[string]any) error {
+	room := r.rooms[roomID]
+	for column, value := range settings {
+		switch column {
+		case "encryption_mode":
+			room.EncryptionMode = value.(string)
+		case "requested_encryption_mode":
+			room.RequestedEncryptionMode = nil
+			if mode, ok := value.(string); ok {
+				room.RequestedEncryptionMode = &mode
+			}
+		case "encryption_requested_by":
+			room.EncryptionRequestedBy = nil
+			if id, ok := value.(uuid.UUID); ok {
+				room.EncryptionRequestedBy = &id
+			}
+		}
+	}
+	return nil
+}
+
+func TestDirectMessagesLeaveEndToEndEncryptionOnlyTogether(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	rooms := chatroom.NewService(f.rooms, f.users, f.blocks.contacts, f.blocks, f.events)
+	room, err := rooms.CreateRoom(ana.ID.String(), dto.CreateChatRoomRequest{OtherUserID: ben.ID})
+	require.NoError(t, err)
+	roomID := room.ID.String()
+	mode := func(m string) dto.UpdateRoomSettingsRequest { return dto.UpdateRoomSettingsRequest{EncryptionMode: &m} }
+
+	res, err := rooms.UpdateSettings(ana.ID.String(), roomID, mode(models.EncryptionModeNone))
+	require.NoError(t, err)
+	assert.Equal(t, models.EncryptionModeE2E, res.EncryptionMode, "one participant cannot downgrade alone")
+	assert.Equal(t, models.EncryptionModeNone, res.RequestedEncryptionMode)
+	assert.ElementsMatch(t, []string{ana.ID.String(), ben.ID.String()}, f.events.userEvents("room.encryption_requested"))
+
+	res, err = rooms.UpdateSettings(ana.ID.String(), roomID, mode(models.EncryptionModeNone))
+	require.NoError(t, err)
+	assert.Equal(t, models.EncryptionModeE2E, res.EncryptionMode, "asking twice is not agreeing")
+	res, err = rooms.UpdateSettings(ben.ID.String(), roomID, mode(models.EncryptionModeServer))
+	require.NoError(t, err)
+	assert.Equal(t, models.EncryptionModeE2E, res.EncryptionMode, "a different mode is a new request")
+	assert.Equal(t, models.EncryptionModeServer, res.RequestedEncryptionMode)
+
+	res, err = rooms.UpdateSettings(ana.ID.String(), roomID, mode(models.EncryptionModeServer))
+	require.NoError(t, err)
+	assert.Equal(t, models.EncryptionModeServer, res.EncryptionMode)
+	assert.Empty(t, res.RequestedEncryptionMode)
+	assert.ElementsMatch(t, []string{ana.ID.String(), ben.ID.String()}, f.events.userEvents("room.encryption_changed"))
+
+	// Going back to end-to-end encryption needs no agreement
+	res, err = rooms.UpdateSettings(ben.ID.String(), roomID, mode(models.EncryptionModeE2E))
+	require.NoError(t, err)
+	assert.Equal(t, models.EncryptionModeE2E, res.EncryptionMode)
+}
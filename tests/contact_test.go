@@ -0,0 +1,427 @@
+package tests
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/chatroom"
+	chatroomdto "mozho_chat/internal/chatroom/dto"
+	"mozho_chat/internal/contact"
+	"mozho_chat/internal/contact/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/presence"
+	"mozho_chat/internal/repository"
+)
+
+type fakeContactRepo struct {
+	mu       sync.Mutex
+	users    *fakeUserRepo
+	requests []models.ContactRequest
+	contacts []models.Contact
+}
+
+// withUsers fills in the associations the real repository preloads.
+func (r *fakeContactRepo) withUsers(req models.ContactRequest) *models.ContactRequest {
+	requester, _ := r.users.FindByID(req.RequesterID.String())
+	addressee, _ := r.users.FindByID(req.AddresseeID.String())
+	req.Requester, req.Addressee = *requester, *addressee
+	return &req
+}
+
+func (r *fakeContactRepo) CreateRequest(req *models.ContactRequest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req.ID = uuid.New()
+	req.CreatedAt = time.Now()
+	r.requests = append(r.requests, *req)
+	return nil
+}
+
+func (r *fakeContactRepo) FindRequest(id string) (*models.ContactRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, req := range r.requests {
+		if req.ID.String() == id {
+			return r.withUsers(req), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeContactRepo) FindPendingRequest(userID1, userID2 string) (*models.ContactRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, req := range r.requests {
+		pair := (req.RequesterID.String() == userID1 && req.AddresseeID.String() == userID2) ||
+			(req.RequesterID.String() == userID2 && req.AddresseeID.String() == userID1)
+		if pair && req.Status == models.ContactRequestPending {
+			return r.withUsers(req), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeContactRepo) FindLatestRequest(requesterID, addresseeID string) (*models.ContactRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.requests) - 1; i >= 0; i-- {
+		req := r.requests[i]
+		if req.RequesterID.String() == requesterID && req.AddresseeID.String() == addresseeID {
+			return r.withUsers(req), nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeContactRepo) ListPendingRequests(userID string, incoming bool) ([]models.ContactRequest, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.ContactRequest
+	for _, req := range r.requests {
+		mine := req.RequesterID.String() == userID
+		if incoming {
+			mine = req.AddresseeID.String() == userID
+		}
+		if mine && req.Status == models.ContactRequestPending {
+			found = append(found, *r.withUsers(req))
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeContactRepo) respond(id, status string, now time.Time) *models.ContactRequest {
+	for i := range r.requests {
+		if r.requests[i].ID.String() == id && r.requests[i].Status == models.ContactRequestPending {
+			r.requests[i].Status = status
+			r.requests[i].RespondedAt = &now
+			return &r.requests[i]
+		}
+	}
+	return nil
+}
+
+func (r *fakeContactRepo) AcceptRequest(id string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req := r.respond(id, models.ContactRequestAccepted, now)
+	if req == nil {
+		return false, nil
+	}
+	r.contacts = append(r.contacts,
+		models.Contact{UserID: req.RequesterID, ContactID: req.AddresseeID, CreatedAt: now},
+		models.Contact{UserID: req.AddresseeID, ContactID: req.RequesterID, CreatedAt: now},
+	)
+	return true, nil
+}
+
+func (r *fakeContactRepo) DeclineRequest(id string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.respond(id, models.ContactRequestDeclined, now) != nil, nil
+}
+
+func (r *fakeContactRepo) CancelRequest(id, requesterID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, req := range r.requests {
+		if req.ID.String() == id && req.RequesterID.String() == requesterID && req.Status == models.ContactRequestPending {
+			r.requests = append(r.requests[:i], r.requests[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeContactRepo) AreContacts(userID1, userID2 string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.contacts {
+		if c.UserID.String() == userID1 && c.ContactID.String() == userID2 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeContactRepo) ListContacts(userID string) ([]models.Contact, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.Contact
+	for _, c := range r.contacts {
+		if c.UserID.String() == userID {
+			u, _ := r.users.FindByID(c.ContactID.String())
+			c.ContactUser = *u
+			found = append(found, c)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].ContactUser.Username < found[j].ContactUser.Username })
+	return found, nil
+}
+
+func (r *fakeContactRepo) ListContactIDs(userID string, userIDs []string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	wanted := map[string]bool{}
+	for _, id := range userIDs {
+		wanted[id] = true
+	}
+	var ids []string
+	for _, c := range r.contacts {
+		if c.UserID.String() == userID && wanted[c.ContactID.String()] {
+			ids = append(ids, c.ContactID.String())
+		}
+	}
+	return ids, nil
+}
+
+func (r *fakeContactRepo) RemoveContact(userID, contactID string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.contacts[:0]
+	for _, c := range r.contacts {
+		if (c.UserID.String() == userID && c.ContactID.String() == contactID) ||
+			(c.UserID.String() == contactID && c.ContactID.String() == userID) {
+			continue
+		}
+		kept = append(kept, c)
+	}
+	removed := len(kept) < len(r.contacts)
+	r.contacts = kept
+	return removed, nil
+}
+
+// fakePresence stands in for the Redis presence store.
+type fakePresence struct {
+	mu       sync.Mutex
+	online   map[string]bool
+	lastSeen map[string]time.Time
+	notified []string
+}
+
+func newFakePresence() *fakePresence {
+	return &fakePresence{online: map[string]bool{}, lastSeen: map[string]time.Time{}}
+}
+
+func (p *fakePresence) MarkUserOnline(userID string, ttl time.Duration, now time.Time) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	wasOnline := p.online[userID]
+	p.online[userID] = true
+	p.lastSeen[userID] = now
+	return !wasOnline, nil
+}
+
+func (p *fakePresence) IsUserOnline(userID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.online[userID], nil
+}
+
+func (p *fakePresence) LastSeen(userID string) (*time.Time, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.lastSeen[userID]
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (p *fakePresence) PublishUserEvent(userID, eventType string, data any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.notified = append(p.notified, userID)
+	return nil
+}
+
+// fakeChatRoomRepo keeps direct message rooms in memory. Methods the
+// tests do not reach are left to the embedded nil interface.
+type fakeChatRoomRepo struct {
+	repository.ChatRoomRepository
+	users   *fakeUserRepo
+	rooms   map[string]*models.ChatRoom
+	members map[string][]string
+}
+
+func (r *fakeChatRoomRepo) Create(room *models.ChatRoom) error {
+	// Like the column default
+	if room.EncryptionMode == "" {
+		room.EncryptionMode = models.EncryptionModeE2E
+	}
+	r.rooms[room.ID.String()] = room
+	return nil
+}
+
+func (r *fakeChatRoomRepo) AddUserWithRole(roomID, userID, role string) error {
+	r.members[roomID] = append(r.members[roomID], userID)
+	return nil
+}
+
+func (r *fakeChatRoomRepo) AddUser(roomID, userID string) error {
+	return r.AddUserWithRole(roomID, userID, models.RoleMember)
+}
+
+func (r *fakeChatRoomRepo) FindByID(id string) (*models.ChatRoom, error) {
+	room := *r.rooms[id]
+	room.Users = nil
+	for _, userID := range r.members[id] {
+		u, _ := r.users.FindByID(userID)
+		room.Users = append(room.Users, *u)
+	}
+	return &room, nil
+}
+
+func (r *fakeChatRoomRepo) FindRoomBetweenUsers(userID1, userID2 string) (*models.ChatRoom, error) {
+	for id, members := range r.members {
+		if len(members) == 2 && ((members[0] == userID1 && members[1] == userID2) || (members[0] == userID2 && members[1] == userID1)) {
+			return r.FindByID(id)
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeChatRoomRepo) IsUserInRoom(roomID, userID string) (bool, error) {
+	for _, id := range r.members[roomID] {
+		if id == userID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakeChatRoomRepo) ListMemberIDs(roomID string) ([]string, error) {
+	return r.members[roomID], nil
+}
+
+type contactFixture struct {
+	users       *fakeUserRepo
+	contactRepo *fakeContactRepo
+	contacts    contact.Service
+	rooms       chatroom.Service
+	presence    presence.Service
+	store       *fakePresence
+	events      *fakeUserEvents
+}
+
+func newContactFixture() *contactFixture {
+	users := &fakeUserRepo{users: map[uuid.UUID]models.User{}}
+	repo := &fakeContactRepo{users: users}
+	blocks := &fakeBlockRepo{users: users, contacts: repo}
+	store := newFakePresence()
+	presences := presence.NewService(store, users, repo, blocks)
+	events := &fakeUserEvents{}
+	rooms := &fakeChatRoomRepo{users: users, rooms: map[string]*models.ChatRoom{}, members: map[string][]string{}}
+	return &contactFixture{
+		users:       users,
+		contactRepo: repo,
+		contacts:    contact.NewService(repo, blocks, users, presences),
+		rooms:       chatroom.NewService(rooms, users, repo, blocks, events),
+		presence:    presences,
+		store:       store,
+		events:      events,
+	}
+}
+
+func (f *contactFixture) addUser(t *testing.T, username string) *models.User {
+	u := &models.User{Username: username, Email: username + "@example.com"}
+	require.NoError(t, f.users.Create(u))
+	return u
+}
+
+func TestContactRequestLifecycle(t *testing.T) {
+	f := newContactFixture()
+	pia, quinn := f.addUser(t, "pia"), f.addUser(t, "quinn")
+	require.NoError(t, f.presence.SetUserOnline(quinn.ID.String(), time.Minute))
+
+	sent, err := f.contacts.SendRequest(pia.ID.String(), dto.SendContactRequest{UserID: quinn.ID})
+	require.NoError(t, err)
+	assert.Equal(t, models.ContactRequestPending, sent.Status)
+	_, err = f.contacts.SendRequest(pia.ID.String(), dto.SendContactRequest{UserID: quinn.ID})
+	assert.Error(t, err, "one open request per pair")
+
+	incoming, err := f.contacts.ListRequests(quinn.ID.String(), dto.ListContactRequestsQuery{})
+	require.NoError(t, err)
+	require.Len(t, incoming, 1)
+	assert.Equal(t, "pia", incoming[0].From.Username)
+
+	_, err = f.contacts.AcceptRequest(pia.ID.String(), sent.ID.String())
+	assert.Error(t, err, "only the addressee can accept")
+	accepted, err := f.contacts.AcceptRequest(quinn.ID.String(), sent.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "pia", accepted.User.Username)
+
+	list, err := f.contacts.ListContacts(pia.ID.String())
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "quinn", list[0].User.Username)
+	assert.True(t, list[0].Online)
+
+	_, err = f.contacts.SendRequest(quinn.ID.String(), dto.SendContactRequest{UserID: pia.ID})
+	assert.Error(t, err, "already contacts")
+
+	require.NoError(t, f.contacts.RemoveContact(quinn.ID.String(), pia.ID.String()))
+	list, err = f.contacts.ListContacts(pia.ID.String())
+	require.NoError(t, err)
+	assert.Empty(t, list, "removed for both users")
+}
+
+func TestCrossedContactRequestsAreAccepted(t *testing.T) {
+	f := newContactFixture()
+	rosa, sam := f.addUser(t, "rosa"), f.addUser(t, "sam")
+
+	_, err := f.contacts.SendRequest(rosa.ID.String(), dto.SendContactRequest{UserID: sam.ID})
+	require.NoError(t, err)
+	res, err := f.contacts.SendRequest(sam.ID.String(), dto.SendContactRequest{UserID: rosa.ID})
+	require.NoError(t, err)
+	assert.Equal(t, models.ContactRequestAccepted, res.Status)
+
+	list, err := f.contacts.ListContacts(sam.ID.String())
+	require.NoError(t, err)
+	assert.Len(t, list, 1)
+}
+
+func TestDeclinedAndCancelledContactRequests(t *testing.T) {
+	f := newContactFixture()
+	tara, uma := f.addUser(t, "tara"), f.addUser(t, "uma")
+
+	sent, err := f.contacts.SendRequest(tara.ID.String(), dto.SendContactRequest{UserID: uma.ID})
+	require.NoError(t, err)
+	require.NoError(t, f.contacts.DeclineRequest(uma.ID.String(), sent.ID.String()))
+	_, err = f.contacts.SendRequest(tara.ID.String(), dto.SendContactRequest{UserID: uma.ID})
+	assert.EqualError(t, err, "a contact request is already pending", "a decline is not revealed")
+
+	back, err := f.contacts.SendRequest(uma.ID.String(), dto.SendContactRequest{UserID: tara.ID})
+	require.NoError(t, err, "the other way round still works")
+	assert.Error(t, f.contacts.CancelRequest(tara.ID.String(), back.ID.String()), "only the requester can cancel")
+	require.NoError(t, f.contacts.CancelRequest(uma.ID.String(), back.ID.String()))
+	outgoing, err := f.contacts.ListRequests(uma.ID.String(), dto.ListContactRequestsQuery{Direction: "outgoing"})
+	require.NoError(t, err)
+	assert.Empty(t, outgoing)
+}
+
+func TestDirectMessagesFromContactsOnly(t *testing.T) {
+	f := newContactFixture()
+	vera, will := f.addUser(t, "vera"), f.addUser(t, "will")
+	vera.Privacy.DMsFromContactsOnly = true
+	require.NoError(t, f.users.Update(vera))
+
+	_, err := f.rooms.CreateRoom(will.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: vera.ID})
+	assert.EqualError(t, err, "this user only accepts messages from contacts")
+
+	room, err := f.rooms.CreateRoom(vera.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: will.ID})
+	require.NoError(t, err, "the rule only protects its owner")
+	assert.Len(t, room.Users, 2)
+
+	sent, err := f.contacts.SendRequest(will.ID.String(), dto.SendContactRequest{UserID: vera.ID})
+	require.NoError(t, err)
+	_, err = f.contacts.AcceptRequest(vera.ID.String(), sent.ID.String())
+	require.NoError(t, err)
+	_, err = f.rooms.CreateRoom(will.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: vera.ID})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,411 @@
+package tests
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/internal/user"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/mailer"
+)
+
+type fakeUserRepo struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]models.User
+}
+
+func (r *fakeUserRepo) Create(u *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u.ID = uuid.New()
+	// Mirror the column defaults
+	u.Privacy = models.PrivacySettings{DiscoverableByEmail: true, DiscoverableByPhone: true}
+	r.users[u.ID] = *u
+	return nil
+}
+
+func (r *fakeUserRepo) FindByEmail(email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeUserRepo) FindByID(id string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[uuid.MustParse(id)]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &u, nil
+}
+
+func (r *fakeUserRepo) Update(u *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[u.ID] = *u
+	return nil
+}
+
+type fakeUserTokenRepo struct {
+	mu     sync.Mutex
+	tokens map[uuid.UUID]models.UserToken
+}
+
+func (r *fakeUserTokenRepo) Create(t *models.UserToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[t.ID] = *t
+	return nil
+}
+
+func (r *fakeUserTokenRepo) Find(id, purpose string, now time.Time) (*models.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tokens[uuid.MustParse(id)]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil || !t.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+func (r *fakeUserTokenRepo) RecordFailedAttempt(id string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.tokens[uuid.MustParse(id)]
+	t.Attempts++
+	r.tokens[t.ID] = t
+	return t.Attempts, nil
+}
+
+func (r *fakeUserTokenRepo) Consume(ctx context.Context, id, purpose string, now time.Time) (*models.UserToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tokens[uuid.MustParse(id)]
+	if !ok || t.Purpose != purpose || t.UsedAt != nil || !t.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	t.UsedAt = &now
+	r.tokens[t.ID] = t
+	return &t, nil
+}
+
+func (r *fakeUserTokenRepo) InvalidateForUser(userID, purpose string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, t := range r.tokens {
+		if t.UserID.String() == userID && t.Purpose == purpose && t.UsedAt == nil {
+			t.UsedAt = &now
+			r.tokens[id] = t
+		}
+	}
+	return nil
+}
+
+func (r *fakeUserTokenRepo) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// fakeSessionRepo keeps refresh tokens in memory and records logins and
+// sign-outs.
+type fakeSessionRepo struct {
+	repository.SessionRepository
+	mu sync.Mutex
+	// created holds the sessions started by a login
+	created    []models.Session
+	sessions   []models.Session
+	revokedAll []string
+	// beforeRotate runs at the start of Rotate, e.g. to let a concurrent
+	// refresh of the same token win
+	beforeRotate func()
+}
+
+func (r *fakeSessionRepo) Create(session *models.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.created = append(r.created, *session)
+	r.sessions = append(r.sessions, *session)
+	return nil
+}
+
+func (r *fakeSessionRepo) FindByTokenHash(hash string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.sessions {
+		if s.RefreshTokenHash == hash {
+			return &s, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeSessionRepo) Rotate(ctx context.Context, current, next *models.Session, now time.Time) error {
+	if hook := r.beforeRotate; hook != nil {
+		r.beforeRotate = nil
+		hook()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.sessions {
+		s := &r.sessions[i]
+		if s.ID != current.ID {
+			continue
+		}
+		if s.RotatedAt != nil || s.RevokedAt != nil {
+			return repository.ErrSessionRotated
+		}
+		s.RotatedAt = &now
+		r.sessions = append(r.sessions, *next)
+		return nil
+	}
+	return repository.ErrSessionRotated
+}
+
+func (r *fakeSessionRepo) RevokeFamily(familyID string, now time.Time) error {
+	r.revoke(func(s models.Session) bool { return s.FamilyID.String() == familyID }, now)
+	return nil
+}
+
+func (r *fakeSessionRepo) ListActive(userID string, now time.Time) ([]models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var active []models.Session
+	for _, s := range r.sessions {
+		if s.UserID.String() == userID && s.RotatedAt == nil && s.RevokedAt == nil && s.ExpiresAt.After(now) {
+			active = append([]models.Session{s}, active...)
+		}
+	}
+	return active, nil
+}
+
+func (r *fakeSessionRepo) RevokeUserFamily(userID, familyID string, now time.Time) (bool, error) {
+	families := r.revoke(func(s models.Session) bool {
+		return s.UserID.String() == userID && s.FamilyID.String() == familyID
+	}, now)
+	return len(families) > 0, nil
+}
+
+func (r *fakeSessionRepo) RevokeAllForUser(userID string, now time.Time) ([]string, error) {
+	r.mu.Lock()
+	r.revokedAll = append(r.revokedAll, userID)
+	r.mu.Unlock()
+	return r.revoke(func(s models.Session) bool { return s.UserID.String() == userID }, now), nil
+}
+
+// revoke revokes the active sessions that match and returns their families.
+func (r *fakeSessionRepo) revoke(match func(models.Session) bool, now time.Time) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var families []string
+	seen := map[string]bool{}
+	for i := range r.sessions {
+		s := &r.sessions[i]
+		if s.RevokedAt != nil || !match(*s) {
+			continue
+		}
+		s.RevokedAt = &now
+		if family := s.FamilyID.String(); !seen[family] {
+			seen[family] = true
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+func tokenFromEmail(t *testing.T, msg mailer.Message) string {
+	m := linkToken.FindStringSubmatch(msg.Text)
+	require.NotNil(t, m, "email has a link")
+	token, err := url.QueryUnescape(m[1])
+	require.NoError(t, err)
+	return token
+}
+
+func newTestUserService(t *testing.T) (user.Service, *mailer.MemoryMailer, *fakeSessionRepo) {
+	return newTestUserServiceWithOIDC(t, nil)
+}
+
+func newTestUserServiceWithOIDC(t *testing.T, providers *auth.OIDCProviders) (user.Service, *mailer.MemoryMailer, *fakeSessionRepo) {
+	return newTestUserServiceWithUsers(t, &fakeUserRepo{users: map[uuid.UUID]models.User{}}, providers)
+}
+
+func newTestUserServiceWithUsers(t *testing.T, users *fakeUserRepo, providers *auth.OIDCProviders) (user.Service, *mailer.MemoryMailer, *fakeSessionRepo) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	mail := mailer.NewMemoryMailer()
+	sessions := &fakeSessionRepo{}
+	service := user.NewUserService(user.Deps{
+		Repo:         users,
+		SessionRepo:  sessions,
+		TokenRepo:    &fakeUserTokenRepo{tokens: map[uuid.UUID]models.UserToken{}},
+		MFARepo:      &fakeMFARepo{codes: map[string]map[string]bool{}},
+		PasskeyRepo:  &fakePasskeyRepo{},
+		WebAuthn:     testWebAuthnConfig,
+		IdentityRepo: &fakeIdentityRepo{},
+		OIDC:         providers,
+		AuditRepo:    &fakeAuditRepo{},
+		Limiter:      newFakeRateLimiter(time.Now),
+		ContactRepo:  &fakeContactRepo{users: users},
+		Mailer:       mail,
+		Encryption:   encryption.NewEncryptionService(),
+		S3Service:    &fakeS3Service{},
+		Revoker:      fakeRevocations{},
+	})
+	return service, mail, sessions
+}
+
+func TestUserTokensAreSignedAndScoped(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	id := uuid.New()
+	now := time.Now()
+	token := auth.SignUserToken(id, models.UserTokenPurposeVerifyEmail, now.Add(time.Hour))
+
+	got, err := auth.VerifyUserToken(token, models.UserTokenPurposeVerifyEmail, now)
+	require.NoError(t, err)
+	assert.Equal(t, id, got)
+
+	_, err = auth.VerifyUserToken(token, models.UserTokenPurposeResetPassword, now)
+	assert.ErrorIs(t, err, auth.ErrInvalidUserToken, "other purpose")
+	_, err = auth.VerifyUserToken(token, models.UserTokenPurposeVerifyEmail, now.Add(2*time.Hour))
+	assert.ErrorIs(t, err, auth.ErrInvalidUserToken, "expired")
+
+	payload, mac, _ := strings.Cut(token, ".")
+	forged := auth.SignUserToken(uuid.New(), models.UserTokenPurposeVerifyEmail, now.Add(time.Hour))
+	_, forgedMAC, _ := strings.Cut(forged, ".")
+	_, err = auth.VerifyUserToken(payload+"."+forgedMAC, models.UserTokenPurposeVerifyEmail, now)
+	assert.ErrorIs(t, err, auth.ErrInvalidUserToken, "signature of another token")
+	assert.NotEqual(t, mac, forgedMAC)
+}
+
+func TestRegistrationSendsVerificationEmail(t *testing.T) {
+	service, mail, _ := newTestUserService(t)
+
+	registered, err := service.Register(dto.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	assert.False(t, registered.EmailVerified)
+
+	sent := mail.Sent()
+	require.Len(t, sent, 1)
+	assert.Equal(t, "alice@example.com", sent[0].To)
+	assert.Contains(t, sent[0].HTML, "Confirm email address")
+	token := tokenFromEmail(t, sent[0])
+
+	require.NoError(t, service.VerifyEmail(dto.VerifyEmailRequest{Token: token}))
+	profile, err := service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	assert.True(t, profile.EmailVerified)
+
+	assert.Error(t, service.VerifyEmail(dto.VerifyEmailRequest{Token: token}), "links work once")
+}
+
+func TestVerificationLinkDiesWhenEmailChanges(t *testing.T) {
+	service, mail, _ := newTestUserService(t)
+
+	registered, err := service.Register(dto.CreateUserRequest{Username: "bob", Email: "bob@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	oldToken := tokenFromEmail(t, mail.Sent()[0])
+
+	newEmail := "bob@example.org"
+	require.NoError(t, service.ChangeEmail(registered.ID, "", dto.ChangeEmailRequest{Email: newEmail, Password: "secret1"}))
+	sent := mail.Sent()
+	require.Len(t, sent, 2)
+	assert.Equal(t, newEmail, sent[1].To)
+	require.NoError(t, service.ConfirmEmailChange(dto.VerifyEmailRequest{Token: tokenFromEmail(t, sent[1])}))
+
+	assert.Error(t, service.VerifyEmail(dto.VerifyEmailRequest{Token: oldToken}))
+	profile, err := service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	assert.Equal(t, newEmail, profile.Email)
+	assert.True(t, profile.EmailVerified, "the link proved the new email")
+}
+
+func TestEmailChangesWaitForTheNewAddress(t *testing.T) {
+	service, mail, _ := newTestUserService(t)
+
+	registered, err := service.Register(dto.CreateUserRequest{Username: "erin", Email: "erin@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	_, err = service.Register(dto.CreateUserRequest{Username: "frank", Email: "frank@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	require.Len(t, mail.Sent(), 2)
+
+	assert.Error(t, service.ChangeEmail(registered.ID, "", dto.ChangeEmailRequest{Email: "mallory@example.com"}), "an access token alone is not enough")
+	assert.Error(t, service.ChangeEmail(registered.ID, "", dto.ChangeEmailRequest{Email: "mallory@example.com", Password: "wrong-secret"}))
+	assert.Error(t, service.ChangeEmail(registered.ID, "", dto.ChangeEmailRequest{Email: "frank@example.com", Password: "secret1"}), "taken")
+	assert.Len(t, mail.Sent(), 2)
+
+	require.NoError(t, service.ChangeEmail(registered.ID, "", dto.ChangeEmailRequest{Email: "erin@example.org", Password: "secret1"}))
+	profile, err := service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "erin@example.com", profile.Email, "kept until the link is used")
+
+	sent := mail.Sent()
+	require.Len(t, sent, 3)
+	assert.Equal(t, "erin@example.org", sent[2].To)
+	token := tokenFromEmail(t, sent[2])
+	assert.Error(t, service.VerifyEmail(dto.VerifyEmailRequest{Token: token}), "change links only confirm changes")
+	require.NoError(t, service.ConfirmEmailChange(dto.VerifyEmailRequest{Token: token}))
+	assert.Error(t, service.ConfirmEmailChange(dto.VerifyEmailRequest{Token: token}), "links work once")
+
+	profile, err = service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "erin@example.org", profile.Email)
+}
+
+func TestPasswordResetSignsOutEverywhere(t *testing.T) {
+	service, mail, sessions := newTestUserService(t)
+
+	registered, err := service.Register(dto.CreateUserRequest{Username: "carol", Email: "carol@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	service.ForgotPassword(dto.ForgotPasswordRequest{Email: "nobody@example.com"})
+	service.ForgotPassword(dto.ForgotPasswordRequest{Email: "carol@example.com"})
+	require.Eventually(t, func() bool { return len(mail.Sent()) == 2 }, time.Second, 10*time.Millisecond)
+	reset := mail.Sent()[1]
+	assert.Equal(t, "Reset your password", reset.Subject)
+
+	token := tokenFromEmail(t, reset)
+	require.NoError(t, service.ResetPassword(dto.ResetPasswordRequest{Token: token, NewPassword: "better-secret"}))
+	assert.Equal(t, []string{registered.ID}, sessions.revokedAll)
+
+	assert.Error(t, service.ResetPassword(dto.ResetPasswordRequest{Token: token, NewPassword: "another-one"}), "links work once")
+	_, err = service.Login(dto.LoginRequest{Email: "carol@example.com", Password: "secret1"}, dto.ClientInfo{})
+	assert.EqualError(t, err, "invalid credentials")
+
+	profile, err := service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	assert.True(t, profile.EmailVerified, "the reset link proved the email")
+}
+
+func TestSMTPMessageHasBothBodies(t *testing.T) {
+	body, err := mailer.BuildMIME("mozho <no-reply@example.com>", "alice@example.com", mailer.Message{
+		Subject: "Réinitialiser",
+		Text:    "plain body",
+		HTML:    "<p>html body</p>",
+	})
+	require.NoError(t, err)
+
+	raw := string(body)
+	assert.Contains(t, raw, "Subject: =?utf-8?q?R=C3=A9initialiser?=")
+	assert.Contains(t, raw, "Content-Type: text/plain; charset=utf-8")
+	assert.Contains(t, raw, "Content-Type: text/html; charset=utf-8")
+	assert.Contains(t, raw, "plain body")
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+)
+
+// DeleteExpired removes expired messages, handing the attachments no other
+// message shares to deleteObjects first.
+func (r *fakeMessageRepo) DeleteExpired(ctx context.Context, now time.Time, limit int, deleteObjects func([]models.Attachment) error) ([]models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired, kept []models.Message
+	for _, m := range r.messages {
+		if m.ExpiresAt != nil && !m.ExpiresAt.After(now) && len(expired) < limit {
+			expired = append(expired, m)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	shared := map[string]bool{}
+	for _, m := range kept {
+		for _, a := range m.Attachments {
+			shared[a.Key] = true
+		}
+	}
+	var orphaned []models.Attachment
+	for _, m := range expired {
+		for _, a := range m.Attachments {
+			if !shared[a.Key] {
+				orphaned = append(orphaned, a)
+			}
+		}
+	}
+	if len(orphaned) > 0 {
+		if err := deleteObjects(orphaned); err != nil {
+			return nil, err
+		}
+	}
+	r.messages = kept
+	return expired, nil
+}
+
+func TestMessagesExpireWithTheRoomTimer(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+
+	kept, err := f.send(ana, roomID, "forever")
+	require.NoError(t, err)
+	assert.Empty(t, kept.ExpiresAt, "rooms without a timer keep messages")
+
+	ttl := 3600
+	f.rooms.rooms[roomID].MessageTTLSeconds = &ttl
+	timed, err := f.send(ana, roomID, "for an hour")
+	require.NoError(t, err)
+	expiresAt, err := time.Parse(time.RFC3339, timed.ExpiresAt)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute)
+
+	// An explicit expiry wins over the timer but must lie ahead
+	soon := time.Now().Add(time.Minute)
+	override, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{ReceiverID: roomID, Content: "briefly", ExpiresAt: &soon}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, soon.UTC().Format(time.RFC3339), override.ExpiresAt)
+	past := time.Now().Add(-time.Minute)
+	_, err = f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{ReceiverID: roomID, Content: "too late", ExpiresAt: &past}, nil)
+	assert.Error(t, err)
+
+	// Scheduled messages start the timer when they are sent, not scheduled
+	scheduled, err := f.schedule(ana, roomID, "later", time.Now().Add(24*time.Hour))
+	require.NoError(t, err)
+	f.scheduled.makeDue(scheduled.ID)
+	_, err = f.service.PublishDueMessages(context.Background())
+	require.NoError(t, err)
+	sent := f.messages.inRoom(roomID)
+	require.Len(t, sent, 4)
+	require.NotNil(t, sent[3].ExpiresAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *sent[3].ExpiresAt, time.Minute)
+}
+
+func TestExpiredMessagesArePurged(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+
+	mention, err := f.send(ana, roomID, "hi @ben", mentionOf(ben, 3, 4))
+	require.NoError(t, err)
+	inbox, err := f.service.GetMentions(ben.ID.String(), 10, 0)
+	require.NoError(t, err)
+	require.Len(t, inbox, 1)
+
+	past := time.Now().Add(-time.Second)
+	room := uuid.MustParse(roomID)
+	f.messages.mu.Lock()
+	for i := range f.messages.messages {
+		f.messages.messages[i].ExpiresAt = &past
+	}
+	// The forwarded copy shares its object, so only the other one may go
+	f.messages.messages = append(f.messages.messages,
+		models.Message{ID: uuid.New(), ChatRoomID: room, SenderID: ana.ID, ExpiresAt: &past,
+			Attachments: []models.Attachment{{Key: "messages/a.pdf"}, {Key: "messages/shared.pdf"}}},
+		models.Message{ID: uuid.New(), ChatRoomID: uuid.New(), SenderID: ana.ID,
+			Attachments: []models.Attachment{{Key: "messages/shared.pdf"}}},
+	)
+	f.messages.mu.Unlock()
+
+	inbox, err = f.service.GetMentions(ben.ID.String(), 10, 0)
+	require.NoError(t, err)
+	assert.Empty(t, inbox, "expired messages leave the mentions inbox before they are purged")
+
+	// A storage failure leaves everything for the next run
+	f.s3.failDeletes = true
+	_, err = f.service.PurgeExpiredMessages(context.Background())
+	assert.Error(t, err)
+	assert.Len(t, f.messages.inRoom(roomID), 2)
+	f.s3.failDeletes = false
+
+	purged, err := f.service.PurgeExpiredMessages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Empty(t, f.messages.inRoom(roomID))
+	assert.Equal(t, []string{"messages/a.pdf"}, f.s3.deleted)
+
+	var expired []any
+	for _, ev := range f.events.room {
+		if ev.kind == "message.expired" {
+			assert.Equal(t, roomID, ev.target)
+			expired = append(expired, ev.data)
+		}
+	}
+	require.Len(t, expired, 1)
+	assert.Contains(t, expired[0].(map[string]any)["message_ids"], mention.ID)
+}
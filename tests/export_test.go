@@ -0,0 +1,318 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/export"
+	exportdto "mozho_chat/internal/export/dto"
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/pkg/encryption"
+	s3upload "mozho_chat/pkg/s3"
+)
+
+func (r *fakeMessageRepo) FindByChatRoom(chatRoomID string, limit, offset int) ([]models.Message, error) {
+	msgs := r.inRoom(chatRoomID)
+	if offset >= len(msgs) {
+		return nil, nil
+	}
+	msgs = msgs[offset:]
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+	}
+	return msgs, nil
+}
+
+// fakeExportJobRepo keeps export jobs in memory. Jobs are claimed in the
+// order they were requested.
+type fakeExportJobRepo struct {
+	repository.ExportJobRepository
+	jobs []*models.ExportJob
+}
+
+func (r *fakeExportJobRepo) Create(job *models.ExportJob) error {
+	stored := *job
+	r.jobs = append(r.jobs, &stored)
+	return nil
+}
+
+func (r *fakeExportJobRepo) FindByID(id string) (*models.ExportJob, error) {
+	for _, job := range r.jobs {
+		if job.ID.String() == id {
+			found := *job
+			return &found, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeExportJobRepo) FindActive(chatRoomID, userID string) (*models.ExportJob, error) {
+	for _, job := range r.jobs {
+		if job.ChatRoomID.String() == chatRoomID && job.RequestedBy.String() == userID &&
+			(job.Status == models.ExportStatusPending || job.Status == models.ExportStatusRunning) {
+			found := *job
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeExportJobRepo) ClaimNext(ctx context.Context, now time.Time, lease time.Duration) (*models.ExportJob, error) {
+	for _, job := range r.jobs {
+		if job.Status == models.ExportStatusPending && !job.RunAfter.After(now) {
+			job.Status = models.ExportStatusRunning
+			job.Attempts++
+			claimed := *job
+			return &claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeExportJobRepo) Complete(job *models.ExportJob) error {
+	for i, stored := range r.jobs {
+		if stored.ID == job.ID {
+			completed := *job
+			r.jobs[i] = &completed
+		}
+	}
+	return nil
+}
+
+// fakeObjectStore keeps the bodies written with UploadObject so they can be
+// read back. Methods the tests do not reach are left to the embedded nil
+// interface.
+type fakeObjectStore struct {
+	s3upload.Service
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (s *fakeObjectStore) UploadObject(key string, body io.ReadSeeker, contentType string, isPublic bool) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files == nil {
+		s.files = map[string][]byte{}
+	}
+	s.files[key] = data
+	return nil
+}
+
+func (s *fakeObjectStore) OpenFile(key string, isPublic bool) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[key]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// readZip returns the files of a zip archive by name.
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		body, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		files[f.Name] = string(body)
+	}
+	return files
+}
+
+func TestExportArchiveRendering(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	jobs := &fakeExportJobRepo{}
+	store := &fakeObjectStore{}
+	service := export.NewService(jobs, f.messages, f.rooms, f.users, &fakeAuditRepo{}, store, encryption.NewEncryptionService(), f.events)
+
+	_, err := f.send(ana, roomID, "hello <b>there</b>\nsecond line")
+	require.NoError(t, err)
+	_, err = f.sendStructured(ben, roomID, models.MessageTypeLocation, `{"latitude": 1, "longitude": 2}`)
+	require.NoError(t, err)
+
+	photo, notes := uuid.New(), uuid.New()
+	require.NoError(t, store.UploadObject("messages/photo", strings.NewReader("jpeg bytes"), "image/jpeg", false))
+	f.messages.mu.Lock()
+	f.messages.messages[0].Attachments = []models.Attachment{
+		{ID: photo, Key: "messages/photo", FileName: "../photo.jpg", MimeType: "image/jpeg", Size: 10},
+		{ID: notes, Key: "messages/gone", FileName: "notes.pdf", MimeType: "application/pdf", Size: 3},
+	}
+	// A message sent before the room left end-to-end encryption
+	f.messages.messages = append(f.messages.messages, models.Message{
+		ID:                 uuid.New(),
+		ChatRoomID:         uuid.MustParse(roomID),
+		SenderID:           ben.ID,
+		Type:               models.MessageTypeText,
+		Content:            "ciphertext",
+		EncryptionMetadata: models.EncryptionMetadata{Algorithm: "RSA"},
+		CreatedAt:          time.Now().Add(time.Minute),
+	})
+	f.messages.mu.Unlock()
+
+	requested, err := service.RequestExport(ana.ID.String(), exportdto.CreateExportRequest{ChatRoomID: uuid.MustParse(roomID)})
+	require.NoError(t, err)
+	completed, err := service.ProcessExports(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, completed)
+	assert.Equal(t, []string{ana.ID.String()}, f.events.userEvents("export.completed"))
+
+	job, err := jobs.FindByID(requested.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, models.ExportStatusCompleted, job.Status)
+	assert.Equal(t, 3, job.MessageCount)
+	require.NotNil(t, job.ObjectKey)
+	data := store.files[*job.ObjectKey]
+	assert.Equal(t, int64(len(data)), job.Size)
+	files := readZip(t, data)
+
+	photoPath := "attachments/" + photo.String() + "-photo.jpg"
+	assert.Equal(t, "jpeg bytes", files[photoPath], "file names cannot escape the attachments folder")
+	assert.Len(t, files, 4, "missing attachments are left out")
+
+	var archive struct {
+		Room struct {
+			ID         string `json:"id"`
+			ExportedBy string `json:"exported_by"`
+		} `json:"room"`
+		Messages []struct {
+			Sender      string          `json:"sender"`
+			Type        string          `json:"type"`
+			Text        *string         `json:"text"`
+			Encrypted   bool            `json:"encrypted"`
+			Payload     json.RawMessage `json:"payload"`
+			Attachments []struct {
+				Path    string `json:"path"`
+				Missing bool   `json:"missing"`
+			} `json:"attachments"`
+		} `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(files["messages.json"]), &archive))
+	assert.Equal(t, roomID, archive.Room.ID)
+	assert.Equal(t, ana.ID.String(), archive.Room.ExportedBy)
+	require.Len(t, archive.Messages, 3)
+	text, location, secret := archive.Messages[0], archive.Messages[1], archive.Messages[2]
+	assert.Equal(t, "ana", text.Sender)
+	require.NotNil(t, text.Text)
+	assert.Equal(t, "hello <b>there</b>\nsecond line", *text.Text, "server encrypted messages are decrypted")
+	require.Len(t, text.Attachments, 2)
+	assert.Equal(t, photoPath, text.Attachments[0].Path)
+	assert.True(t, text.Attachments[1].Missing)
+	assert.Empty(t, text.Attachments[1].Path)
+	assert.Equal(t, models.MessageTypeLocation, location.Type)
+	assert.JSONEq(t, `{"latitude": 1, "longitude": 2}`, string(location.Payload))
+	assert.True(t, secret.Encrypted)
+	assert.Nil(t, secret.Text)
+
+	html := files["transcript.html"]
+	assert.Contains(t, html, "hello &lt;b&gt;there&lt;/b&gt;")
+	assert.NotContains(t, html, "<b>there</b>")
+	assert.Contains(t, html, `<img src="`+photoPath+`" alt="../photo.jpg">`)
+	assert.Contains(t, html, `<div class="missing">notes.pdf (missing)</div>`)
+	assert.Contains(t, html, `<div class="body encrypted">End-to-end encrypted message</div>`)
+	assert.NotContains(t, html, "ciphertext")
+
+	lines := strings.Split(strings.TrimSpace(files["transcript.txt"]), "\n")
+	require.Len(t, lines, 6)
+	assert.True(t, strings.HasSuffix(lines[0], "] ana: hello <b>there</b>"), lines[0])
+	assert.Equal(t, "    second line", lines[1])
+	assert.Equal(t, "    attachment: "+photoPath, lines[2])
+	assert.Equal(t, "    attachment: notes.pdf (missing)", lines[3])
+	assert.True(t, strings.HasSuffix(lines[4], `] ben: [location] {"latitude":1,"longitude":2}`), lines[4])
+	assert.True(t, strings.HasSuffix(lines[5], "] ben: [end-to-end encrypted message]"), lines[5])
+}
+
+func TestExportsOfEndToEndRoomsStaySealed(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeE2E, ana, ben)
+	jobs := &fakeExportJobRepo{}
+	store := &fakeObjectStore{}
+	service := export.NewService(jobs, f.messages, f.rooms, f.users, &fakeAuditRepo{}, store, encryption.NewEncryptionService(), f.events)
+
+	// The server holds the key of AES messages, but may not read them here
+	_, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{ReceiverID: roomID, Content: "meet at noon", Algorithm: "AES"}, nil)
+	require.NoError(t, err)
+
+	requested, err := service.RequestExport(ben.ID.String(), exportdto.CreateExportRequest{ChatRoomID: uuid.MustParse(roomID)})
+	require.NoError(t, err)
+	_, err = service.ProcessExports(context.Background())
+	require.NoError(t, err)
+	job, err := jobs.FindByID(requested.ID.String())
+	require.NoError(t, err)
+	require.NotNil(t, job.ObjectKey)
+	files := readZip(t, store.files[*job.ObjectKey])
+
+	var archive struct {
+		Messages []struct {
+			Text      *string `json:"text"`
+			Encrypted bool    `json:"encrypted"`
+		} `json:"messages"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(files["messages.json"]), &archive))
+	require.Len(t, archive.Messages, 1)
+	assert.True(t, archive.Messages[0].Encrypted)
+	assert.Nil(t, archive.Messages[0].Text)
+	for name, body := range files {
+		assert.NotContains(t, body, "meet at noon", name)
+	}
+}
+
+func TestExportsByNonMembersAreAudited(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben, cyd, eve := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd"), f.addUser(t, "eve")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	compliance := f.users.users[cyd.ID]
+	compliance.Role = models.UserRoleCompliance
+	f.users.users[cyd.ID] = compliance
+	audit := &fakeAuditRepo{}
+	service := export.NewService(&fakeExportJobRepo{}, f.messages, f.rooms, f.users, audit, &fakeObjectStore{}, encryption.NewEncryptionService(), f.events)
+	request := exportdto.CreateExportRequest{ChatRoomID: uuid.MustParse(roomID)}
+
+	_, err := service.RequestExport(ana.ID.String(), request)
+	require.NoError(t, err)
+	assert.Empty(t, audit.events, "members export their own rooms")
+	_, err = service.RequestExport(eve.ID.String(), request)
+	assert.Error(t, err)
+
+	requested, err := service.RequestExport(cyd.ID.String(), request)
+	require.NoError(t, err)
+	require.Len(t, audit.events, 1)
+	event := audit.events[0]
+	assert.Equal(t, models.AuditEventRoomExportedByNonMember, event.Event)
+	assert.Equal(t, cyd.ID, *event.ActorID)
+	var details map[string]string
+	require.NoError(t, json.Unmarshal(event.Details, &details))
+	assert.Equal(t, roomID, details["chat_room_id"])
+	assert.Equal(t, requested.ID.String(), details["export_id"])
+	assert.Equal(t, models.UserRoleCompliance, details["role"])
+
+	_, err = service.RequestExport(cyd.ID.String(), request)
+	require.NoError(t, err)
+	assert.Len(t, audit.events, 1, "asking again returns the queued export")
+}
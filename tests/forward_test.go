@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+)
+
+func (r *fakeMessageRepo) FindByIDs(ids []string) ([]models.Message, error) {
+	var found []models.Message
+	for _, id := range ids {
+		if msg, err := r.FindByID(id); err == nil {
+			found = append(found, *msg)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeMessageRepo) CreateBatch(ctx context.Context, messages []*models.Message) error {
+	for _, msg := range messages {
+		if err := r.Create(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *messageFixture) forward(user *models.User, messageIDs []string, roomIDs ...string) ([]dto.MessageResponse, error) {
+	return f.service.ForwardMessages(user.ID.String(), dto.ForwardMessagesRequest{MessageIDs: messageIDs, ChatRoomIDs: roomIDs})
+}
+
+func TestForwardKeepsTheOriginalProvenance(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben, cyd := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd")
+	first := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	second := f.addRoom(t, models.EncryptionModeServer, ben, cyd)
+	third := f.addRoom(t, models.EncryptionModeServer, cyd)
+
+	one, err := f.send(ana, first, "one")
+	require.NoError(t, err)
+	two, err := f.send(ana, first, "two")
+	require.NoError(t, err)
+	f.messages.mu.Lock()
+	f.messages.messages[0].Attachments = []models.Attachment{{Key: "messages/photo.jpg", FileName: "photo.jpg"}}
+	f.messages.mu.Unlock()
+
+	_, err = f.forward(cyd, []string{one.ID}, second)
+	assert.Error(t, err, "cyd cannot see the first room")
+	_, err = f.forward(ana, []string{one.ID}, second)
+	assert.Error(t, err, "ana is not in the second room")
+
+	copies, err := f.forward(ben, []string{two.ID, one.ID}, second)
+	require.NoError(t, err)
+	require.Len(t, copies, 2)
+	for i, src := range []*dto.MessageResponse{two, one} {
+		assert.Equal(t, ben.ID.String(), copies[i].SenderID)
+		assert.Equal(t, second, copies[i].ChatRoomID)
+		assert.Equal(t, src.Content, copies[i].Content, "content is copied as is")
+		require.NotNil(t, copies[i].ForwardedFrom)
+		assert.Equal(t, dto.ForwardedFrom{MessageID: src.ID, ChatRoomID: first, SenderID: ana.ID.String()}, *copies[i].ForwardedFrom)
+	}
+	stored := f.messages.inRoom(second)
+	require.Len(t, stored, 2)
+	assert.True(t, stored[0].CreatedAt.Before(stored[1].CreatedAt), "copies keep the requested order")
+	require.Len(t, stored[1].Attachments, 1)
+	assert.Equal(t, "messages/photo.jpg", stored[1].Attachments[0].Key, "attachments share the object")
+	assert.Equal(t, "one", f.messages.indexed[stored[1].ID.String()])
+
+	// Forwarding a forward still points at the original
+	again, err := f.forward(cyd, []string{copies[1].ID}, third)
+	require.NoError(t, err)
+	require.Len(t, again, 1)
+	assert.Equal(t, dto.ForwardedFrom{MessageID: one.ID, ChatRoomID: first, SenderID: ana.ID.String()}, *again[0].ForwardedFrom)
+
+	f.rooms.rooms[second].RestrictForwarding = true
+	_, err = f.forward(cyd, []string{copies[0].ID}, third)
+	assert.Error(t, err, "the second room does not allow forwarding")
+}
+
+func TestForwardRespectsTheTargetEncryptionMode(t *testing.T) {
+	f := newMessageFixture()
+	ana := f.addUser(t, "ana")
+	server := f.addRoom(t, models.EncryptionModeServer, ana)
+	plain := f.addRoom(t, models.EncryptionModeNone, ana)
+	e2e := f.addRoom(t, models.EncryptionModeE2E, ana)
+	otherE2E := f.addRoom(t, models.EncryptionModeE2E, ana)
+
+	fromServer, err := f.send(ana, server, "aes")
+	require.NoError(t, err)
+	fromPlain, err := f.send(ana, plain, "plain")
+	require.NoError(t, err)
+	fromE2E, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{ReceiverID: e2e, Content: "secret", Algorithm: "AES"}, nil)
+	require.NoError(t, err)
+	location, err := f.sendStructured(ana, plain, models.MessageTypeLocation, `{"latitude": 1, "longitude": 2}`)
+	require.NoError(t, err)
+
+	for name, c := range map[string]struct{ messageID, roomID string }{
+		"server readable into e2e": {fromServer.ID, e2e},
+		"e2e into server readable": {fromE2E.ID, server},
+		"plaintext into aes":       {fromPlain.ID, server},
+		"aes into plaintext":       {fromServer.ID, plain},
+		"location into e2e":        {location.ID, e2e},
+	} {
+		_, err := f.forward(ana, []string{c.messageID}, c.roomID)
+		assert.Error(t, err, name)
+	}
+	assert.Len(t, f.messages.messages, 4, "nothing is copied when a check fails")
+
+	_, err = f.forward(ana, []string{fromE2E.ID}, otherE2E)
+	assert.NoError(t, err)
+	_, err = f.forward(ana, []string{location.ID}, server)
+	assert.NoError(t, err, "structured messages go wherever they could be sent")
+}
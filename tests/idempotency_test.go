@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/middleware"
+)
+
+// fakeIdempotencyStore stands in for Redis. TTLs are ignored.
+type fakeIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]string
+}
+
+func (s *fakeIdempotencyStore) ReserveIdempotencyKey(key, value string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[key]; ok {
+		return false, nil
+	}
+	s.records[key] = value
+	return true, nil
+}
+
+func (s *fakeIdempotencyStore) GetIdempotencyRecord(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[key], nil
+}
+
+func (s *fakeIdempotencyStore) SaveIdempotencyRecord(key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[key] = value
+	return nil
+}
+
+func (s *fakeIdempotencyStore) DeleteIdempotencyKey(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	gin.SetMode(gin.TestMode)
+	store := &fakeIdempotencyStore{records: map[string]string{}}
+	middleware.UseIdempotencyStore(store)
+	t.Cleanup(func() { middleware.UseIdempotencyStore(nil) })
+
+	calls := 0
+	status := http.StatusCreated
+	release := make(chan struct{})
+	r := gin.New()
+	r.POST("/send", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(middleware.MaxJSONBodySize), func(c *gin.Context) {
+		calls++
+		if c.Query("slow") != "" {
+			<-release
+		}
+		c.JSON(status, gin.H{"call": calls})
+	})
+
+	token := func(userID uuid.UUID) string {
+		token, _, err := auth.GenerateJWT(userID, uuid.New())
+		require.NoError(t, err)
+		return token
+	}
+	send := func(token, key, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	ana, ben := uuid.New(), uuid.New()
+	first := send(token(ana), "k1", "/send", `{"content":"hi"}`)
+	require.Equal(t, http.StatusCreated, first.Code)
+
+	// A retry after a token refresh is the same request
+	again := send(token(ana), "k1", "/send", `{"content":"hi"}`)
+	assert.Equal(t, http.StatusCreated, again.Code)
+	assert.Equal(t, first.Body.String(), again.Body.String())
+	assert.Equal(t, "true", again.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, 1, calls)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, send(token(ana), "k1", "/send", `{"content":"bye"}`).Code)
+	assert.Equal(t, http.StatusCreated, send(token(ben), "k1", "/send", `{"content":"hi"}`).Code, "keys are per user")
+	assert.Equal(t, 2, calls)
+
+	send(token(ana), "", "/send", `{"content":"hi"}`)
+	send(token(ana), "", "/send", `{"content":"hi"}`)
+	assert.Equal(t, 4, calls, "requests without a key are not deduplicated")
+
+	// Nothing is stored for requests that never got past authentication
+	assert.Equal(t, http.StatusUnauthorized, send("not-a-token", "k2", "/send", `{}`).Code)
+	assert.Len(t, store.records, 2)
+
+	// Server errors can be retried
+	status = http.StatusInternalServerError
+	assert.Equal(t, http.StatusInternalServerError, send(token(ana), "k3", "/send", `{}`).Code)
+	status = http.StatusCreated
+	assert.Equal(t, http.StatusCreated, send(token(ana), "k3", "/send", `{}`).Code)
+	assert.Equal(t, 6, calls)
+
+	// A retry while the first request is still running is turned away
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send(token(ana), "k4", "/send?slow=1", `{}`) }()
+	require.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.records) == 4
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusConflict, send(token(ana), "k4", "/send?slow=1", `{}`).Code)
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+}
+
+func TestIdempotencyMiddlewareFingerprintsForms(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	gin.SetMode(gin.TestMode)
+	store := &fakeIdempotencyStore{records: map[string]string{}}
+	middleware.UseIdempotencyStore(store)
+	t.Cleanup(func() { middleware.UseIdempotencyStore(nil) })
+
+	calls := 0
+	var received []string
+	r := gin.New()
+	r.POST("/send", middleware.AuthMiddleware(), middleware.IdempotencyMiddleware(64<<10), func(c *gin.Context) {
+		calls++
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		received = append(received, c.PostForm("content")+"/"+header.Filename)
+		c.JSON(http.StatusCreated, gin.H{"call": calls})
+	})
+
+	token, _, err := auth.GenerateJWT(uuid.New(), uuid.New())
+	require.NoError(t, err)
+	send := func(key, content string, file []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		require.NoError(t, form.WriteField("content", content))
+		part, err := form.CreateFormFile("file", "a.bin")
+		require.NoError(t, err)
+		_, err = part.Write(file)
+		require.NoError(t, err)
+		require.NoError(t, form.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/send", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(middleware.IdempotencyHeader, key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Each retry is encoded with a new boundary
+	assert.Equal(t, http.StatusCreated, send("k1", "hi", []byte("one")).Code)
+	again := send("k1", "hi", []byte("one"))
+	assert.Equal(t, http.StatusCreated, again.Code)
+	assert.Equal(t, "true", again.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, []string{"hi/a.bin"}, received, "the handler still reads the parsed form")
+
+	assert.Equal(t, http.StatusUnprocessableEntity, send("k1", "hi", []byte("two")).Code, "a different file is a different request")
+	assert.Equal(t, http.StatusUnprocessableEntity, send("k1", "bye", []byte("one")).Code)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, send("k2", "big", bytes.Repeat([]byte("x"), 128<<10)).Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestClientMsgIDRetries(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	request := dto.SendMessageRequest{ReceiverID: roomID, Content: "hi", ClientMsgID: "c1"}
+
+	sent, err := f.service.SendMessage(ana.ID.String(), request, nil)
+	require.NoError(t, err)
+	retried, err := f.service.SendMessage(ana.ID.String(), request, nil)
+	require.NoError(t, err)
+	assert.Equal(t, sent.ID, retried.ID)
+	other, err := f.service.SendMessage(ben.ID.String(), request, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, sent.ID, other.ID, "client_msg_id is per sender")
+
+	// An expired message is not handed back, and its ID cannot be reused
+	// until it has been purged
+	past := time.Now().Add(-time.Second)
+	f.messages.mu.Lock()
+	f.messages.messages[0].ExpiresAt = &past
+	f.messages.mu.Unlock()
+	_, err = f.service.SendMessage(ana.ID.String(), request, nil)
+	assert.Error(t, err)
+	assert.Len(t, f.messages.inRoom(roomID), 2)
+}
@@ -0,0 +1,353 @@
+package tests
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/importer"
+	importdto "mozho_chat/internal/importer/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/encryption"
+)
+
+func buildZip(t *testing.T, files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = io.WriteString(w, content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestParseSlackExport(t *testing.T) {
+	r := buildZip(t, map[string]string{
+		"users.json": `[
+			{"id": "U1", "name": "alice", "profile": {"email": "Alice@Example.com"}},
+			{"id": "U2", "name": "bob", "profile": {}}
+		]`,
+		"channels.json": `[{"id": "C1", "name": "general", "creator": "U1", "members": ["U1", "U2"]}]`,
+		"dms.json":      `[{"id": "D1", "members": ["U1", "U2"]}]`,
+		"general/2024-01-02.json": `[
+			{"type": "message", "user": "U2", "text": "second &amp; last", "ts": "1704200000.000200"},
+			{"type": "message", "subtype": "channel_join", "user": "U2", "text": "<@U2> has joined", "ts": "1704200000.000100"}
+		]`,
+		"general/2024-01-01.json": `[
+			{"type": "message", "user": "U1", "text": "hi <@U2>, see <https://example.com|the docs>", "ts": "1704100000.000100",
+			 "files": [{"id": "F1", "name": "plan.txt"}, {"id": "F2", "name": "gone.png"}]}
+		]`,
+		"__uploads/F1/plan.txt": "the plan",
+		"D1/2024-01-01.json":    `[{"type": "message", "user": "U1", "text": "psst", "ts": "1704100001.000000"}]`,
+	})
+
+	convs, err := importer.ParseSlackExport(r, r.Size())
+	require.NoError(t, err)
+	require.Len(t, convs, 2)
+
+	general := convs[0]
+	assert.Equal(t, "slack:C1", general.ExternalID)
+	assert.True(t, general.IsGroup)
+	assert.Equal(t, "U1", general.Owner)
+	require.Len(t, general.Messages, 2, "joins are skipped")
+	assert.Equal(t, "alice@example.com", general.Participants[0].Email)
+
+	first := general.Messages[0]
+	assert.Equal(t, "hi @bob, see the docs (https://example.com)", first.Text)
+	assert.Equal(t, time.Unix(1704100000, 100000).UTC(), first.SentAt)
+	require.Len(t, first.Files, 2)
+	require.NotNil(t, first.Files[0].Open, "embedded file is readable")
+	rc, err := first.Files[0].Open()
+	require.NoError(t, err)
+	data, _ := io.ReadAll(rc)
+	rc.Close()
+	assert.Equal(t, "the plan", string(data))
+	assert.Nil(t, first.Files[1].Open, "file missing from the export")
+
+	assert.Equal(t, "second & last", general.Messages[1].Text)
+
+	dm := convs[1]
+	assert.False(t, dm.IsGroup)
+	require.Len(t, dm.Messages, 1)
+	assert.Equal(t, "psst", dm.Messages[0].Text)
+}
+
+func TestParseSlackExportRejectsOtherArchives(t *testing.T) {
+	r := buildZip(t, map[string]string{"readme.txt": "hello"})
+	_, err := importer.ParseSlackExport(r, r.Size())
+	assert.Error(t, err)
+}
+
+func TestParseWhatsAppAndroidExport(t *testing.T) {
+	chat := strings.Join([]string{
+		"31/12/23, 21:41 - Messages and calls are end-to-end encrypted.",
+		"31/12/23, 21:41 - Alice: Happy new year!",
+		"31/12/23, 21:41 - Bob: You too",
+		"see you",
+		"tomorrow",
+		"01/01/24, 9:05 - Alice: IMG-20240101-WA0001.jpg (file attached)",
+		"the fireworks",
+	}, "\n")
+	r := bytes.NewReader([]byte(chat))
+
+	conv, err := importer.ParseWhatsAppExport("WhatsApp Chat with Bob.txt", r, r.Size(), importer.WhatsAppOptions{
+		Participants: map[string]string{"Alice": "alice@example.com"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "Bob", conv.Name)
+	assert.False(t, conv.IsGroup)
+	require.Len(t, conv.Messages, 3)
+	assert.Equal(t, time.Date(2023, 12, 31, 21, 41, 0, 0, time.UTC), conv.Messages[0].SentAt)
+	assert.Equal(t, "You too\nsee you\ntomorrow", conv.Messages[1].Text)
+
+	photo := conv.Messages[2]
+	assert.Equal(t, "the fireworks", photo.Text)
+	require.Len(t, photo.Files, 1)
+	assert.Equal(t, "IMG-20240101-WA0001.jpg", photo.Files[0].Name)
+	assert.Nil(t, photo.Files[0].Open, "a .txt export has no media")
+
+	for _, p := range conv.Participants {
+		if p.Key == "Alice" {
+			assert.Equal(t, "alice@example.com", p.Email)
+		}
+	}
+}
+
+func TestParseWhatsAppIOSExportWithMedia(t *testing.T) {
+	chat := "\ufeff[1/2/2024, 9:41:05 PM] Alice: \u200e<attached: 00000001-PHOTO.jpg>\n" +
+		"[1/2/2024, 9:42:00 PM] Bob: nice\n" +
+		"[1/13/2024, 10:00:00 AM] Carol: hello all\n"
+	r := buildZip(t, map[string]string{
+		"_chat.txt":          chat,
+		"00000001-PHOTO.jpg": "jpeg bytes",
+	})
+
+	conv, err := importer.ParseWhatsAppExport("Team.zip", r, r.Size(), importer.WhatsAppOptions{ChatName: "Team"})
+	require.NoError(t, err)
+
+	assert.True(t, conv.IsGroup)
+	require.Len(t, conv.Messages, 3)
+	// 1/13 can only be month first, which decides how 1/2 is read
+	assert.Equal(t, time.Date(2024, 1, 2, 21, 41, 5, 0, time.UTC), conv.Messages[0].SentAt)
+	assert.Equal(t, "", conv.Messages[0].Text)
+	require.Len(t, conv.Messages[0].Files, 1)
+	assert.NotNil(t, conv.Messages[0].Files[0].Open)
+}
+
+func TestParseWhatsAppExportIsStable(t *testing.T) {
+	first := "01/02/24, 10:00 - Alice: hi\n01/02/24, 10:00 - Alice: hi\n"
+	later := first + "01/02/24, 10:05 - Bob: hey\n"
+
+	parse := func(chat string) *importer.Conversation {
+		r := bytes.NewReader([]byte(chat))
+		conv, err := importer.ParseWhatsAppExport("chat.txt", r, r.Size(), importer.WhatsAppOptions{})
+		require.NoError(t, err)
+		return conv
+	}
+	a, b := parse(first), parse(later)
+
+	assert.Equal(t, a.ExternalID, b.ExternalID)
+	require.Len(t, a.Messages, 2)
+	require.Len(t, b.Messages, 3)
+	assert.NotEqual(t, a.Messages[0].SourceID, a.Messages[1].SourceID, "repeated messages stay distinct")
+	assert.Equal(t, a.Messages[1].SourceID, b.Messages[1].SourceID)
+}
+
+func (r *fakeMessageRepo) ListClientMsgIDs(chatRoomID, prefix string) ([]string, error) {
+	var ids []string
+	for _, msg := range r.inRoom(chatRoomID) {
+		if msg.ClientMsgID != nil && strings.HasPrefix(*msg.ClientMsgID, prefix) {
+			ids = append(ids, *msg.ClientMsgID)
+		}
+	}
+	return ids, nil
+}
+
+func (r *fakeChatRoomRepo) FindByExternalID(externalID string) (*models.ChatRoom, error) {
+	for _, room := range r.rooms {
+		if room.ExternalID != nil && *room.ExternalID == externalID {
+			found := *room
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+type importFixture struct {
+	users    *fakeUserRepo
+	rooms    *fakeChatRoomRepo
+	messages *fakeMessageRepo
+	s3       *fakeObjectStore
+	service  importer.Service
+}
+
+func newImportFixture() *importFixture {
+	users := &fakeUserRepo{users: map[uuid.UUID]models.User{}}
+	f := &importFixture{
+		users:    users,
+		rooms:    &fakeChatRoomRepo{users: users, rooms: map[string]*models.ChatRoom{}, members: map[string][]string{}},
+		messages: &fakeMessageRepo{},
+		s3:       &fakeObjectStore{},
+	}
+	f.service = importer.NewService(f.messages, f.rooms, f.users, f.s3, encryption.NewEncryptionService())
+	return f
+}
+
+// importChat imports a WhatsApp group export holding chat and one photo.
+func (f *importFixture) importChat(t *testing.T, chat string) *importdto.ImportReport {
+	r := buildZip(t, map[string]string{"_chat.txt": chat, "00000001-PHOTO.jpg": "jpeg bytes"})
+	report, err := f.service.ImportWhatsApp(context.Background(), "Team.zip", r, r.Size(), importer.WhatsAppOptions{
+		ChatName:     "Team",
+		Participants: map[string]string{"Alice": "alice@example.com", "Bob": "bob@example.com"},
+	})
+	require.NoError(t, err)
+	return report
+}
+
+const teamChat = "[1/2/2024, 9:41:05 PM] Alice: <attached: 00000001-PHOTO.jpg>\n" +
+	"[1/2/2024, 9:42:00 PM] Bob: nice\n" +
+	"[1/13/2024, 10:00:00 AM] Carol: hello all\n"
+
+func TestImportRunsAreIdempotent(t *testing.T) {
+	f := newImportFixture()
+	alice := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "hash"}
+	require.NoError(t, f.users.Create(alice))
+
+	first := f.importChat(t, teamChat)
+	require.Len(t, first.Rooms, 1)
+	assert.True(t, first.Rooms[0].Created)
+	assert.Equal(t, 3, first.MessagesImported)
+	assert.Equal(t, 1, first.FilesUploaded)
+	assert.Equal(t, 2, first.UsersCreated, "alice already has an account")
+
+	roomID := first.Rooms[0].ChatRoomID.String()
+	room := f.rooms.rooms[roomID]
+	require.NotNil(t, room.ExternalID)
+	assert.Equal(t, models.EncryptionModeServer, room.EncryptionMode)
+	assert.Len(t, f.rooms.members[roomID], 3)
+
+	bob, err := f.users.FindByEmail("bob@example.com")
+	require.NoError(t, err)
+	assert.True(t, bob.Placeholder)
+	assert.Equal(t, "bob", bob.Username)
+	var carol *models.User
+	for _, u := range f.users.users {
+		if u.Username == "carol" {
+			carol = &u
+		}
+	}
+	require.NotNil(t, carol)
+	assert.True(t, carol.Placeholder)
+	assert.True(t, models.IsPlaceholderEmail(carol.Email), "participants without an email get an address nobody can register")
+
+	imported := f.messages.inRoom(roomID)
+	require.Len(t, imported, 3)
+	assert.Equal(t, alice.ID, imported[0].SenderID)
+	assert.Equal(t, bob.ID, imported[1].SenderID)
+	require.Len(t, imported[0].Attachments, 1)
+	photo := imported[0].Attachments[0]
+	assert.Equal(t, "jpeg bytes", string(f.s3.files[photo.Key]))
+
+	// The same export again adds nothing
+	again := f.importChat(t, teamChat)
+	require.Len(t, again.Rooms, 1)
+	assert.False(t, again.Rooms[0].Created)
+	assert.Equal(t, first.Rooms[0].ChatRoomID, again.Rooms[0].ChatRoomID)
+	assert.Equal(t, 0, again.MessagesImported)
+	assert.Equal(t, 3, again.MessagesSkipped)
+	assert.Zero(t, again.UsersCreated)
+	assert.Zero(t, again.FilesUploaded)
+	assert.Len(t, f.rooms.rooms, 1)
+	assert.Len(t, f.rooms.members[roomID], 3)
+	assert.Len(t, f.users.users, 3)
+	assert.Len(t, f.messages.inRoom(roomID), 3)
+
+	// A later export of the same chat only adds what is new
+	later := f.importChat(t, teamChat+"[1/14/2024, 8:00:00 AM] Bob: bye\n")
+	assert.Equal(t, 1, later.MessagesImported)
+	assert.Equal(t, 3, later.MessagesSkipped)
+	assert.Len(t, f.messages.inRoom(roomID), 4)
+
+	// A run that stored nothing produces the same ids and keys, so uploads
+	// it repeats overwrite the earlier ones
+	f.messages.mu.Lock()
+	f.messages.messages = nil
+	f.messages.mu.Unlock()
+	rerun := f.importChat(t, teamChat)
+	assert.Equal(t, 3, rerun.MessagesImported)
+	redone := f.messages.inRoom(roomID)
+	require.Len(t, redone, 3)
+	for i := range imported {
+		assert.Equal(t, imported[i].ID, redone[i].ID)
+	}
+	require.Len(t, redone[0].Attachments, 1)
+	assert.Equal(t, photo.ID, redone[0].Attachments[0].ID)
+	assert.Equal(t, photo.Key, redone[0].Attachments[0].Key)
+	assert.Len(t, f.s3.files, 1)
+}
+
+func TestImportedAccountsAreClaimedThroughEmail(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	f := newImportFixture()
+	f.importChat(t, teamChat)
+	service, mail, _ := newTestUserServiceWithUsers(t, f.users, nil)
+	placeholder, err := f.users.FindByEmail("bob@example.com")
+	require.NoError(t, err)
+
+	registered, err := service.Register(dto.CreateUserRequest{Username: "bobby", Email: "bob@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	assert.Equal(t, placeholder.ID.String(), registered.ID, "the imported history stays with the account")
+	assert.Equal(t, "bobby", registered.Username, "the reply looks like any signup")
+
+	// Anyone can type the email, so registering sets nothing
+	login := dto.LoginRequest{Email: "bob@example.com", Password: "secret1"}
+	_, err = service.Login(login, dto.ClientInfo{IPAddress: "203.0.113.1"})
+	assert.Error(t, err)
+	stored, err := f.users.FindByEmail("bob@example.com")
+	require.NoError(t, err)
+	assert.True(t, stored.Placeholder)
+	assert.Equal(t, placeholder.PasswordHash, stored.PasswordHash)
+	assert.Equal(t, placeholder.Username, stored.Username)
+
+	// The owner of the email claims it by choosing a password from the link
+	sent := mail.Sent()
+	require.Len(t, sent, 1)
+	claim := tokenFromEmail(t, sent[0])
+	assert.Error(t, service.VerifyEmail(dto.VerifyEmailRequest{Token: claim}), "the claim link is not a verification link")
+	require.NoError(t, service.SendVerificationEmail(placeholder.ID.String()))
+	require.Len(t, mail.Sent(), 2)
+	assert.Error(t, service.VerifyEmail(dto.VerifyEmailRequest{Token: tokenFromEmail(t, mail.Sent()[1])}), "verifying does not claim an imported account")
+	require.NoError(t, service.ResetPassword(dto.ResetPasswordRequest{Token: claim, NewPassword: "secret3"}))
+	_, err = service.Login(login, dto.ClientInfo{IPAddress: "203.0.113.1"})
+	assert.Error(t, err, "the password given at registration never applies")
+	res, err := service.Login(dto.LoginRequest{Email: "bob@example.com", Password: "secret3"}, dto.ClientInfo{IPAddress: "203.0.113.1"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, res.Token)
+	claimed, err := f.users.FindByEmail("bob@example.com")
+	require.NoError(t, err)
+	assert.False(t, claimed.Placeholder)
+	assert.NotNil(t, claimed.EmailVerifiedAt)
+
+	// Whoever reads the email can also claim an account with a reset link
+	dan := &models.User{Username: "dan", Email: "dan@example.com", Placeholder: true}
+	require.NoError(t, f.users.Create(dan))
+	service.ForgotPassword(dto.ForgotPasswordRequest{Email: "dan@example.com"})
+	require.Eventually(t, func() bool { return len(mail.Sent()) == 3 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, service.ResetPassword(dto.ResetPasswordRequest{Token: tokenFromEmail(t, mail.Sent()[2]), NewPassword: "secret2"}))
+	_, err = service.Login(dto.LoginRequest{Email: "dan@example.com", Password: "secret2"}, dto.ClientInfo{IPAddress: "203.0.113.1"})
+	assert.NoError(t, err)
+}
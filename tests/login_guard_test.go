@@ -0,0 +1,284 @@
+package tests
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/mailer"
+)
+
+// fakeClock is a clock that only moves when a test advances it.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Now()}
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// fakeRateLimiter keeps sliding windows and locks in memory. Locks expire
+// by the limiter's clock, like the service's it should be given.
+type fakeRateLimiter struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	windows map[string][]time.Time
+	locks   map[string]time.Time
+}
+
+func newFakeRateLimiter(now func() time.Time) *fakeRateLimiter {
+	return &fakeRateLimiter{now: now, windows: map[string][]time.Time{}, locks: map[string]time.Time{}}
+}
+
+func (l *fakeRateLimiter) prune(key string, now time.Time, window time.Duration) []time.Time {
+	var kept []time.Time
+	for _, hit := range l.windows[key] {
+		if hit.After(now.Add(-window)) {
+			kept = append(kept, hit)
+		}
+	}
+	l.windows[key] = kept
+	return kept
+}
+
+func (l *fakeRateLimiter) HitSlidingWindow(key string, now time.Time, window time.Duration) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.windows[key] = append(l.prune(key, now, window), now)
+	return int64(len(l.windows[key])), nil
+}
+
+func (l *fakeRateLimiter) SlidingWindow(key string, now time.Time, window time.Duration) (int64, time.Time, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hits := l.prune(key, now, window)
+	if len(hits) == 0 {
+		return 0, time.Time{}, nil
+	}
+	return int64(len(hits)), hits[len(hits)-1], nil
+}
+
+func (l *fakeRateLimiter) ResetSlidingWindow(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.windows, key)
+	return nil
+}
+
+func (l *fakeRateLimiter) Lock(key string, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locks[key] = l.now().Add(ttl)
+	return nil
+}
+
+func (l *fakeRateLimiter) LockedFor(key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if left := l.locks[key].Sub(l.now()); left > 0 {
+		return left, nil
+	}
+	return 0, nil
+}
+
+func (l *fakeRateLimiter) Unlock(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.locks, key)
+	return nil
+}
+
+type fakeAuditRepo struct {
+	mu     sync.Mutex
+	events []models.AuditEvent
+}
+
+func (r *fakeAuditRepo) Create(event *models.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event.ID = uuid.New()
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *fakeAuditRepo) list(event string) []models.AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.AuditEvent
+	for _, e := range r.events {
+		if e.Event == event {
+			found = append(found, e)
+		}
+	}
+	return found
+}
+
+type loginGuardFixture struct {
+	service user.Service
+	users   *fakeUserRepo
+	clock   *fakeClock
+	limiter *fakeRateLimiter
+	audit   *fakeAuditRepo
+}
+
+func newLoginGuardFixture(t *testing.T) *loginGuardFixture {
+	t.Setenv("JWT_SECRET", "test-secret")
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	clock := newFakeClock()
+	f := &loginGuardFixture{
+		users:   &fakeUserRepo{users: map[uuid.UUID]models.User{}},
+		clock:   clock,
+		limiter: newFakeRateLimiter(clock.now),
+		audit:   &fakeAuditRepo{},
+	}
+	f.service = user.NewUserService(user.Deps{
+		Repo:         f.users,
+		SessionRepo:  &fakeSessionRepo{},
+		TokenRepo:    &fakeUserTokenRepo{tokens: map[uuid.UUID]models.UserToken{}},
+		MFARepo:      &fakeMFARepo{codes: map[string]map[string]bool{}},
+		PasskeyRepo:  &fakePasskeyRepo{},
+		WebAuthn:     testWebAuthnConfig,
+		IdentityRepo: &fakeIdentityRepo{},
+		AuditRepo:    f.audit,
+		Limiter:      f.limiter,
+		ContactRepo:  &fakeContactRepo{users: f.users},
+		Mailer:       mailer.NewMemoryMailer(),
+		Encryption:   encryption.NewEncryptionService(),
+		S3Service:    &fakeS3Service{},
+		Revoker:      fakeRevocations{},
+		Now:          clock.now,
+	})
+	return f
+}
+
+// failLogin makes a wrong-password attempt, waiting out any delay from
+// earlier failures first. It gives up once the email or IP is locked.
+func (f *loginGuardFixture) failLogin(t *testing.T, email, ip string) error {
+	for waited := time.Duration(0); ; waited += time.Second {
+		_, err := f.service.Login(dto.LoginRequest{Email: email, Password: "wrong"}, dto.ClientInfo{IPAddress: ip})
+		if err == nil || !strings.Contains(err.Error(), "too many failed login attempts") || waited > time.Minute {
+			return err
+		}
+		f.clock.advance(time.Second)
+	}
+}
+
+func TestLoginFailuresLookTheSameForUnknownEmails(t *testing.T) {
+	f := newLoginGuardFixture(t)
+	_, err := f.service.Register(dto.CreateUserRequest{Username: "gail", Email: "gail@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	wrongPassword := f.failLogin(t, "gail@example.com", "")
+	unknownEmail := f.failLogin(t, "nobody@example.com", "")
+	require.Error(t, wrongPassword)
+	assert.Equal(t, wrongPassword.Error(), unknownEmail.Error())
+
+	for i := 0; i < 10; i++ {
+		f.failLogin(t, "gail@example.com", "")
+		f.failLogin(t, "nobody@example.com", "")
+	}
+	_, lockedReal := f.service.Login(dto.LoginRequest{Email: "gail@example.com", Password: "secret1"}, dto.ClientInfo{})
+	_, lockedUnknown := f.service.Login(dto.LoginRequest{Email: "nobody@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.Error(t, lockedReal)
+	assert.Equal(t, lockedReal.Error(), lockedUnknown.Error(), "unknown emails are locked too")
+}
+
+func TestLoginThrottlesAfterRepeatedFailures(t *testing.T) {
+	f := newLoginGuardFixture(t)
+	_, err := f.service.Register(dto.CreateUserRequest{Username: "hal", Email: "hal@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.Error(t, f.failLogin(t, "hal@example.com", ""))
+	}
+	_, err = f.service.Login(dto.LoginRequest{Email: "hal@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.Error(t, err, "even the right password has to wait")
+	assert.Contains(t, err.Error(), "too many failed login attempts")
+
+	f.clock.advance(2 * time.Second)
+	_, err = f.service.Login(dto.LoginRequest{Email: "hal@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.Error(t, f.failLogin(t, "hal@example.com", ""))
+	}
+	require.Error(t, f.failLogin(t, "hal@example.com", ""))
+	f.clock.advance(time.Second)
+	_, err = f.service.Login(dto.LoginRequest{Email: "hal@example.com", Password: "secret1"}, dto.ClientInfo{})
+	assert.Error(t, err, "the delay doubles with each failure")
+	f.clock.advance(time.Second)
+	_, err = f.service.Login(dto.LoginRequest{Email: "hal@example.com", Password: "secret1"}, dto.ClientInfo{})
+	assert.NoError(t, err)
+}
+
+func TestAccountLockoutIsAuditedAndAdminsCanUnlock(t *testing.T) {
+	f := newLoginGuardFixture(t)
+	victim, err := f.service.Register(dto.CreateUserRequest{Username: "ivy", Email: "ivy@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	admin, err := f.service.Register(dto.CreateUserRequest{Username: "root", Email: "root@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	adminUser, err := f.users.FindByID(admin.ID)
+	require.NoError(t, err)
+	adminUser.Role = models.UserRoleAdmin
+	require.NoError(t, f.users.Update(adminUser))
+
+	for i := 0; i < 10; i++ {
+		require.Error(t, f.failLogin(t, "ivy@example.com", "203.0.113.7"))
+	}
+	locks := f.audit.list(models.AuditEventAccountLocked)
+	require.Len(t, locks, 1)
+	assert.Equal(t, victim.ID, locks[0].UserID.String())
+	assert.Equal(t, "203.0.113.7", locks[0].IPAddress)
+
+	f.clock.advance(5 * time.Minute)
+	_, err = f.service.Login(dto.LoginRequest{Email: "ivy@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.Error(t, err, "locked even with the right password")
+
+	assert.Error(t, f.service.UnlockUser(victim.ID, victim.ID), "only admins can unlock")
+	require.NoError(t, f.service.UnlockUser(admin.ID, victim.ID))
+	unlocks := f.audit.list(models.AuditEventAccountUnlocked)
+	require.Len(t, unlocks, 1)
+	assert.Equal(t, admin.ID, unlocks[0].ActorID.String())
+
+	_, err = f.service.Login(dto.LoginRequest{Email: "ivy@example.com", Password: "secret1"}, dto.ClientInfo{})
+	assert.NoError(t, err)
+}
+
+func TestLoginLocksIPsThatTryManyAccounts(t *testing.T) {
+	f := newLoginGuardFixture(t)
+	_, err := f.service.Register(dto.CreateUserRequest{Username: "jo", Email: "jo@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	for i := 0; i < 50; i++ {
+		f.failLogin(t, uuid.NewString()+"@example.com", "198.51.100.9")
+	}
+	require.Len(t, f.audit.list(models.AuditEventIPLocked), 1)
+
+	_, err = f.service.Login(dto.LoginRequest{Email: "jo@example.com", Password: "secret1"}, dto.ClientInfo{IPAddress: "198.51.100.9"})
+	assert.Error(t, err)
+	_, err = f.service.Login(dto.LoginRequest{Email: "jo@example.com", Password: "secret1"}, dto.ClientInfo{IPAddress: "192.0.2.1"})
+	assert.NoError(t, err, "other addresses are not affected")
+}
@@ -0,0 +1,447 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/message"
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/pkg/encryption"
+)
+
+// fakeMessageRepo keeps messages in memory. Methods the tests do not reach
+// are left to the embedded nil interface.
+type fakeMessageRepo struct {
+	repository.MessageRepository
+	mu       sync.Mutex
+	messages []models.Message
+	indexed  map[string]string
+}
+
+func (r *fakeMessageRepo) Create(ctx context.Context, msg *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if msg.ClientMsgID != nil {
+		for _, m := range r.messages {
+			if m.ClientMsgID != nil && *m.ClientMsgID == *msg.ClientMsgID && m.SenderID == msg.SenderID && m.ChatRoomID == msg.ChatRoomID {
+				return repository.ErrDuplicateClientMsgID
+			}
+		}
+	}
+	r.messages = append(r.messages, *msg)
+	return nil
+}
+
+func (r *fakeMessageRepo) FindByClientMsgID(senderID, chatRoomID, clientMsgID string) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.messages {
+		if m.ClientMsgID != nil && *m.ClientMsgID == clientMsgID && m.SenderID.String() == senderID && m.ChatRoomID.String() == chatRoomID &&
+			(m.ExpiresAt == nil || m.ExpiresAt.After(time.Now())) {
+			return &m, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeMessageRepo) FindByID(id string) (*models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, m := range r.messages {
+		if m.ID.String() == id {
+			return &m, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeMessageRepo) IndexForSearch(id, text string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.indexed == nil {
+		r.indexed = map[string]string{}
+	}
+	r.indexed[id] = text
+	return nil
+}
+
+// inRoom returns the messages of a room in the order they were sent.
+func (r *fakeMessageRepo) inRoom(roomID string) []models.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.Message
+	for _, m := range r.messages {
+		if m.ChatRoomID.String() == roomID {
+			found = append(found, m)
+		}
+	}
+	return found
+}
+
+type fakeMentionRepo struct {
+	mu       sync.Mutex
+	messages *fakeMessageRepo
+	mentions []models.UserMention
+	// fail makes CreateBatch fail
+	fail error
+}
+
+func (r *fakeMentionRepo) CreateBatch(ctx context.Context, mentions []models.UserMention) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		return r.fail
+	}
+	r.mentions = append(r.mentions, mentions...)
+	return nil
+}
+
+func (r *fakeMentionRepo) live(m models.UserMention) bool {
+	msg, err := r.messages.FindByID(m.MessageID.String())
+	return err == nil && (msg.ExpiresAt == nil || msg.ExpiresAt.After(time.Now()))
+}
+
+func (r *fakeMentionRepo) FindByUser(userID string, limit, offset int) ([]models.UserMention, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.UserMention
+	for _, m := range r.mentions {
+		if m.UserID.String() == userID && r.live(m) {
+			found = append(found, m)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].CreatedAt.After(found[j].CreatedAt) })
+	if offset >= len(found) {
+		return nil, nil
+	}
+	found = found[offset:]
+	if len(found) > limit {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (r *fakeMentionRepo) CountUnreadByRoom(userID string) ([]repository.MentionUnreadCount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var counts []repository.MentionUnreadCount
+	for _, m := range r.mentions {
+		if m.UserID.String() != userID || m.ReadAt != nil || !r.live(m) {
+			continue
+		}
+		i := 0
+		for i < len(counts) && counts[i].ChatRoomID != m.ChatRoomID.String() {
+			i++
+		}
+		if i == len(counts) {
+			counts = append(counts, repository.MentionUnreadCount{ChatRoomID: m.ChatRoomID.String()})
+		}
+		counts[i].Count++
+	}
+	return counts, nil
+}
+
+func (r *fakeMentionRepo) markRead(match func(models.UserMention) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for i, m := range r.mentions {
+		if m.ReadAt == nil && match(m) {
+			r.mentions[i].ReadAt = &now
+		}
+	}
+}
+
+func (r *fakeMentionRepo) MarkRead(userID, messageID string) error {
+	r.markRead(func(m models.UserMention) bool {
+		return m.UserID.String() == userID && m.MessageID.String() == messageID
+	})
+	return nil
+}
+
+func (r *fakeMentionRepo) MarkRoomRead(userID, chatRoomID string) error {
+	r.markRead(func(m models.UserMention) bool {
+		return m.UserID.String() == userID && m.ChatRoomID.String() == chatRoomID
+	})
+	return nil
+}
+
+type fakeLinkPreviewRepo struct {
+	repository.LinkPreviewRepository
+	mu   sync.Mutex
+	jobs []models.LinkPreviewJob
+}
+
+func (r *fakeLinkPreviewRepo) CreateJob(job *models.LinkPreviewJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs = append(r.jobs, *job)
+	return nil
+}
+
+// GetMemberRole treats the first member of a room as its owner; the fake
+// keeps no other roles.
+func (r *fakeChatRoomRepo) GetMemberRole(roomID, userID string) (string, error) {
+	for i, id := range r.members[roomID] {
+		if id != userID {
+			continue
+		}
+		if i == 0 {
+			return models.RoleOwner, nil
+		}
+		return models.RoleMember, nil
+	}
+	return "", gorm.ErrRecordNotFound
+}
+
+func (r *fakeChatRoomRepo) GetMessageTTL(roomID string) (time.Duration, error) {
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return 0, gorm.ErrRecordNotFound
+	}
+	if room.MessageTTLSeconds == nil {
+		return 0, nil
+	}
+	return time.Duration(*room.MessageTTLSeconds) * time.Second, nil
+}
+
+func (r *fakeChatRoomRepo) GetEncryptionMode(roomID string) (string, error) {
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return "", gorm.ErrRecordNotFound
+	}
+	if room.EncryptionMode == "" {
+		return models.EncryptionModeE2E, nil
+	}
+	return room.EncryptionMode, nil
+}
+
+type publishedEvent struct {
+	target string
+	kind   string
+	data   any
+}
+
+// fakeEvents records what would have been published to Redis.
+type fakeEvents struct {
+	mu     sync.Mutex
+	online map[string]bool
+	room   []publishedEvent
+	user   []publishedEvent
+}
+
+func (e *fakeEvents) PublishRoomEvent(roomID, eventType string, data any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.room = append(e.room, publishedEvent{roomID, eventType, data})
+	return nil
+}
+
+func (e *fakeEvents) PublishUserEvent(userID, eventType string, data any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.user = append(e.user, publishedEvent{userID, eventType, data})
+	return nil
+}
+
+func (e *fakeEvents) IsUserOnline(userID string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.online[userID], nil
+}
+
+// userEvents returns the IDs of the users that got an event of this kind.
+func (e *fakeEvents) userEvents(kind string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var ids []string
+	for _, ev := range e.user {
+		if ev.kind == kind {
+			ids = append(ids, ev.target)
+		}
+	}
+	return ids
+}
+
+type messageFixture struct {
+	users     *fakeUserRepo
+	rooms     *fakeChatRoomRepo
+	blocks    *fakeBlockRepo
+	messages  *fakeMessageRepo
+	mentions  *fakeMentionRepo
+	scheduled *fakeScheduledRepo
+	previews  *fakeLinkPreviewRepo
+	s3        *fakeS3Service
+	events    *fakeEvents
+	service   message.Service
+}
+
+func newMessageFixture() *messageFixture {
+	users := &fakeUserRepo{users: map[uuid.UUID]models.User{}}
+	contacts := &fakeContactRepo{users: users}
+	messages := &fakeMessageRepo{}
+	f := &messageFixture{
+		users:    users,
+		rooms:    &fakeChatRoomRepo{users: users, rooms: map[string]*models.ChatRoom{}, members: map[string][]string{}},
+		blocks:   &fakeBlockRepo{users: users, contacts: contacts},
+		messages: messages,
+		mentions: &fakeMentionRepo{messages: messages},
+		previews: &fakeLinkPreviewRepo{},
+		s3:       &fakeS3Service{},
+		events:   &fakeEvents{online: map[string]bool{}},
+	}
+	f.scheduled = &fakeScheduledRepo{rooms: f.rooms, messages: messages}
+	f.service = message.NewMessageService(
+		f.messages,
+		f.rooms,
+		f.users,
+		nil,
+		f.mentions,
+		f.scheduled,
+		f.previews,
+		f.blocks,
+		contacts,
+		f.s3,
+		encryption.NewEncryptionService(),
+		nil,
+		f.events,
+	)
+	return f
+}
+
+func (f *messageFixture) addUser(t *testing.T, username string) *models.User {
+	u := &models.User{Username: username, Email: username + "@example.com"}
+	require.NoError(t, f.users.Create(u))
+	return u
+}
+
+// addRoom creates a group room in the given encryption mode, owned by the
+// first user.
+func (f *messageFixture) addRoom(t *testing.T, mode string, owner *models.User, members ...*models.User) string {
+	room := &models.ChatRoom{ID: uuid.New(), Name: "room", IsGroup: true, EncryptionMode: mode}
+	require.NoError(t, f.rooms.Create(room))
+	require.NoError(t, f.rooms.AddUserWithRole(room.ID.String(), owner.ID.String(), models.RoleOwner))
+	for _, m := range members {
+		require.NoError(t, f.rooms.AddUser(room.ID.String(), m.ID.String()))
+	}
+	return room.ID.String()
+}
+
+func (f *messageFixture) send(sender *models.User, roomID, content string, mentions ...dto.MentionEntity) (*dto.MessageResponse, error) {
+	return f.service.SendMessage(sender.ID.String(), dto.SendMessageRequest{
+		ReceiverID: roomID,
+		Content:    content,
+		Mentions:   mentions,
+	}, nil)
+}
+
+func mentionOf(u *models.User, offset, length int) dto.MentionEntity {
+	return dto.MentionEntity{Type: models.MentionTypeUser, UserID: u.ID.String(), Offset: offset, Length: length}
+}
+
+func TestMentionsAreValidated(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben, cyd := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd")
+	roomID := f.addRoom(t, models.EncryptionModeNone, ana, ben)
+
+	_, err := f.send(ana, roomID, "hi @ben", mentionOf(ben, 3, 5))
+	assert.Error(t, err, "the mention runs past the content")
+	_, err = f.send(ana, roomID, "hi @cyd", mentionOf(cyd, 3, 4))
+	assert.Error(t, err, "cyd is not in the room")
+	_, err = f.send(ben, roomID, "hey @room", dto.MentionEntity{Type: models.MentionTypeRoom, Offset: 4, Length: 5})
+	assert.Error(t, err, "only owners and admins may mention everyone")
+	_, err = f.send(ana, roomID, "hi @ben", dto.MentionEntity{Type: "channel", Offset: 3, Length: 4})
+	assert.Error(t, err)
+	assert.Empty(t, f.messages.inRoom(roomID), "rejected messages are not stored")
+
+	// Offsets count runes, not bytes
+	sent, err := f.send(ana, roomID, "héllo @ben", mentionOf(ben, 6, 4))
+	require.NoError(t, err)
+	require.Len(t, sent.Mentions, 1)
+	assert.Equal(t, 6, sent.Mentions[0].Offset)
+}
+
+func TestMentionRecipients(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben, cyd, dee, eli := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd"), f.addUser(t, "dee"), f.addUser(t, "eli")
+	roomID := f.addRoom(t, models.EncryptionModeNone, ana, ben, cyd, dee, eli)
+	require.NoError(t, f.blocks.Create(eli.ID.String(), ana.ID.String(), time.Now()))
+	f.events.online[cyd.ID.String()] = true
+	f.events.online[eli.ID.String()] = true
+
+	_, err := f.send(ana, roomID, "@ben @here", mentionOf(ben, 0, 4), dto.MentionEntity{Type: models.MentionTypeHere, Offset: 5, Length: 5})
+	require.NoError(t, err)
+
+	notified := f.events.userEvents("mention.created")
+	assert.ElementsMatch(t, []string{ben.ID.String(), cyd.ID.String()}, notified,
+		"ben is named, cyd is online, dee is offline, eli blocked ana and ana is the sender")
+
+	inbox, err := f.service.GetMentions(ben.ID.String(), 10, 0)
+	require.NoError(t, err)
+	require.Len(t, inbox, 1)
+	assert.Equal(t, models.MentionTypeUser, inbox[0].Type, "a direct mention wins over @here")
+	inbox, err = f.service.GetMentions(cyd.ID.String(), 10, 0)
+	require.NoError(t, err)
+	require.Len(t, inbox, 1)
+	assert.Equal(t, models.MentionTypeHere, inbox[0].Type)
+
+	_, err = f.send(ana, roomID, "all of you @room", dto.MentionEntity{Type: models.MentionTypeRoom, Offset: 11, Length: 5})
+	require.NoError(t, err)
+	counts, err := f.service.GetMentionUnreadCounts(dee.ID.String())
+	require.NoError(t, err)
+	require.Len(t, counts, 1)
+	assert.Equal(t, int64(1), counts[0].Count, "@room reaches offline members")
+
+	require.NoError(t, f.service.MarkMentionsRead(ben.ID.String(), roomID))
+	counts, err = f.service.GetMentionUnreadCounts(ben.ID.String())
+	require.NoError(t, err)
+	assert.Empty(t, counts)
+}
+
+func TestNewMessagesSkipMembersWhoBlockedTheSender(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben, cyd := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd")
+	roomID := f.addRoom(t, models.EncryptionModeNone, ana, ben, cyd)
+	require.NoError(t, f.blocks.Create(cyd.ID.String(), ana.ID.String(), time.Now()))
+
+	_, err := f.send(ana, roomID, "hello")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{ana.ID.String(), ben.ID.String()}, f.events.userEvents("message.created"),
+		"the sender's other devices get it too, cyd blocked ana")
+	for _, ev := range f.events.room {
+		assert.NotEqual(t, "message.created", ev.kind, "new messages are never sent to the whole room")
+	}
+}
+
+func TestFailedMentionsDoNotFailTheSend(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeNone, ana, ben)
+	f.mentions.fail = errors.New("database is down")
+
+	sent, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{
+		ReceiverID:  roomID,
+		Content:     "hi @ben",
+		Mentions:    []dto.MentionEntity{mentionOf(ben, 3, 4)},
+		ClientMsgID: "msg-1",
+	}, nil)
+	require.NoError(t, err, "the message is already stored and broadcast")
+
+	// So a retry finds the original instead of sending it twice
+	retried, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{ReceiverID: roomID, Content: "hi @ben", ClientMsgID: "msg-1"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, sent.ID, retried.ID)
+	assert.Len(t, f.messages.inRoom(roomID), 1)
+	assert.Empty(t, f.events.userEvents("mention.created"))
+}
@@ -0,0 +1,256 @@
+package tests
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+type fakeMFARepo struct {
+	mu    sync.Mutex
+	totp  *models.UserTOTP
+	codes map[string]map[string]bool // user id -> code hash -> used
+}
+
+func (r *fakeMFARepo) FindTOTP(userID string) (*models.UserTOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.totp == nil || r.totp.UserID.String() != userID {
+		return nil, nil
+	}
+	totp := *r.totp
+	return &totp, nil
+}
+
+func (r *fakeMFARepo) SaveTOTP(totp *models.UserTOTP) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	saved := *totp
+	r.totp = &saved
+	return nil
+}
+
+func (r *fakeMFARepo) ConfirmTOTP(userID string, step int64, hashes []string, now time.Time) error {
+	r.mu.Lock()
+	r.totp.ConfirmedAt = &now
+	r.totp.LastStep = step
+	r.mu.Unlock()
+	return r.ReplaceRecoveryCodes(userID, hashes)
+}
+
+func (r *fakeMFARepo) UseTOTPStep(userID string, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if step <= r.totp.LastStep {
+		return false, nil
+	}
+	r.totp.LastStep = step
+	return true, nil
+}
+
+func (r *fakeMFARepo) Disable(userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.totp = nil
+	delete(r.codes, userID)
+	return nil
+}
+
+func (r *fakeMFARepo) ReplaceRecoveryCodes(userID string, hashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.codes[userID] = map[string]bool{}
+	for _, hash := range hashes {
+		r.codes[userID][hash] = false
+	}
+	return nil
+}
+
+func (r *fakeMFARepo) UseRecoveryCode(userID, hash string, now time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	used, ok := r.codes[userID][hash]
+	if !ok || used {
+		return false, nil
+	}
+	r.codes[userID][hash] = true
+	return true, nil
+}
+
+func (r *fakeMFARepo) CountRecoveryCodes(userID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, used := range r.codes[userID] {
+		if !used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func TestTOTPMatchesRFC6238(t *testing.T) {
+	// Test vector from RFC 6238 appendix B, truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	code, err := auth.TOTPCode(secret, time.Unix(59, 0))
+	require.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	now := time.Unix(1111111109, 0)
+	code, err = auth.TOTPCode(secret, now)
+	require.NoError(t, err)
+	assert.Equal(t, "081804", code)
+
+	_, ok := auth.ValidateTOTP(secret, code, now.Add(30*time.Second))
+	assert.True(t, ok, "one step of clock drift is allowed")
+	_, ok = auth.ValidateTOTP(secret, code, now.Add(2*time.Minute))
+	assert.False(t, ok)
+}
+
+func TestRecoveryCodesAreNormalizedBeforeHashing(t *testing.T) {
+	codes, hashes, err := auth.GenerateRecoveryCodes(3)
+	require.NoError(t, err)
+	require.Len(t, codes, 3)
+	assert.Len(t, codes[0], 19)
+	assert.Equal(t, hashes[0], auth.HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))))
+	assert.NotEqual(t, hashes[0], hashes[1])
+}
+
+// enableTOTP registers a user and turns on two-factor login, returning the
+// user's id, secret and recovery codes.
+func enableTOTP(t *testing.T, service user.Service, email string) (string, string, []string) {
+	registered, err := service.Register(dto.CreateUserRequest{Username: email, Email: email, Password: "secret1"})
+	require.NoError(t, err)
+
+	enrollment, err := service.EnrollTOTP(registered.ID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/mozho:"))
+
+	status, err := service.GetMFAStatus(registered.ID)
+	require.NoError(t, err)
+	assert.False(t, status.TOTPEnabled, "not on until confirmed")
+
+	code, err := auth.TOTPCode(enrollment.Secret, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+	codes, err := service.ConfirmTOTP(registered.ID, dto.TOTPCodeRequest{Code: code})
+	require.NoError(t, err)
+	require.Len(t, codes.RecoveryCodes, 10)
+	return registered.ID, enrollment.Secret, codes.RecoveryCodes
+}
+
+func TestLoginWithTOTP(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	service, _, sessions := newTestUserService(t)
+	_, secret, _ := enableTOTP(t, service, "dana@example.com")
+
+	login, err := service.Login(dto.LoginRequest{Email: "dana@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.NoError(t, err)
+	assert.True(t, login.MFARequired)
+	assert.Nil(t, login.TokenResponse, "no tokens before the second factor")
+	assert.Empty(t, sessions.created)
+
+	_, err = service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: login.MFAToken, Code: "000000"}, dto.ClientInfo{})
+	assert.Error(t, err)
+
+	code, err := auth.TOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	tokens, err := service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: login.MFAToken, Code: code}, dto.ClientInfo{})
+	require.NoError(t, err)
+	assert.NotEmpty(t, tokens.Token)
+	assert.Len(t, sessions.created, 1)
+
+	_, err = service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: login.MFAToken, Code: code}, dto.ClientInfo{})
+	assert.Error(t, err, "the challenge is spent")
+
+	again, err := service.Login(dto.LoginRequest{Email: "dana@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.NoError(t, err)
+	_, err = service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: again.MFAToken, Code: code}, dto.ClientInfo{})
+	assert.Error(t, err, "a code works once")
+}
+
+func TestLoginChallengeEndsAfterTooManyWrongCodes(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	service, _, _ := newTestUserService(t)
+	_, _, recovery := enableTOTP(t, service, "erin@example.com")
+
+	login, err := service.Login(dto.LoginRequest{Email: "erin@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		_, err = service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: login.MFAToken, RecoveryCode: "nope"}, dto.ClientInfo{})
+		require.Error(t, err)
+	}
+	_, err = service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: login.MFAToken, RecoveryCode: recovery[0]}, dto.ClientInfo{})
+	assert.Error(t, err, "even a right code is refused once the challenge is over")
+}
+
+func TestRecoveryCodesAndDisablingTOTP(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	service, _, _ := newTestUserService(t)
+	userID, _, recovery := enableTOTP(t, service, "finn@example.com")
+
+	login, err := service.Login(dto.LoginRequest{Email: "finn@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.NoError(t, err)
+	_, err = service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: login.MFAToken, RecoveryCode: strings.ToUpper(recovery[0])}, dto.ClientInfo{})
+	require.NoError(t, err)
+
+	second, err := service.Login(dto.LoginRequest{Email: "finn@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.NoError(t, err)
+	_, err = service.VerifyMFA(dto.MFAVerifyRequest{MFAToken: second.MFAToken, RecoveryCode: recovery[0]}, dto.ClientInfo{})
+	assert.Error(t, err, "recovery codes work once")
+
+	status, err := service.GetMFAStatus(userID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 9, status.RecoveryCodesRemaining)
+
+	err = service.DisableTOTP(userID, "", dto.DisableTOTPRequest{Password: "wrong", RecoveryCode: recovery[1]})
+	assert.Error(t, err, "re-authentication is required")
+	require.NoError(t, service.DisableTOTP(userID, "", dto.DisableTOTPRequest{Password: "secret1", RecoveryCode: recovery[1]}))
+
+	login, err = service.Login(dto.LoginRequest{Email: "finn@example.com", Password: "secret1"}, dto.ClientInfo{})
+	require.NoError(t, err)
+	assert.False(t, login.MFARequired)
+	assert.NotEmpty(t, login.Token)
+}
+
+func TestAccountsWithoutPasswordDisableTOTPFromAFreshSignIn(t *testing.T) {
+	users := &fakeUserRepo{users: map[uuid.UUID]models.User{}}
+	service, _, sessions := newTestUserServiceWithUsers(t, users, nil)
+	sso := &models.User{Username: "gus", Email: "gus@example.com"}
+	require.NoError(t, users.Create(sso))
+	userID := sso.ID.String()
+
+	enrollment, err := service.EnrollTOTP(userID)
+	require.NoError(t, err)
+	code, err := auth.TOTPCode(enrollment.Secret, time.Now().Add(-30*time.Second))
+	require.NoError(t, err)
+	codes, err := service.ConfirmTOTP(userID, dto.TOTPCodeRequest{Code: code})
+	require.NoError(t, err)
+
+	signIn := func(at time.Time) string {
+		session := models.Session{ID: uuid.New(), UserID: sso.ID, FamilyID: uuid.New(), AuthenticatedAt: at, ExpiresAt: at.Add(time.Hour)}
+		require.NoError(t, sessions.Create(&session))
+		return session.FamilyID.String()
+	}
+	stale := signIn(time.Now().Add(-11 * time.Minute))
+	fresh := signIn(time.Now())
+
+	err = service.DisableTOTP(userID, stale, dto.DisableTOTPRequest{RecoveryCode: codes.RecoveryCodes[0]})
+	assert.Error(t, err, "the sign-in is too old")
+	err = service.DisableTOTP(userID, fresh, dto.DisableTOTPRequest{})
+	assert.Error(t, err, "a fresh sign-in still needs the second factor")
+
+	require.NoError(t, service.DisableTOTP(userID, fresh, dto.DisableTOTPRequest{RecoveryCode: codes.RecoveryCodes[0]}))
+	status, err := service.GetMFAStatus(userID)
+	require.NoError(t, err)
+	assert.False(t, status.TOTPEnabled)
+}
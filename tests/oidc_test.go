@@ -0,0 +1,380 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+type fakeIdentityRepo struct {
+	mu         sync.Mutex
+	identities []models.UserIdentity
+	states     map[string]models.OIDCLoginState
+}
+
+func (r *fakeIdentityRepo) Find(provider, subject string) (*models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, identity := range r.identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return &identity, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeIdentityRepo) Create(identity *models.UserIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	identity.ID = uuid.New()
+	identity.CreatedAt = time.Now()
+	r.identities = append(r.identities, *identity)
+	return nil
+}
+
+func (r *fakeIdentityRepo) ListByUser(userID string) ([]models.UserIdentity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var identities []models.UserIdentity
+	for _, identity := range r.identities {
+		if identity.UserID.String() == userID {
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+func (r *fakeIdentityRepo) RecordLogin(id, email string, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.identities {
+		if r.identities[i].ID.String() == id {
+			r.identities[i].Email, r.identities[i].LastLoginAt = email, &now
+		}
+	}
+	return nil
+}
+
+func (r *fakeIdentityRepo) CreateLoginState(state *models.OIDCLoginState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = map[string]models.OIDCLoginState{}
+	}
+	r.states[state.StateHash] = *state
+	return nil
+}
+
+func (r *fakeIdentityRepo) ConsumeLoginState(stateHash string, now time.Time) (*models.OIDCLoginState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[stateHash]
+	if !ok || !state.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	delete(r.states, stateHash)
+	return &state, nil
+}
+
+func (r *fakeIdentityRepo) DeleteExpiredLoginStates(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+type mockAuthorization struct {
+	claims        jwt.MapClaims
+	codeChallenge string
+	redirectURI   string
+}
+
+// mockIdP is an OpenID Connect provider that logs in whoever the test
+// says, checking the client, redirect URI and PKCE like a real one.
+type mockIdP struct {
+	server       *httptest.Server
+	key          *rsa.PrivateKey
+	clientID     string
+	clientSecret string
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+	// tamper edits ID token claims before they are signed
+	tamper func(claims jwt.MapClaims)
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	idp := &mockIdP{key: key, clientID: "mozho", clientSecret: "s3cret", codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.issuer(),
+			"authorization_endpoint": idp.issuer() + "/authorize",
+			"token_endpoint":         idp.issuer() + "/token",
+			"jwks_uri":               idp.issuer() + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []auth.JWK{{
+			Kty: "RSA", Kid: "idp-key", Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *mockIdP) setTamper(tamper func(claims jwt.MapClaims)) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.tamper = tamper
+}
+
+func (idp *mockIdP) issuer() string {
+	return idp.server.URL
+}
+
+func (idp *mockIdP) config(name string) auth.OIDCProviderConfig {
+	return auth.OIDCProviderConfig{
+		Name:         name,
+		DisplayName:  name,
+		Issuer:       idp.issuer(),
+		ClientID:     idp.clientID,
+		ClientSecret: idp.clientSecret,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != idp.clientID || secret != idp.clientSecret {
+		fail("invalid_client")
+		return
+	}
+	idp.mu.Lock()
+	authz, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	tamper := idp.tamper
+	idp.mu.Unlock()
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != authz.redirectURI {
+		fail("invalid_grant")
+		return
+	}
+	if auth.PKCEChallenge(r.PostFormValue("code_verifier")) != authz.codeChallenge {
+		fail("invalid_grant")
+		return
+	}
+	if tamper != nil {
+		tamper(authz.claims)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, authz.claims)
+	token.Header["kid"] = "idp-key"
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		fail("server_error")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+}
+
+// login plays the user logging in at the provider after being sent to
+// authURL, and returns the state and code it redirects back with.
+func (idp *mockIdP) login(t *testing.T, authURL, subject, email string, emailVerified bool) (string, string) {
+	u, err := url.Parse(authURL)
+	require.NoError(t, err)
+	q := u.Query()
+	require.Equal(t, idp.issuer()+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	require.Equal(t, idp.clientID, q.Get("client_id"))
+	require.Equal(t, "code", q.Get("response_type"))
+	require.Equal(t, "S256", q.Get("code_challenge_method"))
+
+	now := time.Now()
+	code := uuid.NewString()
+	idp.mu.Lock()
+	idp.codes[code] = mockAuthorization{
+		claims: jwt.MapClaims{
+			"iss":                idp.issuer(),
+			"sub":                subject,
+			"aud":                idp.clientID,
+			"iat":                now.Unix(),
+			"exp":                now.Add(5 * time.Minute).Unix(),
+			"nonce":              q.Get("nonce"),
+			"email":              email,
+			"email_verified":     emailVerified,
+			"preferred_username": subject,
+		},
+		codeChallenge: q.Get("code_challenge"),
+		redirectURI:   q.Get("redirect_uri"),
+	}
+	idp.mu.Unlock()
+	return q.Get("state"), code
+}
+
+func oidcLogin(t *testing.T, service user.Service, idp *mockIdP, provider, subject, email string, emailVerified bool) (*dto.LoginResponse, error) {
+	start, err := service.StartOIDCLogin(provider)
+	require.NoError(t, err)
+	state, code := idp.login(t, start.AuthorizationURL, subject, email, emailVerified)
+	require.Equal(t, start.State, state)
+	return service.FinishOIDCLogin(dto.OIDCCallbackRequest{State: state, Code: code}, dto.ClientInfo{})
+}
+
+func loggedInUserID(t *testing.T, res *dto.LoginResponse) string {
+	require.NotNil(t, res.TokenResponse)
+	claims, err := auth.ParseJWT(res.Token)
+	require.NoError(t, err)
+	return claims.UserID
+}
+
+func TestOIDCLoginProvisionsAndRecognizesUsers(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	corp, partner := newMockIdP(t), newMockIdP(t)
+	providers := auth.NewOIDCProviders("https://chat.example.com/oidc/callback",
+		[]auth.OIDCProviderConfig{corp.config("corp"), partner.config("partner")}, nil)
+	service, _, _ := newTestUserServiceWithOIDC(t, providers)
+
+	listed := service.ListOIDCProviders()
+	require.Len(t, listed, 2)
+	assert.Equal(t, "corp", listed[0].Name)
+
+	res, err := oidcLogin(t, service, corp, "corp", "dana", "dana@corp.example", true)
+	require.NoError(t, err)
+	danaID := loggedInUserID(t, res)
+	profile, err := service.GetProfile(danaID)
+	require.NoError(t, err)
+	assert.Equal(t, "dana@corp.example", profile.Email)
+	assert.True(t, profile.EmailVerified, "the provider verified the email")
+
+	// The subject finds the account again, even after the email changed
+	res, err = oidcLogin(t, service, corp, "corp", "dana", "dana.new@corp.example", true)
+	require.NoError(t, err)
+	assert.Equal(t, danaID, loggedInUserID(t, res))
+	identities, err := service.ListIdentities(danaID)
+	require.NoError(t, err)
+	require.Len(t, identities, 1)
+	assert.Equal(t, "dana.new@corp.example", identities[0].Email)
+
+	// Subjects are only unique within a provider
+	res, err = oidcLogin(t, service, partner, "partner", "dana", "erin@partner.example", true)
+	require.NoError(t, err)
+	assert.NotEqual(t, danaID, loggedInUserID(t, res))
+
+	_, err = service.StartOIDCLogin("unknown")
+	assert.Error(t, err)
+}
+
+func TestOIDCLoginLinksAccountsByVerifiedEmail(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	idp, restricted := newMockIdP(t), newMockIdP(t)
+	restrictedCfg := restricted.config("restricted")
+	restrictedCfg.AllowedDomains = []string{"corp.example"}
+	providers := auth.NewOIDCProviders("https://chat.example.com/oidc/callback",
+		[]auth.OIDCProviderConfig{idp.config("corp"), restrictedCfg}, nil)
+	service, _, _ := newTestUserServiceWithOIDC(t, providers)
+
+	registered, err := service.Register(dto.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	_, err = oidcLogin(t, service, idp, "corp", "alice-sub", "alice@example.com", false)
+	assert.Error(t, err, "unverified emails do not link accounts")
+	_, err = oidcLogin(t, service, restricted, "restricted", "alice-sub", "alice@example.com", true)
+	assert.Error(t, err, "provider may not vouch for other domains")
+
+	res, err := oidcLogin(t, service, idp, "corp", "alice-sub", "alice@example.com", true)
+	require.NoError(t, err)
+	assert.Equal(t, registered.ID, loggedInUserID(t, res))
+
+	// Whoever registered the unverified account may not have been alice, so
+	// the password no longer works
+	_, err = service.Login(dto.LoginRequest{Email: "alice@example.com", Password: "secret1"}, dto.ClientInfo{})
+	assert.Error(t, err)
+
+	// Accounts with two-factor login still need the second factor
+	enableTOTP(t, service, "bob@corp.example")
+	res, err = oidcLogin(t, service, idp, "corp", "bob-sub", "bob@corp.example", true)
+	require.NoError(t, err)
+	assert.True(t, res.MFARequired)
+	assert.Nil(t, res.TokenResponse)
+}
+
+func TestOIDCRejectsForgedAndReplayedLogins(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	idp := newMockIdP(t)
+	providers := auth.NewOIDCProviders("https://chat.example.com/oidc/callback", []auth.OIDCProviderConfig{idp.config("corp")}, nil)
+	service, _, _ := newTestUserServiceWithOIDC(t, providers)
+
+	start, err := service.StartOIDCLogin("corp")
+	require.NoError(t, err)
+	state, code := idp.login(t, start.AuthorizationURL, "carol", "carol@corp.example", true)
+	_, err = service.FinishOIDCLogin(dto.OIDCCallbackRequest{State: state, Code: code}, dto.ClientInfo{})
+	require.NoError(t, err)
+	_, err = service.FinishOIDCLogin(dto.OIDCCallbackRequest{State: state, Code: code}, dto.ClientInfo{})
+	assert.Error(t, err, "state is single use")
+	_, err = service.FinishOIDCLogin(dto.OIDCCallbackRequest{State: "made-up", Code: code}, dto.ClientInfo{})
+	assert.Error(t, err, "unknown state")
+
+	forged := map[string]func(jwt.MapClaims){
+		"other nonce":    func(c jwt.MapClaims) { c["nonce"] = "replayed" },
+		"other audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"other issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.test" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"other client":   func(c jwt.MapClaims) { c["aud"] = []string{idp.clientID, "other"}; c["azp"] = "other" },
+	}
+	for name, tamper := range forged {
+		idp.setTamper(tamper)
+		_, err := oidcLogin(t, service, idp, "corp", "carol", "carol@corp.example", true)
+		assert.Error(t, err, name)
+	}
+	idp.setTamper(nil)
+
+	// The code is only exchanged with the verifier of the login that
+	// asked for it
+	provider := providers.Get("corp")
+	login, err := auth.NewOIDCLogin()
+	require.NoError(t, err)
+	authURL, err := provider.AuthorizationURL(context.Background(), providers.RedirectURL, login)
+	require.NoError(t, err)
+	_, code = idp.login(t, authURL, "carol", "carol@corp.example", true)
+	stolen := *login
+	stolen.CodeVerifier = "not-the-verifier"
+	_, err = provider.Exchange(context.Background(), providers.RedirectURL, code, &stolen)
+	assert.Error(t, err)
+
+	// Tokens signed by another key are rejected
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": idp.issuer(), "sub": "carol", "aud": idp.clientID, "nonce": "n",
+		"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "idp-key"
+	forgedToken, err := token.SignedString(other)
+	require.NoError(t, err)
+	_, err = provider.VerifyIDToken(context.Background(), forgedToken, "n")
+	assert.Error(t, err)
+}
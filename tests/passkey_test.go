@@ -0,0 +1,485 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+)
+
+var testWebAuthnConfig = auth.WebAuthnConfig{
+	RPID:    "chat.example.com",
+	RPName:  "mozho",
+	Origins: []string{"https://chat.example.com"},
+}
+
+type fakePasskeyRepo struct {
+	mu         sync.Mutex
+	passkeys   []models.Passkey
+	challenges map[string]models.WebAuthnChallenge
+}
+
+func (r *fakePasskeyRepo) Create(passkey *models.Passkey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.passkeys {
+		if bytes.Equal(p.CredentialID, passkey.CredentialID) {
+			return gorm.ErrDuplicatedKey
+		}
+	}
+	passkey.ID = uuid.New()
+	passkey.CreatedAt = time.Now()
+	r.passkeys = append(r.passkeys, *passkey)
+	return nil
+}
+
+func (r *fakePasskeyRepo) ListByUser(userID string) ([]models.Passkey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var passkeys []models.Passkey
+	for _, p := range r.passkeys {
+		if p.UserID.String() == userID {
+			passkeys = append(passkeys, p)
+		}
+	}
+	return passkeys, nil
+}
+
+func (r *fakePasskeyRepo) FindByCredentialID(credentialID []byte) (*models.Passkey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range r.passkeys {
+		if bytes.Equal(p.CredentialID, credentialID) {
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakePasskeyRepo) update(id string, fn func(p *models.Passkey) bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.passkeys {
+		if r.passkeys[i].ID.String() == id {
+			return fn(&r.passkeys[i])
+		}
+	}
+	return false
+}
+
+func (r *fakePasskeyRepo) RecordLogin(id string, signCount int64, backedUp bool, now time.Time) (bool, error) {
+	return r.update(id, func(p *models.Passkey) bool {
+		if p.CloneSuspectedAt != nil || (signCount != 0 && p.SignCount >= signCount) {
+			return false
+		}
+		p.SignCount, p.BackedUp, p.LastUsedAt = signCount, backedUp, &now
+		return true
+	}), nil
+}
+
+func (r *fakePasskeyRepo) MarkCloneSuspected(id string, now time.Time) error {
+	r.update(id, func(p *models.Passkey) bool {
+		p.CloneSuspectedAt = &now
+		return true
+	})
+	return nil
+}
+
+func (r *fakePasskeyRepo) Rename(userID, id, name string) (bool, error) {
+	return r.update(id, func(p *models.Passkey) bool {
+		if p.UserID.String() != userID {
+			return false
+		}
+		p.Name = name
+		return true
+	}), nil
+}
+
+func (r *fakePasskeyRepo) Delete(userID, id string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, p := range r.passkeys {
+		if p.ID.String() == id && p.UserID.String() == userID {
+			r.passkeys = append(r.passkeys[:i], r.passkeys[i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *fakePasskeyRepo) CreateChallenge(challenge *models.WebAuthnChallenge) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.challenges == nil {
+		r.challenges = map[string]models.WebAuthnChallenge{}
+	}
+	r.challenges[string(challenge.Challenge)] = *challenge
+	return nil
+}
+
+func (r *fakePasskeyRepo) ConsumeChallenge(challenge []byte, purpose string, now time.Time) (*models.WebAuthnChallenge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch, ok := r.challenges[string(challenge)]
+	if !ok || ch.Purpose != purpose || !ch.ExpiresAt.After(now) {
+		return nil, nil
+	}
+	delete(r.challenges, string(challenge))
+	return &ch, nil
+}
+
+func (r *fakePasskeyRepo) DeleteExpiredChallenges(ctx context.Context, now time.Time) (int64, error) {
+	return 0, nil
+}
+
+// cborMap keeps the key order of an encoded map, as authenticators send
+// COSE keys in canonical order.
+type cborMap [][2]any
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 1<<8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+}
+
+func encodeCBOR(v any) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, kv := range v {
+			out = append(out, encodeCBOR(kv[0])...)
+			out = append(out, encodeCBOR(kv[1])...)
+		}
+		return out
+	}
+	panic("unsupported CBOR value")
+}
+
+// softAuthenticator is a passkey held in memory, standing in for a
+// security key or a phone.
+type softAuthenticator struct {
+	origin       string
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	counter      uint32
+	// skipUV answers without asking for a PIN or biometric
+	skipUV bool
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	require.NoError(t, err)
+	return &softAuthenticator{origin: "https://chat.example.com", key: key, credentialID: id}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) clientData(t *testing.T, ceremony, challenge string) []byte {
+	data, err := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": a.origin})
+	require.NoError(t, err)
+	return data
+}
+
+func (a *softAuthenticator) authData(rpID string, flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags |= 0x01 // user present
+	if !a.skipUV {
+		flags |= 0x04
+	}
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *dto.PasskeyCreationOptions) dto.AttestationCredential {
+	pub := a.key.PublicKey
+	coseKey := encodeCBOR(cborMap{
+		{1, 2}, {3, auth.COSEAlgES256}, {-1, 1},
+		{-2, pub.X.FillBytes(make([]byte, 32))},
+		{-3, pub.Y.FillBytes(make([]byte, 32))},
+	})
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(append(attested, a.credentialID...), coseKey...)
+
+	authData := a.authData(options.PublicKey.RP.ID, 0x40, attested)
+	attestation := encodeCBOR(cborMap{{"fmt", "none"}, {"attStmt", cborMap{}}, {"authData", authData}})
+	return dto.AttestationCredential{
+		ID:   b64(a.credentialID),
+		Type: "public-key",
+		Response: dto.AttestationResponse{
+			ClientDataJSON:    b64(a.clientData(t, "webauthn.create", options.PublicKey.Challenge)),
+			AttestationObject: b64(attestation),
+			Transports:        []string{"internal"},
+		},
+	}
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *dto.PasskeyRequestOptions) dto.AssertionCredential {
+	a.counter++
+	authData := a.authData(options.PublicKey.RPID, 0, nil)
+	clientData := a.clientData(t, "webauthn.get", options.PublicKey.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+	return dto.AssertionCredential{
+		ID:   b64(a.credentialID),
+		Type: "public-key",
+		Response: dto.AssertionResponse{
+			ClientDataJSON:    b64(clientData),
+			AuthenticatorData: b64(authData),
+			Signature:         b64(signature),
+		},
+	}
+}
+
+// registerPasskey adds the authenticator's passkey to the user's account.
+func registerPasskey(t *testing.T, service interface {
+	BeginPasskeyRegistration(string, string, dto.PasskeyRegistrationOptionsRequest) (*dto.PasskeyCreationOptions, error)
+	FinishPasskeyRegistration(string, dto.RegisterPasskeyRequest) (*dto.PasskeyResponse, error)
+}, userID, name string, a *softAuthenticator) *dto.PasskeyResponse {
+	options, err := service.BeginPasskeyRegistration(userID, "", dto.PasskeyRegistrationOptionsRequest{Password: "secret1"})
+	require.NoError(t, err)
+	passkey, err := service.FinishPasskeyRegistration(userID, dto.RegisterPasskeyRequest{Name: name, Credential: a.create(t, options)})
+	require.NoError(t, err)
+	return passkey
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	service, _, _ := newTestUserService(t)
+	registered, err := service.Register(dto.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	laptop, phone := newSoftAuthenticator(t), newSoftAuthenticator(t)
+	registerPasskey(t, service, registered.ID, "Laptop", laptop)
+
+	options, err := service.BeginPasskeyRegistration(registered.ID, "", dto.PasskeyRegistrationOptionsRequest{Password: "secret1"})
+	require.NoError(t, err)
+	require.Len(t, options.PublicKey.ExcludeCredentials, 1, "the browser is told which passkeys exist")
+	_, err = service.FinishPasskeyRegistration(registered.ID, dto.RegisterPasskeyRequest{Credential: phone.create(t, options)})
+	require.NoError(t, err)
+
+	passkeys, err := service.ListPasskeys(registered.ID)
+	require.NoError(t, err)
+	require.Len(t, passkeys, 2)
+	assert.Equal(t, "Passkey", passkeys[1].Name, "default name")
+
+	// With an email the browser only offers that account's passkeys
+	loginOptions, err := service.BeginPasskeyLogin(dto.PasskeyLoginOptionsRequest{Email: "alice@example.com"})
+	require.NoError(t, err)
+	assert.Len(t, loginOptions.PublicKey.AllowCredentials, 2)
+	tokens, err := service.FinishPasskeyLogin(dto.PasskeyLoginRequest{Credential: laptop.get(t, loginOptions)}, dto.ClientInfo{})
+	require.NoError(t, err)
+	claims, err := auth.ParseJWT(tokens.Token)
+	require.NoError(t, err)
+	assert.Equal(t, registered.ID, claims.UserID)
+
+	// Without one, the user handle tells us whose passkey it is
+	loginOptions, err = service.BeginPasskeyLogin(dto.PasskeyLoginOptionsRequest{})
+	require.NoError(t, err)
+	assert.Empty(t, loginOptions.PublicKey.AllowCredentials)
+	credential := phone.get(t, loginOptions)
+	handle, err := uuid.Parse(registered.ID)
+	require.NoError(t, err)
+	credential.Response.UserHandle = b64(handle[:])
+	_, err = service.FinishPasskeyLogin(dto.PasskeyLoginRequest{Credential: credential}, dto.ClientInfo{})
+	require.NoError(t, err)
+
+	// A response only answers its own challenge once
+	_, err = service.FinishPasskeyLogin(dto.PasskeyLoginRequest{Credential: credential}, dto.ClientInfo{})
+	assert.Error(t, err, "replayed assertion")
+
+	unknown, err := service.BeginPasskeyLogin(dto.PasskeyLoginOptionsRequest{Email: "nobody@example.com"})
+	require.NoError(t, err)
+	assert.Empty(t, unknown.PublicKey.AllowCredentials, "unknown emails look like no email")
+}
+
+func TestClonedPasskeyIsDisabled(t *testing.T) {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	service, _, _ := newTestUserService(t)
+	registered, err := service.Register(dto.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	original := newSoftAuthenticator(t)
+	registerPasskey(t, service, registered.ID, "Security key", original)
+	clone := *original
+
+	login := func(a *softAuthenticator) error {
+		options, err := service.BeginPasskeyLogin(dto.PasskeyLoginOptionsRequest{Email: "alice@example.com"})
+		require.NoError(t, err)
+		_, err = service.FinishPasskeyLogin(dto.PasskeyLoginRequest{Credential: a.get(t, options)}, dto.ClientInfo{})
+		return err
+	}
+	require.NoError(t, login(original))
+	assert.Error(t, login(&clone), "the copy reports a counter that was already used")
+	assert.Error(t, login(original), "the passkey stays disabled")
+
+	passkeys, err := service.ListPasskeys(registered.ID)
+	require.NoError(t, err)
+	require.Len(t, passkeys, 1)
+	assert.True(t, passkeys[0].CloneSuspected)
+
+	// Removing it and registering again is the way back
+	require.NoError(t, service.DeletePasskey(registered.ID, passkeys[0].ID))
+	fresh := newSoftAuthenticator(t)
+	registerPasskey(t, service, registered.ID, "New key", fresh)
+	assert.NoError(t, login(fresh))
+}
+
+func TestPasskeysAreManagedByTheirOwner(t *testing.T) {
+	service, _, _ := newTestUserService(t)
+	alice, err := service.Register(dto.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	bob, err := service.Register(dto.CreateUserRequest{Username: "bob", Email: "bob@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	a := newSoftAuthenticator(t)
+	passkey := registerPasskey(t, service, alice.ID, "Laptop", a)
+
+	options, err := service.BeginPasskeyRegistration(bob.ID, "", dto.PasskeyRegistrationOptionsRequest{Password: "secret1"})
+	require.NoError(t, err)
+	_, err = service.FinishPasskeyRegistration(bob.ID, dto.RegisterPasskeyRequest{Credential: a.create(t, options)})
+	assert.Error(t, err, "a passkey belongs to one account")
+
+	// A challenge opened for one user cannot register a passkey for another
+	options, err = service.BeginPasskeyRegistration(alice.ID, "", dto.PasskeyRegistrationOptionsRequest{Password: "secret1"})
+	require.NoError(t, err)
+	_, err = service.FinishPasskeyRegistration(bob.ID, dto.RegisterPasskeyRequest{Credential: newSoftAuthenticator(t).create(t, options)})
+	assert.Error(t, err)
+
+	assert.Error(t, service.RenamePasskey(bob.ID, passkey.ID, dto.RenamePasskeyRequest{Name: "Mine"}))
+	assert.Error(t, service.DeletePasskey(bob.ID, passkey.ID))
+	require.NoError(t, service.RenamePasskey(alice.ID, passkey.ID, dto.RenamePasskeyRequest{Name: "Work laptop"}))
+
+	passkeys, err := service.ListPasskeys(alice.ID)
+	require.NoError(t, err)
+	require.Len(t, passkeys, 1)
+	assert.Equal(t, "Work laptop", passkeys[0].Name)
+}
+
+func TestWebAuthnResponsesAreBoundToTheRelyingParty(t *testing.T) {
+	cfg := testWebAuthnConfig
+	challenge, err := auth.NewWebAuthnChallenge()
+	require.NoError(t, err)
+	options := &dto.PasskeyCreationOptions{PublicKey: dto.PublicKeyCredentialCreationOptions{
+		RP:        dto.RelyingParty{ID: cfg.RPID},
+		Challenge: b64(challenge),
+	}}
+	register := func(a *softAuthenticator, options *dto.PasskeyCreationOptions) (*auth.WebAuthnCredential, error) {
+		cred := a.create(t, options)
+		clientData, _ := auth.DecodeBase64URL(cred.Response.ClientDataJSON)
+		attestation, _ := auth.DecodeBase64URL(cred.Response.AttestationObject)
+		return cfg.VerifyWebAuthnRegistration(challenge, clientData, attestation, true)
+	}
+
+	a := newSoftAuthenticator(t)
+	credential, err := register(a, options)
+	require.NoError(t, err)
+	assert.Equal(t, a.credentialID, credential.ID)
+	assert.Equal(t, int64(auth.COSEAlgES256), credential.Algorithm)
+
+	phished := newSoftAuthenticator(t)
+	phished.origin = "https://chat.example.com.evil.test"
+	_, err = register(phished, options)
+	assert.Error(t, err, "other origin")
+
+	otherRP := *options
+	otherRP.PublicKey.RP.ID = "evil.test"
+	_, err = register(newSoftAuthenticator(t), &otherRP)
+	assert.Error(t, err, "credential scoped to another relying party")
+
+	otherChallenge := *options
+	otherChallenge.PublicKey.Challenge = b64([]byte("some other challenge"))
+	_, err = register(newSoftAuthenticator(t), &otherChallenge)
+	assert.Error(t, err, "other challenge")
+
+	noUV := newSoftAuthenticator(t)
+	noUV.skipUV = true
+	_, err = register(noUV, options)
+	assert.Error(t, err, "user verification required")
+
+	// Assertions must be signed by the registered key
+	get := &dto.PasskeyRequestOptions{PublicKey: dto.PublicKeyCredentialRequestOptions{RPID: cfg.RPID, Challenge: b64(challenge)}}
+	verify := func(a *softAuthenticator, stored uint32) error {
+		cred := a.get(t, get)
+		clientData, _ := auth.DecodeBase64URL(cred.Response.ClientDataJSON)
+		authData, _ := auth.DecodeBase64URL(cred.Response.AuthenticatorData)
+		signature, _ := auth.DecodeBase64URL(cred.Response.Signature)
+		_, err := cfg.VerifyWebAuthnAssertion(challenge, credential.PublicKey, stored, clientData, authData, signature, true)
+		return err
+	}
+	assert.NoError(t, verify(a, 0))
+	assert.ErrorIs(t, verify(a, 5), auth.ErrSignCountRegressed)
+	impostor := newSoftAuthenticator(t)
+	impostor.credentialID = a.credentialID
+	assert.Error(t, verify(impostor, 0), "signed by another key")
+}
+
+func TestAddingAPasskeyNeedsReauthentication(t *testing.T) {
+	users := &fakeUserRepo{users: map[uuid.UUID]models.User{}}
+	service, _, sessions := newTestUserServiceWithUsers(t, users, nil)
+	registered, err := service.Register(dto.CreateUserRequest{Username: "alice", Email: "alice@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	_, err = service.BeginPasskeyRegistration(registered.ID, "", dto.PasskeyRegistrationOptionsRequest{})
+	assert.Error(t, err, "an access token alone is not enough")
+	_, err = service.BeginPasskeyRegistration(registered.ID, "", dto.PasskeyRegistrationOptionsRequest{Password: "wrong-secret"})
+	assert.Error(t, err)
+
+	// Without a password, a recent sign-in from the same session confirms it
+	sso := &models.User{Username: "cleo", Email: "cleo@example.com"}
+	require.NoError(t, users.Create(sso))
+	signIn := func(at time.Time) string {
+		session := models.Session{ID: uuid.New(), UserID: sso.ID, FamilyID: uuid.New(), AuthenticatedAt: at, ExpiresAt: at.Add(time.Hour)}
+		require.NoError(t, sessions.Create(&session))
+		return session.FamilyID.String()
+	}
+	stale := signIn(time.Now().Add(-11 * time.Minute))
+	fresh := signIn(time.Now())
+
+	_, err = service.BeginPasskeyRegistration(sso.ID.String(), stale, dto.PasskeyRegistrationOptionsRequest{})
+	assert.Error(t, err, "the sign-in is too old")
+	options, err := service.BeginPasskeyRegistration(sso.ID.String(), fresh, dto.PasskeyRegistrationOptionsRequest{})
+	require.NoError(t, err)
+	_, err = service.FinishPasskeyRegistration(sso.ID.String(), dto.RegisterPasskeyRequest{Credential: newSoftAuthenticator(t).create(t, options)})
+	assert.NoError(t, err)
+}
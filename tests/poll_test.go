@@ -0,0 +1,245 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/poll"
+	"mozho_chat/internal/poll/dto"
+	"mozho_chat/internal/repository"
+)
+
+// fakePollRepo keeps polls and their votes in memory and stores poll
+// messages in a fakeMessageRepo.
+type fakePollRepo struct {
+	messages *fakeMessageRepo
+	polls    map[string]*models.Poll
+	votes    []models.PollVote
+}
+
+func (r *fakePollRepo) CreateWithMessage(ctx context.Context, p *models.Poll, msg *models.Message) error {
+	if err := r.messages.Create(ctx, msg); err != nil {
+		return err
+	}
+	stored := *p
+	r.polls[p.ID.String()] = &stored
+	return nil
+}
+
+func (r *fakePollRepo) FindByID(id string) (*models.Poll, error) {
+	p, ok := r.polls[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := *p
+	return &found, nil
+}
+
+func (r *fakePollRepo) ReplaceVotes(pollID, userID string, optionIDs []uuid.UUID, now time.Time) error {
+	p, ok := r.polls[pollID]
+	if !ok {
+		return gorm.ErrRecordNotFound
+	}
+	if p.IsClosed(now) {
+		return repository.ErrPollClosed
+	}
+	kept := r.votes[:0]
+	for _, v := range r.votes {
+		if v.PollID.String() != pollID || v.UserID.String() != userID {
+			kept = append(kept, v)
+		}
+	}
+	r.votes = kept
+	for _, optionID := range optionIDs {
+		r.votes = append(r.votes, models.PollVote{ID: uuid.New(), PollID: p.ID, OptionID: optionID, UserID: uuid.MustParse(userID), CreatedAt: now})
+	}
+	return nil
+}
+
+func (r *fakePollRepo) FindVotes(pollID string) ([]models.PollVote, error) {
+	var votes []models.PollVote
+	for _, v := range r.votes {
+		if v.PollID.String() == pollID {
+			votes = append(votes, v)
+		}
+	}
+	return votes, nil
+}
+
+func (r *fakePollRepo) Close(pollID string, now time.Time) (*models.Poll, error) {
+	p, ok := r.polls[pollID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if p.ClosedAt == nil {
+		closedAt := now
+		if p.ClosesAt != nil && p.ClosesAt.Before(now) {
+			closedAt = *p.ClosesAt
+		}
+		votes, _ := r.FindVotes(pollID)
+		p.ClosedAt = &closedAt
+		p.FinalResults = p.Tally(votes)
+	}
+	closed := *p
+	return &closed, nil
+}
+
+type pollFixture struct {
+	*messageFixture
+	polls   *fakePollRepo
+	service poll.Service
+}
+
+func newPollFixture() *pollFixture {
+	f := &pollFixture{messageFixture: newMessageFixture()}
+	f.polls = &fakePollRepo{messages: f.messages, polls: map[string]*models.Poll{}}
+	f.service = poll.NewService(f.polls, f.rooms, f.blocks, f.events)
+	return f
+}
+
+func (f *pollFixture) create(t *testing.T, creator *models.User, roomID string, change func(*dto.CreatePollRequest)) *dto.PollResponse {
+	req := dto.CreatePollRequest{ChatRoomID: uuid.MustParse(roomID), Question: "Lunch?", Options: []string{"Pizza", "Sushi", "Tacos"}}
+	if change != nil {
+		change(&req)
+	}
+	created, err := f.service.CreatePoll(creator.ID.String(), req)
+	require.NoError(t, err)
+	return created
+}
+
+func vote(ids ...uuid.UUID) dto.VoteRequest {
+	return dto.VoteRequest{OptionIDs: ids}
+}
+
+func TestPollCreationIsValidated(t *testing.T) {
+	f := newPollFixture()
+	ana, ben, cyd := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	room := uuid.MustParse(roomID)
+	past := time.Now().Add(-time.Minute)
+
+	for name, req := range map[string]dto.CreatePollRequest{
+		"duplicate options": {ChatRoomID: room, Question: "Lunch?", Options: []string{"Pizza", " pizza "}},
+		"empty option":      {ChatRoomID: room, Question: "Lunch?", Options: []string{"Pizza", " "}},
+		"blank question":    {ChatRoomID: room, Question: "  ", Options: []string{"Pizza", "Sushi"}},
+		"closed already":    {ChatRoomID: room, Question: "Lunch?", Options: []string{"Pizza", "Sushi"}, ClosesAt: &past},
+	} {
+		_, err := f.service.CreatePoll(ana.ID.String(), req)
+		assert.Error(t, err, name)
+	}
+	_, err := f.service.CreatePoll(cyd.ID.String(), dto.CreatePollRequest{ChatRoomID: room, Question: "Lunch?", Options: []string{"Pizza", "Sushi"}})
+	assert.Error(t, err, "only members can create polls")
+	sealed := f.addRoom(t, models.EncryptionModeE2E, ana, ben)
+	_, err = f.service.CreatePoll(ana.ID.String(), dto.CreatePollRequest{ChatRoomID: uuid.MustParse(sealed), Question: "Lunch?", Options: []string{"Pizza", "Sushi"}})
+	assert.Error(t, err, "the server cannot tally votes in end-to-end encrypted rooms")
+
+	created := f.create(t, ben, roomID, nil)
+	sent := f.messages.inRoom(roomID)
+	require.Len(t, sent, 1)
+	assert.Equal(t, models.MessageTypePoll, sent[0].Type)
+	assert.Equal(t, created.MessageID, sent[0].ID)
+	assert.Equal(t, models.EncryptionNone, sent[0].EncryptionMetadata.Algorithm)
+}
+
+func TestPollVoteRules(t *testing.T) {
+	f := newPollFixture()
+	ana, ben, cyd := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	single := f.create(t, ana, roomID, nil)
+	pizza, sushi, tacos := single.Options[0].ID, single.Options[1].ID, single.Options[2].ID
+
+	_, err := f.service.Vote(ana.ID.String(), single.ID.String(), vote(pizza, sushi))
+	assert.Error(t, err, "single choice polls take one option")
+	_, err = f.service.Vote(ana.ID.String(), single.ID.String(), vote(uuid.New()))
+	assert.Error(t, err, "options must belong to the poll")
+	_, err = f.service.Vote(cyd.ID.String(), single.ID.String(), vote(pizza))
+	assert.Error(t, err, "only members can vote")
+
+	res, err := f.service.Vote(ana.ID.String(), single.ID.String(), vote(pizza, pizza))
+	require.NoError(t, err, "repeating an option counts once")
+	assert.Equal(t, []uuid.UUID{pizza}, res.MyVotes)
+	res, err = f.service.Vote(ana.ID.String(), single.ID.String(), vote(sushi))
+	require.NoError(t, err)
+	assert.Equal(t, []uuid.UUID{sushi}, res.MyVotes, "voting again replaces the vote")
+	res, err = f.service.Vote(ben.ID.String(), single.ID.String(), vote(sushi))
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), res.Options[1].Votes)
+	assert.ElementsMatch(t, []uuid.UUID{ana.ID, ben.ID}, res.Options[1].VoterIDs)
+	assert.Equal(t, 2, res.TotalVoters)
+
+	res, err = f.service.RetractVote(ana.ID.String(), single.ID.String())
+	require.NoError(t, err)
+	assert.Empty(t, res.MyVotes)
+	assert.Equal(t, 1, res.TotalVoters)
+
+	multi := f.create(t, ana, roomID, func(req *dto.CreatePollRequest) {
+		req.MultipleChoice = true
+		req.Anonymous = true
+	})
+	res, err = f.service.Vote(ben.ID.String(), multi.ID.String(), vote(multi.Options[0].ID, multi.Options[2].ID))
+	require.NoError(t, err)
+	assert.Len(t, res.MyVotes, 2)
+	assert.Equal(t, 1, res.TotalVoters)
+	assert.Empty(t, res.Options[0].VoterIDs, "anonymous polls hide voters")
+
+	// Only the creator or room admins may close a poll; afterwards the
+	// results are frozen
+	_, err = f.service.ClosePoll(ben.ID.String(), single.ID.String())
+	assert.Error(t, err)
+	closed, err := f.service.ClosePoll(ana.ID.String(), single.ID.String())
+	require.NoError(t, err)
+	assert.True(t, closed.Closed)
+	_, err = f.service.Vote(ana.ID.String(), single.ID.String(), vote(tacos))
+	assert.ErrorIs(t, err, repository.ErrPollClosed)
+	_, err = f.service.RetractVote(ben.ID.String(), single.ID.String())
+	assert.ErrorIs(t, err, repository.ErrPollClosed)
+
+	res, err = f.service.GetPoll(ben.ID.String(), single.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), res.Options[1].Votes)
+	assert.Equal(t, []uuid.UUID{sushi}, res.MyVotes)
+}
+
+func TestPollsCloseAtTheirDeadline(t *testing.T) {
+	f := newPollFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	closesAt := time.Now().Add(time.Hour)
+	created := f.create(t, ana, roomID, func(req *dto.CreatePollRequest) { req.ClosesAt = &closesAt })
+	_, err := f.service.Vote(ben.ID.String(), created.ID.String(), vote(created.Options[0].ID))
+	require.NoError(t, err)
+
+	deadline := time.Now().Add(-time.Minute)
+	f.polls.polls[created.ID.String()].ClosesAt = &deadline
+	res, err := f.service.GetPoll(ana.ID.String(), created.ID.String())
+	require.NoError(t, err)
+	assert.True(t, res.Closed)
+	require.NotNil(t, res.ClosedAt)
+	assert.Equal(t, deadline, *res.ClosedAt, "a poll that ran out of time closes at its deadline")
+	assert.Equal(t, int64(1), res.Options[0].Votes)
+}
+
+func TestPollEventsSkipMembersWhoBlockedTheCreator(t *testing.T) {
+	f := newPollFixture()
+	ana, ben, cyd := f.addUser(t, "ana"), f.addUser(t, "ben"), f.addUser(t, "cyd")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben, cyd)
+	require.NoError(t, f.blocks.Create(cyd.ID.String(), ana.ID.String(), time.Now()))
+
+	created := f.create(t, ana, roomID, nil)
+	_, err := f.service.Vote(ben.ID.String(), created.ID.String(), vote(created.Options[0].ID))
+	require.NoError(t, err)
+	_, err = f.service.ClosePoll(ana.ID.String(), created.ID.String())
+	require.NoError(t, err)
+
+	both := []string{ana.ID.String(), ben.ID.String()}
+	assert.ElementsMatch(t, both, f.events.userEvents("message.created"))
+	assert.ElementsMatch(t, append(both, both...), f.events.userEvents("poll.updated"), "cyd blocked ana")
+	assert.Empty(t, f.events.room, "poll events are never sent to the whole room")
+}
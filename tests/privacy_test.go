@@ -0,0 +1,176 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/contact/dto"
+	"mozho_chat/internal/message"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+)
+
+func TestVisibilitySettingsAreReciprocal(t *testing.T) {
+	alice := &models.User{ID: uuid.New()}
+	bob := &models.User{ID: uuid.New()}
+
+	assert.True(t, alice.LastSeenVisibleTo(bob, false), "unset settings mean everyone")
+
+	alice.Privacy.LastSeenVisibility = models.VisibilityContacts
+	assert.False(t, alice.LastSeenVisibleTo(bob, false))
+	assert.True(t, alice.LastSeenVisibleTo(bob, true))
+	assert.False(t, bob.LastSeenVisibleTo(alice, false), "hiding your own last seen hides other people's")
+	assert.True(t, alice.LastSeenVisibleTo(alice, false), "users always see their own")
+
+	bob.Privacy.ReadReceiptsVisibility = models.VisibilityNobody
+	assert.False(t, alice.ReadReceiptsVisibleTo(bob, true))
+	assert.False(t, bob.ReadReceiptsVisibleTo(alice, true))
+
+	alice.Privacy.ProfilePhotoVisibility = models.VisibilityContacts
+	bob.Privacy.ProfilePhotoVisibility = models.VisibilityNobody
+	assert.True(t, alice.ProfilePhotoVisible(true), "photo visibility is not reciprocal")
+	assert.False(t, alice.ProfilePhotoVisible(false))
+}
+
+func (f *contactFixture) befriend(t *testing.T, a, b *models.User) {
+	sent, err := f.contacts.SendRequest(a.ID.String(), dto.SendContactRequest{UserID: b.ID})
+	require.NoError(t, err)
+	_, err = f.contacts.AcceptRequest(b.ID.String(), sent.ID.String())
+	require.NoError(t, err)
+}
+
+func (f *contactFixture) setPrivacy(t *testing.T, u *models.User, change func(*models.PrivacySettings)) {
+	stored, err := f.users.FindByID(u.ID.String())
+	require.NoError(t, err)
+	change(&stored.Privacy)
+	require.NoError(t, f.users.Update(stored))
+}
+
+func TestPresenceFollowsPrivacySettings(t *testing.T) {
+	f := newContactFixture()
+	rhea, sol, tam, uma := f.addUser(t, "rhea"), f.addUser(t, "sol"), f.addUser(t, "tam"), f.addUser(t, "uma")
+	f.befriend(t, rhea, sol)
+	f.befriend(t, rhea, tam)
+	f.setPrivacy(t, rhea, func(p *models.PrivacySettings) {
+		p.OnlineVisibility = models.VisibilityContacts
+		p.LastSeenVisibility = models.VisibilityContacts
+	})
+	// Tam hides their own online status, so they do not see Rhea's
+	f.setPrivacy(t, tam, func(p *models.PrivacySettings) { p.OnlineVisibility = models.VisibilityNobody })
+
+	require.NoError(t, f.presence.SetUserOnline(rhea.ID.String(), time.Minute))
+	assert.Equal(t, []string{sol.ID.String()}, f.store.notified)
+	require.NoError(t, f.presence.SetUserOnline(rhea.ID.String(), time.Minute))
+	assert.Len(t, f.store.notified, 1, "only coming online is announced")
+
+	seen, err := f.presence.GetPresence(sol.ID.String(), rhea.ID.String())
+	require.NoError(t, err)
+	require.NotNil(t, seen.Online)
+	assert.True(t, *seen.Online)
+	assert.NotNil(t, seen.LastSeen)
+
+	hidden, err := f.presence.GetPresence(uma.ID.String(), rhea.ID.String())
+	require.NoError(t, err)
+	assert.Nil(t, hidden.Online)
+	assert.Nil(t, hidden.LastSeen)
+
+	list, err := f.contacts.ListContacts(tam.ID.String())
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.False(t, list[0].Online)
+	assert.NotNil(t, list[0].LastSeen)
+
+	require.NoError(t, f.contacts.BlockUser(rhea.ID.String(), uma.ID.String()))
+	_, err = f.presence.GetPresence(uma.ID.String(), rhea.ID.String())
+	assert.Error(t, err)
+}
+
+func TestProfilePhotoFollowsPrivacySettings(t *testing.T) {
+	f := newContactFixture()
+	vera, wes := f.addUser(t, "vera"), f.addUser(t, "wes")
+	stored, err := f.users.FindByID(vera.ID.String())
+	require.NoError(t, err)
+	require.NoError(t, stored.SetProfileData(models.UserProfile{AvatarThumbnailURL: "https://cdn.test/vera.png"}))
+	stored.Privacy.ProfilePhotoVisibility = models.VisibilityContacts
+	require.NoError(t, f.users.Update(stored))
+
+	sent, err := f.contacts.SendRequest(vera.ID.String(), dto.SendContactRequest{UserID: wes.ID})
+	require.NoError(t, err)
+	assert.Empty(t, sent.From.AvatarURL, "not shown before the request is accepted")
+
+	_, err = f.contacts.AcceptRequest(wes.ID.String(), sent.ID.String())
+	require.NoError(t, err)
+	list, err := f.contacts.ListContacts(wes.ID.String())
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "https://cdn.test/vera.png", list[0].User.AvatarURL)
+}
+
+// fakeReceiptRepo serves one message and its statuses. Methods the tests
+// do not reach are left to the embedded nil interface.
+type fakeReceiptRepo struct {
+	repository.MessageRepository
+	users    *fakeUserRepo
+	message  models.Message
+	statuses []models.MessageStatus
+}
+
+func (r *fakeReceiptRepo) FindByID(id string) (*models.Message, error) {
+	if id != r.message.ID.String() {
+		return nil, assert.AnError
+	}
+	msg := r.message
+	return &msg, nil
+}
+
+func (r *fakeReceiptRepo) ListStatuses(messageID string) ([]models.MessageStatus, error) {
+	statuses := make([]models.MessageStatus, len(r.statuses))
+	for i, st := range r.statuses {
+		u, _ := r.users.FindByID(st.UserID.String())
+		st.User = *u
+		statuses[i] = st
+	}
+	return statuses, nil
+}
+
+func TestReadReceiptsFollowPrivacySettings(t *testing.T) {
+	f := newContactFixture()
+	xia, yan, zoe, abe := f.addUser(t, "xia"), f.addUser(t, "yan"), f.addUser(t, "zoe"), f.addUser(t, "abe")
+	f.befriend(t, xia, yan)
+	f.setPrivacy(t, yan, func(p *models.PrivacySettings) { p.ReadReceiptsVisibility = models.VisibilityContacts })
+	f.setPrivacy(t, zoe, func(p *models.PrivacySettings) { p.ReadReceiptsVisibility = models.VisibilityContacts })
+
+	msg := models.Message{ID: uuid.New(), SenderID: xia.ID}
+	repo := &fakeReceiptRepo{users: f.users, message: msg, statuses: []models.MessageStatus{
+		{MessageID: msg.ID, UserID: yan.ID, Delivered: true, Read: true},
+		{MessageID: msg.ID, UserID: zoe.ID, Delivered: true, Read: true},
+		{MessageID: msg.ID, UserID: abe.ID, Read: true},
+	}}
+	service := message.NewMessageService(repo, nil, f.users, nil, nil, nil, nil, nil, f.contactRepo, nil, nil, nil, nil)
+
+	receipts, err := service.ListReceipts(xia.ID.String(), msg.ID.String())
+	require.NoError(t, err)
+	read := map[string]bool{}
+	for _, r := range receipts {
+		assert.True(t, r.Delivered)
+		read[r.UserID] = r.Read
+	}
+	assert.True(t, read[yan.ID.String()], "contacts see the read")
+	assert.False(t, read[zoe.ID.String()], "non-contacts do not")
+	assert.True(t, read[abe.ID.String()])
+
+	_, err = service.ListReceipts(yan.ID.String(), msg.ID.String())
+	assert.Error(t, err, "only the sender sees receipts")
+
+	// Turning off your own read receipts hides everyone else's
+	f.setPrivacy(t, xia, func(p *models.PrivacySettings) { p.ReadReceiptsVisibility = models.VisibilityNobody })
+	receipts, err = service.ListReceipts(xia.ID.String(), msg.ID.String())
+	require.NoError(t, err)
+	for _, r := range receipts {
+		assert.False(t, r.Read)
+	}
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/mailer"
+	s3upload "mozho_chat/pkg/s3"
+)
+
+// fakeS3Service keeps uploaded photos in memory. Only the calls the tests
+// use are implemented.
+type fakeS3Service struct {
+	mu      sync.Mutex
+	objects map[string]bool
+	deleted []string
+	// failDeletes makes DeleteFile fail
+	failDeletes bool
+}
+
+func (s *fakeS3Service) UploadFile(*multipart.FileHeader, string, string, string, bool) (*s3upload.UploadResult, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *fakeS3Service) UploadPhoto(buffer []byte, originalName, entityType, entityId, fileType string, isPublic bool, withThumbnail bool) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.objects == nil {
+		s.objects = map[string]bool{}
+	}
+	key := fmt.Sprintf("%s/%s/%s/%s-%s", entityType, entityId, fileType, uuid.NewString(), originalName)
+	s.objects[key] = true
+	result := map[string]string{"original": "https://cdn.test/" + key, "originalKey": key}
+	if withThumbnail {
+		s.objects["thumb-"+key] = true
+		result["thumbnail"] = "https://cdn.test/thumb-" + key
+		result["thumbnailKey"] = "thumb-" + key
+	}
+	return result, nil
+}
+
+func (s *fakeS3Service) UploadObject(string, io.ReadSeeker, string, bool) error {
+	return fmt.Errorf("not implemented")
+}
+
+func (s *fakeS3Service) OpenFile(string, bool) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (s *fakeS3Service) GetPrivateSignedUrl(string, time.Duration) (string, error) {
+	return "", fmt.Errorf("not implemented")
+}
+
+func (s *fakeS3Service) DeleteFile(key string, isPublic bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failDeletes {
+		return fmt.Errorf("storage unavailable")
+	}
+	delete(s.objects, key)
+	s.deleted = append(s.deleted, key)
+	return nil
+}
+
+func (s *fakeS3Service) stored() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.objects)
+}
+
+func newProfileTestService(t *testing.T) (user.Service, *fakeUserRepo, *fakeS3Service) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	users := &fakeUserRepo{users: map[uuid.UUID]models.User{}}
+	s3 := &fakeS3Service{}
+	service := user.NewUserService(user.Deps{
+		Repo:         users,
+		SessionRepo:  &fakeSessionRepo{},
+		TokenRepo:    &fakeUserTokenRepo{tokens: map[uuid.UUID]models.UserToken{}},
+		MFARepo:      &fakeMFARepo{codes: map[string]map[string]bool{}},
+		PasskeyRepo:  &fakePasskeyRepo{},
+		WebAuthn:     testWebAuthnConfig,
+		IdentityRepo: &fakeIdentityRepo{},
+		AuditRepo:    &fakeAuditRepo{},
+		Limiter:      newFakeRateLimiter(time.Now),
+		ContactRepo:  &fakeContactRepo{users: users},
+		Mailer:       mailer.NewMemoryMailer(),
+		Encryption:   encryption.NewEncryptionService(),
+		S3Service:    s3,
+		Revoker:      fakeRevocations{},
+	})
+	return service, users, s3
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestUpdateProfileValidatesAndStoresProfile(t *testing.T) {
+	service, users, _ := newProfileTestService(t)
+	registered, err := service.Register(dto.CreateUserRequest{Username: "ada", Email: "ada@example.com", Password: "secret123"})
+	require.NoError(t, err)
+
+	bad := dto.UpdateUserRequest{Profile: &dto.ProfileInput{Timezone: strPtr("Mars/Olympus_Mons")}}
+	assert.Error(t, binding.Validator.ValidateStruct(bad))
+	good := dto.UpdateUserRequest{Profile: &dto.ProfileInput{Timezone: strPtr("Europe/Berlin"), Bio: strPtr("")}}
+	assert.NoError(t, binding.Validator.ValidateStruct(good))
+
+	past := time.Now().Add(-time.Minute)
+	_, err = service.UpdateProfile(registered.ID, dto.UpdateUserRequest{Profile: &dto.ProfileInput{StatusText: strPtr("lunch"), StatusExpiresAt: &past}})
+	assert.Error(t, err)
+
+	expiresAt := time.Now().Add(time.Hour)
+	updated, err := service.UpdateProfile(registered.ID, dto.UpdateUserRequest{
+		DisplayName: strPtr("Ada Lovelace"),
+		Profile: &dto.ProfileInput{
+			Bio:             strPtr("  Analyst  "),
+			Timezone:        strPtr("Europe/London"),
+			StatusText:      strPtr("In a meeting"),
+			StatusExpiresAt: &expiresAt,
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Ada Lovelace", updated.DisplayName)
+	assert.Equal(t, "Analyst", updated.Profile.Bio)
+	assert.Equal(t, "Europe/London", updated.Profile.Timezone)
+	assert.Equal(t, "In a meeting", updated.Profile.StatusText)
+
+	// Fields left out are kept
+	updated, err = service.UpdateProfile(registered.ID, dto.UpdateUserRequest{Profile: &dto.ProfileInput{Bio: strPtr("")}})
+	require.NoError(t, err)
+	assert.Empty(t, updated.Profile.Bio)
+	assert.Equal(t, "Europe/London", updated.Profile.Timezone)
+
+	// A status past its expiry is no longer shown
+	stored, err := users.FindByID(registered.ID)
+	require.NoError(t, err)
+	profile, err := stored.ProfileData()
+	require.NoError(t, err)
+	profile.StatusExpiresAt = &past
+	require.NoError(t, stored.SetProfileData(profile))
+	require.NoError(t, users.Update(stored))
+
+	got, err := service.GetProfile(registered.ID)
+	require.NoError(t, err)
+	assert.Empty(t, got.Profile.StatusText)
+	assert.Nil(t, got.Profile.StatusExpiresAt)
+}
+
+func TestUpdateAvatarReplacesAndDeletesOldObjects(t *testing.T) {
+	service, _, s3 := newProfileTestService(t)
+	registered, err := service.Register(dto.CreateUserRequest{Username: "ada", Email: "ada@example.com", Password: "secret123"})
+	require.NoError(t, err)
+
+	_, err = service.UpdateAvatar(registered.ID, []byte("not an image"))
+	assert.Error(t, err)
+	_, err = service.UpdateAvatar(registered.ID, testPNG(t, 5000, 10))
+	assert.Error(t, err)
+	assert.Zero(t, s3.stored())
+
+	first, err := service.UpdateAvatar(registered.ID, testPNG(t, 64, 64))
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.Profile.AvatarURL)
+	assert.NotEmpty(t, first.Profile.AvatarThumbnailURL)
+	assert.Equal(t, 2, s3.stored())
+
+	second, err := service.UpdateAvatar(registered.ID, testPNG(t, 64, 64))
+	require.NoError(t, err)
+	assert.NotEqual(t, first.Profile.AvatarURL, second.Profile.AvatarURL)
+	assert.Equal(t, 2, s3.stored(), "the old avatar and thumbnail are deleted")
+
+	removed, err := service.DeleteAvatar(registered.ID)
+	require.NoError(t, err)
+	assert.Empty(t, removed.Profile.AvatarURL)
+	assert.Zero(t, s3.stored())
+	assert.Len(t, s3.deleted, 4)
+}
@@ -0,0 +1,289 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+)
+
+// fakeScheduledRepo keeps scheduled messages in memory and publishes them
+// into a fakeMessageRepo the way the real repository does.
+type fakeScheduledRepo struct {
+	rooms     *fakeChatRoomRepo
+	messages  *fakeMessageRepo
+	scheduled []models.ScheduledMessage
+	// failing makes publishing the scheduled message with that ID fail
+	failing map[uuid.UUID]error
+}
+
+func (r *fakeScheduledRepo) Create(ctx context.Context, msg *models.ScheduledMessage) error {
+	if msg.ClientMsgID != nil {
+		existing, _ := r.FindByClientMsgID(msg.SenderID.String(), msg.ChatRoomID.String(), *msg.ClientMsgID)
+		if existing != nil {
+			return repository.ErrDuplicateClientMsgID
+		}
+	}
+	r.scheduled = append(r.scheduled, *msg)
+	return nil
+}
+
+func (r *fakeScheduledRepo) FindByClientMsgID(senderID, chatRoomID, clientMsgID string) (*models.ScheduledMessage, error) {
+	for _, m := range r.scheduled {
+		if m.ClientMsgID != nil && *m.ClientMsgID == clientMsgID && m.SenderID.String() == senderID && m.ChatRoomID.String() == chatRoomID {
+			return &m, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *fakeScheduledRepo) FindByID(id string) (*models.ScheduledMessage, error) {
+	for _, m := range r.scheduled {
+		if m.ID.String() == id {
+			return &m, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *fakeScheduledRepo) FindPendingBySender(senderID, chatRoomID string) ([]models.ScheduledMessage, error) {
+	var found []models.ScheduledMessage
+	for _, m := range r.scheduled {
+		if m.SenderID.String() == senderID && m.Status == models.ScheduledStatusPending && (chatRoomID == "" || m.ChatRoomID.String() == chatRoomID) {
+			found = append(found, m)
+		}
+	}
+	return found, nil
+}
+
+func (r *fakeScheduledRepo) pending(id uuid.UUID) *models.ScheduledMessage {
+	for i := range r.scheduled {
+		if r.scheduled[i].ID == id && r.scheduled[i].Status == models.ScheduledStatusPending {
+			return &r.scheduled[i]
+		}
+	}
+	return nil
+}
+
+func (r *fakeScheduledRepo) UpdatePending(msg *models.ScheduledMessage) error {
+	stored := r.pending(msg.ID)
+	if stored == nil {
+		return repository.ErrScheduledMessageNotPending
+	}
+	stored.Content = msg.Content
+	stored.EncryptionMetadata = msg.EncryptionMetadata
+	stored.Mentions = msg.Mentions
+	stored.SendAt = msg.SendAt
+	stored.ExpiresAt = msg.ExpiresAt
+	return nil
+}
+
+func (r *fakeScheduledRepo) CancelPending(id, senderID string) error {
+	stored := r.pending(uuid.MustParse(id))
+	if stored == nil || stored.SenderID.String() != senderID {
+		return repository.ErrScheduledMessageNotPending
+	}
+	stored.Status = models.ScheduledStatusCancelled
+	return nil
+}
+
+func (r *fakeScheduledRepo) PublishNextDue(ctx context.Context, now time.Time) (*models.Message, *models.ScheduledMessage, error) {
+	var due *models.ScheduledMessage
+	for i := range r.scheduled {
+		m := &r.scheduled[i]
+		if m.Status == models.ScheduledStatusPending && !m.SendAt.After(now) && (due == nil || m.SendAt.Before(due.SendAt)) {
+			due = m
+		}
+	}
+	if due == nil {
+		return nil, nil, nil
+	}
+	if err := r.failing[due.ID]; err != nil {
+		repository.FailScheduledAttempt(due, err, now)
+		failed := *due
+		return nil, nil, &repository.ScheduledPublishError{Scheduled: &failed, Err: err}
+	}
+
+	inRoom, _ := r.rooms.IsUserInRoom(due.ChatRoomID.String(), due.SenderID.String())
+	if !inRoom {
+		due.Status = models.ScheduledStatusCancelled
+		cancelled := *due
+		return nil, &cancelled, nil
+	}
+
+	expiresAt := due.ExpiresAt
+	if expiresAt == nil {
+		ttl, err := r.rooms.GetMessageTTL(due.ChatRoomID.String())
+		if err != nil {
+			return nil, nil, err
+		}
+		if ttl > 0 {
+			t := now.Add(ttl)
+			expiresAt = &t
+		}
+	}
+	msg := &models.Message{
+		ID:                 uuid.New(),
+		ChatRoomID:         due.ChatRoomID,
+		SenderID:           due.SenderID,
+		Type:               models.MessageTypeText,
+		Content:            due.Content,
+		EncryptionMetadata: due.EncryptionMetadata,
+		Mentions:           due.Mentions,
+		ExpiresAt:          expiresAt,
+		CreatedAt:          now,
+	}
+	for _, file := range due.Attachments {
+		msg.Attachments = append(msg.Attachments, models.Attachment{ID: uuid.New(), MessageID: msg.ID, Key: file.Key, FileName: file.FileName})
+	}
+	if err := r.messages.Create(ctx, msg); err != nil {
+		return nil, nil, err
+	}
+	due.Status = models.ScheduledStatusSent
+	due.MessageID = &msg.ID
+	return msg, nil, nil
+}
+
+// makeDue moves a scheduled message into the past.
+func (r *fakeScheduledRepo) makeDue(id string) {
+	for i := range r.scheduled {
+		if r.scheduled[i].ID.String() == id {
+			r.scheduled[i].SendAt = time.Now().Add(-time.Second)
+		}
+	}
+}
+
+func (f *messageFixture) schedule(sender *models.User, roomID, content string, sendAt time.Time) (*dto.ScheduledMessageResponse, error) {
+	return f.service.ScheduleMessage(sender.ID.String(), dto.SendMessageRequest{
+		ReceiverID: roomID,
+		Content:    content,
+		SendAt:     &sendAt,
+	}, nil)
+}
+
+func TestScheduledMessageLifecycle(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+	later := time.Now().Add(time.Hour)
+
+	_, err := f.schedule(ana, roomID, "too late", time.Now().Add(-time.Minute))
+	assert.Error(t, err, "send_at must be in the future")
+	_, err = f.schedule(ana, roomID, "too early", time.Now().Add(2*365*24*time.Hour))
+	assert.Error(t, err)
+
+	scheduled, err := f.schedule(ana, roomID, "good morning", later)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduledStatusPending, scheduled.Status)
+
+	edited := "good morning everyone"
+	_, err = f.service.UpdateScheduledMessage(ben.ID.String(), scheduled.ID, dto.UpdateScheduledMessageRequest{Content: &edited})
+	assert.Error(t, err, "only the sender may edit it")
+	_, err = f.service.UpdateScheduledMessage(ana.ID.String(), scheduled.ID, dto.UpdateScheduledMessageRequest{Content: &edited})
+	require.NoError(t, err)
+
+	published, err := f.service.PublishDueMessages(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, published, "nothing is due yet")
+
+	f.scheduled.makeDue(scheduled.ID)
+	published, err = f.service.PublishDueMessages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+
+	sent := f.messages.inRoom(roomID)
+	require.Len(t, sent, 1)
+	assert.Equal(t, "good morning everyone", f.messages.indexed[sent[0].ID.String()], "the edited text is sent")
+	stored, err := f.scheduled.FindByID(scheduled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduledStatusSent, stored.Status)
+	assert.Equal(t, sent[0].ID, *stored.MessageID)
+
+	// Once sent it can no longer be changed or cancelled
+	_, err = f.service.UpdateScheduledMessage(ana.ID.String(), scheduled.ID, dto.UpdateScheduledMessageRequest{Content: &edited})
+	assert.Error(t, err)
+	assert.Error(t, f.service.CancelScheduledMessage(ana.ID.String(), scheduled.ID))
+
+	pending, err := f.service.ListScheduledMessages(ana.ID.String(), roomID)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestCancelledScheduledMessagesDeleteTheirAttachments(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+
+	// Cancelled by the sender
+	scheduled, err := f.schedule(ben, roomID, "see you", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	f.scheduled.scheduled[0].Attachments = append(f.scheduled.scheduled[0].Attachments, models.ScheduledAttachment{Key: "scheduled/a.pdf"})
+	require.NoError(t, f.service.CancelScheduledMessage(ben.ID.String(), scheduled.ID))
+	assert.Error(t, f.service.CancelScheduledMessage(ben.ID.String(), scheduled.ID))
+	assert.Equal(t, []string{"scheduled/a.pdf"}, f.s3.deleted)
+
+	// Cancelled by the scheduler because the sender left the room
+	scheduled, err = f.schedule(ben, roomID, "bye", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	f.scheduled.scheduled[1].Attachments = append(f.scheduled.scheduled[1].Attachments, models.ScheduledAttachment{Key: "scheduled/b.pdf"})
+	f.scheduled.makeDue(scheduled.ID)
+	f.rooms.members[roomID] = []string{ana.ID.String()}
+
+	published, err := f.service.PublishDueMessages(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, published)
+	assert.Empty(t, f.messages.inRoom(roomID))
+	stored, err := f.scheduled.FindByID(scheduled.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduledStatusCancelled, stored.Status)
+	assert.Equal(t, []string{"scheduled/a.pdf", "scheduled/b.pdf"}, f.s3.deleted)
+}
+
+func TestFailingScheduledMessageDoesNotBlockTheQueue(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+
+	broken, err := f.schedule(ana, roomID, "broken", time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	f.scheduled.scheduled[0].Attachments = append(f.scheduled.scheduled[0].Attachments, models.ScheduledAttachment{Key: "scheduled/broken.pdf"})
+	fine, err := f.schedule(ben, roomID, "fine", time.Now().Add(2*time.Hour))
+	require.NoError(t, err)
+	f.scheduled.makeDue(broken.ID)
+	f.scheduled.makeDue(fine.ID)
+	f.scheduled.failing = map[uuid.UUID]error{uuid.MustParse(broken.ID): errors.New("insert failed")}
+
+	published, err := f.service.PublishDueMessages(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, published, "the message behind the failing one is still sent")
+	stored, err := f.scheduled.FindByID(broken.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.ScheduledStatusPending, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+	assert.Equal(t, "insert failed", *stored.LastError)
+	assert.True(t, stored.SendAt.After(time.Now()), "the failing message is pushed back")
+
+	// It is given up on after a few attempts
+	for i := 0; i < 10 && stored.Status == models.ScheduledStatusPending; i++ {
+		f.scheduled.makeDue(broken.ID)
+		_, err = f.service.PublishDueMessages(context.Background())
+		require.NoError(t, err)
+		stored, err = f.scheduled.FindByID(broken.ID)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, models.ScheduledStatusFailed, stored.Status)
+	assert.Equal(t, []string{"scheduled/broken.pdf"}, f.s3.deleted)
+	listed, err := f.service.ListScheduledMessages(ana.ID.String(), roomID)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+	assert.Len(t, f.messages.inRoom(roomID), 1)
+}
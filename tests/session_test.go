@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/user"
+	"mozho_chat/internal/user/dto"
+	"mozho_chat/pkg/auth"
+	"mozho_chat/pkg/encryption"
+	"mozho_chat/pkg/mailer"
+)
+
+// flakyRevoker fails to revoke the sessions in down.
+type flakyRevoker struct {
+	fakeRevocations
+	down map[string]bool
+}
+
+func (r flakyRevoker) RevokeSession(sessionID, userID string, ttl time.Duration) error {
+	if r.down[sessionID] {
+		return errors.New("redis is down")
+	}
+	return r.fakeRevocations.RevokeSession(sessionID, userID, ttl)
+}
+
+type sessionFixture struct {
+	sessions    *fakeSessionRepo
+	revocations fakeRevocations
+	// revokeDown makes revoking these sessions' access tokens fail
+	revokeDown map[string]bool
+	service    user.Service
+	userID     string
+}
+
+// newSessionFixture registers one user whose sessions the tests work with.
+func newSessionFixture(t *testing.T) *sessionFixture {
+	useTestKeys(t, testKeyOptions(auth.AlgorithmEdDSA))
+	t.Setenv("JWT_SECRET", "test-secret")
+	users := &fakeUserRepo{users: map[uuid.UUID]models.User{}}
+	f := &sessionFixture{sessions: &fakeSessionRepo{}, revocations: fakeRevocations{}, revokeDown: map[string]bool{}}
+	f.service = user.NewUserService(user.Deps{
+		Repo:         users,
+		SessionRepo:  f.sessions,
+		TokenRepo:    &fakeUserTokenRepo{tokens: map[uuid.UUID]models.UserToken{}},
+		MFARepo:      &fakeMFARepo{codes: map[string]map[string]bool{}},
+		PasskeyRepo:  &fakePasskeyRepo{},
+		WebAuthn:     testWebAuthnConfig,
+		IdentityRepo: &fakeIdentityRepo{},
+		AuditRepo:    &fakeAuditRepo{},
+		Limiter:      newFakeRateLimiter(time.Now),
+		ContactRepo:  &fakeContactRepo{users: users},
+		Mailer:       mailer.NewMemoryMailer(),
+		Encryption:   encryption.NewEncryptionService(),
+		S3Service:    &fakeS3Service{},
+		Revoker:      flakyRevoker{f.revocations, f.revokeDown},
+	})
+	registered, err := f.service.Register(dto.CreateUserRequest{Username: "dora", Email: "dora@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	f.userID = registered.ID
+	return f
+}
+
+func (f *sessionFixture) login(t *testing.T, device string) *dto.TokenResponse {
+	res, err := f.service.Login(dto.LoginRequest{Email: "dora@example.com", Password: "secret1", DeviceName: device}, dto.ClientInfo{IPAddress: "203.0.113.1"})
+	require.NoError(t, err)
+	require.NotNil(t, res.TokenResponse)
+	return res.TokenResponse
+}
+
+// sessionIDs lists the user's active sessions by device.
+func (f *sessionFixture) sessionIDs(t *testing.T) map[string]string {
+	sessions, err := f.service.ListSessions(f.userID, "")
+	require.NoError(t, err)
+	ids := make(map[string]string)
+	for _, s := range sessions {
+		ids[s.Device] = s.ID
+	}
+	return ids
+}
+
+func TestSigningOutRevokesAccessTokens(t *testing.T) {
+	f := newSessionFixture(t)
+	f.login(t, "phone")
+	f.login(t, "laptop")
+	f.login(t, "tablet")
+	ids := f.sessionIDs(t)
+	require.Len(t, ids, 3)
+
+	assert.Error(t, f.service.RevokeSession(f.userID, uuid.NewString()))
+	assert.Error(t, f.service.RevokeSession(uuid.NewString(), ids["phone"]), "only the owner can sign a device out")
+	assert.Empty(t, f.revocations)
+
+	require.NoError(t, f.service.RevokeSession(f.userID, ids["phone"]))
+	assert.Equal(t, fakeRevocations{ids["phone"]: true}, f.revocations)
+	assert.NotContains(t, f.sessionIDs(t), "phone")
+
+	n, err := f.service.RevokeAllSessions(f.userID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, fakeRevocations{ids["phone"]: true, ids["laptop"]: true, ids["tablet"]: true}, f.revocations)
+	assert.Empty(t, f.sessionIDs(t))
+}
+
+func TestSigningOutEverywhereRevokesEverySession(t *testing.T) {
+	f := newSessionFixture(t)
+	f.login(t, "phone")
+	f.login(t, "laptop")
+	f.login(t, "tablet")
+	ids := f.sessionIDs(t)
+	f.revokeDown[ids["phone"]] = true
+
+	n, err := f.service.RevokeAllSessions(f.userID)
+	assert.ErrorContains(t, err, ids["phone"])
+	assert.Equal(t, 3, n, "the refresh tokens of all three are gone")
+	assert.Equal(t, fakeRevocations{ids["laptop"]: true, ids["tablet"]: true}, f.revocations, "one failure does not stop the others")
+	assert.Empty(t, f.sessionIDs(t))
+}
+
+func (f *sessionFixture) refresh(token string) (*dto.TokenResponse, error) {
+	return f.service.Refresh(context.Background(), dto.RefreshRequest{RefreshToken: token}, dto.ClientInfo{IPAddress: "203.0.113.1"})
+}
+
+func TestRefreshTokensWorkOnce(t *testing.T) {
+	f := newSessionFixture(t)
+	login := f.login(t, "phone")
+	family := f.sessionIDs(t)["phone"]
+
+	_, err := f.refresh("not-a-token")
+	assert.Error(t, err)
+
+	refreshed, err := f.refresh(login.RefreshToken)
+	require.NoError(t, err)
+	assert.NotEqual(t, login.RefreshToken, refreshed.RefreshToken)
+	assert.Equal(t, family, f.sessionIDs(t)["phone"], "the device keeps its session")
+	again, err := f.refresh(refreshed.RefreshToken)
+	require.NoError(t, err)
+	assert.Empty(t, f.revocations)
+
+	// Presenting a used token again means it was stolen, so the whole
+	// session ends, including the tokens issued after it
+	_, err = f.refresh(login.RefreshToken)
+	assert.Error(t, err)
+	assert.Equal(t, fakeRevocations{family: true}, f.revocations)
+	_, err = f.refresh(again.RefreshToken)
+	assert.Error(t, err)
+	assert.Empty(t, f.sessionIDs(t))
+}
+
+func TestLosingTheRotationRaceEndsTheSession(t *testing.T) {
+	f := newSessionFixture(t)
+	login := f.login(t, "phone")
+	family := f.sessionIDs(t)["phone"]
+
+	// The other request rotates the token between our lookup and our rotation
+	var winner *dto.TokenResponse
+	var winnerErr error
+	f.sessions.beforeRotate = func() { winner, winnerErr = f.refresh(login.RefreshToken) }
+	_, err := f.refresh(login.RefreshToken)
+	assert.Error(t, err)
+	require.NoError(t, winnerErr)
+
+	assert.Equal(t, fakeRevocations{family: true}, f.revocations)
+	_, err = f.refresh(winner.RefreshToken)
+	assert.Error(t, err, "the winner's token is revoked with the rest of the session")
+}
+
+func TestLogoutEndsOneSession(t *testing.T) {
+	f := newSessionFixture(t)
+	phone := f.login(t, "phone")
+	laptop := f.login(t, "laptop")
+	ids := f.sessionIDs(t)
+	refreshed, err := f.refresh(phone.RefreshToken)
+	require.NoError(t, err)
+
+	// Any token of the session signs it out, even one already rotated
+	require.NoError(t, f.service.Logout(dto.LogoutRequest{RefreshToken: phone.RefreshToken}))
+	assert.Equal(t, fakeRevocations{ids["phone"]: true}, f.revocations)
+	_, err = f.refresh(refreshed.RefreshToken)
+	assert.Error(t, err)
+
+	assert.NoError(t, f.service.Logout(dto.LogoutRequest{RefreshToken: phone.RefreshToken}), "logging out twice is harmless")
+	assert.NoError(t, f.service.Logout(dto.LogoutRequest{RefreshToken: "unknown"}))
+	_, err = f.refresh(laptop.RefreshToken)
+	assert.NoError(t, err, "other devices stay signed in")
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/datatypes"
+
+	"mozho_chat/internal/chatroom"
+	chatroomdto "mozho_chat/internal/chatroom/dto"
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+)
+
+func (r *fakeMessageRepo) UpdatePayload(id string, payload datatypes.JSON) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.messages {
+		if r.messages[i].ID.String() == id {
+			r.messages[i].Payload = payload
+		}
+	}
+	return nil
+}
+
+func (f *messageFixture) sendStructured(sender *models.User, roomID, msgType, payload string) (*dto.MessageResponse, error) {
+	return f.service.SendMessage(sender.ID.String(), dto.SendMessageRequest{
+		ReceiverID: roomID,
+		Type:       msgType,
+		Payload:    json.RawMessage(payload),
+	}, nil)
+}
+
+func TestStructuredPayloadsAreValidated(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeServer, ana, ben)
+
+	for name, c := range map[string]struct{ msgType, payload string }{
+		"missing payload":     {models.MessageTypeLocation, ``},
+		"latitude too large":  {models.MessageTypeLocation, `{"latitude": 91, "longitude": 0}`},
+		"longitude too small": {models.MessageTypeLocation, `{"latitude": 0, "longitude": -181}`},
+		"not an object":       {models.MessageTypeLocation, `[1, 2]`},
+		"negative accuracy":   {models.MessageTypeLiveLocation, `{"latitude": 1, "longitude": 2, "accuracy": -1, "duration_minutes": 15}`},
+		"too long to share":   {models.MessageTypeLiveLocation, `{"latitude": 1, "longitude": 2, "duration_minutes": 481}`},
+		"unknown contact":     {models.MessageTypeContact, `{"user_id": "6f1c1f9e-1b1a-4bde-9a55-8c2d4f2b7a10"}`},
+		"malformed contact":   {models.MessageTypeContact, `{"user_id": "ben"}`},
+		"unknown type":        {"sticker", `{}`},
+	} {
+		_, err := f.sendStructured(ana, roomID, c.msgType, c.payload)
+		assert.Error(t, err, name)
+	}
+	_, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{
+		ReceiverID: roomID,
+		Type:       models.MessageTypeLocation,
+		Content:    "here",
+		Payload:    json.RawMessage(`{"latitude": 1, "longitude": 2}`),
+	}, nil)
+	assert.Error(t, err, "structured messages carry no content")
+	assert.Empty(t, f.messages.inRoom(roomID))
+
+	location, err := f.sendStructured(ana, roomID, models.MessageTypeLocation, `{"latitude": 52.52, "longitude": 13.405, "label": "  Office  "}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"latitude": 52.52, "longitude": 13.405, "label": "Office"}`, string(location.Payload))
+
+	// Client-supplied usernames are replaced with the real one
+	card, err := f.sendStructured(ana, roomID, models.MessageTypeContact, `{"user_id": "`+ben.ID.String()+`", "username": "admin"}`)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"user_id": "`+ben.ID.String()+`", "username": "ben"}`, string(card.Payload))
+}
+
+func TestEndToEndRoomsOnlyTakeSealedStructuredMessages(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeE2E, ana, ben)
+
+	for _, c := range []struct{ msgType, payload string }{
+		{models.MessageTypeLocation, `{"latitude": 1, "longitude": 2}`},
+		{models.MessageTypeLiveLocation, `{"latitude": 1, "longitude": 2, "duration_minutes": 15}`},
+		{models.MessageTypeContact, `{"user_id": "` + ben.ID.String() + `"}`},
+	} {
+		_, err := f.sendStructured(ana, roomID, c.msgType, c.payload)
+		assert.Error(t, err, "the server may not read a %s here", c.msgType)
+	}
+	_, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{
+		ReceiverID: roomID,
+		Type:       models.MessageTypeLiveLocation,
+		Content:    "sealed",
+		Algorithm:  "AES",
+	}, nil)
+	assert.Error(t, err, "the server cannot move a sealed live location")
+	assert.Empty(t, f.messages.inRoom(roomID))
+
+	card, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{
+		ReceiverID: roomID,
+		Type:       models.MessageTypeContact,
+		Content:    "sealed card",
+		Algorithm:  "AES",
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.MessageTypeContact, card.Type)
+	assert.Empty(t, card.Payload)
+	assert.NotEqual(t, "sealed card", card.Content)
+}
+
+func TestLocationsCanBeSentToNewRooms(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	rooms := chatroom.NewService(f.rooms, f.users, f.blocks.contacts, f.blocks, f.events)
+	room, err := rooms.CreateRoom(ana.ID.String(), chatroomdto.CreateChatRoomRequest{OtherUserID: ben.ID})
+	require.NoError(t, err)
+	roomID := room.ID.String()
+
+	location := `{"latitude": 52.52, "longitude": 13.405}`
+	_, err = f.sendStructured(ana, roomID, models.MessageTypeLocation, location)
+	assert.Error(t, err, "new rooms are end-to-end encrypted")
+
+	sent, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{
+		ReceiverID: roomID,
+		Type:       models.MessageTypeLocation,
+		Content:    location,
+		Algorithm:  "AES",
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, models.MessageTypeLocation, sent.Type)
+	stored := f.messages.inRoom(roomID)
+	require.Len(t, stored, 1)
+	assert.Equal(t, "AES", stored[0].EncryptionMetadata.Algorithm)
+	assert.Empty(t, stored[0].Payload)
+	assert.ElementsMatch(t, []string{ana.ID.String(), ben.ID.String()}, f.events.userEvents("message.created"))
+}
+
+func TestLiveLocationUpdates(t *testing.T) {
+	f := newMessageFixture()
+	ana, ben := f.addUser(t, "ana"), f.addUser(t, "ben")
+	roomID := f.addRoom(t, models.EncryptionModeNone, ana, ben)
+	live, err := f.sendStructured(ana, roomID, models.MessageTypeLiveLocation, `{"latitude": 1, "longitude": 2, "duration_minutes": 15}`)
+	require.NoError(t, err)
+
+	lat, lng := 3.0, 4.0
+	_, err = f.service.UpdateLiveLocation(ben.ID.String(), live.ID, dto.UpdateLiveLocationRequest{Latitude: &lat, Longitude: &lng})
+	assert.Error(t, err, "only the sender moves their location")
+	moved, err := f.service.UpdateLiveLocation(ana.ID.String(), live.ID, dto.UpdateLiveLocationRequest{Latitude: &lat, Longitude: &lng})
+	require.NoError(t, err)
+	var payload dto.LiveLocationPayload
+	require.NoError(t, json.Unmarshal(moved.Payload, &payload))
+	assert.Equal(t, 3.0, payload.Latitude)
+
+	// After the room switches to end-to-end encryption sharing can only stop
+	f.rooms.rooms[roomID].EncryptionMode = models.EncryptionModeE2E
+	_, err = f.service.UpdateLiveLocation(ana.ID.String(), live.ID, dto.UpdateLiveLocationRequest{Latitude: &lng, Longitude: &lat})
+	assert.Error(t, err)
+	stopped, err := f.service.UpdateLiveLocation(ana.ID.String(), live.ID, dto.UpdateLiveLocationRequest{Stop: true})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(stopped.Payload, &payload))
+	assert.NotNil(t, payload.StoppedAt)
+	assert.Equal(t, 3.0, payload.Latitude)
+
+	_, err = f.service.UpdateLiveLocation(ana.ID.String(), live.ID, dto.UpdateLiveLocationRequest{Stop: true})
+	assert.Error(t, err, "sharing has ended")
+}
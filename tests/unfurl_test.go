@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/message/dto"
+	"mozho_chat/internal/models"
+	"mozho_chat/pkg/unfurl"
+)
+
+func newUnfurlServer(t *testing.T) *httptest.Server {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+	pngData := buf.Bytes()
+
+	mux := http.NewServeMux()
+	var srv *httptest.Server
+
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head>
+			<title>Fallback title</title>
+			<meta property="og:title" content="Mozho Chat ships previews">
+			<meta property="og:description" content="Links now unfurl.">
+			<meta property="og:site_name" content="Mozho Blog">
+			<meta property="og:image" content="/cover.png">
+		</head><body><meta property="og:title" content="ignored"></body></html>`)
+	})
+	mux.HandleFunc("/video", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<html><head>
+			<link rel="alternate" type="application/json+oembed" href="%s/oembed">
+		</head></html>`, srv.URL)
+	})
+	mux.HandleFunc("/oembed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"title": "A video", "provider_name": "Tube", "thumbnail_url": "%s/cover.png"}`, srv.URL)
+	})
+	mux.HandleFunc("/cover.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngData)
+	})
+	mux.HandleFunc("/big", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><title>" + strings.Repeat("a", 4096) + "</title></head></html>"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article", http.StatusFound)
+	})
+
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestFetcher() *unfurl.HTTPFetcher {
+	return unfurl.NewHTTPFetcher(unfurl.Options{
+		Timeout:              200 * time.Millisecond,
+		MaxPageBytes:         2048,
+		AllowPrivateNetworks: true,
+	})
+}
+
+func TestUnfurlOpenGraph(t *testing.T) {
+	srv := newUnfurlServer(t)
+
+	preview, err := newTestFetcher().Fetch(context.Background(), srv.URL+"/article")
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/article", preview.URL)
+	assert.Equal(t, "Mozho Chat ships previews", preview.Title)
+	assert.Equal(t, "Links now unfurl.", preview.Description)
+	assert.Equal(t, "Mozho Blog", preview.SiteName)
+	assert.Equal(t, srv.URL+"/cover.png", preview.ImageURL)
+}
+
+func TestUnfurlFollowsRedirects(t *testing.T) {
+	srv := newUnfurlServer(t)
+
+	preview, err := newTestFetcher().Fetch(context.Background(), srv.URL+"/redirect")
+	assert.NoError(t, err)
+	assert.Equal(t, "Mozho Chat ships previews", preview.Title)
+}
+
+func TestUnfurlOEmbedFallback(t *testing.T) {
+	srv := newUnfurlServer(t)
+
+	preview, err := newTestFetcher().Fetch(context.Background(), srv.URL+"/video")
+	assert.NoError(t, err)
+	assert.Equal(t, "A video", preview.Title)
+	assert.Equal(t, "Tube", preview.SiteName)
+	assert.Equal(t, srv.URL+"/cover.png", preview.ImageURL)
+}
+
+func TestUnfurlImage(t *testing.T) {
+	srv := newUnfurlServer(t)
+	fetcher := newTestFetcher()
+
+	data, mimeType, err := fetcher.FetchImage(context.Background(), srv.URL+"/cover.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", mimeType)
+	assert.NotEmpty(t, data)
+
+	_, _, err = fetcher.FetchImage(context.Background(), srv.URL+"/article")
+	assert.ErrorIs(t, err, unfurl.ErrNotImage)
+}
+
+func TestUnfurlLimits(t *testing.T) {
+	srv := newUnfurlServer(t)
+	fetcher := newTestFetcher()
+
+	_, err := fetcher.Fetch(context.Background(), srv.URL+"/big")
+	assert.ErrorIs(t, err, unfurl.ErrResponseTooLarge)
+
+	_, err = fetcher.Fetch(context.Background(), srv.URL+"/slow")
+	assert.Error(t, err)
+
+	_, err = fetcher.Fetch(context.Background(), "file:///etc/passwd")
+	assert.ErrorIs(t, err, unfurl.ErrUnsupportedURL)
+}
+
+func TestUnfurlBlocksPrivateAddresses(t *testing.T) {
+	srv := newUnfurlServer(t)
+	fetcher := unfurl.NewHTTPFetcher(unfurl.Options{})
+
+	for _, target := range []string{
+		srv.URL + "/article",
+		"http://localhost" + strings.TrimPrefix(srv.URL, "http://127.0.0.1"),
+		"http://169.254.169.254/latest/meta-data/",
+		"http://10.0.0.1/",
+		"http://[::1]/",
+	} {
+		_, err := fetcher.Fetch(context.Background(), target)
+		assert.True(t, errors.Is(err, unfurl.ErrBlockedAddress), "expected %s to be blocked, got %v", target, err)
+	}
+}
+
+func TestExtractURLs(t *testing.T) {
+	text := "see https://example.com/a, and (http://example.org/b) or https://example.com/a again. ftp://nope https://x.io/c"
+
+	assert.Equal(t, []string{"https://example.com/a", "http://example.org/b", "https://x.io/c"}, unfurl.ExtractURLs(text, 5))
+	assert.Equal(t, []string{"https://example.com/a"}, unfurl.ExtractURLs(text, 1))
+}
+
+func TestLinksAreNotUnfurledInEndToEndRooms(t *testing.T) {
+	f := newMessageFixture()
+	ana := f.addUser(t, "ana")
+	server := f.addRoom(t, models.EncryptionModeServer, ana)
+	plain := f.addRoom(t, models.EncryptionModeNone, ana)
+	e2e := f.addRoom(t, models.EncryptionModeE2E, ana)
+
+	// AES messages are unfurled elsewhere, but not here
+	_, err := f.service.SendMessage(ana.ID.String(), dto.SendMessageRequest{ReceiverID: e2e, Content: "see https://example.com", Algorithm: "AES"}, nil)
+	require.NoError(t, err)
+	assert.Empty(t, f.previews.jobs)
+
+	fromServer, err := f.send(ana, server, "see https://example.com")
+	require.NoError(t, err)
+	fromPlain, err := f.send(ana, plain, "see https://example.org")
+	require.NoError(t, err)
+	require.Len(t, f.previews.jobs, 2)
+	assert.Equal(t, fromServer.ID, f.previews.jobs[0].MessageID.String())
+	assert.Equal(t, fromPlain.ID, f.previews.jobs[1].MessageID.String())
+}
@@ -0,0 +1,129 @@
+package tests
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mozho_chat/internal/models"
+	"mozho_chat/internal/repository"
+	"mozho_chat/internal/user/dto"
+)
+
+// Search approximates the SQL query: substring matches stand in for
+// trigram similarity.
+func (r *fakeUserRepo) Search(params repository.UserSearchParams) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found []models.User
+	for _, u := range r.users {
+		if u.Placeholder || u.ID.String() == params.ExcludeID {
+			continue
+		}
+		byName := params.Name != "" &&
+			(strings.Contains(strings.ToLower(u.Username), params.Name) || strings.Contains(strings.ToLower(u.DisplayName), params.Name))
+		byEmail := params.Email != "" && strings.ToLower(u.Email) == params.Email && u.Privacy.DiscoverableByEmail
+		byPhone := params.Phone != "" && u.Phone != nil && *u.Phone == params.Phone && u.Privacy.DiscoverableByPhone
+		if byName || byEmail || byPhone {
+			found = append(found, u)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Username < found[j].Username })
+	if params.Offset >= len(found) {
+		return nil, nil
+	}
+	found = found[params.Offset:]
+	if len(found) > params.Limit {
+		found = found[:params.Limit]
+	}
+	return found, nil
+}
+
+func usernames(users []dto.UserBasic) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Username
+	}
+	return names
+}
+
+func TestSearchUsersByNameIsPaginated(t *testing.T) {
+	service, _, _ := newTestUserService(t)
+	me, err := service.Register(dto.CreateUserRequest{Username: "kimberly", Email: "kim@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	for _, name := range []string{"kim_a", "kim_b", "kim_c", "lee"} {
+		_, err := service.Register(dto.CreateUserRequest{Username: name, Email: name + "@example.com", Password: "secret1"})
+		require.NoError(t, err)
+	}
+
+	page, err := service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: " KIM ", Limit: 2})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kim_a", "kim_b"}, usernames(page.Users), "the searcher is left out")
+	require.NotNil(t, page.NextOffset)
+
+	page, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "kim", Limit: 2, Offset: *page.NextOffset})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kim_c"}, usernames(page.Users))
+	assert.Nil(t, page.NextOffset)
+
+	_, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "k"})
+	assert.Error(t, err, "single characters are too broad")
+	_, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "kim", Offset: 1000})
+	assert.Error(t, err)
+}
+
+func TestSearchUsersByEmailAndPhoneRespectsPrivacy(t *testing.T) {
+	service, _, _ := newTestUserService(t)
+	me, err := service.Register(dto.CreateUserRequest{Username: "mona", Email: "mona@example.com", Password: "secret1"})
+	require.NoError(t, err)
+	other, err := service.Register(dto.CreateUserRequest{Username: "nils", Email: "nils@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	phone := "+49 (151) 1234-5678"
+	updated, err := service.UpdateProfile(other.ID, dto.UpdateUserRequest{Phone: &phone})
+	require.NoError(t, err)
+	assert.Equal(t, "+4915112345678", updated.Phone)
+	bad := "0151 12345678"
+	_, err = service.UpdateProfile(other.ID, dto.UpdateUserRequest{Phone: &bad})
+	assert.Error(t, err, "a country code is required")
+
+	found, err := service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "NILS@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nils"}, usernames(found.Users))
+	found, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "nils@example"})
+	require.NoError(t, err)
+	assert.Empty(t, found.Users, "emails only match in full")
+	found, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "+49 151 12345678"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"nils"}, usernames(found.Users))
+
+	no := false
+	settings, err := service.UpdatePrivacySettings(other.ID, dto.UpdatePrivacySettingsRequest{DiscoverableByEmail: &no})
+	require.NoError(t, err)
+	assert.False(t, settings.DiscoverableByEmail)
+	assert.True(t, settings.DiscoverableByPhone)
+
+	found, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "nils@example.com"})
+	require.NoError(t, err)
+	assert.Empty(t, found.Users)
+	found, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "+4915112345678"})
+	require.NoError(t, err)
+	assert.Len(t, found.Users, 1, "still discoverable by phone")
+}
+
+func TestSearchUsersIsRateLimited(t *testing.T) {
+	service, _, _ := newTestUserService(t)
+	me, err := service.Register(dto.CreateUserRequest{Username: "otto", Email: "otto@example.com", Password: "secret1"})
+	require.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		_, err := service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "someone@example.com"})
+		require.NoError(t, err)
+	}
+	_, err = service.SearchUsers(me.ID, dto.SearchUsersQuery{Query: "someone@example.com"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many requests")
+}